@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/util"
+)
+
+// cacheUsage describes the cache subcommand.
+const cacheUsage = `Usage: repo-to-txt cache <command> [flags]
+
+Commands:
+  list    List the cached repositories, most recently used first
+  prune   Remove cached repositories (needs -max-size, -max-age or -all)
+
+Flags:
+`
+
+// runCache runs the cache subcommand, which lists or prunes the clone cache.
+//
+// Parameters:
+//   - args: The command-line arguments following "cache".
+//   - w: The destination for the listing or the report of what was pruned.
+//
+// Returns:
+//   - error: An error if the arguments are invalid or the cache cannot be read or pruned.
+func runCache(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("repo-to-txt cache", flag.ContinueOnError)
+	cacheDir := fs.String("cache-dir", "", "Directory of the clone cache (defaults to $REPO_TO_TXT_CACHE_DIR or the user cache directory)")
+	maxSize := fs.String("max-size", "", "prune: remove the least recently used repositories until the cache fits this size, e.g. 2GiB")
+	maxAge := fs.String("max-age", "", "prune: remove repositories not used for this long, e.g. 720h or 30d")
+	all := fs.Bool("all", false, "prune: remove every cached repository")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), cacheUsage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("missing cache command: use list or prune")
+	}
+	command := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cache, err := clone.NewCache(*cacheDir)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "list":
+		return listCache(w, cache)
+	case "prune":
+		opts := clone.PruneOptions{All: *all}
+		if *maxSize != "" {
+			if opts.MaxSize, err = util.ParseSize(*maxSize); err != nil {
+				return fmt.Errorf("invalid -max-size: %w", err)
+			}
+		}
+		if *maxAge != "" {
+			if opts.MaxAge, err = parseAge(*maxAge); err != nil {
+				return fmt.Errorf("invalid -max-age: %w", err)
+			}
+		}
+		if !opts.All && opts.MaxSize == 0 && opts.MaxAge == 0 {
+			return errors.New("prune needs -max-size, -max-age or -all")
+		}
+		return pruneCache(w, cache, opts)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown cache command %q: use list or prune", command)
+	}
+}
+
+// listCache prints the cached repositories and their sizes.
+//
+// Parameters:
+//   - out: The destination for the listing.
+//   - cache: The clone cache.
+//
+// Returns:
+//   - error: An error if the cache cannot be read.
+func listCache(out io.Writer, cache *clone.Cache) error {
+	entries, err := cache.List()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(out, "The clone cache at %s is empty.\n", cache.Dir)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tLAST USED\tREPOSITORY")
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+		fmt.Fprintf(w, "%s\t%s\t%s\n", util.FormatSize(entry.Size), entry.LastUsed.Local().Format("2006-01-02 15:04"), entry.URL)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\n%d repositories, %s in %s\n", len(entries), util.FormatSize(total), cache.Dir)
+	return nil
+}
+
+// pruneCache removes the cached repositories selected by opts and reports what was removed.
+//
+// Parameters:
+//   - w: The destination for the report.
+//   - cache: The clone cache.
+//   - opts: The limits selecting the repositories to remove.
+//
+// Returns:
+//   - error: An error if the cache cannot be read or a repository cannot be removed.
+func pruneCache(w io.Writer, cache *clone.Cache, opts clone.PruneOptions) error {
+	removed, err := cache.Prune(opts)
+	var freed int64
+	for _, entry := range removed {
+		freed += entry.Size
+		fmt.Fprintf(w, "Removed %s (%s)\n", entry.URL, util.FormatSize(entry.Size))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Removed %d repositories, freeing %s\n", len(removed), util.FormatSize(freed))
+	return nil
+}
+
+// parseAge parses a duration such as 720h, also accepting a number of days such as 30d.
+//
+// Parameters:
+//   - input: The duration to parse.
+//
+// Returns:
+//   - time.Duration: The parsed duration.
+//   - error: An error if the duration is malformed or not positive.
+func parseAge(input string) (time.Duration, error) {
+	var age time.Duration
+	if days, ok := strings.CutSuffix(input, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", input)
+		}
+		age = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if age, err = time.ParseDuration(input); err != nil {
+			return 0, err
+		}
+	}
+	if age <= 0 {
+		return 0, fmt.Errorf("duration %q must be positive", input)
+	}
+	return age, nil
+}
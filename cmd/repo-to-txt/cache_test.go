@@ -0,0 +1,118 @@
+// Package main_test contains unit tests for the cache subcommand.
+package main
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
+)
+
+// TestParseAge verifies that ages are accepted as Go durations or a number of days and must be positive.
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{"720h", 720 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"30d", 30 * 24 * time.Hour, false},
+		{"1d", 24 * time.Hour, false},
+		{"0d", 0, true},
+		{"-5h", 0, true},
+		{"xd", 0, true},
+		{"30", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			age, err := parseAge(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAge(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if age != tt.expected {
+				t.Errorf("parseAge(%q) = %v; want %v", tt.input, age, tt.expected)
+			}
+		})
+	}
+}
+
+// TestRunCacheErrors verifies that malformed cache commands are rejected before the cache is touched.
+func TestRunCacheErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"missing command", nil, "missing cache command"},
+		{"unknown command", []string{"clean", "-cache-dir", dir}, `unknown cache command "clean"`},
+		{"prune without limits", []string{"prune", "-cache-dir", dir}, "prune needs -max-size, -max-age or -all"},
+		{"invalid max size", []string{"prune", "-cache-dir", dir, "-max-size", "lots"}, "invalid -max-size"},
+		{"invalid max age", []string{"prune", "-cache-dir", dir, "-max-age", "0d"}, "invalid -max-age"},
+		{"unknown flag", []string{"list", "-depth", "1"}, "flag provided but not defined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runCache(tt.args, &out)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("runCache(%q) error = %v; want one containing %q", tt.args, err, tt.expected)
+			}
+		})
+	}
+}
+
+// TestRunCacheListPrune verifies that list reports the cached repositories and that prune removes
+// them and reports what was freed.
+//
+// Filling the cache from a local path uses the git-upload-pack binary, so the test is skipped when git is not installed.
+func TestRunCacheListPrune(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("Skipping cache test; git is not installed")
+	}
+
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := runCache(append(args, "-cache-dir", dir), &out); err != nil {
+			t.Fatalf("runCache(%q) returned an error: %v", args, err)
+		}
+		return out.String()
+	}
+
+	if out := run("list"); !strings.Contains(out, "is empty") {
+		t.Errorf("Expected an empty cache to be reported, got %q", out)
+	}
+
+	url := newTestRepo(t, "package cached\n")
+	if err := (&clone.Cache{Dir: dir}).Update(context.Background(), url, nil, nil); err != nil {
+		t.Fatalf("Failed to fill the cache: %v", err)
+	}
+
+	out := run("list")
+	if !strings.Contains(out, "REPOSITORY") || !strings.Contains(out, url) || !strings.Contains(out, "1 repositories") {
+		t.Errorf("Expected the listing to show the cached repository, got %q", out)
+	}
+
+	if out := run("prune", "-max-age", "30d"); !strings.Contains(out, "Removed 0 repositories") {
+		t.Errorf("Expected a recently used repository to be kept, got %q", out)
+	}
+
+	out = run("prune", "-all")
+	if !strings.Contains(out, "Removed "+url) || !strings.Contains(out, "Removed 1 repositories") {
+		t.Errorf("Expected prune -all to remove the cached repository, got %q", out)
+	}
+	if out := run("list"); !strings.Contains(out, "is empty") {
+		t.Errorf("Expected the cache to be empty after pruning, got %q", out)
+	}
+}
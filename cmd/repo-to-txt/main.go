@@ -4,20 +4,24 @@
 package main
 
 import (
-	"bufio" // Added bufio package
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 
 	"github.com/atotto/clipboard"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/archive"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/auth"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/output"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/prompt"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/util"
 )
 
 // main is the entry point of the repo-to-txt application.
@@ -30,19 +34,14 @@ func main() {
 	}
 }
 
-// run orchestrates the main workflow of the repo-to-txt tool.
+// run orchestrates the main workflow of the repo-to-txt tool, or runs the cache subcommand.
 // It performs the following steps:
 //  1. Initializes a new configuration instance.
-//  2. Parses command-line flags into the configuration.
-//  3. Prompts the user for any missing configuration inputs.
-//  4. Extracts the repository name from the provided URL.
-//  5. Determines the output file path based on the configuration.
-//  6. Creates a temporary directory for cloning the repository.
-//  7. Sets up the authentication method.
-//  8. Clones the repository or pulls the latest changes if it already exists locally.
-//  9. Writes the repository contents or copies specified files to the output directory.
-//
-// 10. Optionally copies the contents to clipboard if requested.
+//  2. Parses command-line flags and the user configuration file into the configuration.
+//  3. Resolves the repository to pack: a local directory, an archive or a remote repository.
+//  4. Merges the repository's .repototxt.yaml packing policy into the configuration.
+//  5. Writes the repository contents or copies specified files to the output directory.
+//  6. Optionally copies the contents to clipboard if requested.
 //
 // Parameters:
 //   - ctx: The context for managing cancellation and deadlines.
@@ -50,6 +49,11 @@ func main() {
 // Returns:
 //   - error: An error if any step in the workflow fails.
 func run(ctx context.Context) error {
+	// The cache subcommand manages the clone cache instead of packing a repository.
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		return runCache(os.Args[2:], os.Stdout)
+	}
+
 	// Initialize a new configuration instance.
 	cfg := config.NewConfig()
 
@@ -58,146 +62,516 @@ func run(ctx context.Context) error {
 		return fmt.Errorf("error parsing flags: %w", err)
 	}
 
-	// Prompt the user for any missing configuration inputs.
-	if err := prompt.PromptForMissingInputs(cfg); err != nil {
-		return fmt.Errorf("error prompting for inputs: %w", err)
+	// Load the user configuration file before prompting so that its defaults apply.
+	if err := cfg.LoadUserConfig(); err != nil {
+		return fmt.Errorf("error loading user configuration: %w", err)
 	}
 
-	log.Println("Welcome to repo-to-txt!")
+	// Prompts cannot be answered when standard input is not a terminal, e.g. under cron or CI.
+	if !cfg.NonInteractive && !prompt.StdinIsTerminal() {
+		log.Println("Standard input is not a terminal; running in non-interactive mode")
+		cfg.NonInteractive = true
+	}
 
-	// Extract the repository name from the provided URL.
-	repoName, err := clone.ExtractRepoName(cfg.RepoURL)
+	// Several repositories are fetched concurrently and packed together.
+	if cfg.IsMulti() {
+		return runMulti(ctx, cfg)
+	}
+
+	var snap output.Snapshot
+	var meta output.Metadata
+	switch {
+	case cfg.IsLocal():
+		// Local directories are packed in place, so prompting, authentication and cloning are skipped.
+		log.Println("Welcome to repo-to-txt!")
+
+		name, err := clone.ExtractLocalRepoName(cfg.LocalPath)
+		if err != nil {
+			return fmt.Errorf("error extracting repository name: %w", err)
+		}
+		snap, meta = output.DirSnapshot(cfg.LocalPath), output.Metadata{Name: name, Source: cfg.LocalPath, Subdirs: cfg.Subdirs}
+
+		if err := createOutputDir(cfg); err != nil {
+			return err
+		}
+		log.Printf("Packing local directory: %s", cfg.LocalPath)
+	case cfg.IsArchive():
+		// Archives are read in place like local directories, without extracting them to disk.
+		log.Println("Welcome to repo-to-txt!")
+
+		a, archiveSnap, archiveMeta, err := openArchive(cfg)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		snap, meta = archiveSnap, archiveMeta
+
+		if err := createOutputDir(cfg); err != nil {
+			return err
+		}
+	default:
+		cleanup, remoteSnap, remoteMeta, err := cloneRemoteRepo(ctx, cfg)
+		if cleanup != nil {
+			defer cleanup() // Ensure the temporary directory is removed after execution.
+		}
+		if err != nil {
+			return err
+		}
+		snap, meta = remoteSnap, remoteMeta
+	}
+
+	// Merge the packing policy committed to the repository.
+	found, err := cfg.ApplyRepoConfigFS(snap.FS)
 	if err != nil {
-		return fmt.Errorf("error extracting repository name: %w", err)
+		return fmt.Errorf("error loading repository configuration: %w", err)
+	}
+	if found {
+		log.Printf("Applied packing policy from %s", config.RepoConfigFile)
 	}
 
 	// Determine the output file path based on the configuration.
-	outputFile := filepath.Join(cfg.OutputDir, fmt.Sprintf("%s%s", repoName, config.DefaultOutputExt))
+	outputFile := cfg.Output
+	if outputFile == "" {
+		outputFile = filepath.Join(cfg.OutputDir, fmt.Sprintf("%s%s", meta.Name, output.FileExtension(cfg.Format)))
+	} else if !cfg.WritesToStdout() {
+		if err := os.MkdirAll(filepath.Dir(outputFile), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
 
-	// Create a temporary directory for cloning the repository.
-	tempDir, err := os.MkdirTemp("", config.DefaultCloneDir)
+	summary, err := writeSnapshot(snap, meta, outputFile, cfg)
 	if err != nil {
-		return fmt.Errorf("unable to create temporary directory: %w", err)
+		return err
+	}
+	description := "Repository contents"
+	if len(cfg.FileNames) > 0 {
+		description = "Specified files' contents"
+		if len(summary.Chunks) == 0 && !cfg.WritesToStdout() {
+			for _, file := range summary.Files {
+				log.Printf("Added %s to %s", file.Path, outputFile)
+			}
+		}
+	}
+	return report(summary, description, outputFile, cfg)
+}
+
+// writeSnapshot writes the repository snapshot, or the files selected from it with -files, to the
+// output file or standard output in the configured output format.
+//
+// Parameters:
+//   - snap: The snapshot of the repository.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - outputFile: The path to the output file; ignored when writing to standard output.
+//   - cfg: A pointer to the Config struct of the repository.
+//
+// Returns:
+//   - *output.Summary: The files written and their token counts.
+//   - error: An error if the files cannot be selected or the output cannot be written.
+func writeSnapshot(snap output.Snapshot, meta output.Metadata, outputFile string, cfg *config.Config) (*output.Summary, error) {
+	if len(cfg.FileNames) > 0 {
+		selectedPaths, err := selectFiles(snap, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		// Write the selected files in the configured output format
+		var summary *output.Summary
+		if cfg.WritesToStdout() {
+			summary, err = output.WriteSnapshotFiles(os.Stdout, snap, selectedPaths, meta, cfg)
+		} else {
+			summary, err = output.WriteSnapshotFilesToFile(snap, outputFile, selectedPaths, meta, cfg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error writing specified files to file: %w", err)
+		}
+		return summary, nil
 	}
-	defer os.RemoveAll(tempDir) // Ensure the temporary directory is removed after execution.
 
-	// Set up the authentication method based on the configuration.
-	authMethod, err := auth.SetupAuth(cfg)
+	// Write the repository contents to the specified output file or standard output.
+	var summary *output.Summary
+	var err error
+	if cfg.WritesToStdout() {
+		summary, err = output.WriteSnapshot(os.Stdout, snap, meta, cfg)
+	} else {
+		summary, err = output.WriteSnapshotToFile(snap, outputFile, meta, cfg)
+	}
 	if err != nil {
-		return fmt.Errorf("error setting up authentication: %w", err)
+		return nil, fmt.Errorf("error writing repository contents to file: %w", err)
 	}
+	return summary, nil
+}
 
-	// Clone the repository or pull the latest changes if it already exists locally.
-	if err := clone.CloneOrPullRepo(ctx, cfg.RepoURL, tempDir, authMethod); err != nil {
-		return fmt.Errorf("error cloning/pulling repository: %w", err)
+// selectFiles finds the files named with -files in the snapshot, resolving names with several
+// matches by the configured policy and prompting if it allows.
+//
+// Parameters:
+//   - snap: The snapshot of the repository.
+//   - cfg: A pointer to the Config struct holding the file names and the policy.
+//
+// Returns:
+//   - []string: The slash-separated paths of the selected files, in the order of the names; never nil.
+//   - error: An error if the snapshot cannot be searched or a name cannot be resolved.
+func selectFiles(snap output.Snapshot, cfg *config.Config) ([]string, error) {
+	fileMatches, err := output.FindSnapshotFiles(snap, cfg.FileNames, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error searching for specified files: %w", err)
 	}
 
-	if len(cfg.FileNames) > 0 {
-		// Handle writing specified files' contents to outputFile
-		fileMatches, err := output.FindFiles(tempDir, cfg.FileNames)
+	// Iterate over each specified file name
+	selectedPaths := []string{}
+	for _, fileName := range cfg.FileNames {
+		matches, exists := fileMatches[fileName]
+		if !exists || len(matches) == 0 {
+			log.Printf("No matches found for file name: %s", fileName)
+			continue
+		}
+
+		if len(matches) == 1 {
+			log.Printf("Found one match for %s: %s", fileName, matches[0])
+		}
+
+		// Resolve multiple matches by the configured policy, prompting if it allows
+		selected, err := prompt.ResolveMatches(fileName, matches, cfg)
 		if err != nil {
-			return fmt.Errorf("error searching for specified files: %w", err)
+			return nil, fmt.Errorf("error selecting file for %s: %w", fileName, err)
+		}
+		selectedPaths = append(selectedPaths, selected...)
+	}
+	return selectedPaths, nil
+}
+
+// report logs the files left out, where the output was written and the token report, and copies
+// the output to the clipboard if requested.
+//
+// Parameters:
+//   - summary: The summary of the files written to the output.
+//   - description: What was written, e.g. "Repository contents".
+//   - outputFile: The path to the output file; ignored when writing to standard output.
+//   - cfg: A pointer to the Config struct holding the output and clipboard options.
+//
+// Returns:
+//   - error: An error if the output cannot be copied to the clipboard.
+func report(summary *output.Summary, description, outputFile string, cfg *config.Config) error {
+	for _, skipped := range summary.Skipped {
+		if skipped.Dir {
+			log.Printf("Skipping directory %s: %s", skipped.Path, skipped.Reason)
+		} else {
+			log.Printf("Skipping file %s: %s", skipped.Path, skipped.Reason)
 		}
+	}
+
+	switch {
+	case len(summary.Chunks) > 0:
+		logChunks(summary)
+	case cfg.WritesToStdout():
+		log.Printf("%s written to standard output", description)
+	default:
+		log.Printf("%s written to %s", description, outputFile)
+	}
+	logTokenReport(summary, cfg.TopFiles)
 
-		// Open outputFile for writing (truncate if exists)
-		outFile, err := os.Create(outputFile)
+	// Handle clipboard copy if requested
+	switch {
+	case !cfg.CopyToClipboard:
+		log.Printf("%s were not copied to the clipboard.", description)
+	case len(summary.Chunks) > 0:
+		log.Println("Chunked output is not copied to the clipboard.")
+	case cfg.WritesToStdout():
+		log.Println("Output written to standard output is not copied to the clipboard.")
+	default:
+		content, err := os.ReadFile(outputFile)
 		if err != nil {
-			return fmt.Errorf("unable to create output file: %w", err)
+			return fmt.Errorf("error reading output file for clipboard: %w", err)
+		}
+		if err := clipboard.WriteAll(string(content)); err != nil {
+			return fmt.Errorf("error copying to clipboard: %w", err)
 		}
-		defer outFile.Close()
+		log.Printf("%s have been copied to the clipboard.", description)
+	}
 
-		writer := bufio.NewWriter(outFile)
-		defer writer.Flush()
+	return nil
+}
 
-		// Iterate over each specified file name
-		for _, fileName := range cfg.FileNames {
-			matches, exists := fileMatches[fileName]
-			if !exists || len(matches) == 0 {
-				log.Printf("No matches found for file name: %s", fileName)
-				continue
-			}
+// logChunks logs the chunk files the output was split into and the index describing them.
+//
+// Parameters:
+//   - summary: The summary of the files written to the output.
+func logChunks(summary *output.Summary) {
+	log.Printf("Output split into %d chunks, indexed in %s", len(summary.Chunks), summary.Index)
+	for _, chunk := range summary.Chunks {
+		log.Printf("  %s (%s)", chunk.File, util.FormatSize(chunk.Size))
+	}
+}
 
-			var selectedPath string
-			if len(matches) == 1 {
-				selectedPath = matches[0]
-				log.Printf("Found one match for %s: %s", fileName, selectedPath)
-			} else {
-				// Prompt user to select which file to include
-				selectedPath, err = prompt.SelectFile(fileName, matches)
-				if err != nil {
-					return fmt.Errorf("error selecting file for %s: %w", fileName, err)
-				}
-			}
+// logTokenReport logs the total number of tokens written, the files left out to fit the token
+// budget and the files with the most tokens.
+// Nothing is logged when tokens were not counted.
+//
+// Parameters:
+//   - summary: The summary of the files written to the output.
+//   - topFiles: The number of largest files to list; 0 lists none.
+func logTokenReport(summary *output.Summary, topFiles int) {
+	if summary.Tokenizer == "" {
+		return
+	}
+	log.Printf("Packed %d files, %d tokens (%s)", len(summary.Files), summary.Tokens, summary.Tokenizer)
+	if len(summary.Omitted) > 0 {
+		log.Printf("Left out or truncated %d files to fit the token budget of %d; they are listed at the end of the output", len(summary.Omitted), summary.MaxTokens)
+	}
 
-			// Read file content
-			content, err := ioutil.ReadFile(selectedPath)
-			if err != nil {
-				log.Printf("Failed to read file %s: %v", selectedPath, err)
-				continue
-			}
+	largest := summary.Largest(topFiles)
+	if len(largest) == 0 {
+		return
+	}
+	log.Printf("Largest files by tokens:")
+	for i, file := range largest {
+		share := 0.0
+		if summary.Tokens > 0 {
+			share = float64(file.Tokens) / float64(summary.Tokens) * 100
+		}
+		log.Printf("  %2d. %8d tokens (%4.1f%%)  %s", i+1, file.Tokens, share, file.Path)
+	}
+}
 
-			// Compute relative path
-			relPath, err := filepath.Rel(tempDir, selectedPath)
-			if err != nil {
-				log.Printf("Failed to compute relative path for %s: %v", selectedPath, err)
-				relPath = filepath.Base(selectedPath) // fallback to base name
-			}
+// createOutputDir creates the -output-dir directory, if one is set, for sources that are not
+// cloned and so skip the prompt that asks for it.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct holding the output directory.
+//
+// Returns:
+//   - error: An error if the directory cannot be created.
+func createOutputDir(cfg *config.Config) error {
+	if cfg.OutputDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(cfg.OutputDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return nil
+}
 
-			// Write content to outputFile with separator
-			separator := fmt.Sprintf("=== %s ===\n", relPath) // include relative path
-			if _, err := writer.WriteString(separator); err != nil {
-				log.Printf("Failed to write separator to output file: %v", err)
-				continue
-			}
-			if _, err := writer.Write(content); err != nil {
-				log.Printf("Failed to write content to output file: %v", err)
-				continue
-			}
-			if _, err := writer.WriteString("\n\n"); err != nil {
-				log.Printf("Failed to write newline to output file: %v", err)
-				continue
-			}
+// openArchive opens the tarball, zip archive or git bundle given with -archive, refusing
+// archives that leave their root or expand beyond -max-archive-size.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct describing the archive.
+//
+// Returns:
+//   - *archive.Archive: The opened archive, which must be closed once the output is written.
+//   - output.Snapshot: The snapshot of the archive.
+//   - output.Metadata: The repository name, archive path, ref, commit and subdirectories of the snapshot.
+//   - error: An error if the archive cannot be opened or the ref is not in the bundle.
+func openArchive(cfg *config.Config) (*archive.Archive, output.Snapshot, output.Metadata, error) {
+	name, err := archive.Name(cfg.ArchivePath)
+	if err != nil {
+		return nil, output.Snapshot{}, output.Metadata{}, fmt.Errorf("error extracting repository name: %w", err)
+	}
+
+	a, err := archive.Open(cfg.ArchivePath, archive.Options{Ref: cfg.Ref, MaxSize: cfg.MaxArchiveSize})
+	if err != nil {
+		return nil, output.Snapshot{}, output.Metadata{}, fmt.Errorf("error opening archive: %w", err)
+	}
+	snap, err := output.ArchiveSnapshot(a)
+	if err != nil {
+		a.Close()
+		return nil, output.Snapshot{}, output.Metadata{}, err
+	}
+
+	meta := output.Metadata{Name: name, Source: cfg.ArchivePath, Ref: cfg.Ref, Subdirs: cfg.Subdirs}
+	if a.Repo != nil {
+		meta.Commit = a.Commit.String()
+		log.Printf("Packing git bundle %s at commit %s", cfg.ArchivePath, meta.Commit)
+	} else {
+		log.Printf("Packing %s archive: %s", a.Format, cfg.ArchivePath)
+	}
+	return a, snap, meta, nil
+}
+
+// cloneRemoteRepo prompts for any missing inputs, sets up authentication and checks out the
+// configured remote repository into a new temporary directory at the requested ref with
+// fetchRemoteRepo. With -cache, the repository is fetched into the clone cache and checked out
+// from it, and the cache is pruned to -cache-max-size afterwards.
+//
+// Parameters:
+//   - ctx: The context for managing cancellation and deadlines.
+//   - cfg: A pointer to the Config struct describing the remote repository.
+//
+// Returns:
+//   - func(): Removes the temporary directory holding the clone; nil with -in-memory. It is returned even on failure so the caller can remove the directory.
+//   - output.Snapshot: The snapshot of the checked out or in-memory repository.
+//   - output.Metadata: The repository name, redacted URL, ref, commit, submodules and subdirectories of the snapshot.
+//   - error: An error if prompting, authentication or cloning fails.
+func cloneRemoteRepo(ctx context.Context, cfg *config.Config) (func(), output.Snapshot, output.Metadata, error) {
+	// Prompt the user for any missing configuration inputs.
+	if err := prompt.PromptForMissingInputs(cfg); err != nil {
+		return nil, output.Snapshot{}, output.Metadata{}, fmt.Errorf("error prompting for inputs: %w", err)
+	}
+
+	log.Println("Welcome to repo-to-txt!")
+
+	// Extract the repository name from the provided URL.
+	repoName, err := clone.ExtractRepoName(cfg.RepoURL)
+	if err != nil {
+		return nil, output.Snapshot{}, output.Metadata{}, fmt.Errorf("error extracting repository name: %w", err)
+	}
+
+	cache, err := openCache(cfg)
+	if err != nil {
+		return nil, output.Snapshot{}, output.Metadata{}, err
+	}
 
-			log.Printf("Added %s to %s", selectedPath, outputFile)
+	// Set up the authentication method based on the configuration; offline runs never connect.
+	var authMethod transport.AuthMethod
+	if !cfg.Offline {
+		if authMethod, err = auth.SetupAuth(cfg, os.Stderr); err != nil {
+			return nil, output.Snapshot{}, output.Metadata{}, fmt.Errorf("error setting up authentication: %w", err)
 		}
+	}
 
-		// After writing all specified files
-		log.Printf("Specified files' contents written to %s", outputFile)
+	cleanup, snap, meta, err := fetchRemoteRepo(ctx, cfg, repoName, cache, authMethod, os.Stderr) // Keep standard output free for the packed output
+	if err != nil {
+		return cleanup, output.Snapshot{}, output.Metadata{}, err
+	}
+	trimCache(cache, cfg, []string{cfg.RepoURL})
+	return cleanup, snap, meta, nil
+}
 
-		// Handle clipboard copy if requested
-		if cfg.CopyToClipboard {
-			content, err := ioutil.ReadFile(outputFile)
-			if err != nil {
-				return fmt.Errorf("error reading output file for clipboard: %w", err)
-			}
-			if err := clipboard.WriteAll(string(content)); err != nil {
-				return fmt.Errorf("error copying to clipboard: %w", err)
-			}
-			log.Println("Specified files' contents have been copied to the clipboard.")
-		} else {
-			log.Println("Specified files' contents were not copied to the clipboard.")
+// openCache opens the clone cache when -cache or -offline is given. A cache that cannot be opened
+// is skipped, so that the run clones from scratch, unless -offline requires it.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct holding the cache options.
+//
+// Returns:
+//   - *clone.Cache: The clone cache; nil if it is disabled or unavailable.
+//   - error: An error if -offline is given and the cache cannot be opened.
+func openCache(cfg *config.Config) (*clone.Cache, error) {
+	if !cfg.Cache || cfg.NoCache {
+		return nil, nil
+	}
+	cache, err := clone.NewCache(cfg.CacheDir)
+	if err != nil {
+		if cfg.Offline {
+			return nil, err
+		}
+		log.Printf("Clone cache disabled: %v", err)
+		return nil, nil
+	}
+	return cache, nil
+}
+
+// trimCache removes the least recently used repositories from the clone cache until it fits
+// -cache-max-size, keeping the repositories packed in this run. Failures are logged.
+//
+// Parameters:
+//   - cache: The clone cache; nil does nothing.
+//   - cfg: A pointer to the Config struct holding the cache size limit.
+//   - keep: The URLs of the repositories packed in this run.
+func trimCache(cache *clone.Cache, cfg *config.Config, keep []string) {
+	if cache == nil || cfg.CacheMaxSize <= 0 {
+		return
+	}
+	removed, err := cache.Prune(clone.PruneOptions{MaxSize: cfg.CacheMaxSize, Keep: keep})
+	if err != nil {
+		log.Printf("Failed to prune the clone cache: %v", err)
+	}
+	for _, entry := range removed {
+		log.Printf("Removed %s (%s) from the clone cache to stay within %s", entry.URL, util.FormatSize(entry.Size), util.FormatSize(cfg.CacheMaxSize))
+	}
+}
+
+// fetchRemoteRepo checks out the configured remote repository into a new temporary directory at
+// the requested ref, fetching it into the clone cache first when one is given. With -submodules,
+// the submodules are checked out recursively, reusing the authentication for submodules on the
+// same host. With -in-memory, nothing is checked out: the repository is cloned into memory, or
+// read from the cache in place, and the snapshot reads the commit tree from the object store.
+//
+// Parameters:
+//   - ctx: The context for managing cancellation and deadlines.
+//   - cfg: A pointer to the Config struct describing the remote repository.
+//   - repoName: The name of the repository in the output.
+//   - cache: The clone cache; nil clones from scratch.
+//   - authMethod: The authentication method for the repository; nil for anonymous clones.
+//   - progress: The destination for progress messages; nil discards them.
+//
+// Returns:
+//   - func(): Removes the temporary directory holding the clone; nil with -in-memory. It is returned even on failure so the caller can remove the directory.
+//   - output.Snapshot: The snapshot of the checked out or in-memory repository.
+//   - output.Metadata: The repository name, redacted URL, ref, commit, submodules and subdirectories of the snapshot.
+//   - error: An error if cloning fails.
+func fetchRemoteRepo(ctx context.Context, cfg *config.Config, repoName string, cache *clone.Cache, authMethod transport.AuthMethod, progress io.Writer) (func(), output.Snapshot, output.Metadata, error) {
+	// Create a temporary directory for the working tree, inside the cache so that objects can be hard-linked.
+	var tempDir string
+	var cleanup func()
+	var err error
+	switch {
+	case cfg.InMemory:
+		// Nothing is written to disk outside the cache.
+	case cache != nil:
+		tempDir, cleanup, err = cache.TempDir()
+	default:
+		if tempDir, err = os.MkdirTemp("", config.DefaultCloneDir); err == nil {
+			cleanup = func() { os.RemoveAll(tempDir) }
 		}
+	}
+	if err != nil {
+		return nil, output.Snapshot{}, output.Metadata{}, fmt.Errorf("unable to create temporary directory: %w", err)
+	}
+
+	// Clone the repository, or fetch it into the cache and check it out from there.
+	opts := clone.Options{
+		Ref:          cfg.Ref,
+		Depth:        cfg.Depth,
+		SingleBranch: cfg.SingleBranch,
+		Progress:     progress,
+		Cache:        cache,
+		Offline:      cfg.Offline,
+		Subdirs:      cfg.Subdirs,
+	}
+	var snap output.Snapshot
+	var commit string
+	if cfg.InMemory {
+		repo, hash, err := clone.CloneToMemory(ctx, cfg.RepoURL, authMethod, opts)
+		if err != nil {
+			return cleanup, output.Snapshot{}, output.Metadata{}, fmt.Errorf("error cloning repository: %w", err)
+		}
+		if snap, err = output.TreeSnapshot(repo, hash); err != nil {
+			return cleanup, output.Snapshot{}, output.Metadata{}, err
+		}
+		commit = hash.String()
 	} else {
-		// Write the repository contents to the specified output file.
-		if err := output.WriteRepoContentsToFile(tempDir, outputFile, cfg); err != nil {
-			return fmt.Errorf("error writing repository contents to file: %w", err)
+		if commit, err = clone.CloneOrPullRepo(ctx, cfg.RepoURL, tempDir, authMethod, opts); err != nil {
+			return cleanup, output.Snapshot{}, output.Metadata{}, fmt.Errorf("error cloning/pulling repository: %w", err)
 		}
-		log.Printf("Repository contents written to %s", outputFile)
+		snap = output.DirSnapshot(tempDir)
+	}
+	if cfg.Ref != "" {
+		log.Printf("Checked out %s of %s at commit %s", cfg.Ref, repoName, commit)
+	}
 
-		// Handle clipboard copy if requested
-		if cfg.CopyToClipboard {
-			content, err := ioutil.ReadFile(outputFile)
-			if err != nil {
-				return fmt.Errorf("error reading output file for clipboard: %w", err)
-			}
-			if err := clipboard.WriteAll(string(content)); err != nil {
-				return fmt.Errorf("error copying to clipboard: %w", err)
-			}
-			log.Println("Repository contents have been copied to the clipboard.")
-		} else {
-			log.Println("Repository contents were not copied to the clipboard.")
+	meta := output.Metadata{Name: repoName, Source: clone.RedactURL(cfg.RepoURL), Ref: cfg.Ref, Commit: commit, Subdirs: cfg.Subdirs}
+	if cfg.Submodules {
+		meta.Submodules, err = clone.UpdateSubmodules(ctx, tempDir, cfg.RepoURL, clone.SubmoduleOptions{
+			Auth:      authMethod,
+			AuthFor:   func(repoURL string) (transport.AuthMethod, error) { return auth.ForURL(cfg, repoURL, progress) },
+			AllowHTTP: cfg.SubmodulesAllowHTTP,
+			AllowFile: cfg.SubmodulesAllowFile,
+			Progress:  progress,
+			Subdirs:   cfg.Subdirs,
+		})
+		if errors.Is(err, clone.ErrSubmoduleProtocol) {
+			return cleanup, output.Snapshot{}, output.Metadata{}, fmt.Errorf("error checking out submodules: %w; pass -submodules-allow-http or -submodules-allow-file to allow such submodules", err)
 		}
+		if err != nil {
+			return cleanup, output.Snapshot{}, output.Metadata{}, fmt.Errorf("error checking out submodules: %w", err)
+		}
+		log.Printf("Checked out %d submodules of %s", len(meta.Submodules), repoName)
+	} else if _, err := fs.Stat(snap.FS, ".gitmodules"); err == nil && cfg.InMemory {
+		log.Printf("%s has submodules, which are left empty in memory", repoName)
+	} else if err == nil {
+		log.Printf("%s has submodules, which are left empty; pass -submodules to pack them", repoName)
 	}
 
-	return nil
+	return cleanup, snap, meta, nil
 }
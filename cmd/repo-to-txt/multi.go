@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/archive"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/auth"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/output"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/prompt"
+)
+
+// maxCombinedNameLength is the longest name derived by joining the repository names; longer
+// lists fall back to defaultCombinedName.
+const maxCombinedNameLength = 64
+
+// defaultCombinedName names the output of a run packing many repositories without a manifest name.
+const defaultCombinedName = "repositories"
+
+// fetchedRepo is a repository of a run packing several, fetched and ready to be packed.
+type fetchedRepo struct {
+	snap    output.Snapshot
+	meta    output.Metadata
+	cfg     *config.Config // Configuration of the repository, with its .repototxt.yaml merged
+	cleanup func()         // Removes the checkout or closes the archive; nil if there is nothing to release
+	err     error          // Why the repository could not be fetched; nil if it was
+}
+
+// runMulti packs the repositories given with several -repo flags or a manifest. Up to -jobs
+// repositories are fetched concurrently, each with its own copy of the configuration, and the
+// repositories are then packed in the order given: into one combined document with a section
+// per repository, or with -per-repo into one output per repository and an index describing them.
+// A combined document needs every repository, so the run stops at the first failure; with
+// -per-repo the other repositories are still packed and the failures are listed in the index.
+//
+// Parameters:
+//   - ctx: The context for managing cancellation and deadlines.
+//   - cfg: A pointer to the Config struct listing the repositories.
+//
+// Returns:
+//   - error: An error if prompting fails, a repository of a combined document cannot be packed,
+//     any repository fails with -per-repo, or the output cannot be written.
+func runMulti(ctx context.Context, cfg *config.Config) error {
+	if err := prompt.PromptForRunInputs(cfg); err != nil {
+		return fmt.Errorf("error prompting for inputs: %w", err)
+	}
+
+	log.Println("Welcome to repo-to-txt!")
+
+	names, err := repoNames(cfg.Repos)
+	if err != nil {
+		return err
+	}
+	name := combinedName(cfg, names)
+	log.Printf("Packing %d repositories with up to %d at a time", len(cfg.Repos), cfg.Jobs)
+
+	var cache *clone.Cache
+	var remotes []string
+	for _, spec := range cfg.Repos {
+		if spec.URL != "" {
+			remotes = append(remotes, spec.URL)
+		}
+	}
+	if len(remotes) > 0 {
+		if cache, err = openCache(cfg); err != nil {
+			return err
+		}
+	}
+
+	repos := fetchRepos(ctx, cfg, names, cache)
+	defer func() {
+		for _, repo := range repos {
+			if repo.cleanup != nil {
+				repo.cleanup()
+			}
+		}
+	}()
+	trimCache(cache, cfg, remotes)
+
+	if cfg.PerRepo {
+		return writePerRepo(repos, name, cfg)
+	}
+	if err := firstError(repos); err != nil {
+		return err
+	}
+	return writeCombined(repos, name, cfg)
+}
+
+// firstError returns the error of the repository whose failure stopped the run, passing over the
+// repositories cancelled because of it.
+//
+// Parameters:
+//   - repos: The fetched repositories.
+//
+// Returns:
+//   - error: The error of the failed repository; nil if every repository was fetched.
+func firstError(repos []fetchedRepo) error {
+	var cancelled error
+	for _, repo := range repos {
+		switch {
+		case repo.err == nil:
+		case errors.Is(repo.err, context.Canceled) && cancelled == nil:
+			cancelled = fmt.Errorf("error fetching %s: %w", repo.meta.Name, repo.err)
+		case !errors.Is(repo.err, context.Canceled):
+			return fmt.Errorf("error fetching %s: %w", repo.meta.Name, repo.err)
+		}
+	}
+	return cancelled
+}
+
+// repoNames returns the names of the repositories in the output: the name given in the
+// manifest, or one derived from the URL, directory or archive. Repeated names are made distinct
+// by appending a number, e.g. client-2, so that every repository has its own section and output.
+//
+// Parameters:
+//   - specs: The repositories to pack.
+//
+// Returns:
+//   - []string: The names, in the order of the repositories.
+//   - error: An error if a name cannot be derived from a repository.
+func repoNames(specs []config.RepoSpec) ([]string, error) {
+	names := make([]string, 0, len(specs))
+	used := make(map[string]bool)
+	for _, spec := range specs {
+		name := spec.Name
+		var err error
+		switch {
+		case name != "":
+		case spec.Path != "":
+			name, err = clone.ExtractLocalRepoName(spec.Path)
+		case spec.Archive != "":
+			name, err = archive.Name(spec.Archive)
+		default:
+			name, err = clone.ExtractRepoName(spec.URL)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error extracting repository name: %w", err)
+		}
+
+		unique := name
+		for n := 2; used[unique]; n++ {
+			unique = fmt.Sprintf("%s-%d", name, n)
+		}
+		used[unique] = true
+		names = append(names, unique)
+	}
+	return names, nil
+}
+
+// combinedName returns the name of the combined output, or of the index written with -per-repo:
+// the manifest name, or the repository names joined with "+" when they are short enough.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct holding the manifest name.
+//   - names: The names of the repositories.
+//
+// Returns:
+//   - string: The name of the output.
+func combinedName(cfg *config.Config, names []string) string {
+	if cfg.CombinedName != "" {
+		return cfg.CombinedName
+	}
+	if name := strings.Join(names, "+"); len(name) <= maxCombinedNameLength {
+		return name
+	}
+	return defaultCombinedName
+}
+
+// fetchRepos fetches the repositories with up to cfg.Jobs at a time. Unless -per-repo is given,
+// the first failure cancels the fetches still running and skips those not yet started.
+//
+// Parameters:
+//   - ctx: The context for managing cancellation and deadlines.
+//   - cfg: A pointer to the Config struct listing the repositories.
+//   - names: The names of the repositories in the output.
+//   - cache: The clone cache; nil clones from scratch.
+//
+// Returns:
+//   - []fetchedRepo: The fetched repositories in the order given, each with its error if it failed.
+func fetchRepos(ctx context.Context, cfg *config.Config, names []string, cache *clone.Cache) []fetchedRepo {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Progress messages of concurrent clones would interleave, so they are only shown one at a time.
+	var progress io.Writer
+	if cfg.Jobs == 1 {
+		progress = os.Stderr
+	}
+
+	repos := make([]fetchedRepo, len(cfg.Repos))
+	slots := make(chan struct{}, cfg.Jobs)
+	var wg sync.WaitGroup
+	for i, spec := range cfg.Repos {
+		// Taking the slot before starting the fetch starts the repositories in the order given
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, spec config.RepoSpec) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			repoCfg := cfg.ForRepo(spec)
+			if err := ctx.Err(); err != nil {
+				repos[i] = fetchedRepo{meta: output.Metadata{Name: names[i]}, cfg: repoCfg, err: err}
+				return
+			}
+			repos[i] = fetchRepo(ctx, repoCfg, names[i], cache, progress)
+			if repos[i].err != nil && !cfg.PerRepo {
+				cancel()
+			}
+		}(i, spec)
+	}
+	wg.Wait()
+	return repos
+}
+
+// fetchRepo fetches one repository of a run packing several, without prompting, and merges its
+// .repototxt.yaml into its configuration.
+//
+// Parameters:
+//   - ctx: The context for managing cancellation and deadlines.
+//   - cfg: A pointer to the Config struct of the repository, from Config.ForRepo.
+//   - name: The name of the repository in the output.
+//   - cache: The clone cache; nil clones from scratch.
+//   - progress: The destination for clone progress messages; nil discards them.
+//
+// Returns:
+//   - fetchedRepo: The fetched repository, or the error that prevented fetching it.
+func fetchRepo(ctx context.Context, cfg *config.Config, name string, cache *clone.Cache, progress io.Writer) fetchedRepo {
+	repo := fetchedRepo{meta: output.Metadata{Name: name}, cfg: cfg}
+	switch {
+	case cfg.IsLocal():
+		repo.meta.Source = cfg.LocalPath
+		if _, err := os.Stat(cfg.LocalPath); err != nil {
+			repo.err = fmt.Errorf("unable to read local directory: %w", err)
+			return repo
+		}
+		repo.snap, repo.meta = output.DirSnapshot(cfg.LocalPath), output.Metadata{Name: name, Source: cfg.LocalPath, Subdirs: cfg.Subdirs}
+		log.Printf("Packing local directory %s as %s", cfg.LocalPath, name)
+	case cfg.IsArchive():
+		repo.meta.Source = cfg.ArchivePath
+		a, snap, meta, err := openArchive(cfg)
+		if err != nil {
+			repo.err = err
+			return repo
+		}
+		repo.cleanup = func() { a.Close() }
+		repo.snap, repo.meta = snap, meta
+		repo.meta.Name = name
+	default:
+		repo.meta.Source = clone.RedactURL(cfg.RepoURL)
+		authMethod, err := repoAuth(cfg, progress)
+		if err != nil {
+			repo.err = fmt.Errorf("error setting up authentication: %w", err)
+			return repo
+		}
+		cleanup, snap, meta, err := fetchRemoteRepo(ctx, cfg, name, cache, authMethod, progress)
+		repo.cleanup = cleanup
+		if err != nil {
+			repo.err = err
+			return repo
+		}
+		repo.snap, repo.meta = snap, meta
+		log.Printf("Fetched %s at commit %s", name, meta.Commit)
+	}
+
+	// Merge the packing policy committed to the repository.
+	found, err := cfg.ApplyRepoConfigFS(repo.snap.FS)
+	if err != nil {
+		repo.err = fmt.Errorf("error loading repository configuration: %w", err)
+		return repo
+	}
+	if found {
+		log.Printf("Applied packing policy from %s of %s", config.RepoConfigFile, name)
+	}
+	return repo
+}
+
+// repoAuth returns the authentication method for a remote repository of a run packing several,
+// without prompting. A method given with -auth applies to every repository, a token given with
+// -pat to every HTTPS repository, and otherwise HTTPS repositories use the token found by
+// LookupCredentials and SSH repositories the SSH keys.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct of the repository.
+//   - progress: Destination for notices such as host keys added to known_hosts.
+//
+// Returns:
+//   - transport.AuthMethod: The authentication method; nil for anonymous clones and -offline.
+//   - error: An error if the authentication cannot be set up.
+func repoAuth(cfg *config.Config, progress io.Writer) (transport.AuthMethod, error) {
+	if cfg.Offline {
+		return nil, nil
+	}
+	if cfg.AuthFlagSet {
+		return auth.SetupAuth(cfg, progress)
+	}
+	u, err := clone.ParseRepoURL(cfg.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.IsHTTP() && cfg.PersonalAccessToken != "" {
+		return auth.HTTPAuth(cfg.RepoURL, cfg.Username, cfg.PersonalAccessToken), nil
+	}
+	if u.IsHTTP() {
+		if creds := auth.LookupCredentials(cfg.RepoURL, cfg.Username, true); creds != nil {
+			username := cfg.Username
+			if username == "" {
+				username = creds.Username
+			}
+			return auth.HTTPAuth(cfg.RepoURL, username, creds.Token), nil
+		}
+		return nil, nil
+	}
+	return auth.ForURL(cfg, cfg.RepoURL, progress)
+}
+
+// writeCombined packs the repositories into one document with a section per repository, sharing
+// the token budget, and reports the result as a single run does.
+//
+// Parameters:
+//   - repos: The fetched repositories, in output order.
+//   - name: The name of the combined document.
+//   - cfg: A pointer to the Config struct holding the output options.
+//
+// Returns:
+//   - error: An error if the files cannot be selected or the output cannot be written.
+func writeCombined(repos []fetchedRepo, name string, cfg *config.Config) error {
+	combined := make([]output.Repository, 0, len(repos))
+	for _, repo := range repos {
+		entry := output.Repository{Snapshot: repo.snap, Meta: repo.meta, Config: repo.cfg}
+		if len(cfg.FileNames) > 0 {
+			paths, err := selectFiles(repo.snap, repo.cfg)
+			if err != nil {
+				return fmt.Errorf("%s: %w", repo.meta.Name, err)
+			}
+			entry.Paths = paths
+		}
+		combined = append(combined, entry)
+	}
+
+	outputFile := cfg.Output
+	if outputFile == "" {
+		outputFile = filepath.Join(cfg.OutputDir, name+output.FileExtension(cfg.Format))
+	} else if !cfg.WritesToStdout() {
+		if err := os.MkdirAll(filepath.Dir(outputFile), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	meta := output.Metadata{Name: name, Source: cfg.Manifest}
+	var summary *output.Summary
+	var err error
+	if cfg.WritesToStdout() {
+		summary, err = output.WriteCombined(os.Stdout, combined, meta, cfg)
+	} else {
+		summary, err = output.WriteCombinedToFile(combined, outputFile, meta, cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing repository contents to file: %w", err)
+	}
+	return report(summary, fmt.Sprintf("Contents of %d repositories", len(repos)), outputFile, cfg)
+}
+
+// writePerRepo packs each repository into its own output in the output directory, named after
+// the repository, and writes an index describing the outputs. Repositories that failed to fetch
+// or pack are listed in the index with the reason.
+//
+// Parameters:
+//   - repos: The fetched repositories, in order.
+//   - name: The name of the run, which names the index.
+//   - cfg: A pointer to the Config struct holding the output options.
+//
+// Returns:
+//   - error: An error if the index cannot be written or any repository failed.
+func writePerRepo(repos []fetchedRepo, name string, cfg *config.Config) error {
+	outputs := make([]output.RepositoryOutput, 0, len(repos))
+	var failed []string
+	for _, repo := range repos {
+		out := output.RepositoryOutput{Meta: repo.meta, Err: repo.err}
+		if out.Err == nil {
+			out.File = filepath.Join(cfg.OutputDir, repo.meta.Name+output.FileExtension(cfg.Format))
+			out.Summary, out.Err = writeSnapshot(repo.snap, repo.meta, out.File, repo.cfg)
+		}
+		switch {
+		case out.Err != nil:
+			log.Printf("Failed to pack %s: %v", repo.meta.Name, out.Err)
+			out.File, out.Summary = "", nil
+			failed = append(failed, repo.meta.Name)
+		case len(out.Summary.Chunks) > 0:
+			out.File = out.Summary.Index
+			log.Printf("%s split into %d chunks, indexed in %s", repo.meta.Name, len(out.Summary.Chunks), out.File)
+		default:
+			log.Printf("%s written to %s", repo.meta.Name, out.File)
+		}
+		if out.Summary != nil && out.Summary.Tokenizer != "" {
+			log.Printf("  %d files, %d tokens (%s)", len(out.Summary.Files), out.Summary.Tokens, out.Summary.Tokenizer)
+		}
+		outputs = append(outputs, out)
+	}
+
+	index := output.RepositoryIndexFile(cfg.OutputDir, name, cfg.Format)
+	if err := output.WriteRepositoryIndex(index, cfg.Format, name, outputs); err != nil {
+		return err
+	}
+	log.Printf("Index of %d repositories written to %s", len(repos), index)
+	if cfg.CopyToClipboard {
+		log.Println("Per-repository output is not copied to the clipboard.")
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d repositories could not be packed: %s", len(failed), len(repos), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Package main_test contains unit tests for packing several repositories in one run.
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+)
+
+// newTestRepo creates a repository with a single commit of main.go and returns its file:// URL.
+func newTestRepo(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add("main.go"); err != nil {
+		t.Fatal(err)
+	}
+	signature := &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	if _, err := worktree.Commit("Initial commit", &git.CommitOptions{Author: signature}); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	return "file://" + filepath.ToSlash(dir)
+}
+
+// TestRepoNames verifies that repository names come from the manifest or the source and that
+// repeated names get a numbered suffix.
+func TestRepoNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		specs    []config.RepoSpec
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "distinct",
+			specs:    []config.RepoSpec{{URL: "https://github.com/user/api.git"}, {Path: "/src/web"}, {Archive: "/tmp/tool-1.0.tar.gz"}},
+			expected: []string{"api", "web", "tool-1.0"},
+		},
+		{
+			name:     "repeated urls",
+			specs:    []config.RepoSpec{{URL: "https://github.com/a/client.git"}, {URL: "https://gitlab.com/b/client.git"}, {URL: "git@example.com:c/client.git"}},
+			expected: []string{"client", "client-2", "client-3"},
+		},
+		{
+			name:     "manifest name clashes with derived name",
+			specs:    []config.RepoSpec{{Name: "app"}, {URL: "https://github.com/user/app.git"}},
+			expected: []string{"app", "app-2"},
+		},
+		{
+			name:     "suffixed name already taken",
+			specs:    []config.RepoSpec{{Name: "lib-2"}, {Name: "lib"}, {Name: "lib"}},
+			expected: []string{"lib-2", "lib", "lib-3"},
+		},
+		{
+			name:    "underivable name",
+			specs:   []config.RepoSpec{{URL: "https://github.com/"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, err := repoNames(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("repoNames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("repoNames() = %v; want %v", names, tt.expected)
+			}
+		})
+	}
+}
+
+// TestFetchRepos verifies that a failure cancels the remaining fetches of a combined run, that
+// -per-repo fetches every repository regardless, and that -per-repo packs the repositories that
+// were fetched and lists the failure in the index.
+//
+// Fetching from a local path uses the git-upload-pack binary, so the test is skipped when git is not installed.
+func TestFetchRepos(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("Skipping fetch test; git is not installed")
+	}
+
+	first := newTestRepo(t, "package first\n")
+	second := newTestRepo(t, "package second\n")
+	missing := "file://" + filepath.ToSlash(filepath.Join(t.TempDir(), "missing"))
+
+	tests := []struct {
+		name      string
+		urls      []string
+		jobs      int
+		perRepo   bool
+		failed    []bool // Whether each repository fails with an error of its own
+		cancelled []bool // Whether each repository is skipped because another failed
+	}{
+		{"all fetched", []string{first, second}, 2, false, []bool{false, false}, []bool{false, false}},
+		{"first error cancels the rest", []string{missing, first, second}, 1, false, []bool{true, false, false}, []bool{false, true, true}},
+		{"per-repo continues past errors", []string{missing, first, second}, 1, true, []bool{true, false, false}, []bool{false, false, false}},
+		{"per-repo with concurrent jobs", []string{first, missing, second}, 3, true, []bool{false, true, false}, []bool{false, false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Jobs: tt.jobs, PerRepo: tt.perRepo, AuthMethod: config.AuthMethodNone, OutputDir: t.TempDir()}
+			for _, url := range tt.urls {
+				cfg.Repos = append(cfg.Repos, config.RepoSpec{URL: url})
+			}
+			names, err := repoNames(cfg.Repos)
+			if err != nil {
+				t.Fatalf("repoNames returned an error: %v", err)
+			}
+
+			repos := fetchRepos(context.Background(), cfg, names, nil)
+			t.Cleanup(func() {
+				for _, repo := range repos {
+					if repo.cleanup != nil {
+						repo.cleanup()
+					}
+				}
+			})
+			for i, repo := range repos {
+				cancelled := errors.Is(repo.err, context.Canceled)
+				failed := repo.err != nil && !cancelled
+				if failed != tt.failed[i] || cancelled != tt.cancelled[i] {
+					t.Errorf("Repository %s: failed %v, cancelled %v; want %v, %v (error: %v)", repo.meta.Name, failed, cancelled, tt.failed[i], tt.cancelled[i], repo.err)
+				}
+				if repo.err == nil && repo.meta.Commit == "" {
+					t.Errorf("Expected repository %s to record its commit", repo.meta.Name)
+				}
+			}
+
+			err = firstError(repos)
+			if wantErr := slices.Contains(tt.failed, true); (err != nil) != wantErr || (err != nil && errors.Is(err, context.Canceled)) {
+				t.Errorf("firstError() = %v; want the error of the failed repository: %v", err, wantErr)
+			}
+			if !tt.perRepo {
+				return
+			}
+
+			err = writePerRepo(repos, "run", cfg)
+			if err == nil || !strings.Contains(err.Error(), "1 of 3 repositories could not be packed") {
+				t.Errorf("Expected writePerRepo to report the failed repository, got %v", err)
+			}
+			for i, repo := range repos {
+				_, statErr := os.Stat(filepath.Join(cfg.OutputDir, repo.meta.Name+".txt"))
+				if (statErr == nil) == tt.failed[i] {
+					t.Errorf("Expected an output for %s only if it was fetched, got %v", repo.meta.Name, statErr)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(cfg.OutputDir, "run.index.txt")); err != nil {
+				t.Errorf("Expected the index to be written: %v", err)
+			}
+		})
+	}
+}
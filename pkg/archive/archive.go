@@ -0,0 +1,321 @@
+// Package archive opens tarballs, zip archives and git bundles as read-only file systems, so
+// that they can be packed like a directory without extracting them to disk.
+//
+// Archives come from outside the repository and are treated as untrusted: entries whose names
+// leave the archive root (zip-slip) are rejected, and the total size of the extracted files is
+// capped so that a small archive cannot expand without bound (size bomb).
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Formats of the archives that can be opened.
+const (
+	FormatTar    = "tar"    // Tarball, optionally compressed with gzip or bzip2
+	FormatZip    = "zip"    // Zip archive
+	FormatBundle = "bundle" // Git bundle created with git bundle create
+)
+
+// DefaultMaxSize is the total size in bytes of the files an archive may expand to when no
+// limit is given.
+const DefaultMaxSize = 1 << 30
+
+// maxEntries is the number of entries an archive may hold, or objects a bundle may hold.
+const maxEntries = 1 << 20
+
+var (
+	// ErrUnsafePath is returned for archives with entries that are absolute or leave the
+	// archive root with "..".
+	ErrUnsafePath = errors.New("unsafe path in archive")
+
+	// ErrTooLarge is returned for archives that expand beyond the size limit or hold too many entries.
+	ErrTooLarge = errors.New("archive exceeds the size limit")
+
+	// ErrUnsupported is returned for files that are not a tarball, zip archive or git bundle.
+	ErrUnsupported = errors.New("unsupported archive format: expected a .tar, .tar.gz, .tar.bz2 or .zip archive or a git bundle")
+)
+
+// Options configures how an archive is opened.
+type Options struct {
+	Ref     string // Branch, tag or commit SHA to pack from a git bundle; empty selects the bundle's HEAD
+	MaxSize int64  // Total size in bytes the files may expand to; 0 selects DefaultMaxSize
+}
+
+// Archive is an opened archive. Tarballs are read into memory when opened, zip archives are
+// read from disk as their files are opened, and git bundles are loaded into an in-memory
+// repository whose commit is packed like an in-memory clone.
+type Archive struct {
+	Format string          // Format of the archive: FormatTar, FormatZip or FormatBundle
+	FS     fs.FS           // Files of a tarball or zip archive; nil for git bundles
+	Repo   *git.Repository // Repository loaded from a git bundle; nil for other formats
+	Commit plumbing.Hash   // Commit of a git bundle to pack; zero for other formats
+
+	closer io.Closer // Underlying file of a zip archive; nil otherwise
+}
+
+// Open opens the archive at path, detecting its format from its content rather than its name.
+// When every entry of a tarball or zip archive lies under a single top-level directory, as in
+// the archives downloaded from Git hosts, that directory becomes the root of the file system.
+//
+// Parameters:
+//   - path: The path of the archive.
+//   - opts: The bundle ref and size limit.
+//
+// Returns:
+//   - *Archive: The opened archive, which must be closed.
+//   - error: An error if the file cannot be read, is not a supported archive, is unsafe or too large,
+//     or the ref is not in the bundle.
+func Open(path string, opts Options) (*Archive, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open archive: %w", err)
+	}
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	reader := bufio.NewReader(file)
+	format, compressed, err := detect(reader)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Ref != "" && format != FormatBundle {
+		return nil, fmt.Errorf("a ref can only be selected from a git bundle, not a %s archive", format)
+	}
+
+	switch format {
+	case FormatBundle:
+		repo, commit, err := readBundle(reader, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &Archive{Format: format, Repo: repo, Commit: commit}, nil
+	case FormatZip:
+		info, err := file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("unable to open archive: %w", err)
+		}
+		fsys, err := readZip(file, info.Size(), opts.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		a := &Archive{Format: format, FS: fsys, closer: file}
+		file = nil // Kept open to read the files of the archive
+		return a, nil
+	default:
+		fsys, err := readTar(compressed, opts.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		return &Archive{Format: format, FS: fsys}, nil
+	}
+}
+
+// Close releases the file of a zip archive. Other archives hold nothing open.
+//
+// Returns:
+//   - error: An error if the file cannot be closed.
+func (a *Archive) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// Name derives a repository name from the file name of an archive by removing its extensions,
+// e.g. "project-main" for project-main.tar.gz.
+//
+// Parameters:
+//   - archivePath: The path of the archive.
+//
+// Returns:
+//   - string: The name of the repository.
+//   - error: An error if no name is left.
+func Name(archivePath string) (string, error) {
+	name := filepath.Base(archivePath)
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tar.bz2", ".tgz", ".tbz2", ".tar", ".zip", ".bundle"} {
+		if strings.HasSuffix(lower, ext) {
+			name = name[:len(name)-len(ext)]
+			break
+		}
+	}
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		return "", errors.New("could not determine repository name from archive path")
+	}
+	return name, nil
+}
+
+// Magic numbers identifying the supported formats.
+var (
+	gzipMagic     = []byte{0x1f, 0x8b}
+	bzip2Magic    = []byte("BZh")
+	zipMagic      = []byte("PK\x03\x04")
+	emptyZipMagic = []byte("PK\x05\x06")
+	bundleMagic   = [][]byte{[]byte("# v2 git bundle\n"), []byte("# v3 git bundle\n")}
+	tarMagic      = []byte("ustar")
+)
+
+// tarMagicOffset is the offset of the magic number in the header of a POSIX tarball.
+const tarMagicOffset = 257
+
+// detect identifies the format of an archive from its first bytes.
+//
+// Parameters:
+//   - r: The reader positioned at the start of the archive; it is only advanced for compressed tarballs.
+//
+// Returns:
+//   - string: The format of the archive.
+//   - io.Reader: For tarballs, the reader of the uncompressed tarball; nil otherwise.
+//   - error: ErrUnsupported if the format is not recognized.
+func detect(r *bufio.Reader) (string, io.Reader, error) {
+	head, _ := r.Peek(tarMagicOffset + len(tarMagic))
+	switch {
+	case bytes.HasPrefix(head, zipMagic), bytes.HasPrefix(head, emptyZipMagic):
+		return FormatZip, nil, nil
+	case bytes.HasPrefix(head, bundleMagic[0]), bytes.HasPrefix(head, bundleMagic[1]):
+		return FormatBundle, nil, nil
+	case bytes.HasPrefix(head, gzipMagic):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to decompress archive: %w", err)
+		}
+		return FormatTar, gz, nil
+	case bytes.HasPrefix(head, bzip2Magic):
+		return FormatTar, bzip2.NewReader(r), nil
+	case len(head) > tarMagicOffset && bytes.HasPrefix(head[tarMagicOffset:], tarMagic):
+		return FormatTar, r, nil
+	}
+	return "", nil, ErrUnsupported
+}
+
+// readTar reads the files of a tarball into memory. Directories, regular files, hard links and
+// symbolic links are kept; other entries such as devices are ignored.
+//
+// Parameters:
+//   - r: The reader of the uncompressed tarball.
+//   - maxSize: The total size in bytes the files may expand to.
+//
+// Returns:
+//   - fs.FS: The files of the tarball.
+//   - error: An error if the tarball is malformed, unsafe or too large.
+func readTar(r io.Reader, maxSize int64) (fs.FS, error) {
+	b := newBuilder(maxSize)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read tarball: %w", err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = b.addDir(hdr.Name, hdr.ModTime)
+		case tar.TypeReg, tar.TypeRegA:
+			err = b.addFile(hdr.Name, fs.FileMode(hdr.Mode).Perm(), hdr.ModTime, hdr.Size, func() ([]byte, error) {
+				data := make([]byte, hdr.Size)
+				_, err := io.ReadFull(tr, data)
+				return data, err
+			})
+		case tar.TypeLink:
+			err = b.addHardLink(hdr.Name, hdr.Linkname, hdr.ModTime)
+		case tar.TypeSymlink:
+			err = b.addSymlink(hdr.Name, int64(len(hdr.Linkname)), hdr.ModTime)
+		default:
+			continue // Devices, FIFOs and global headers hold no repository files
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.build(), nil
+}
+
+// readZip lists the files of a zip archive. Their contents are decompressed when opened, and
+// never beyond the size recorded in the archive, so the recorded sizes are checked up front.
+//
+// Parameters:
+//   - r: The zip archive.
+//   - size: The size of the zip archive in bytes.
+//   - maxSize: The total size in bytes the files may expand to.
+//
+// Returns:
+//   - fs.FS: The files of the zip archive.
+//   - error: An error if the archive is malformed, unsafe or too large.
+func readZip(r io.ReaderAt, size, maxSize int64) (fs.FS, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return nil, fmt.Errorf("unable to read zip archive: %w", err)
+	}
+
+	b := newBuilder(maxSize)
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = b.addDir(f.Name, f.Modified)
+		case mode&fs.ModeSymlink != 0:
+			err = b.addSymlink(f.Name, int64(f.UncompressedSize64), f.Modified)
+		case mode.IsRegular():
+			err = b.addLazyFile(f.Name, mode.Perm(), f.Modified, int64(f.UncompressedSize64), f.Open)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.build(), nil
+}
+
+// cleanName validates the name of an archive entry and returns it as a path accepted by
+// fs.ValidPath. Backslashes, written by some Windows tools, are treated as separators.
+//
+// Parameters:
+//   - name: The name of the entry as recorded in the archive.
+//
+// Returns:
+//   - string: The cleaned path; "." for the archive root.
+//   - error: ErrUnsafePath if the name is absolute or leaves the archive root.
+func cleanName(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(slashed, "/") || (len(slashed) >= 2 && slashed[1] == ':') {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	for _, elem := range strings.Split(slashed, "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+		}
+	}
+	cleaned := path.Clean(slashed)
+	if !fs.ValidPath(cleaned) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,300 @@
+// Package archive_test contains unit tests for the archive package.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testEntry is an entry written to a test archive.
+type testEntry struct {
+	name    string
+	content string
+	dir     bool
+	symlink string // Target of a symbolic link
+	size    int64  // Size recorded in the header; 0 records the length of content
+}
+
+// writeTar writes a tarball of the entries, compressed with gzip if compress is set.
+func writeTar(t *testing.T, entries []testEntry, compress bool) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, ModTime: modTime, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0o755, 0
+		case e.symlink != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.symlink, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatalf("Failed to write tar entry: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tarball: %v", err)
+	}
+
+	data, name := buf.Bytes(), "project-main.tar"
+	if compress {
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		zw.Write(data)
+		zw.Close()
+		data, name = gz.Bytes(), "project-main.tar.gz"
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	return path
+}
+
+// writeZip writes a zip archive of the entries.
+func writeZip(t *testing.T, entries []testEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+		switch {
+		case e.dir:
+			hdr.Name = strings.TrimSuffix(e.name, "/") + "/"
+			hdr.SetMode(fs.ModeDir | 0o755)
+		case e.symlink != "":
+			hdr.SetMode(fs.ModeSymlink | 0o777)
+			e.content = e.symlink
+		default:
+			hdr.SetMode(0o644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatalf("Failed to write zip header: %v", err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatalf("Failed to write zip entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close zip archive: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "project-main.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	return path
+}
+
+// TestOpen verifies that tarballs and zip archives are read as file systems rooted below their
+// single top-level directory, with symbolic links listed but not followed.
+func TestOpen(t *testing.T) {
+	entries := []testEntry{
+		{name: "project-main/", dir: true},
+		{name: "project-main/README.md", content: "# Project\n"},
+		{name: "./project-main/src/main.go", content: "package main\n"},
+		{name: `project-main\docs\guide.md`, content: "Guide\n"},
+	}
+	withLink := append(append([]testEntry(nil), entries...), testEntry{name: "project-main/link", symlink: "/etc/passwd"})
+
+	testCases := []struct {
+		name   string
+		path   string
+		format string
+	}{
+		{"tar", writeTar(t, entries, false), FormatTar},
+		{"tar.gz", writeTar(t, entries, true), FormatTar},
+		{"zip", writeZip(t, entries), FormatZip},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := Open(tc.path, Options{})
+			if err != nil {
+				t.Fatalf("Open returned an error: %v", err)
+			}
+			defer a.Close()
+			if a.Format != tc.format {
+				t.Errorf("Format = %s; want %s", a.Format, tc.format)
+			}
+			if err := fstest.TestFS(a.FS, "README.md", "src/main.go", "docs/guide.md"); err != nil {
+				t.Error(err)
+			}
+			content, err := fs.ReadFile(a.FS, "src/main.go")
+			if err != nil || string(content) != "package main\n" {
+				t.Errorf("src/main.go = %q, %v", content, err)
+			}
+		})
+	}
+
+	for _, path := range []string{writeTar(t, withLink, true), writeZip(t, withLink)} {
+		a, err := Open(path, Options{})
+		if err != nil {
+			t.Fatalf("Open(%s) returned an error: %v", filepath.Base(path), err)
+		}
+		if _, err := a.FS.Open("link"); !errors.Is(err, errSymlink) {
+			t.Errorf("Opening a symbolic link in %s returned %v; want errSymlink", filepath.Base(path), err)
+		}
+		a.Close()
+	}
+}
+
+// TestOpenUnsafe verifies that archives with entries outside the archive root are rejected,
+// and that archives too large for the size limit are rejected before they are expanded.
+func TestOpenUnsafe(t *testing.T) {
+	zeros := strings.Repeat("\x00", 1<<20)
+
+	testCases := []struct {
+		name    string
+		path    string
+		maxSize int64
+		want    error
+	}{
+		{"tar parent", writeTar(t, []testEntry{{name: "../evil.sh", content: "rm -rf /\n"}}, true), 0, ErrUnsafePath},
+		{"tar absolute", writeTar(t, []testEntry{{name: "/etc/cron.d/evil", content: "x"}}, false), 0, ErrUnsafePath},
+		{"zip parent", writeZip(t, []testEntry{{name: "ok/../../evil.sh", content: "x"}}), 0, ErrUnsafePath},
+		{"zip backslash", writeZip(t, []testEntry{{name: `..\evil.bat`, content: "x"}}), 0, ErrUnsafePath},
+		{"zip drive", writeZip(t, []testEntry{{name: `C:\evil.bat`, content: "x"}}), 0, ErrUnsafePath},
+		{"tar bomb", writeTar(t, []testEntry{{name: "a", content: zeros}, {name: "b", content: zeros}}, true), 3 << 19, ErrTooLarge},
+		{"zip bomb", writeZip(t, []testEntry{{name: "a", content: zeros}, {name: "b", content: zeros}}), 3 << 19, ErrTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := Open(tc.path, Options{MaxSize: tc.maxSize})
+			if err == nil {
+				a.Close()
+			}
+			if !errors.Is(err, tc.want) {
+				t.Errorf("Open returned %v; want %v", err, tc.want)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte("not an archive"), 0o644)
+	if _, err := Open(path, Options{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Open of a text file returned %v; want ErrUnsupported", err)
+	}
+	if _, err := Open(writeZip(t, nil), Options{Ref: "main"}); err == nil {
+		t.Error("Expected an error selecting a ref from a zip archive")
+	}
+}
+
+// TestOpenBundle verifies that git bundles are loaded into memory at their HEAD or a given
+// ref, and that incremental bundles are rejected.
+func TestOpenBundle(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("Skipping bundle test; git is not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com", "-c", "init.defaultBranch=main"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main // v1\n"), 0o644)
+	git("add", ".")
+	git("commit", "-q", "-m", "first")
+	git("tag", "v1.0.0")
+	first := git("rev-parse", "HEAD")
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main // v2\n"), 0o644)
+	git("commit", "-q", "-am", "second")
+	second := git("rev-parse", "HEAD")
+
+	full := filepath.Join(t.TempDir(), "project.bundle")
+	git("bundle", "create", full, "--all")
+	incremental := filepath.Join(t.TempDir(), "incremental.bundle")
+	git("bundle", "create", incremental, "v1.0.0..main")
+
+	testCases := []struct {
+		ref     string
+		commit  string
+		content string
+	}{
+		{"", second, "package main // v2\n"},
+		{"v1.0.0", first, "package main // v1\n"},
+		{"main", second, "package main // v2\n"},
+	}
+
+	for _, tc := range testCases {
+		a, err := Open(full, Options{Ref: tc.ref})
+		if err != nil {
+			t.Fatalf("Open(%q) returned an error: %v", tc.ref, err)
+		}
+		if a.Format != FormatBundle || a.Commit.String() != tc.commit {
+			t.Errorf("Open(%q) = %s at %s; want a bundle at %s", tc.ref, a.Format, a.Commit, tc.commit)
+		}
+		c, err := a.Repo.CommitObject(a.Commit)
+		if err != nil {
+			t.Fatalf("Unable to read commit %s: %v", a.Commit, err)
+		}
+		f, err := c.File("main.go")
+		if err != nil {
+			t.Fatalf("Unable to find main.go: %v", err)
+		}
+		if content, _ := f.Contents(); content != tc.content {
+			t.Errorf("Open(%q) main.go = %q; want %q", tc.ref, content, tc.content)
+		}
+	}
+
+	if _, err := Open(full, Options{Ref: "missing"}); err == nil {
+		t.Error("Expected an error for a ref that is not in the bundle")
+	}
+	if _, err := Open(full, Options{MaxSize: 64}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Open with a tiny size limit returned %v; want ErrTooLarge", err)
+	}
+	if _, err := Open(incremental, Options{}); err == nil || !strings.Contains(err.Error(), "incremental") {
+		t.Errorf("Open of an incremental bundle returned %v; want an error", err)
+	}
+}
+
+// TestName verifies that repository names are derived from archive file names.
+func TestName(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected string
+		wantErr  bool
+	}{
+		{"/tmp/project-main.tar.gz", "project-main", false},
+		{"project.TGZ", "project", false},
+		{"downloads/project.zip", "project", false},
+		{"project.tar.bz2", "project", false},
+		{"project.bundle", "project", false},
+		{"project.snapshot", "project.snapshot", false},
+		{".zip", "", true},
+	}
+
+	for _, tc := range testCases {
+		name, err := Name(tc.path)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Name(%q) error = %v, wantErr %v", tc.path, err, tc.wantErr)
+			continue
+		}
+		if name != tc.expected {
+			t.Errorf("Name(%q) = %q; want %q", tc.path, name, tc.expected)
+		}
+	}
+}
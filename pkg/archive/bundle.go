@@ -0,0 +1,157 @@
+package archive
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/util"
+)
+
+// readBundle loads a git bundle into an in-memory repository and resolves the commit to pack.
+// A bundle is a header listing its refs followed by a packfile. Incremental bundles, which
+// depend on commits they do not hold, and filtered bundles, which lack objects, are rejected.
+//
+// Parameters:
+//   - r: The reader of the bundle, positioned at its first line.
+//   - opts: The ref to pack and the total size the objects may expand to.
+//
+// Returns:
+//   - *git.Repository: The repository holding the objects and refs of the bundle.
+//   - plumbing.Hash: The commit to pack.
+//   - error: An error if the bundle is malformed, incomplete or too large, or the ref is not in it.
+func readBundle(r *bufio.Reader, opts Options) (*git.Repository, plumbing.Hash, error) {
+	refs, err := readBundleHeader(r)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	storage := &limitedStorage{Storage: memory.NewStorage(), maxSize: opts.MaxSize}
+	parser, err := packfile.NewParserWithStorage(packfile.NewScanner(r), storage)
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("unable to read bundle: %w", err)
+	}
+	if _, err := parser.Parse(); err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("unable to read bundle: %w", err)
+	}
+
+	for _, ref := range refs {
+		if err := storage.SetReference(ref); err != nil {
+			return nil, plumbing.ZeroHash, fmt.Errorf("unable to read bundle: %w", err)
+		}
+	}
+	if _, err := storage.Reference(plumbing.HEAD); err != nil {
+		head := bundleHead(refs)
+		if head == "" {
+			return nil, plumbing.ZeroHash, errors.New("bundle has no refs")
+		}
+		if err := storage.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, head)); err != nil {
+			return nil, plumbing.ZeroHash, fmt.Errorf("unable to read bundle: %w", err)
+		}
+	}
+
+	repo, err := git.Open(storage.Storage, nil)
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("unable to read bundle: %w", err)
+	}
+	commit, err := clone.ResolveRef(repo, opts.Ref)
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("unable to resolve %q in bundle: %w", opts.Ref, err)
+	}
+	return repo, commit, nil
+}
+
+// readBundleHeader reads the header of a version 2 or 3 bundle up to the blank line before the
+// packfile.
+//
+// Parameters:
+//   - r: The reader of the bundle, positioned at its first line.
+//
+// Returns:
+//   - []*plumbing.Reference: The refs recorded in the bundle, in order.
+//   - error: An error if the header is malformed, or the bundle is incremental, filtered or not SHA-1.
+func readBundleHeader(r *bufio.Reader) ([]*plumbing.Reference, error) {
+	var refs []*plumbing.Reference
+	for first := true; ; first = false {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("unable to read bundle header: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case first:
+			continue // The signature, checked by detect
+		case line == "":
+			return refs, nil
+		case strings.HasPrefix(line, "@"):
+			capability, value, _ := strings.Cut(line[1:], "=")
+			switch {
+			case capability == "object-format" && value == "sha1":
+			case capability == "object-format":
+				return nil, fmt.Errorf("bundle uses the unsupported object format %s", value)
+			case capability == "filter":
+				return nil, errors.New("bundle was created with a filter and lacks objects; create it without --filter")
+			default:
+				return nil, fmt.Errorf("bundle requires the unsupported capability %s", capability)
+			}
+		case strings.HasPrefix(line, "-"):
+			return nil, errors.New("bundle is incremental and depends on commits it does not hold; create it with git bundle create <file> --all")
+		default:
+			hash, name, ok := strings.Cut(line, " ")
+			if !ok || !plumbing.IsHash(hash) {
+				return nil, fmt.Errorf("malformed bundle ref %q", line)
+			}
+			refs = append(refs, plumbing.NewHashReference(plumbing.ReferenceName(name), plumbing.NewHash(hash)))
+		}
+	}
+}
+
+// bundleHead picks the branch checked out from a bundle without a HEAD ref: main or master if
+// present, otherwise the first branch, or the first ref when there are no branches.
+func bundleHead(refs []*plumbing.Reference) plumbing.ReferenceName {
+	for _, name := range []plumbing.ReferenceName{plumbing.Main, plumbing.Master} {
+		for _, ref := range refs {
+			if ref.Name() == name {
+				return name
+			}
+		}
+	}
+	for _, ref := range refs {
+		if ref.Name().IsBranch() {
+			return ref.Name()
+		}
+	}
+	if len(refs) > 0 {
+		return refs[0].Name()
+	}
+	return ""
+}
+
+// limitedStorage is an in-memory object store that fails once the objects written to it
+// exceed maxSize bytes in total, so that a small packfile cannot expand without bound.
+type limitedStorage struct {
+	*memory.Storage
+	size    int64
+	objects int
+	maxSize int64
+}
+
+// SetEncodedObject stores an object after counting it against the limits.
+func (s *limitedStorage) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	s.size += obj.Size()
+	if s.objects++; s.size > s.maxSize || s.objects > maxEntries {
+		return plumbing.ZeroHash, fmt.Errorf("%w: the bundle expands beyond %s or %d objects", ErrTooLarge, util.FormatSize(s.maxSize), maxEntries)
+	}
+	return s.Storage.SetEncodedObject(obj)
+}
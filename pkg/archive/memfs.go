@@ -0,0 +1,322 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/util"
+)
+
+// errSymlink is reported when opening a symbolic link of an archive. Links are listed but not
+// followed, since their targets may lie outside the archive.
+var errSymlink = errors.New("symbolic links in archives are not followed")
+
+// memEntry is a file, directory or symbolic link of an archive.
+type memEntry struct {
+	info     memInfo
+	data     []byte                        // Content of a file read into memory
+	open     func() (io.ReadCloser, error) // Opens the content of a file read on demand
+	children []fs.DirEntry                 // Entries of a directory, sorted by name
+}
+
+// builder collects the entries of an archive, enforcing the path and size rules.
+type builder struct {
+	entries map[string]*memEntry
+	size    int64 // Total size of the files added so far
+	maxSize int64
+}
+
+// newBuilder returns a builder for an archive that may expand to maxSize bytes.
+func newBuilder(maxSize int64) *builder {
+	root := &memEntry{info: memInfo{name: ".", mode: fs.ModeDir | 0o755}}
+	return &builder{entries: map[string]*memEntry{".": root}, maxSize: maxSize}
+}
+
+// addDir adds a directory.
+func (b *builder) addDir(name string, modTime time.Time) error {
+	clean, err := b.place(name, true)
+	if err != nil || clean == "." {
+		return err
+	}
+	b.entries[clean] = &memEntry{info: memInfo{name: path.Base(clean), mode: fs.ModeDir | 0o755, modTime: modTime}}
+	return nil
+}
+
+// addFile adds a file whose content is read by read, after checking its size against the limit.
+func (b *builder) addFile(name string, perm fs.FileMode, modTime time.Time, size int64, read func() ([]byte, error)) error {
+	clean, err := b.reserve(name, size)
+	if err != nil {
+		return err
+	}
+	data, err := read()
+	if err != nil {
+		return fmt.Errorf("unable to read %s from archive: %w", name, err)
+	}
+	b.entries[clean] = &memEntry{info: memInfo{name: path.Base(clean), size: size, mode: perm, modTime: modTime}, data: data}
+	return nil
+}
+
+// addLazyFile adds a file whose content is opened on demand and never exceeds size bytes.
+func (b *builder) addLazyFile(name string, perm fs.FileMode, modTime time.Time, size int64, open func() (io.ReadCloser, error)) error {
+	clean, err := b.reserve(name, size)
+	if err != nil {
+		return err
+	}
+	b.entries[clean] = &memEntry{info: memInfo{name: path.Base(clean), size: size, mode: perm, modTime: modTime}, open: open}
+	return nil
+}
+
+// addHardLink adds a copy of a file added earlier, as tar stores hard links.
+func (b *builder) addHardLink(name, target string, modTime time.Time) error {
+	cleanTarget, err := cleanName(target)
+	if err != nil {
+		return err
+	}
+	original, ok := b.entries[cleanTarget]
+	if !ok || !original.info.mode.IsRegular() {
+		return fmt.Errorf("hard link %s points to %s, which is not a file earlier in the archive", name, target)
+	}
+	return b.addFile(name, original.info.mode, modTime, original.info.size, func() ([]byte, error) { return original.data, nil })
+}
+
+// addSymlink adds a symbolic link, which is listed but never followed. Its size is the length
+// of its target.
+func (b *builder) addSymlink(name string, size int64, modTime time.Time) error {
+	clean, err := b.place(name, false)
+	if err != nil {
+		return err
+	}
+	b.entries[clean] = &memEntry{info: memInfo{name: path.Base(clean), size: size, mode: fs.ModeSymlink | 0o777, modTime: modTime}}
+	return nil
+}
+
+// reserve places a file and counts its size against the limit.
+func (b *builder) reserve(name string, size int64) (string, error) {
+	clean, err := b.place(name, false)
+	if err != nil {
+		return "", err
+	}
+	if size < 0 || size > b.maxSize-b.size {
+		return "", fmt.Errorf("%w of %s at %s", ErrTooLarge, util.FormatSize(b.maxSize), name)
+	}
+	b.size += size
+	return clean, nil
+}
+
+// place validates the name of a new entry and creates its parent directories. An entry may
+// replace an earlier one of the same kind, as when a tarball is appended to.
+//
+// Parameters:
+//   - name: The name of the entry as recorded in the archive.
+//   - dir: Whether the entry is a directory.
+//
+// Returns:
+//   - string: The cleaned path of the entry.
+//   - error: An error if the name is unsafe, clashes with an entry of another kind, or the
+//     archive holds too many entries.
+func (b *builder) place(name string, dir bool) (string, error) {
+	clean, err := cleanName(name)
+	if err != nil {
+		return "", err
+	}
+	if clean == "." {
+		if !dir {
+			return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+		}
+		return clean, nil
+	}
+	if existing, ok := b.entries[clean]; ok && existing.info.IsDir() != dir {
+		return "", fmt.Errorf("%s is both a file and a directory in the archive", clean)
+	}
+	if len(b.entries) >= maxEntries {
+		return "", fmt.Errorf("%w of %d entries", ErrTooLarge, maxEntries)
+	}
+
+	for parent := path.Dir(clean); parent != "."; parent = path.Dir(parent) {
+		existing, ok := b.entries[parent]
+		if ok && !existing.info.IsDir() {
+			return "", fmt.Errorf("%s is both a file and a directory in the archive", parent)
+		}
+		if ok {
+			break
+		}
+		b.entries[parent] = &memEntry{info: memInfo{name: path.Base(parent), mode: fs.ModeDir | 0o755}}
+	}
+	return clean, nil
+}
+
+// build links every entry to its directory and returns the file system. When the root holds a
+// single directory and nothing else, that directory becomes the root.
+func (b *builder) build() *memFS {
+	entries := b.entries
+	if root := b.singleDir(); root != "" {
+		entries = make(map[string]*memEntry, len(b.entries))
+		prefix := root + "/"
+		for name, e := range b.entries {
+			switch {
+			case name == root:
+				e.info.name = "."
+				entries["."] = e
+			case len(name) > len(prefix) && name[:len(prefix)] == prefix:
+				entries[name[len(prefix):]] = e
+			}
+		}
+	}
+
+	for name, e := range entries {
+		if name != "." {
+			parent := entries[path.Dir(name)]
+			parent.children = append(parent.children, fs.FileInfoToDirEntry(&e.info))
+		}
+	}
+	for _, e := range entries {
+		sort.Slice(e.children, func(i, j int) bool { return e.children[i].Name() < e.children[j].Name() })
+	}
+	return &memFS{entries: entries}
+}
+
+// singleDir returns the only top-level entry of the archive if it is a directory, or "".
+func (b *builder) singleDir() string {
+	var top string
+	for name := range b.entries {
+		if name == "." || path.Dir(name) != "." {
+			continue
+		}
+		if top != "" {
+			return ""
+		}
+		top = name
+	}
+	if top == "" || !b.entries[top].info.IsDir() {
+		return ""
+	}
+	return top
+}
+
+// memFS is a read-only file system over the entries of an archive.
+type memFS struct {
+	entries map[string]*memEntry
+}
+
+// Open opens the named file or directory. Symbolic links cannot be opened.
+func (m *memFS) Open(name string) (fs.File, error) {
+	e, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case e.info.IsDir():
+		return &memDir{info: &e.info, entries: e.children}, nil
+	case e.open != nil:
+		rc, err := e.open()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &memFile{info: &e.info, reader: rc, closer: rc}, nil
+	default:
+		return &memFile{info: &e.info, reader: bytes.NewReader(e.data)}, nil
+	}
+}
+
+// ReadDir lists the named directory, sorted by file name.
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return append([]fs.DirEntry(nil), e.children...), nil
+}
+
+// Stat returns the file information of the named file. Symbolic links cannot be followed.
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	e, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return &e.info, nil
+}
+
+// lookup finds the entry of a path. Symbolic links are reported with errSymlink.
+func (m *memFS) lookup(op, name string) (*memEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := m.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if e.info.mode&fs.ModeSymlink != 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: errSymlink}
+	}
+	return e, nil
+}
+
+// memInfo describes an archive entry as fs.FileInfo.
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memInfo) ModTime() time.Time { return i.modTime }
+func (i *memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memInfo) Sys() any           { return nil }
+
+// memFile is an open file of a memFS.
+type memFile struct {
+	info   fs.FileInfo
+	reader io.Reader
+	closer io.Closer // Closes the content of a file read on demand; nil for files in memory
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Read(b []byte) (int, error) { return f.reader.Read(b) }
+
+func (f *memFile) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
+
+// memDir is an open directory of a memFS.
+type memDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries of the directory, or all remaining entries if n <= 0.
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
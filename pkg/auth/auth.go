@@ -4,40 +4,86 @@ package auth
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing/transport"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
 )
 
 // SetupAuth prepares the appropriate authentication method based on the provided configuration.
 // It returns a transport.AuthMethod suitable for the chosen authentication type or an error if the setup fails.
+// HTTPS authentication needs only a token: when none is configured it is looked up with
+// LookupCredentials, and a token without a username is sent as described by HTTPAuth.
+// SSH authentication offers the -ssh-key key, ssh-agent keys and the keys named in ~/.ssh/config
+// or found in ~/.ssh, and verifies the host key against known_hosts, as described by setupSSHAuth.
 //
 // Parameters:
 //   - cfg: A pointer to the Config struct containing authentication details.
+//   - notices: Destination for notices such as host keys added to known_hosts; nil discards them.
 //
 // Returns:
 //   - transport.AuthMethod: The configured authentication method.
 //   - error: An error if the authentication setup fails.
-func SetupAuth(cfg *config.Config) (transport.AuthMethod, error) {
+func SetupAuth(cfg *config.Config, notices io.Writer) (transport.AuthMethod, error) {
 	switch cfg.AuthMethod {
 	case config.AuthMethodHTTPS:
-		if cfg.Username == "" || cfg.PersonalAccessToken == "" {
-			return nil, errors.New("username and personal access token must be provided for HTTPS authentication")
+		username, token := cfg.Username, cfg.PersonalAccessToken
+		if token == "" {
+			creds := LookupCredentials(cfg.RepoURL, username, true)
+			if creds == nil && !strings.HasPrefix(strings.ToLower(cfg.RepoURL), "https://") {
+				return nil, errors.New("no token found for HTTPS authentication: stored tokens are only sent over https://, so pass -pat or use an https:// URL")
+			}
+			if creds == nil {
+				return nil, fmt.Errorf("no token found for HTTPS authentication: pass -pat, set %s or a host token variable such as GITHUB_TOKEN, add the host to ~/.netrc, or configure a git credential helper", TokenEnvVar)
+			}
+			token = creds.Token
+			if username == "" {
+				username = creds.Username
+			}
 		}
-		return &http.BasicAuth{
-			Username: cfg.Username,
-			Password: cfg.PersonalAccessToken,
-		}, nil
+		return HTTPAuth(cfg.RepoURL, username, token), nil
 	case config.AuthMethodSSH:
-		if cfg.SSHPassphrase != "" {
-			return ssh.NewPublicKeys(config.DefaultSSHKeyName, []byte(cfg.SSHPassphrase), cfg.SSHKeyPath)
-		}
-		return ssh.NewPublicKeysFromFile(config.DefaultSSHKeyName, cfg.SSHKeyPath, "")
+		return setupSSHAuth(cfg, notices)
 	case config.AuthMethodNone:
 		return nil, nil
 	default:
 		return nil, errors.New("unsupported authentication method")
 	}
 }
+
+// ForURL returns the authentication method for a repository the user did not name, such as a
+// submodule listed in a repository's .gitmodules, without prompting. HTTPS repositories use the
+// token bound to their host found by LookupHostCredentials, or none; SSH repositories use the
+// configured key, ssh-agent and discovered keys with the configured host key checking; other
+// URLs need no authentication.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct containing the SSH settings.
+//   - repoURL: The URL of the repository to authenticate to.
+//   - notices: Destination for notices such as host keys added to known_hosts; nil discards them.
+//
+// Returns:
+//   - transport.AuthMethod: The authentication method; nil for anonymous clones.
+//   - error: An error if the SSH setup fails.
+func ForURL(cfg *config.Config, repoURL string, notices io.Writer) (transport.AuthMethod, error) {
+	u, err := clone.ParseRepoURL(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.IsHTTP():
+		if creds := LookupHostCredentials(repoURL); creds != nil {
+			return HTTPAuth(repoURL, creds.Username, creds.Token), nil
+		}
+		return nil, nil
+	case u.IsSSH():
+		sshCfg := *cfg
+		sshCfg.RepoURL, sshCfg.AuthMethod = repoURL, config.AuthMethodSSH
+		return setupSSHAuth(&sshCfg, notices)
+	default:
+		return nil, nil
+	}
+}
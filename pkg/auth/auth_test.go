@@ -2,10 +2,25 @@
 package auth
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh" // Added import for ssh package
+	"github.com/skeema/knownhosts"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 // TestSetupAuth verifies that the SetupAuth function correctly sets up the authentication method
@@ -17,7 +32,7 @@ func TestSetupAuth(t *testing.T) {
 		Username:            "testuser",
 		PersonalAccessToken: "testtoken",
 	}
-	authMethodHTTPS, err := SetupAuth(cfgHTTPS)
+	authMethodHTTPS, err := SetupAuth(cfgHTTPS, nil)
 	if err != nil {
 		t.Fatalf("SetupAuth returned an error: %v", err)
 	}
@@ -34,11 +49,15 @@ func TestSetupAuth(t *testing.T) {
 	}
 
 	// Test SSH Authentication without passphrase
+	home := setupSSHHome(t)
+	keyPath := filepath.Join(home, "deploy_key")
+	writeTestKey(t, keyPath, "")
 	cfgSSH := &config.Config{
 		AuthMethod: config.AuthMethodSSH,
-		SSHKeyPath: "/path/to/ssh/key",
+		RepoURL:    "git@github.com:user/repo.git",
+		SSHKeyPath: keyPath,
 	}
-	authMethodSSH, err := SetupAuth(cfgSSH)
+	authMethodSSH, err := SetupAuth(cfgSSH, nil)
 	if err != nil {
 		t.Fatalf("SetupAuth returned an error: %v", err)
 	}
@@ -47,18 +66,21 @@ func TestSetupAuth(t *testing.T) {
 		t.Errorf("Expected SSH AuthMethod, got nil")
 	}
 
-	// Assert that authMethodSSH is of type *ssh.PublicKeys
-	if _, ok := authMethodSSH.(*ssh.PublicKeys); !ok {
-		t.Errorf("Expected SSH AuthMethod to be of type *ssh.PublicKeys, got %T", authMethodSSH)
+	// Assert that authMethodSSH is of type *ssh.PublicKeysCallback
+	if _, ok := authMethodSSH.(*ssh.PublicKeysCallback); !ok {
+		t.Errorf("Expected SSH AuthMethod to be of type *ssh.PublicKeysCallback, got %T", authMethodSSH)
 	}
 
 	// Test SSH Authentication with passphrase
+	encryptedKeyPath := filepath.Join(home, "encrypted_key")
+	writeTestKey(t, encryptedKeyPath, "passphrase")
 	cfgSSHPass := &config.Config{
 		AuthMethod:    config.AuthMethodSSH,
-		SSHKeyPath:    "/path/to/ssh/key",
+		RepoURL:       "git@github.com:user/repo.git",
+		SSHKeyPath:    encryptedKeyPath,
 		SSHPassphrase: "passphrase",
 	}
-	authMethodSSHPass, err := SetupAuth(cfgSSHPass)
+	authMethodSSHPass, err := SetupAuth(cfgSSHPass, nil)
 	if err != nil {
 		t.Fatalf("SetupAuth returned an error: %v", err)
 	}
@@ -67,16 +89,22 @@ func TestSetupAuth(t *testing.T) {
 		t.Errorf("Expected SSH AuthMethod with passphrase, got nil")
 	}
 
-	// Assert that authMethodSSHPass is of type *ssh.PublicKeys
-	if _, ok := authMethodSSHPass.(*ssh.PublicKeys); !ok {
-		t.Errorf("Expected SSH AuthMethod to be of type *ssh.PublicKeys, got %T", authMethodSSHPass)
+	// Assert that authMethodSSHPass is of type *ssh.PublicKeysCallback
+	if _, ok := authMethodSSHPass.(*ssh.PublicKeysCallback); !ok {
+		t.Errorf("Expected SSH AuthMethod to be of type *ssh.PublicKeysCallback, got %T", authMethodSSHPass)
+	}
+
+	// Test SSH Authentication with a wrong passphrase
+	cfgSSHPass.SSHPassphrase = "wrong"
+	if _, err := SetupAuth(cfgSSHPass, nil); err == nil || !strings.Contains(err.Error(), "incorrect passphrase") {
+		t.Errorf("Expected an incorrect passphrase error, got %v", err)
 	}
 
 	// Test No Authentication
 	cfgNone := &config.Config{
 		AuthMethod: config.AuthMethodNone,
 	}
-	authMethodNone, err := SetupAuth(cfgNone)
+	authMethodNone, err := SetupAuth(cfgNone, nil)
 	if err != nil {
 		t.Fatalf("SetupAuth returned an error: %v", err)
 	}
@@ -89,7 +117,7 @@ func TestSetupAuth(t *testing.T) {
 	cfgMissingHTTPS := &config.Config{
 		AuthMethod: config.AuthMethodHTTPS,
 	}
-	_, err = SetupAuth(cfgMissingHTTPS)
+	_, err = SetupAuth(cfgMissingHTTPS, nil)
 	if err == nil {
 		t.Errorf("Expected error for missing HTTPS credentials, got nil")
 	}
@@ -98,8 +126,412 @@ func TestSetupAuth(t *testing.T) {
 	cfgInvalid := &config.Config{
 		AuthMethod: 999, // Invalid AuthMethod
 	}
-	_, err = SetupAuth(cfgInvalid)
+	_, err = SetupAuth(cfgInvalid, nil)
 	if err == nil {
 		t.Errorf("Expected error for unsupported authentication method, got nil")
 	}
 }
+
+// clearTokenEnv unsets every token environment variable and points NETRC at a missing file,
+// so that lookups only see what the test sets up.
+func clearTokenEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv(TokenEnvVar, "")
+	t.Setenv(TokenHostsEnvVar, "")
+	for _, vars := range tokenVars {
+		for _, v := range vars {
+			t.Setenv(v.name, "")
+		}
+	}
+	for _, v := range enterpriseTokenVars {
+		t.Setenv(v.name, "")
+	}
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "missing"))
+}
+
+// TestLookupCredentials verifies that tokens are found in host-specific environment variables
+// and in the netrc file, in that order, that service tokens only reach the public host of the
+// service or listed self-hosted servers, and that no token is found for URLs other than https.
+func TestLookupCredentials(t *testing.T) {
+	clearTokenEnv(t)
+
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	content := "machine code.example.com login alice password netrc-secret\ndefault login anon password default-secret\n"
+	if err := os.WriteFile(netrc, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write netrc: %v", err)
+	}
+	t.Setenv("NETRC", netrc)
+	t.Setenv("GH_TOKEN", "gh-secret")
+	t.Setenv("CI_JOB_TOKEN", "job-secret")
+	t.Setenv("GITEA_TOKEN", "gitea-secret")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "ghe-secret")
+	t.Setenv(TokenHostsEnvVar, "gitlab.example.com, GitHub.Corp.Example")
+
+	testCases := []struct {
+		name     string
+		repoURL  string
+		username string
+		expected *Credentials
+	}{
+		{"GitHub variable", "https://github.com/user/repo.git", "", &Credentials{Token: "gh-secret", Source: "GH_TOKEN"}},
+		{"GitLab CI job token", "https://gitlab.com/group/repo.git", "", &Credentials{Username: "gitlab-ci-token", Token: "job-secret", Source: "CI_JOB_TOKEN"}},
+		{"netrc machine", "https://code.example.com/group/repo.git", "", &Credentials{Username: "alice", Token: "netrc-secret", Source: netrc}},
+		{"netrc default", "https://git.example.com/repo.git", "", &Credentials{Username: "anon", Token: "default-secret", Source: netrc}},
+		{"netrc login mismatch", "https://git.example.com/repo.git", "bob", nil},
+		{"enterprise host ignores github.com token", "https://github.example.com/user/repo.git", "bob", nil},
+		{"SSH URL", "git@github.com:user/repo.git", "", nil},
+		{"plain HTTP", "http://github.com/user/repo.git", "", nil},
+		{"plain HTTP netrc", "http://code.example.com/group/repo.git", "", nil},
+		{"look-alike GitLab host", "https://gitlab.evil.io/group/repo.git", "bob", nil},
+		{"look-alike Gitea host", "https://gitea.evil.io/repo.git", "bob", nil},
+		{"listed GitLab host", "https://gitlab.example.com/group/repo.git", "", &Credentials{Username: "gitlab-ci-token", Token: "job-secret", Source: "CI_JOB_TOKEN"}},
+		{"listed enterprise host", "https://github.corp.example/user/repo.git", "", &Credentials{Token: "ghe-secret", Source: "GH_ENTERPRISE_TOKEN"}},
+		{"public Gitea host", "https://gitea.com/user/repo.git", "", &Credentials{Token: "gitea-secret", Source: "GITEA_TOKEN"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			creds := LookupCredentials(tc.repoURL, tc.username, false)
+			if !reflect.DeepEqual(creds, tc.expected) {
+				t.Errorf("LookupCredentials(%q) = %v; want %v", tc.repoURL, creds, tc.expected)
+			}
+		})
+	}
+
+	t.Setenv(TokenEnvVar, "generic-secret")
+	if creds := LookupCredentials("https://github.com/user/repo.git", "", false); creds == nil || creds.Source != TokenEnvVar {
+		t.Errorf("Expected %s to take precedence, got %v", TokenEnvVar, creds)
+	}
+}
+
+// TestSetupAuthToken verifies that HTTPS authentication works with a token alone, using the
+// host's token username or a bearer token, and that the secret never appears in errors or logs.
+func TestSetupAuthToken(t *testing.T) {
+	clearTokenEnv(t)
+
+	cfg := &config.Config{AuthMethod: config.AuthMethodHTTPS, RepoURL: "https://github.com/user/repo.git"}
+	if _, err := SetupAuth(cfg, nil); err == nil || !strings.Contains(err.Error(), "no token found") {
+		t.Errorf("Expected a missing token error, got %v", err)
+	}
+
+	t.Setenv("GITHUB_TOKEN", "gh-secret")
+	method, err := SetupAuth(cfg, nil)
+	if err != nil {
+		t.Fatalf("SetupAuth returned an error: %v", err)
+	}
+	basic, ok := method.(*http.BasicAuth)
+	if !ok || basic.Username != "x-access-token" || basic.Password != "gh-secret" {
+		t.Errorf("Expected basic auth with the GitHub token username, got %T", method)
+	}
+
+	cfg = &config.Config{AuthMethod: config.AuthMethodHTTPS, RepoURL: "https://git.example.com/repo.git", PersonalAccessToken: "secret"}
+	method, err = SetupAuth(cfg, nil)
+	if err != nil {
+		t.Fatalf("SetupAuth returned an error: %v", err)
+	}
+	if _, ok := method.(*http.TokenAuth); !ok {
+		t.Errorf("Expected a bearer token for an unknown host, got %T", method)
+	}
+
+	creds := &Credentials{Username: "alice", Token: "secret", Source: "GITLAB_TOKEN"}
+	for _, s := range []string{fmt.Sprint(creds), fmt.Sprintf("%v %+v %#v", creds, creds, creds), method.(fmt.Stringer).String()} {
+		if strings.Contains(s, "secret") {
+			t.Errorf("Expected the token to be masked, got %q", s)
+		}
+	}
+}
+
+// TestForURL verifies that repositories cloned alongside the main one, such as submodules, are
+// authenticated without prompting: HTTPS with a token bound to the host or anonymously, SSH with
+// discovered keys, and git:// never. Tokens that apply to any host never reach them.
+func TestForURL(t *testing.T) {
+	clearTokenEnv(t)
+	home := setupSSHHome(t)
+	cfg := &config.Config{AuthMethod: config.AuthMethodHTTPS, PersonalAccessToken: "parent-secret"}
+
+	if method, err := ForURL(cfg, "https://gitlab.com/group/lib.git", nil); err != nil || method != nil {
+		t.Errorf("Expected anonymous HTTPS without a token, got %T, %v", method, err)
+	}
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(netrc, []byte("machine code.example.com login alice password netrc-secret\ndefault login anon password default-secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write netrc: %v", err)
+	}
+	t.Setenv("NETRC", netrc)
+	t.Setenv(TokenEnvVar, "generic-secret")
+	t.Setenv("GITLAB_TOKEN", "gl-secret")
+	method, err := ForURL(cfg, "https://gitlab.com/group/lib.git", nil)
+	if basic, ok := method.(*http.BasicAuth); err != nil || !ok || basic.Password != "gl-secret" {
+		t.Errorf("Expected the GitLab token, got %T, %v", method, err)
+	}
+	method, err = ForURL(cfg, "https://code.example.com/group/lib.git", nil)
+	if basic, ok := method.(*http.BasicAuth); err != nil || !ok || basic.Password != "netrc-secret" {
+		t.Errorf("Expected the netrc machine entry, got %T, %v", method, err)
+	}
+	for _, foreign := range []string{"https://evil.example.com/lib.git", "https://gitlab.evil.io/lib.git", "http://gitlab.com/group/lib.git"} {
+		if method, err := ForURL(cfg, foreign, nil); err != nil || method != nil {
+			t.Errorf("Expected no credentials for %s, got %T, %v", foreign, method, err)
+		}
+	}
+
+	if _, err := ForURL(cfg, "git@gitlab.com:group/lib.git", nil); err == nil || !strings.Contains(err.Error(), "no SSH key found") {
+		t.Errorf("Expected a missing key error for SSH, got %v", err)
+	}
+	key := writeTestKey(t, filepath.Join(home, ".ssh", "id_ed25519"), "")
+	method, err = ForURL(cfg, "git@gitlab.com:group/lib.git", nil)
+	if err != nil {
+		t.Fatalf("ForURL returned an error for SSH: %v", err)
+	}
+	assertSigners(t, method, "git", key)
+
+	if method, err := ForURL(cfg, "git://example.com/lib.git", nil); err != nil || method != nil {
+		t.Errorf("Expected no authentication for git://, got %T, %v", method, err)
+	}
+}
+
+// setupSSHHome points HOME at a temporary directory with an empty known_hosts file and disables
+// ssh-agent, so that SSH tests only see the keys and settings they create.
+//
+// Returns:
+//   - string: The temporary home directory.
+func setupSSHHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("SSH_KNOWN_HOSTS", "")
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatalf("Failed to create .ssh: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), nil, 0600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+	return home
+}
+
+// writeTestKey writes a new ed25519 private key in OpenSSH format, encrypted if a passphrase is given.
+//
+// Returns:
+//   - gossh.PublicKey: The public half of the key.
+func writeTestKey(t *testing.T, path, passphrase string) gossh.PublicKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = gossh.MarshalPrivateKeyWithPassphrase(priv, "test", []byte(passphrase))
+	} else {
+		block, err = gossh.MarshalPrivateKey(priv, "test")
+	}
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	sshPub, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to convert public key: %v", err)
+	}
+	return sshPub
+}
+
+// TestSetupSSHAuthDiscovery verifies that SSH authentication finds keys in ~/.ssh without
+// -ssh-key, honours the User and IdentityFile of host aliases in ~/.ssh/config, and explains
+// why no key could be used.
+func TestSetupSSHAuthDiscovery(t *testing.T) {
+	home := setupSSHHome(t)
+	sshDir := filepath.Join(home, ".ssh")
+	cfg := &config.Config{AuthMethod: config.AuthMethodSSH, RepoURL: "git@github.com:user/repo.git"}
+
+	if _, err := SetupAuth(cfg, nil); err == nil || !strings.Contains(err.Error(), "no SSH key found") {
+		t.Errorf("Expected a missing key error, got %v", err)
+	}
+
+	writeTestKey(t, filepath.Join(sshDir, "id_ecdsa"), "secret")
+	if _, err := SetupAuth(cfg, nil); err == nil || !strings.Contains(err.Error(), "is encrypted") {
+		t.Errorf("Expected an encrypted key error, got %v", err)
+	}
+
+	defaultKey := writeTestKey(t, filepath.Join(sshDir, "id_ed25519"), "")
+	method, err := SetupAuth(cfg, nil)
+	if err != nil {
+		t.Fatalf("SetupAuth returned an error: %v", err)
+	}
+	assertSigners(t, method, "git", defaultKey)
+
+	workKey := writeTestKey(t, filepath.Join(sshDir, "work_key"), "")
+	sshConfig := "Host work\n  HostName github.com\n  User alice\n  IdentityFile ~/.ssh/work_key\n"
+	if err := os.WriteFile(filepath.Join(sshDir, "config"), []byte(sshConfig), 0600); err != nil {
+		t.Fatalf("Failed to write ssh config: %v", err)
+	}
+	cfg.RepoURL = "work:user/repo.git"
+	method, err = SetupAuth(cfg, nil)
+	if err != nil {
+		t.Fatalf("SetupAuth returned an error: %v", err)
+	}
+	assertSigners(t, method, "alice", workKey)
+
+	cfg.RepoURL = "ssh://bob@work/user/repo.git"
+	method, err = SetupAuth(cfg, nil)
+	if err != nil {
+		t.Fatalf("SetupAuth returned an error: %v", err)
+	}
+	assertSigners(t, method, "bob", workKey)
+}
+
+// TestAuthNotices verifies that SetupAuth and ForURL report host keys accepted with
+// -ssh-host-key-checking=accept-new to the writer they are given.
+func TestAuthNotices(t *testing.T) {
+	home := setupSSHHome(t)
+	key := writeTestKey(t, filepath.Join(home, ".ssh", "id_ed25519"), "")
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	cfg := &config.Config{AuthMethod: config.AuthMethodSSH, RepoURL: "git@github.com:user/repo.git", SSHHostKeyChecking: config.HostKeyAcceptNew}
+
+	tests := []struct {
+		name     string
+		hostname string
+		setup    func(notices io.Writer) (transport.AuthMethod, error)
+	}{
+		{"SetupAuth", "github.com:22", func(notices io.Writer) (transport.AuthMethod, error) { return SetupAuth(cfg, notices) }},
+		{"ForURL", "gitlab.com:22", func(notices io.Writer) (transport.AuthMethod, error) {
+			return ForURL(cfg, "git@gitlab.com:group/lib.git", notices)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var notices bytes.Buffer
+			method, err := tt.setup(&notices)
+			if err != nil {
+				t.Fatalf("%s returned an error: %v", tt.name, err)
+			}
+			callback, ok := method.(*ssh.PublicKeysCallback)
+			if !ok {
+				t.Fatalf("Expected an SSH authentication method, got %T", method)
+			}
+			if err := callback.HostKeyCallback(tt.hostname, remote, key); err != nil {
+				t.Fatalf("Expected the new host to be accepted, got %v", err)
+			}
+			if want := "Added the ssh-ed25519 host key for " + tt.hostname; !strings.Contains(notices.String(), want) {
+				t.Errorf("Expected the notice %q, got %q", want, notices.String())
+			}
+		})
+	}
+}
+
+// assertSigners checks that an SSH authentication method logs in as user with exactly the given keys.
+func assertSigners(t *testing.T, method interface{}, user string, keys ...gossh.PublicKey) {
+	t.Helper()
+	callback, ok := method.(*ssh.PublicKeysCallback)
+	if !ok {
+		t.Fatalf("Expected *ssh.PublicKeysCallback, got %T", method)
+	}
+	if callback.User != user {
+		t.Errorf("Expected user %q, got %q", user, callback.User)
+	}
+	signers, err := callback.Callback()
+	if err != nil {
+		t.Fatalf("Listing signers returned an error: %v", err)
+	}
+	if len(signers) != len(keys) {
+		t.Fatalf("Expected %d signers, got %d", len(keys), len(signers))
+	}
+	for i, key := range keys {
+		if string(signers[i].PublicKey().Marshal()) != string(key.Marshal()) {
+			t.Errorf("Signer %d does not match the expected key", i)
+		}
+	}
+}
+
+// TestHostKeyCallback verifies that host keys are checked against known_hosts: strict mode
+// rejects unknown hosts, accept-new records them once, and changed keys are always rejected.
+func TestHostKeyCallback(t *testing.T) {
+	home := setupSSHHome(t)
+	knownHostsPath := filepath.Join(home, ".ssh", "known_hosts")
+	knownKey := writeTestKey(t, filepath.Join(home, "known"), "")
+	otherKey := writeTestKey(t, filepath.Join(home, "other"), "")
+	if err := os.WriteFile(knownHostsPath, []byte(knownhosts.Line([]string{"known.example.com"}, knownKey)+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	host := &sshHost{alias: "known.example.com"}
+
+	strict, err := hostKeyCallback(host, config.HostKeyStrict, nil)
+	if err != nil {
+		t.Fatalf("hostKeyCallback returned an error: %v", err)
+	}
+	if err := strict("known.example.com:22", remote, knownKey); err != nil {
+		t.Errorf("Expected the known key to be accepted, got %v", err)
+	}
+	if err := strict("known.example.com:22", remote, otherKey); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Errorf("Expected a changed key error, got %v", err)
+	}
+	for _, tt := range []struct {
+		hostname string
+		command  string
+	}{
+		{"new.example.com:22", "`ssh-keyscan new.example.com >> "},
+		{"new.example.com:2222", "`ssh-keyscan -p 2222 new.example.com >> "},
+	} {
+		err := strict(tt.hostname, remote, otherKey)
+		if err == nil || !strings.Contains(err.Error(), "not in known_hosts") || !strings.Contains(err.Error(), tt.command+knownHostsPath+"`") {
+			t.Errorf("Expected an unknown host error for %s suggesting %q, got %v", tt.hostname, tt.command, err)
+		}
+	}
+
+	var notices bytes.Buffer
+	acceptNew, err := hostKeyCallback(host, config.HostKeyAcceptNew, &notices)
+	if err != nil {
+		t.Fatalf("hostKeyCallback returned an error: %v", err)
+	}
+	// go-git probes the known key types with a placeholder key, which must not be recorded
+	if algos := knownhosts.HostKeyAlgorithms(acceptNew, "new.example.com:22"); len(algos) != 0 {
+		t.Errorf("Expected no known key types for a new host, got %v", algos)
+	}
+	if err := acceptNew("known.example.com:22", remote, otherKey); err == nil {
+		t.Error("Expected a changed key to be rejected in accept-new mode")
+	}
+	for i := 0; i < 2; i++ {
+		if err := acceptNew("new.example.com:22", remote, otherKey); err != nil {
+			t.Fatalf("Expected a new host to be accepted, got %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		t.Fatalf("Failed to read known_hosts: %v", err)
+	}
+	if got := strings.Count(string(data), "new.example.com"); got != 1 {
+		t.Errorf("Expected the new host to be recorded once, got %d entries:\n%s", got, data)
+	}
+	if want := "Added the ssh-ed25519 host key for new.example.com:22 to " + knownHostsPath + "\n"; notices.String() != want {
+		t.Errorf("Expected the added host key to be reported once as %q, got %q", want, notices.String())
+	}
+
+	reloaded, err := hostKeyCallback(host, config.HostKeyStrict, nil)
+	if err != nil {
+		t.Fatalf("hostKeyCallback returned an error: %v", err)
+	}
+	if err := reloaded("new.example.com:22", remote, otherKey); err != nil {
+		t.Errorf("Expected the recorded host to be trusted, got %v", err)
+	}
+
+	// Strict mode needs a known_hosts file, unless the system-wide one exists
+	if err := os.Remove(knownHostsPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(globalKnownHostsFile); err == nil {
+		return
+	}
+	if _, err := hostKeyCallback(host, config.HostKeyStrict, nil); err == nil || !strings.Contains(err.Error(), "no known_hosts file") || !strings.Contains(err.Error(), "`ssh-keyscan known.example.com >> ") {
+		t.Errorf("Expected a missing known_hosts error, got %v", err)
+	}
+	if _, err := hostKeyCallback(&sshHost{alias: "known.example.com", port: 2222}, config.HostKeyStrict, nil); err == nil || !strings.Contains(err.Error(), "`ssh-keyscan -p 2222 known.example.com >> ") {
+		t.Errorf("Expected the missing known_hosts error to pass the port to ssh-keyscan, got %v", err)
+	}
+	if _, err := hostKeyCallback(host, config.HostKeyAcceptNew, nil); err != nil {
+		t.Errorf("Expected accept-new mode to work without known_hosts, got %v", err)
+	}
+}
@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
+)
+
+// TokenEnvVar is the environment variable holding an HTTPS token for any Git host. It is checked
+// before the host-specific variables.
+const TokenEnvVar = "REPO_TO_TXT_TOKEN"
+
+// TokenHostsEnvVar is the environment variable listing, separated by commas, the self-hosted
+// servers that receive the token variables of their hosting service, e.g. gitlab.example.com for
+// GITLAB_TOKEN or github.example.com for GH_ENTERPRISE_TOKEN. Hosts are recognised by name, so
+// without the list a server named after a hosting service could collect its tokens.
+const TokenHostsEnvVar = "REPO_TO_TXT_TOKEN_HOSTS"
+
+// credentialHelperTimeout bounds how long `git credential fill` may take, so that a helper
+// waiting for input cannot hang the run.
+const credentialHelperTimeout = 10 * time.Second
+
+// tokenVar names an environment variable holding an HTTPS token.
+type tokenVar struct {
+	name     string // Name of the environment variable
+	username string // Username the token must be sent with; empty uses the host's token username
+}
+
+// tokenVars lists the host-specific environment variables holding HTTPS tokens, in lookup order.
+// They are only sent to the public host of the service, see publicHosts, and to the self-hosted
+// servers listed in REPO_TO_TXT_TOKEN_HOSTS.
+var tokenVars = map[clone.Provider][]tokenVar{
+	clone.ProviderGitHub:    {{name: "GH_TOKEN"}, {name: "GITHUB_TOKEN"}},
+	clone.ProviderGitLab:    {{name: "GITLAB_TOKEN"}, {name: "CI_JOB_TOKEN", username: "gitlab-ci-token"}},
+	clone.ProviderBitbucket: {{name: "BITBUCKET_TOKEN"}},
+	clone.ProviderAzure:     {{name: "AZURE_DEVOPS_EXT_PAT"}, {name: "SYSTEM_ACCESSTOKEN"}},
+	clone.ProviderGitea:     {{name: "GITEA_TOKEN"}},
+}
+
+// publicHosts lists the hosts of the public hosting services, which receive the token variables
+// of their service without being listed in REPO_TO_TXT_TOKEN_HOSTS.
+var publicHosts = map[string]clone.Provider{
+	"github.com":    clone.ProviderGitHub,
+	"gitlab.com":    clone.ProviderGitLab,
+	"bitbucket.org": clone.ProviderBitbucket,
+	"dev.azure.com": clone.ProviderAzure,
+	"gitea.com":     clone.ProviderGitea,
+}
+
+// enterpriseTokenVars lists the environment variables holding tokens for GitHub Enterprise
+// hosts, which must not receive a github.com token.
+var enterpriseTokenVars = []tokenVar{{name: "GH_ENTERPRISE_TOKEN"}, {name: "GITHUB_ENTERPRISE_TOKEN"}}
+
+// tokenUsernames lists the usernames hosts expect with a token when no username is given.
+// Hosts without an entry receive the token as a bearer token instead.
+var tokenUsernames = map[clone.Provider]string{
+	clone.ProviderGitHub:    "x-access-token",
+	clone.ProviderGitLab:    "oauth2",
+	clone.ProviderBitbucket: "x-token-auth",
+	clone.ProviderAzure:     "pat",
+}
+
+// Credentials holds an HTTPS token and the username to send it with.
+// Its String method never includes the token, so credentials are safe to log.
+type Credentials struct {
+	Username string // Username to send with the token; empty for token-only authentication
+	Token    string // The token or password
+	Source   string // Where the token was found, e.g. GITHUB_TOKEN, ~/.netrc or git credential
+}
+
+// String describes where the credentials came from without revealing the token.
+func (c *Credentials) String() string {
+	if c.Username == "" {
+		return fmt.Sprintf("token from %s", c.Source)
+	}
+	return fmt.Sprintf("token for %s from %s", c.Username, c.Source)
+}
+
+// GoString keeps the token out of %#v formatting.
+func (c *Credentials) GoString() string {
+	return c.String()
+}
+
+// LookupCredentials finds an HTTPS token for the repository without prompting. It checks, in order:
+//  1. The REPO_TO_TXT_TOKEN environment variable and the variables of the repository's host,
+//     such as GH_TOKEN, GITHUB_TOKEN or GITLAB_TOKEN, as described by credentialsFromEnv.
+//  2. The machine entry for the host in ~/.netrc, or the file named by $NETRC.
+//  3. `git credential fill`, if useHelper is set, which asks the configured git credential helpers.
+//
+// Tokens are only looked up for https:// URLs, so that they are never sent in the clear.
+//
+// Parameters:
+//   - repoURL: The repository URL.
+//   - username: The username given by the user, if any; it selects the matching netrc and helper entries.
+//   - useHelper: Whether to ask git credential helpers, which runs git.
+//
+// Returns:
+//   - *Credentials: The credentials found, or nil if there are none.
+func LookupCredentials(repoURL, username string, useHelper bool) *Credentials {
+	u, err := clone.ParseRepoURL(repoURL)
+	if err != nil || u.Scheme != clone.SchemeHTTPS {
+		return nil
+	}
+
+	if creds := credentialsFromEnv(u, true); creds != nil {
+		return creds
+	}
+	if creds := credentialsFromNetrc(netrcPath(), u.Host, username, true); creds != nil {
+		return creds
+	}
+	if useHelper {
+		return credentialsFromHelper(u, username)
+	}
+	return nil
+}
+
+// LookupHostCredentials finds an HTTPS token bound to the repository's host alone, for
+// repositories the user did not name, such as submodules listed in a repository's .gitmodules.
+// Only the variables of the hosting service, which reach its public host or the servers listed
+// in REPO_TO_TXT_TOKEN_HOSTS, and the netrc machine entry for the host are checked. The
+// REPO_TO_TXT_TOKEN variable and the netrc default entry, which apply to any host, and git
+// credential helpers are never consulted, so a repository cannot collect them by naming a
+// server of its own.
+//
+// Parameters:
+//   - repoURL: The repository URL.
+//
+// Returns:
+//   - *Credentials: The credentials found, or nil if there are none or the URL is not https.
+func LookupHostCredentials(repoURL string) *Credentials {
+	u, err := clone.ParseRepoURL(repoURL)
+	if err != nil || u.Scheme != clone.SchemeHTTPS {
+		return nil
+	}
+	if creds := credentialsFromEnv(u, false); creds != nil {
+		return creds
+	}
+	return credentialsFromNetrc(netrcPath(), u.Host, "", false)
+}
+
+// HTTPAuth returns the HTTPS authentication method for a username and token. A token without a
+// username is sent with the username the host expects for tokens, or as a bearer token to hosts
+// that are not recognised.
+//
+// Parameters:
+//   - repoURL: The repository URL, used to recognise the host.
+//   - username: The username; empty for token-only authentication.
+//   - token: The token or password.
+//
+// Returns:
+//   - transport.AuthMethod: The authentication method.
+func HTTPAuth(repoURL, username, token string) transport.AuthMethod {
+	if username == "" {
+		if u, err := clone.ParseRepoURL(repoURL); err == nil {
+			username = tokenUsernames[u.Provider()]
+		}
+	}
+	if username == "" {
+		return &http.TokenAuth{Token: token}
+	}
+	return &http.BasicAuth{Username: username, Password: token}
+}
+
+// credentialsFromEnv returns the token held by the first environment variable set for the host:
+// REPO_TO_TXT_TOKEN, if anyHost is set, then the variables of the hosting service. The service's
+// variables are only used for its public host, such as gitlab.com, and for the self-hosted
+// servers listed in REPO_TO_TXT_TOKEN_HOSTS; GitHub Enterprise servers use the enterprise
+// variables instead.
+//
+// Parameters:
+//   - u: The parsed repository URL.
+//   - anyHost: Whether to check REPO_TO_TXT_TOKEN, which applies to every host.
+//
+// Returns:
+//   - *Credentials: The credentials found, or nil if no variable is set.
+func credentialsFromEnv(u *clone.RepoURL, anyHost bool) *Credentials {
+	var vars []tokenVar
+	if anyHost {
+		vars = append(vars, tokenVar{name: TokenEnvVar})
+	}
+	host, provider := strings.ToLower(u.Host), u.Provider()
+	switch {
+	case publicHosts[host] == provider || (provider == clone.ProviderAzure && strings.HasSuffix(host, ".visualstudio.com")):
+		vars = append(vars, tokenVars[provider]...)
+	case !tokenHostListed(host):
+	case provider == clone.ProviderGitHub:
+		vars = append(vars, enterpriseTokenVars...)
+	default:
+		vars = append(vars, tokenVars[provider]...)
+	}
+
+	for _, v := range vars {
+		if token := strings.TrimSpace(os.Getenv(v.name)); token != "" {
+			return &Credentials{Username: v.username, Token: token, Source: v.name}
+		}
+	}
+	return nil
+}
+
+// tokenHostListed reports whether the host is listed in REPO_TO_TXT_TOKEN_HOSTS.
+func tokenHostListed(host string) bool {
+	for _, listed := range strings.Split(os.Getenv(TokenHostsEnvVar), ",") {
+		if listed = strings.TrimSpace(listed); listed != "" && strings.EqualFold(listed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// netrcPath returns the path of the netrc file: $NETRC if set, otherwise ~/.netrc, or ~/_netrc
+// on Windows.
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// credentialsFromNetrc returns the login and password of the netrc entry for host, falling back
+// to the default entry if useDefault is set. When a username is given, only entries for that
+// login match. Unreadable or malformed files are ignored.
+//
+// Parameters:
+//   - path: The path of the netrc file.
+//   - host: The host to find the entry for.
+//   - username: The username the entry must have, if any.
+//   - useDefault: Whether the default entry, which applies to every host, may be used.
+//
+// Returns:
+//   - *Credentials: The credentials found, or nil if there is no matching entry.
+func credentialsFromNetrc(path, host, username string, useDefault bool) *Credentials {
+	if path == "" || host == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	// The default entry has an empty machine name
+	type entry struct{ machine, login, password string }
+	var entries []entry
+	fields := strings.Fields(string(data))
+	for i := 0; i < len(fields); i++ {
+		switch key := fields[i]; key {
+		case "machine":
+			if i+1 < len(fields) {
+				i++
+				entries = append(entries, entry{machine: fields[i]})
+			}
+		case "default":
+			entries = append(entries, entry{})
+		case "login", "password", "account":
+			if len(entries) == 0 || i+1 >= len(fields) {
+				continue
+			}
+			i++
+			if key == "login" {
+				entries[len(entries)-1].login = fields[i]
+			} else if key == "password" {
+				entries[len(entries)-1].password = fields[i]
+			}
+		case "macdef":
+			// Macro bodies are free text, so stop at the first one rather than misread it
+			i = len(fields)
+		}
+	}
+
+	// Entries for the host win over the default entry, wherever it appears
+	for _, wantDefault := range []bool{false, true} {
+		if wantDefault && !useDefault {
+			break
+		}
+		for _, e := range entries {
+			isDefault := e.machine == ""
+			if isDefault != wantDefault || (!isDefault && !strings.EqualFold(e.machine, host)) {
+				continue
+			}
+			if e.password == "" || (username != "" && e.login != username) {
+				continue
+			}
+			return &Credentials{Username: e.login, Token: e.password, Source: path}
+		}
+	}
+	return nil
+}
+
+// credentialsFromHelper asks the configured git credential helpers for the host's credentials
+// with `git credential fill`. Terminal prompts are disabled, so git never asks the user.
+// Failures, including git not being installed, are treated as no credentials.
+//
+// Parameters:
+//   - u: The parsed repository URL.
+//   - username: The username to ask for, if any.
+//
+// Returns:
+//   - *Credentials: The credentials found, or nil if no helper has any.
+func credentialsFromHelper(u *clone.RepoURL, username string) *Credentials {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+
+	host := u.Host
+	if u.Port != 0 {
+		host = fmt.Sprintf("%s:%d", u.Host, u.Port)
+	}
+	var input strings.Builder
+	fmt.Fprintf(&input, "protocol=%s\nhost=%s\n", u.Scheme, host)
+	if username != "" {
+		fmt.Fprintf(&input, "username=%s\n", username)
+	}
+	input.WriteString("\n")
+
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(input.String())
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GCM_INTERACTIVE=never")
+	output, err := cmd.Output() // Standard error is discarded so helper messages cannot leak secrets
+	if err != nil {
+		return nil
+	}
+
+	creds := &Credentials{Source: "git credential"}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "username":
+			creds.Username = value
+		case "password":
+			creds.Token = value
+		}
+	}
+	if creds.Token == "" {
+		return nil
+	}
+	return creds
+}
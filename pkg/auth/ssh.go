@@ -0,0 +1,468 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/kevinburke/ssh_config"
+	"github.com/skeema/knownhosts"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+	sshagent "github.com/xanzy/ssh-agent"
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// defaultKeyNames lists the private keys in ~/.ssh that are tried when neither -ssh-key nor an
+// IdentityFile in ~/.ssh/config names one, in the order OpenSSH tries them.
+var defaultKeyNames = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// globalKnownHostsFile is the system-wide known_hosts file read by OpenSSH.
+const globalKnownHostsFile = "/etc/ssh/ssh_known_hosts"
+
+// sshHost holds the settings of ~/.ssh/config that apply to a repository host.
+type sshHost struct {
+	alias  string             // Host as written in the repository URL, which may be an alias
+	port   int                // Port given in the repository URL; 0 if none was given
+	config *ssh_config.Config // Parsed ~/.ssh/config; nil if there is none
+}
+
+// loadSSHHost reads ~/.ssh/config for the given host. A missing or malformed file is treated as empty.
+//
+// Parameters:
+//   - alias: The host as written in the repository URL.
+//
+// Returns:
+//   - *sshHost: The host settings.
+func loadSSHHost(alias string) *sshHost {
+	host := &sshHost{alias: alias}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return host
+	}
+	file, err := os.Open(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		return host
+	}
+	defer file.Close()
+	if cfg, err := ssh_config.Decode(file); err == nil {
+		host.config = cfg
+	}
+	return host
+}
+
+// get returns the value of a ~/.ssh/config keyword for the host, or an empty string.
+func (h *sshHost) get(key string) string {
+	if h.config == nil {
+		return ""
+	}
+	value, err := h.config.Get(h.alias, key)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
+// getAll returns every value of a ~/.ssh/config keyword for the host, such as IdentityFile.
+func (h *sshHost) getAll(key string) []string {
+	if h.config == nil {
+		return nil
+	}
+	values, err := h.config.GetAll(h.alias, key)
+	if err != nil {
+		return nil
+	}
+	return values
+}
+
+// setupSSHAuth prepares SSH authentication for the repository. The user is the one given in the
+// URL, the User of the host in ~/.ssh/config, or "git". Keys are offered in this order:
+//  1. The key given with -ssh-key; it must load, since it was asked for explicitly.
+//  2. The keys held by ssh-agent, found through SSH_AUTH_SOCK.
+//  3. The IdentityFile keys of the host in ~/.ssh/config, or else ~/.ssh/id_ed25519,
+//     ~/.ssh/id_ecdsa and ~/.ssh/id_rsa. Keys that are missing, or encrypted without a
+//     passphrase, are skipped.
+//
+// The host key is verified against the known_hosts files as described by hostKeyCallback.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct containing the SSH settings.
+//   - notices: Destination for notices such as host keys added to known_hosts; nil discards them.
+//
+// Returns:
+//   - *gitssh.PublicKeysCallback: The SSH authentication method.
+//   - error: An error if no key is available or the known_hosts files cannot be read.
+func setupSSHAuth(cfg *config.Config, notices io.Writer) (*gitssh.PublicKeysCallback, error) {
+	var alias, urlUser string
+	var urlPort int
+	if u, err := clone.ParseRepoURL(cfg.RepoURL); err == nil {
+		alias, urlUser, urlPort = u.Host, u.User, u.Port
+	}
+	host := loadSSHHost(alias)
+	host.port = urlPort
+
+	remoteUser := urlUser
+	if remoteUser == "" {
+		remoteUser = host.get("User")
+	}
+	if remoteUser == "" {
+		remoteUser = config.DefaultSSHKeyName
+	}
+
+	var explicit, discovered []ssh.Signer
+	if cfg.SSHKeyPath != "" {
+		signer, err := loadKey(cfg.SSHKeyPath, cfg.SSHPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		explicit = append(explicit, signer)
+	}
+
+	// An unreachable agent only matters if no key file can be used either
+	var skipped []string
+	agentSigners, err := sshAgentSigners()
+	if err != nil {
+		skipped = append(skipped, err.Error())
+	}
+
+	if cfg.SSHKeyPath == "" {
+		for _, path := range identityFiles(host, remoteUser) {
+			signer, err := loadKey(path, cfg.SSHPassphrase)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				skipped = append(skipped, err.Error())
+				continue
+			}
+			discovered = append(discovered, signer)
+		}
+	}
+
+	if len(explicit) == 0 && len(discovered) == 0 && agentSigners == nil {
+		msg := "no SSH key found: start ssh-agent and add a key, create ~/.ssh/id_ed25519, set IdentityFile in ~/.ssh/config or pass -ssh-key"
+		if len(skipped) > 0 {
+			msg += " (" + strings.Join(skipped, "; ") + ")"
+		}
+		return nil, errors.New(msg)
+	}
+
+	callback, err := hostKeyCallback(host, hostKeyChecking(cfg, host), notices)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gitssh.PublicKeysCallback{
+		User: remoteUser,
+		Callback: func() ([]ssh.Signer, error) {
+			signers := append([]ssh.Signer{}, explicit...)
+			if agentSigners != nil {
+				fromAgent, err := agentSigners()
+				if err != nil && len(explicit) == 0 && len(discovered) == 0 {
+					return nil, fmt.Errorf("unable to list ssh-agent keys: %w", err)
+				}
+				signers = append(signers, fromAgent...)
+			}
+			return append(signers, discovered...), nil
+		},
+		HostKeyCallbackHelper: gitssh.HostKeyCallbackHelper{HostKeyCallback: callback},
+	}, nil
+}
+
+// loadKey reads a private key file, decrypting it with the passphrase if it is encrypted.
+//
+// Parameters:
+//   - path: The path of the private key.
+//   - passphrase: The passphrase of the key; empty if it is not encrypted.
+//
+// Returns:
+//   - ssh.Signer: The key.
+//   - error: An error wrapping os.ErrNotExist if the file is missing, or describing why it cannot be used.
+func loadKey(path, passphrase string) (ssh.Signer, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read SSH key %s: %w", path, err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(pem)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passphrase == "" {
+			return nil, fmt.Errorf("SSH key %s is encrypted: pass -ssh-passphrase or add it to ssh-agent", path)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return nil, fmt.Errorf("incorrect passphrase for SSH key %s", path)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse SSH key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// sshAgentSigners connects to the running ssh-agent, if any.
+//
+// Returns:
+//   - func() ([]ssh.Signer, error): Lists the agent's keys; nil if no agent is running.
+//   - error: An error if SSH_AUTH_SOCK is set but the agent cannot be reached.
+func sshAgentSigners() (func() ([]ssh.Signer, error), error) {
+	if !sshagent.Available() {
+		return nil, nil
+	}
+	client, _, err := sshagent.New()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to ssh-agent (SSH_AUTH_SOCK): %w", err)
+	}
+	return client.Signers, nil
+}
+
+// identityFiles returns the private keys to try for the host: its IdentityFile entries in
+// ~/.ssh/config, or the default keys in ~/.ssh.
+//
+// Parameters:
+//   - host: The ~/.ssh/config settings of the host.
+//   - remoteUser: The remote user, substituted for %r in IdentityFile.
+//
+// Returns:
+//   - []string: The key paths, which may not exist.
+func identityFiles(host *sshHost, remoteUser string) []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+	for _, path := range host.getAll("IdentityFile") {
+		if path = expandSSHPath(path, home, host.alias, remoteUser); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) > 0 {
+		return paths
+	}
+	for _, name := range defaultKeyNames {
+		paths = append(paths, filepath.Join(home, ".ssh", name))
+	}
+	return paths
+}
+
+// expandSSHPath expands ~ and the %d, %h, %r, %u and %% tokens of a ~/.ssh/config path.
+//
+// Parameters:
+//   - path: The path as written in ~/.ssh/config.
+//   - home: The home directory.
+//   - host: The remote host name.
+//   - remoteUser: The remote user.
+//
+// Returns:
+//   - string: The expanded path, or an empty string for "none".
+func expandSSHPath(path, home, host, remoteUser string) string {
+	path = strings.Trim(strings.TrimSpace(path), `"`)
+	if path == "" || strings.EqualFold(path, "none") {
+		return ""
+	}
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		path = filepath.Join(home, path[1:])
+	}
+	localUser := ""
+	if u, err := user.Current(); err == nil {
+		localUser = u.Username
+	}
+	replacer := strings.NewReplacer("%%", "%", "%d", home, "%h", host, "%r", remoteUser, "%u", localUser)
+	return replacer.Replace(path)
+}
+
+// hostKeyChecking returns the host key checking policy: the one given with
+// -ssh-host-key-checking, or accept-new when StrictHostKeyChecking in ~/.ssh/config is
+// accept-new or no, or strict. Changed host keys are always rejected.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct.
+//   - host: The ~/.ssh/config settings of the host.
+//
+// Returns:
+//   - string: config.HostKeyStrict or config.HostKeyAcceptNew.
+func hostKeyChecking(cfg *config.Config, host *sshHost) string {
+	if cfg.SSHHostKeyChecking != "" {
+		return cfg.SSHHostKeyChecking
+	}
+	switch strings.ToLower(host.get("StrictHostKeyChecking")) {
+	case "accept-new", "no", "off":
+		return config.HostKeyAcceptNew
+	default:
+		return config.HostKeyStrict
+	}
+}
+
+// knownHostsFiles returns the known_hosts files to read: those named by SSH_KNOWN_HOSTS, or the
+// UserKnownHostsFile of the host in ~/.ssh/config, or ~/.ssh/known_hosts; followed by the
+// system-wide file. The first file is where accepted host keys are added.
+//
+// Parameters:
+//   - host: The ~/.ssh/config settings of the host.
+//
+// Returns:
+//   - []string: The known_hosts paths, which may not exist.
+func knownHostsFiles(host *sshHost) []string {
+	home, _ := os.UserHomeDir()
+
+	var files []string
+	if env := os.Getenv("SSH_KNOWN_HOSTS"); env != "" {
+		files = filepath.SplitList(env)
+	} else {
+		for _, value := range host.getAll("UserKnownHostsFile") {
+			for _, path := range strings.Fields(value) {
+				if path = expandSSHPath(path, home, host.alias, ""); path != "" {
+					files = append(files, path)
+				}
+			}
+		}
+	}
+	if len(files) == 0 && home != "" {
+		files = append(files, filepath.Join(home, ".ssh", "known_hosts"))
+	}
+	return append(files, globalKnownHostsFile)
+}
+
+// hostKeyCallback verifies SSH host keys against the known_hosts files. In strict mode hosts
+// that are not listed are rejected; in accept-new mode their key is added to the first
+// known_hosts file and trusted. A host whose key differs from the listed one is always rejected.
+//
+// Parameters:
+//   - host: The ~/.ssh/config settings of the host.
+//   - mode: config.HostKeyStrict or config.HostKeyAcceptNew.
+//   - notices: Destination for a notice of each host key added to known_hosts; nil discards them.
+//
+// Returns:
+//   - ssh.HostKeyCallback: The callback.
+//   - error: An error if a known_hosts file is malformed, or none exists in strict mode.
+func hostKeyCallback(host *sshHost, mode string, notices io.Writer) (ssh.HostKeyCallback, error) {
+	if notices == nil {
+		notices = io.Discard
+	}
+	files := knownHostsFiles(host)
+	var existing []string
+	for _, path := range files {
+		if _, err := os.Stat(path); err == nil {
+			existing = append(existing, path)
+		}
+	}
+
+	if len(existing) == 0 && mode != config.HostKeyAcceptNew {
+		port := host.get("Port")
+		if host.port != 0 {
+			port = strconv.Itoa(host.port)
+		}
+		return nil, fmt.Errorf("no known_hosts file found (looked for %s): add the host key with `%s >> %s` or pass -ssh-host-key-checking=accept-new", strings.Join(files, ", "), keyscanCommand(host.alias, port), files[0])
+	}
+
+	var known knownhosts.HostKeyCallback
+	if len(existing) > 0 {
+		var err error
+		known, err = knownhosts.New(existing...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read known_hosts: %w", err)
+		}
+	}
+
+	target := files[0]
+	var mu sync.Mutex
+	accepted := make(map[string]ssh.PublicKey)
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		// With no known_hosts file every host is unknown
+		err := error(&xknownhosts.KeyError{})
+		if known != nil {
+			err = known(hostname, remote, key)
+		}
+		if err == nil {
+			return nil
+		}
+		if knownhosts.IsHostKeyChanged(err) {
+			return fmt.Errorf("host key for %s has changed and does not match known_hosts; it may have been rotated, or the connection may be intercepted: %w", hostname, err)
+		}
+		if !knownhosts.IsHostUnknown(err) {
+			return err
+		}
+
+		// go-git probes the callback with a placeholder key to learn the known key types; it
+		// must see the unknown host error rather than have the placeholder written to known_hosts
+		if _, parseErr := ssh.ParsePublicKey(key.Marshal()); parseErr != nil {
+			return err
+		}
+		if mode != config.HostKeyAcceptNew {
+			name, port, splitErr := net.SplitHostPort(hostname)
+			if splitErr != nil {
+				name, port = hostname, ""
+			}
+			return fmt.Errorf("host key for %s is not in known_hosts: add it with `%s >> %s` or pass -ssh-host-key-checking=accept-new: %w", hostname, keyscanCommand(name, port), target, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if prev, ok := accepted[hostname]; ok {
+			if string(prev.Marshal()) == string(key.Marshal()) {
+				return nil
+			}
+			return fmt.Errorf("host key for %s changed during the connection", hostname)
+		}
+		if err := appendKnownHost(target, hostname, remote, key); err != nil {
+			return err
+		}
+		accepted[hostname] = key
+		fmt.Fprintf(notices, "Added the %s host key for %s to %s\n", key.Type(), hostname, target)
+		return nil
+	}, nil
+}
+
+// keyscanCommand returns the ssh-keyscan command that prints the host keys of a host, passing
+// the port with -p since ssh-keyscan does not accept it as part of the host name.
+//
+// Parameters:
+//   - host: The host name.
+//   - port: The port; empty or "22" selects the default.
+//
+// Returns:
+//   - string: The command.
+func keyscanCommand(host, port string) string {
+	if port == "" || port == "22" {
+		return "ssh-keyscan " + host
+	}
+	return fmt.Sprintf("ssh-keyscan -p %s %s", port, host)
+}
+
+// appendKnownHost adds a host key to a known_hosts file, creating the file and its directory
+// with private permissions if needed.
+//
+// Parameters:
+//   - path: The known_hosts file.
+//   - hostname: The host name, with its port if it is not 22.
+//   - remote: The address of the server.
+//   - key: The host key.
+//
+// Returns:
+//   - error: An error if the file cannot be written.
+func appendKnownHost(path, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("unable to create %s: %w", filepath.Dir(path), err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	defer file.Close()
+	if err := knownhosts.WriteKnownHost(file, hostname, remote, key); err != nil {
+		return fmt.Errorf("unable to add host key to %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,745 @@
+package clone
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/util"
+)
+
+// CacheDirEnvVar is the environment variable that overrides the default cache directory.
+const CacheDirEnvVar = "REPO_TO_TXT_CACHE_DIR"
+
+const (
+	mirrorsDir    = "mirrors"                // Directory of the cache holding the bare mirrors
+	checkoutsDir  = "checkouts"              // Directory of the cache holding the working trees of running packs
+	metadataFile  = "repo-to-txt-cache.json" // File in each mirror describing the cache entry
+	lockTimeout   = 5 * time.Minute          // How long to wait for another run to finish with a mirror
+	staleLockAge  = 30 * time.Minute         // Age after which a lock, partial mirror or checkout is abandoned
+	lockRetryWait = 200 * time.Millisecond   // Pause between attempts to take a lock
+	lockRefresh   = time.Minute              // How often a held lock is touched so that it never looks abandoned
+)
+
+// mirrorRefSpecs fetch every branch and tag of the remote into the same names in the mirror.
+var mirrorRefSpecs = []gitconfig.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// defaultPorts lists the ports that are left out of cache keys, since URLs with and without them
+// name the same repository.
+var defaultPorts = map[string]int{SchemeHTTPS: 443, SchemeHTTP: 80, SchemeSSH: 22, SchemeGit: 9418}
+
+// ErrNotCached is returned in offline mode when a repository has no cached mirror.
+var ErrNotCached = errors.New("repository is not in the cache")
+
+// Cache stores bare mirrors of remote repositories so that later runs only fetch new objects.
+// Mirrors are keyed by the normalised repository URL, so HTTPS and SSH URLs of the same
+// repository share one mirror.
+type Cache struct {
+	Dir string // Root directory of the cache
+}
+
+// CacheEntry describes a cached mirror.
+type CacheEntry struct {
+	Key      string    `json:"key"`       // Normalised repository URL, see CacheKey
+	URL      string    `json:"url"`       // Repository URL the mirror was last fetched from, without credentials
+	Fetched  time.Time `json:"fetched"`   // When the mirror was last fetched
+	LastUsed time.Time `json:"last_used"` // When the mirror was last used by a run
+	Path     string    `json:"-"`         // Directory of the mirror
+	Size     int64     `json:"-"`         // Size of the mirror on disk in bytes
+}
+
+// PruneOptions selects the cache entries removed by Prune. Entries are removed if they match
+// any of the limits; with no limits nothing is removed.
+type PruneOptions struct {
+	MaxSize int64         // Remove the least recently used entries until the cache is at most this size; 0 disables the limit
+	MaxAge  time.Duration // Remove entries not used for this long; 0 disables the limit
+	All     bool          // Remove every entry
+	Keep    []string      // Repository URLs whose entries are never removed, such as the one just used
+}
+
+// NewCache returns the cache rooted at dir, or at DefaultCacheDir if dir is empty.
+//
+// Parameters:
+//   - dir: The cache directory; empty selects the default.
+//
+// Returns:
+//   - *Cache: The cache.
+//   - error: An error if the default cache directory cannot be determined.
+func NewCache(dir string) (*Cache, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultCacheDir(); err != nil {
+			return nil, err
+		}
+	}
+	return &Cache{Dir: dir}, nil
+}
+
+// DefaultCacheDir returns the directory named by REPO_TO_TXT_CACHE_DIR, or repo-to-txt in the
+// user's cache directory (e.g. ~/.cache/repo-to-txt on Linux).
+//
+// Returns:
+//   - string: The cache directory.
+//   - error: An error if the user's cache directory cannot be determined.
+func DefaultCacheDir() (string, error) {
+	if dir := os.Getenv(CacheDirEnvVar); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine the cache directory: %w; set %s", err, CacheDirEnvVar)
+	}
+	return filepath.Join(dir, "repo-to-txt"), nil
+}
+
+// CacheKey normalises a repository URL into the key of its cache entry: the lower-case host,
+// the port if it is not the default one, and the path without a .git suffix. Credentials and
+// the scheme are left out, so https://github.com/owner/repo.git and git@github.com:owner/repo
+// share a key.
+//
+// Parameters:
+//   - repoURL: The URL of the Git repository.
+//
+// Returns:
+//   - string: The cache key, e.g. github.com/owner/repo.
+//   - error: An error if the URL is invalid.
+func CacheKey(repoURL string) (string, error) {
+	u, err := ParseRepoURL(repoURL)
+	if err != nil {
+		return "", err
+	}
+	repoPath := strings.Trim(u.Path, "/")
+	repoPath = strings.TrimSuffix(repoPath, "/.git")
+	repoPath = strings.TrimSuffix(repoPath, ".git")
+	if u.Scheme == SchemeFile {
+		return "file/" + repoPath, nil
+	}
+
+	host := strings.ToLower(u.Host)
+	if u.Port != 0 && u.Port != defaultPorts[u.Scheme] {
+		host += ":" + strconv.Itoa(u.Port)
+	}
+	return host + "/" + repoPath, nil
+}
+
+// entryPath returns the directory of the mirror for a repository. The name starts with the
+// repository name for readability and ends with a hash of the cache key.
+//
+// Parameters:
+//   - repoURL: The URL of the Git repository.
+//
+// Returns:
+//   - string: The directory of the mirror, which may not exist.
+//   - string: The cache key.
+//   - error: An error if the URL is invalid.
+func (c *Cache) entryPath(repoURL string) (string, string, error) {
+	key, err := CacheKey(repoURL)
+	if err != nil {
+		return "", "", err
+	}
+	name, err := ExtractRepoName(repoURL)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.Dir, mirrorsDir, name+"-"+hex.EncodeToString(sum[:6])), key, nil
+}
+
+// Has reports whether the repository has a cached mirror.
+//
+// Parameters:
+//   - repoURL: The URL of the Git repository.
+//
+// Returns:
+//   - bool: True if a mirror exists.
+func (c *Cache) Has(repoURL string) bool {
+	path, _, err := c.entryPath(repoURL)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(path, metadataFile))
+	return err == nil
+}
+
+// TempDir creates a directory inside the cache for a working tree. Working trees share the
+// objects of the mirror through hard links, which only works on the same file system.
+// The directory is locked until it is removed, so Prune leaves it alone while it is in use.
+//
+// Returns:
+//   - string: The new directory.
+//   - func(): Removes the directory and releases its lock; call it when done.
+//   - error: An error if the directory cannot be created.
+func (c *Cache) TempDir() (string, func(), error) {
+	dir := filepath.Join(c.Dir, checkoutsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, fmt.Errorf("unable to create cache directory: %w", err)
+	}
+	path, err := os.MkdirTemp(dir, "checkout-")
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create cache directory: %w", err)
+	}
+	unlock, err := tryLockEntry(path)
+	if err != nil {
+		os.RemoveAll(path)
+		return "", nil, err
+	}
+	return path, func() {
+		os.RemoveAll(path)
+		unlock()
+	}, nil
+}
+
+// Update creates the mirror of the repository or fetches what changed since the last run.
+// Every branch and tag is mirrored; branches and tags deleted on the remote are pruned.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - repoURL: The URL of the Git repository.
+//   - auth: The authentication method to use for accessing the repository.
+//   - progress: Destination for progress messages; nil discards them.
+//
+// Returns:
+//   - error: An error if the mirror cannot be created or fetched.
+func (c *Cache) Update(ctx context.Context, repoURL string, auth transport.AuthMethod, progress io.Writer) error {
+	if progress == nil {
+		progress = io.Discard
+	}
+	path, key, err := c.entryPath(repoURL)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockEntry(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entry := &CacheEntry{Key: key, URL: RedactURL(repoURL)}
+	if _, err := os.Stat(filepath.Join(path, metadataFile)); err == nil {
+		fmt.Fprintf(progress, "Fetching changes into cached mirror: %s\n", RedactURL(repoURL))
+		repo, err := git.PlainOpen(path)
+		if err != nil {
+			return fmt.Errorf("failed to open cached mirror %s: %w", path, err)
+		}
+		if err := fetchMirror(ctx, repo, repoURL, auth, progress); err != nil {
+			return err
+		}
+	} else {
+		// A new mirror is fetched next to its final path and renamed into place, so that an
+		// interrupted first fetch never leaves a partial mirror behind.
+		fmt.Fprintf(progress, "Mirroring repository into the cache: %s\n", RedactURL(repoURL))
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to remove incomplete mirror: %w", err)
+		}
+		tmp, err := os.MkdirTemp(filepath.Dir(path), ".new-")
+		if err != nil {
+			return fmt.Errorf("unable to create cache directory: %w", err)
+		}
+		unlockTmp, err := tryLockEntry(tmp)
+		if err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+		defer unlockTmp()
+		defer os.RemoveAll(tmp)
+		repo, err := git.PlainInit(tmp, true)
+		if err != nil {
+			return fmt.Errorf("failed to create mirror: %w", err)
+		}
+		if err := fetchMirror(ctx, repo, repoURL, auth, progress); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return fmt.Errorf("failed to store mirror: %w", err)
+		}
+	}
+
+	now := time.Now()
+	entry.Fetched, entry.LastUsed = now, now
+	return writeMetadata(path, entry)
+}
+
+// fetchMirror fetches every branch and tag of the remote into the mirror and points the
+// mirror's HEAD at the remote's default branch.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - repo: The bare mirror.
+//   - repoURL: The URL of the Git repository.
+//   - auth: The authentication method to use for accessing the repository.
+//   - progress: Destination for progress messages.
+//
+// Returns:
+//   - error: An error if the remote cannot be listed or fetched.
+func fetchMirror(ctx context.Context, repo *git.Repository, repoURL string, auth transport.AuthMethod, progress io.Writer) error {
+	remote := git.NewRemote(repo.Storer, &gitconfig.RemoteConfig{
+		Name:  git.DefaultRemoteName,
+		URLs:  []string{repoURL},
+		Fetch: mirrorRefSpecs,
+	})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return fmt.Errorf("failed to list remote references: %w", err)
+	}
+
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: mirrorRefSpecs,
+		Auth:     auth,
+		Progress: progress,
+		Tags:     git.NoTags, // Tags are fetched by the refspecs
+		Prune:    true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch repository: %w", err)
+	}
+
+	head := remoteHead(refs)
+	if head == "" {
+		return nil
+	}
+	return repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, head))
+}
+
+// remoteHead returns the branch the remote's HEAD points to. Servers that do not advertise the
+// branch by name are matched by commit, preferring main and master.
+//
+// Parameters:
+//   - refs: The references advertised by the remote.
+//
+// Returns:
+//   - plumbing.ReferenceName: The default branch, or an empty name if it cannot be determined.
+func remoteHead(refs []*plumbing.Reference) plumbing.ReferenceName {
+	var head *plumbing.Reference
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD {
+			head = ref
+		}
+	}
+	if head == nil {
+		return ""
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target()
+	}
+
+	var match plumbing.ReferenceName
+	for _, ref := range refs {
+		if !ref.Name().IsBranch() || ref.Hash() != head.Hash() {
+			continue
+		}
+		if short := ref.Name().Short(); short == "main" || short == "master" {
+			return ref.Name()
+		}
+		if match == "" {
+			match = ref.Name()
+		}
+	}
+	return match
+}
+
+// Checkout creates a repository at repoPath from the cached mirror and checks out ref. Objects
+// are hard-linked from the mirror when possible, so no data is downloaded or duplicated.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - repoURL: The URL of the Git repository.
+//   - repoPath: The empty or missing directory to create the repository in.
+//   - ref: The branch, tag or commit SHA to check out; empty selects the default branch.
+//   - subdirs: Directories to check out sparsely, as in Options.Subdirs; empty checks out everything.
+//
+// Returns:
+//   - string: The SHA of the commit that was checked out.
+//   - error: An error wrapping ErrNotCached if there is no mirror, or if the checkout fails.
+func (c *Cache) Checkout(ctx context.Context, repoURL, repoPath, ref string, subdirs []string) (string, error) {
+	path, _, err := c.entryPath(repoURL)
+	if err != nil {
+		return "", err
+	}
+	entry, err := readMetadata(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrNotCached, RedactURL(repoURL))
+	} else if err != nil {
+		return "", err
+	}
+
+	unlock, err := lockEntry(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	repo, err := copyMirror(path, repoPath)
+	unlock()
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := ResolveRef(repo, ref)
+	if err != nil {
+		return "", err
+	}
+	if err := checkout(repo, checkoutOptions(repo, hash, ref), subdirs); err != nil {
+		return "", fmt.Errorf("failed to check out %s: %w", hash, err)
+	}
+
+	entry.LastUsed = time.Now()
+	if err := writeMetadata(path, entry); err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+// Open opens the cached mirror of a repository for reading objects in place, without creating a
+// worktree. Fetches only add objects to the mirror, so the repository stays readable while
+// another run updates it.
+//
+// Parameters:
+//   - repoURL: The URL of the Git repository.
+//
+// Returns:
+//   - *git.Repository: The bare mirror.
+//   - error: An error wrapping ErrNotCached if there is no mirror, or if it cannot be opened.
+func (c *Cache) Open(repoURL string) (*git.Repository, error) {
+	path, _, err := c.entryPath(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := readMetadata(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotCached, RedactURL(repoURL))
+	} else if err != nil {
+		return nil, err
+	}
+
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cached mirror %s: %w", path, err)
+	}
+	entry.LastUsed = time.Now()
+	if err := writeMetadata(path, entry); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// copyMirror initialises a repository at repoPath with the objects, branches, tags and HEAD of
+// the mirror. Object files are hard-linked, or copied when the cache is on another file system.
+//
+// Parameters:
+//   - mirrorPath: The directory of the bare mirror.
+//   - repoPath: The directory of the new repository.
+//
+// Returns:
+//   - *git.Repository: The new repository, without a checked out worktree.
+//   - error: An error if the mirror cannot be read or the repository cannot be written.
+func copyMirror(mirrorPath, repoPath string) (*git.Repository, error) {
+	mirror, err := git.PlainOpen(mirrorPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cached mirror %s: %w", mirrorPath, err)
+	}
+	repo, err := git.PlainInit(repoPath, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repository: %w", err)
+	}
+
+	srcObjects := filepath.Join(mirrorPath, "objects")
+	dstObjects := filepath.Join(repoPath, git.GitDirName, "objects")
+	err = filepath.WalkDir(srcObjects, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(srcObjects, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstObjects, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Link(path, dst); err != nil {
+			return util.CopyFile(path, dst)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy objects from cached mirror: %w", err)
+	}
+
+	refs, err := mirror.Storer.IterReferences()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached mirror references: %w", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name().IsBranch() || ref.Name().IsTag() || ref.Name() == plumbing.HEAD {
+			return repo.Storer.SetReference(ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy references from cached mirror: %w", err)
+	}
+	return repo, nil
+}
+
+// List returns the cached mirrors, most recently used first.
+//
+// Returns:
+//   - []CacheEntry: The cache entries with their sizes.
+//   - error: An error if the cache directory cannot be read.
+func (c *Cache) List() ([]CacheEntry, error) {
+	dirs, err := os.ReadDir(filepath.Join(c.Dir, mirrorsDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read cache directory: %w", err)
+	}
+
+	var entries []CacheEntry
+	for _, dir := range dirs {
+		if !dir.IsDir() || strings.HasPrefix(dir.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(c.Dir, mirrorsDir, dir.Name())
+		entry, err := readMetadata(path)
+		if err != nil {
+			continue // Not a complete mirror
+		}
+		entry.Size = dirSize(path)
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.After(entries[j].LastUsed) })
+	return entries, nil
+}
+
+// Prune removes the cache entries selected by opts, along with partial mirrors and working
+// trees left behind by interrupted runs. Entries in use by another run are skipped.
+//
+// Parameters:
+//   - opts: The limits selecting the entries to remove.
+//
+// Returns:
+//   - []CacheEntry: The entries that were removed.
+//   - error: An error if the cache cannot be read or an entry cannot be removed.
+func (c *Cache) Prune(opts PruneOptions) ([]CacheEntry, error) {
+	removeStale(filepath.Join(c.Dir, mirrorsDir), ".new-")
+	removeStale(filepath.Join(c.Dir, checkoutsDir), "")
+
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool)
+	for _, repoURL := range opts.Keep {
+		if key, err := CacheKey(repoURL); err == nil {
+			keep[key] = true
+		}
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	// Entries are listed most recently used first, so the oldest are removed first
+	var removed []CacheEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		tooOld := opts.MaxAge > 0 && time.Since(entry.LastUsed) > opts.MaxAge
+		tooBig := opts.MaxSize > 0 && total > opts.MaxSize
+		if keep[entry.Key] || !(opts.All || tooOld || tooBig) {
+			continue
+		}
+
+		unlock, err := tryLockEntry(entry.Path)
+		if err != nil {
+			continue // In use by another run
+		}
+		err = os.RemoveAll(entry.Path)
+		unlock()
+		if err != nil {
+			return removed, fmt.Errorf("failed to remove cached mirror %s: %w", entry.Path, err)
+		}
+		total -= entry.Size
+		removed = append(removed, entry)
+	}
+	return removed, nil
+}
+
+// removeStale removes the entries of dir whose name starts with prefix, that were created longer
+// ago than staleLockAge and whose lock is not held by a running process. Abandoned lock files
+// are removed as well.
+func removeStale(dir, prefix string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !strings.HasPrefix(entry.Name(), prefix) || time.Since(info.ModTime()) < staleLockAge {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if strings.HasSuffix(path, ".lock") {
+			os.Remove(path) // Held locks are refreshed, so this one was left by a run that crashed
+			continue
+		}
+		// The age check above keeps a directory that was just created but not yet locked
+		unlock, err := tryLockEntry(path)
+		if err != nil {
+			continue // In use by another run
+		}
+		os.RemoveAll(path)
+		unlock()
+	}
+}
+
+// dirSize returns the total size of the files under dir in bytes.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// readMetadata reads the description of the cache entry stored in a mirror.
+//
+// Parameters:
+//   - path: The directory of the mirror.
+//
+// Returns:
+//   - *CacheEntry: The entry, with its Path set.
+//   - error: An error wrapping fs.ErrNotExist if the mirror does not exist, or if the file is malformed.
+func readMetadata(path string) (*CacheEntry, error) {
+	data, err := os.ReadFile(filepath.Join(path, metadataFile))
+	if err != nil {
+		return nil, err
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", filepath.Join(path, metadataFile), err)
+	}
+	entry.Path = path
+	return &entry, nil
+}
+
+// writeMetadata stores the description of the cache entry in its mirror.
+//
+// Parameters:
+//   - path: The directory of the mirror.
+//   - entry: The entry to store.
+//
+// Returns:
+//   - error: An error if the file cannot be written.
+func writeMetadata(path string, entry *CacheEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(path, metadataFile), data, 0644); err != nil {
+		return fmt.Errorf("unable to update cache entry: %w", err)
+	}
+	return nil
+}
+
+// lockEntry takes the lock of a mirror, waiting while another run holds it. Held locks are
+// refreshed every lockRefresh, so locks older than staleLockAge belong to a run that crashed and
+// are taken over.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - path: The directory of the mirror.
+//
+// Returns:
+//   - func(): Releases the lock.
+//   - error: An error if the lock cannot be taken within lockTimeout.
+func lockEntry(ctx context.Context, path string) (func(), error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		unlock, err := tryLockEntry(path)
+		if err == nil {
+			return unlock, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for another run to release %s.lock", path)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryWait):
+		}
+	}
+}
+
+// tryLockEntry takes the lock of a mirror or working tree if it is free. The modification time of
+// the lock file is refreshed every lockRefresh until the lock is released, however long it is
+// held.
+//
+// Parameters:
+//   - path: The directory of the mirror or working tree.
+//
+// Returns:
+//   - func(): Releases the lock; calling it again does nothing.
+//   - error: An error wrapping fs.ErrExist if another run holds the lock.
+func tryLockEntry(path string) (func(), error) {
+	lockPath := path + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create cache directory: %w", err)
+	}
+	if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleLockAge {
+		os.Remove(lockPath)
+	}
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(lockPath, now, now)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-stopped
+			os.Remove(lockPath)
+		})
+	}, nil
+}
@@ -6,17 +6,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/url"
+	"io"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
 )
 
 // ExtractRepoName extracts the repository name from the given repository URL.
-// It supports both SSH and HTTPS URL formats.
+// It supports every URL format accepted by ParseRepoURL, for any Git host.
 //
 // Parameters:
 //   - repoURL: The URL of the Git repository.
@@ -25,77 +27,349 @@ import (
 //   - string: The extracted repository name.
 //   - error: An error if the repository name cannot be determined.
 func ExtractRepoName(repoURL string) (string, error) {
-	if strings.HasPrefix(repoURL, "git@") {
-		// Handle SSH URLs
-		parts := strings.SplitN(repoURL, ":", 2)
-		if len(parts) != 2 {
-			return "", errors.New("invalid SSH repository URL format")
+	u, err := ParseRepoURL(repoURL)
+	if err != nil {
+		return "", err
+	}
+	return u.Name()
+}
+
+// ExtractLocalRepoName derives a repository name from a local directory path.
+// Relative paths such as "." are resolved against the current working directory first.
+//
+// Parameters:
+//   - localPath: The path to the local directory.
+//
+// Returns:
+//   - string: The name of the directory.
+//   - error: An error if the path cannot be resolved or does not name a directory.
+func ExtractLocalRepoName(localPath string) (string, error) {
+	absPath, err := filepath.Abs(localPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid local path: %w", err)
+	}
+	repoName := filepath.Base(absPath)
+	if repoName == "" || repoName == string(filepath.Separator) || repoName == "." {
+		return "", errors.New("could not determine repository name from path")
+	}
+	return repoName, nil
+}
+
+// Options configures how a repository is cloned and which snapshot is checked out.
+type Options struct {
+	Ref          string    // Branch, tag or commit SHA to check out; empty selects the default branch
+	Depth        int       // Number of commits to fetch from the tip; 0 fetches the full history
+	SingleBranch bool      // Fetch only the requested branch or tag instead of every branch
+	Progress     io.Writer // Destination for progress messages; nil discards them
+	Cache        *Cache    // Cache of mirrors to fetch into and check out from; nil clones directly
+	Offline      bool      // Check out from the cache without contacting the remote
+	Subdirs      []string  // Slash-separated directories to check out sparsely; empty checks out everything
+}
+
+// CloneOrPullRepo clones the repository from the provided URL into the specified path.
+// If the repository already exists locally, it attempts to pull the latest changes.
+// Afterwards the reference requested in opts is resolved and checked out.
+//
+// Shallow (Depth > 0) clones imply single-branch fetching, mirroring git itself. When the
+// requested ref is a commit SHA that is not reachable from the shallow history, the shallow
+// clone is discarded and the repository is cloned again with its full history.
+//
+// With a cache, the repository's mirror is created or incrementally fetched and repoPath is
+// checked out from it; in offline mode the remote is not contacted at all. Shallow and
+// single-branch clones use an existing mirror but do not create one, so that they stay cheap.
+//
+// With subdirectories, only they and the files directly inside their parent directories are
+// written to the worktree, as in a cone-mode sparse checkout; the other files are marked
+// skip-worktree.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - repoURL: The URL of the Git repository.
+//   - repoPath: The local file system path where the repository should be cloned.
+//   - auth: The authentication method to use for accessing the repository.
+//   - opts: Options selecting the reference to check out and how much history to fetch.
+//
+// Returns:
+//   - string: The SHA of the commit that was checked out.
+//   - error: An error if the clone, pull or checkout operation fails, or wrapping ErrNotCached
+//     in offline mode when the repository is not cached.
+func CloneOrPullRepo(ctx context.Context, repoURL, repoPath string, auth transport.AuthMethod, opts Options) (string, error) {
+	if opts.Progress == nil {
+		opts.Progress = io.Discard
+	}
+
+	cached, err := updateCache(ctx, repoURL, auth, opts)
+	if err != nil {
+		return "", err
+	}
+	if cached {
+		return opts.Cache.Checkout(ctx, repoURL, repoPath, opts.Ref, opts.Subdirs)
+	}
+
+	repo, hash, err := fetchRepo(ctx, repoURL, repoPath, auth, opts)
+	if err != nil {
+		return "", err
+	}
+
+	// Sparse clones are made without a checkout, so the default branch is checked out here too.
+	if opts.Ref != "" || len(opts.Subdirs) > 0 {
+		if err := checkout(repo, checkoutOptions(repo, hash, opts.Ref), opts.Subdirs); err != nil {
+			return "", fmt.Errorf("failed to check out %s: %w", hash, err)
 		}
-		path := parts[1]
-		repoPath := strings.TrimSuffix(path, ".git")
-		repoName := filepath.Base(repoPath)
-		if repoName == "" {
-			return "", errors.New("could not determine repository name from URL")
+	}
+	return hash.String(), nil
+}
+
+// CloneToMemory makes the repository available without checking out a worktree and resolves the
+// reference requested in opts. Without a cache the repository is cloned into memory, honouring
+// Depth and SingleBranch as CloneOrPullRepo does; with a cache its mirror is fetched as for
+// CloneOrPullRepo and opened in place, so objects are read from disk without being copied.
+// Subdirs is ignored, since nothing is checked out.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - repoURL: The URL of the Git repository.
+//   - auth: The authentication method to use for accessing the repository.
+//   - opts: Options selecting the reference to resolve and how much history to fetch.
+//
+// Returns:
+//   - *git.Repository: The repository, without a worktree.
+//   - plumbing.Hash: The commit the reference resolved to.
+//   - error: An error if the clone or fetch fails or the reference cannot be resolved, or
+//     wrapping ErrNotCached in offline mode when the repository is not cached.
+func CloneToMemory(ctx context.Context, repoURL string, auth transport.AuthMethod, opts Options) (*git.Repository, plumbing.Hash, error) {
+	if opts.Progress == nil {
+		opts.Progress = io.Discard
+	}
+
+	cached, err := updateCache(ctx, repoURL, auth, opts)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	if cached {
+		repo, err := opts.Cache.Open(repoURL)
+		if err != nil {
+			return nil, plumbing.ZeroHash, err
 		}
-		return repoName, nil
-	} else if strings.HasPrefix(repoURL, "https://") || strings.HasPrefix(repoURL, "http://") {
-		// Handle HTTPS URLs
-		u, err := url.Parse(repoURL)
+		hash, err := ResolveRef(repo, opts.Ref)
 		if err != nil {
-			return "", fmt.Errorf("invalid repository URL: %w", err)
+			return nil, plumbing.ZeroHash, err
+		}
+		return repo, hash, nil
+	}
+	return fetchRepo(ctx, repoURL, "", auth, opts)
+}
+
+// updateCache decides whether the repository is read from the cache and, unless in offline
+// mode, fetches the latest changes into its mirror first. Shallow and single-branch clones use
+// an existing mirror but do not create one.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - repoURL: The URL of the Git repository.
+//   - auth: The authentication method to use for accessing the repository.
+//   - opts: Options holding the cache, offline mode and history depth.
+//
+// Returns:
+//   - bool: True if the repository is to be read from the cache.
+//   - error: An error if fetching into the mirror fails, or offline mode is used without a cache.
+func updateCache(ctx context.Context, repoURL string, auth transport.AuthMethod, opts Options) (bool, error) {
+	if opts.Cache == nil {
+		if opts.Offline {
+			return false, errors.New("offline mode needs the cache")
 		}
-		repoPath := strings.TrimSuffix(u.Path, ".git")
-		repoName := filepath.Base(repoPath)
-		if repoName == "" {
-			return "", errors.New("could not determine repository name from URL")
+		return false, nil
+	}
+	switch {
+	case opts.Offline:
+		fmt.Fprintf(opts.Progress, "Using cached mirror without fetching: %s\n", RedactURL(repoURL))
+		return true, nil
+	case opts.Cache.Has(repoURL) || (opts.Depth == 0 && !opts.SingleBranch):
+		if err := opts.Cache.Update(ctx, repoURL, auth, opts.Progress); err != nil {
+			return false, err
 		}
-		return repoName, nil
-	} else {
-		return "", errors.New("invalid repository URL format")
+		return true, nil
 	}
+	fmt.Fprintln(opts.Progress, "Shallow and single-branch clones are not added to the cache.")
+	return false, nil
 }
 
-// CloneOrPullRepo clones the repository from the provided URL into the specified path.
-// If the repository already exists locally, it attempts to pull the latest changes.
+// fetchRepo clones the repository into repoPath, or pulls it if it already exists there, and
+// resolves the reference requested in opts. An empty repoPath clones into memory. When a shallow
+// clone does not contain a requested commit SHA, the repository is cloned again in full.
 //
 // Parameters:
 //   - ctx: The context for the operation.
 //   - repoURL: The URL of the Git repository.
-//   - repoPath: The local file system path where the repository should be cloned.
+//   - repoPath: The local file system path to clone into; empty clones into memory.
 //   - auth: The authentication method to use for accessing the repository.
+//   - opts: Options selecting the reference, history depth and sparse checkout.
 //
 // Returns:
-//   - error: An error if the clone or pull operation fails.
-func CloneOrPullRepo(ctx context.Context, repoURL, repoPath string, auth transport.AuthMethod) error {
-	fmt.Printf("Cloning repository: %s\n", repoURL)
-	_, err := git.PlainCloneContext(ctx, repoPath, false, &git.CloneOptions{
-		URL:      repoURL,
-		Progress: os.Stdout,
-		Auth:     auth,
-	})
+//   - *git.Repository: The cloned repository.
+//   - plumbing.Hash: The commit the reference resolved to.
+//   - error: An error if the clone or pull fails or the reference cannot be resolved.
+func fetchRepo(ctx context.Context, repoURL, repoPath string, auth transport.AuthMethod, opts Options) (*git.Repository, plumbing.Hash, error) {
+	if opts.Depth > 0 {
+		opts.SingleBranch = true
+	}
+
+	// Single-branch clones need to know whether the ref names a branch or a tag up front.
+	var refName plumbing.ReferenceName
+	if opts.Ref != "" && opts.SingleBranch {
+		name, err := lookupRemoteRef(ctx, repoURL, auth, opts.Ref)
+		if err != nil {
+			return nil, plumbing.ZeroHash, err
+		}
+		refName = name
+	}
+
+	fmt.Fprintf(opts.Progress, "Cloning repository: %s\n", RedactURL(repoURL))
+	repo, err := cloneRepo(ctx, repoURL, repoPath, auth, opts, refName)
 	if err != nil {
 		// If the repository already exists, attempt to pull the latest changes
-		if errors.Is(err, git.ErrRepositoryAlreadyExists) {
-			fmt.Println("Repository already exists. Attempting to pull latest changes.")
-			repo, err := git.PlainOpen(repoPath)
-			if err != nil {
-				return fmt.Errorf("failed to open existing repository: %w", err)
-			}
-			w, err := repo.Worktree()
-			if err != nil {
-				return fmt.Errorf("failed to get worktree: %w", err)
+		if !errors.Is(err, git.ErrRepositoryAlreadyExists) {
+			return nil, plumbing.ZeroHash, fmt.Errorf("failed to clone repository: %w", err)
+		}
+		fmt.Fprintln(opts.Progress, "Repository already exists. Attempting to pull latest changes.")
+		repo, err = git.PlainOpen(repoPath)
+		if err != nil {
+			return nil, plumbing.ZeroHash, fmt.Errorf("failed to open existing repository: %w", err)
+		}
+		w, err := repo.Worktree()
+		if err != nil {
+			return nil, plumbing.ZeroHash, fmt.Errorf("failed to get worktree: %w", err)
+		}
+		err = w.Pull(&git.PullOptions{
+			RemoteName:    "origin",
+			ReferenceName: refName,
+			SingleBranch:  opts.SingleBranch,
+			Depth:         opts.Depth,
+			Progress:      opts.Progress,
+			Auth:          auth,
+		})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return nil, plumbing.ZeroHash, fmt.Errorf("failed to pull repository: %w", err)
+		}
+	}
+
+	hash, err := ResolveRef(repo, opts.Ref)
+	if err != nil && opts.Ref != "" && refName == "" && (opts.Depth > 0 || opts.SingleBranch) {
+		// The ref is presumably a commit SHA outside the fetched history; fetch everything.
+		fmt.Fprintf(opts.Progress, "%s is not reachable from the fetched history. Falling back to a full clone.\n", opts.Ref)
+		if repoPath != "" {
+			if err := os.RemoveAll(repoPath); err != nil {
+				return nil, plumbing.ZeroHash, fmt.Errorf("failed to remove shallow clone: %w", err)
 			}
-			err = w.Pull(&git.PullOptions{
-				RemoteName: "origin",
-				Progress:   os.Stdout,
-				Auth:       auth,
-			})
-			if err != nil && err != git.NoErrAlreadyUpToDate {
-				return fmt.Errorf("failed to pull repository: %w", err)
+		}
+		repo, err = cloneRepo(ctx, repoURL, repoPath, auth, Options{Ref: opts.Ref, Progress: opts.Progress, Subdirs: opts.Subdirs}, "")
+		if err != nil {
+			return nil, plumbing.ZeroHash, fmt.Errorf("failed to clone repository: %w", err)
+		}
+		hash, err = ResolveRef(repo, opts.Ref)
+	}
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	return repo, hash, nil
+}
+
+// cloneRepo performs a single clone of repoURL into repoPath using the given options.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - repoURL: The URL of the Git repository.
+//   - repoPath: The local file system path where the repository should be cloned; empty clones into memory without a worktree.
+//   - auth: The authentication method to use for accessing the repository.
+//   - opts: Options controlling the history depth, branch selection and sparse checkout.
+//   - refName: The full name of the branch or tag to clone; empty selects the remote HEAD.
+//
+// Returns:
+//   - *git.Repository: The cloned repository.
+//   - error: An error if the clone fails.
+func cloneRepo(ctx context.Context, repoURL, repoPath string, auth transport.AuthMethod, opts Options, refName plumbing.ReferenceName) (*git.Repository, error) {
+	cloneOpts := &git.CloneOptions{
+		URL:           repoURL,
+		Progress:      opts.Progress,
+		Auth:          auth,
+		ReferenceName: refName,
+		SingleBranch:  opts.SingleBranch,
+		Depth:         opts.Depth,
+		NoCheckout:    len(opts.Subdirs) > 0,
+	}
+	if opts.Ref != "" && !opts.SingleBranch {
+		// Fetch every tag so that tags outside the default branch history can be resolved.
+		cloneOpts.Tags = git.AllTags
+	}
+	if repoPath == "" {
+		return git.CloneContext(ctx, memory.NewStorage(), nil, cloneOpts)
+	}
+	return git.PlainCloneContext(ctx, repoPath, false, cloneOpts)
+}
+
+// lookupRemoteRef lists the references advertised by the remote and returns the full name
+// of the branch or tag matching ref. An empty name is returned when ref names neither,
+// in which case it is treated as a commit SHA.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - repoURL: The URL of the Git repository.
+//   - auth: The authentication method to use for accessing the repository.
+//   - ref: The branch, tag or full reference name to look up.
+//
+// Returns:
+//   - plumbing.ReferenceName: The full reference name, or an empty name if ref is not a branch or tag.
+//   - error: An error if the remote references cannot be listed.
+func lookupRemoteRef(ctx context.Context, repoURL string, auth transport.AuthMethod, ref string) (plumbing.ReferenceName, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{
+		Name: "origin",
+		URLs: []string{repoURL},
+	})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", fmt.Errorf("failed to list remote references: %w", err)
+	}
+
+	candidates := []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(ref),
+		plumbing.NewTagReferenceName(ref),
+		plumbing.ReferenceName(ref),
+	}
+	for _, candidate := range candidates {
+		for _, r := range refs {
+			if r.Name() == candidate && (candidate.IsBranch() || candidate.IsTag()) {
+				return candidate, nil
 			}
-			return nil
 		}
-		return fmt.Errorf("failed to clone repository: %w", err)
 	}
-	return nil
+	return "", nil
+}
+
+// ResolveRef resolves a branch name, tag name or (abbreviated) commit SHA to a commit hash.
+// Branches are looked up both locally and on the "origin" remote, and annotated tags are
+// peeled to the commit they point to. An empty ref resolves to HEAD.
+//
+// Parameters:
+//   - repo: The repository in which to resolve the reference.
+//   - ref: The branch, tag or commit SHA to resolve.
+//
+// Returns:
+//   - plumbing.Hash: The hash of the resolved commit.
+//   - error: An error if the reference cannot be resolved.
+func ResolveRef(repo *git.Repository, ref string) (plumbing.Hash, error) {
+	if ref == "" {
+		head, err := repo.Head()
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to resolve HEAD: %w", err)
+		}
+		return head.Hash(), nil
+	}
+
+	for _, candidate := range []string{ref, "origin/" + ref} {
+		hash, err := repo.ResolveRevision(plumbing.Revision(candidate))
+		if err == nil {
+			return *hash, nil
+		}
+	}
+	return plumbing.ZeroHash, fmt.Errorf("reference %q not found as a branch, tag or commit", ref)
 }
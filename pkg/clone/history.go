@@ -0,0 +1,128 @@
+package clone
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// LastCommitTimes returns, for every file in the HEAD commit of the repository, the time of the
+// most recent commit that changed it, as described for LastCommitTimesAt.
+//
+// Parameters:
+//   - repoPath: The local path of the repository root.
+//
+// Returns:
+//   - map[string]time.Time: The commit times keyed by slash-separated path relative to the repository root.
+//   - error: An error if the path is not the root of a Git repository or its history cannot be read.
+func LastCommitTimes(repoPath string) (map[string]time.Time, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open repository: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve HEAD: %w", err)
+	}
+	return LastCommitTimesAt(repo, head.Hash())
+}
+
+// LastCommitTimesAt returns, for every file in the given commit, the time of the most recent
+// commit that changed it. History is walked from the commit in commit-time order and the walk
+// stops as soon as every file has been seen. In a shallow clone, files whose last change is
+// older than the available history get the time of the oldest available commit.
+//
+// Parameters:
+//   - repo: The repository holding the history.
+//   - commit: The commit whose files are dated.
+//
+// Returns:
+//   - map[string]time.Time: The commit times keyed by slash-separated path relative to the repository root.
+//   - error: An error if the history cannot be read.
+func LastCommitTimesAt(repo *git.Repository, commit plumbing.Hash) (map[string]time.Time, error) {
+	headCommit, err := repo.CommitObject(commit)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read commit %s: %w", commit, err)
+	}
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tree of %s: %w", commit, err)
+	}
+
+	pending := make(map[string]bool)
+	err = headTree.Files().ForEach(func(f *object.File) error {
+		pending[f.Name] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list files: %w", err)
+	}
+
+	times := make(map[string]time.Time, len(pending))
+	commits, err := repo.Log(&git.LogOptions{From: commit, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read history: %w", err)
+	}
+	err = commits.ForEach(func(c *object.Commit) error {
+		if len(pending) == 0 {
+			return storer.ErrStop
+		}
+		changed, err := changedFiles(c)
+		if err != nil {
+			return err
+		}
+		for _, name := range changed {
+			if pending[name] {
+				times[name] = c.Committer.When
+				delete(pending, name)
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, fmt.Errorf("unable to walk history: %w", err)
+	}
+	return times, nil
+}
+
+// changedFiles returns the paths of the files a commit added or modified compared to its first
+// parent. A root commit, or a commit whose parent is missing from a shallow clone, changes every file.
+//
+// Parameters:
+//   - c: The commit to inspect.
+//
+// Returns:
+//   - []string: The slash-separated paths of the changed files.
+//   - error: An error if the trees cannot be read or compared.
+func changedFiles(c *object.Commit) ([]string, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var parentTree *object.Tree
+	if parent, err := c.Parent(0); err == nil {
+		if parentTree, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, object.ErrParentNotFound) && !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(changes))
+	for _, change := range changes {
+		if change.To.Name != "" {
+			names = append(names, change.To.Name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,203 @@
+package clone
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// checkout checks out the commit selected by opts in the worktree of repo. With subdirs, only the
+// files inside subdirs and directly inside their parent directories are written, like a
+// cone-mode sparse checkout by git: the parents keep .gitignore, .gitattributes and .gitmodules
+// files available, and the other files are recorded in the index with the skip-worktree bit.
+//
+// Parameters:
+//   - repo: The repository to check out.
+//   - opts: The commit or branch to check out; opts.Force is implied with subdirs.
+//   - subdirs: Slash-separated directories relative to the repository root; empty checks out everything.
+//
+// Returns:
+//   - error: An error if a subdirectory does not exist in the commit or the checkout fails.
+func checkout(repo *git.Repository, opts *git.CheckoutOptions, subdirs []string) error {
+	w, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if len(subdirs) == 0 {
+		return w.Checkout(opts)
+	}
+
+	// go-git only marks entries already in the index as skip-worktree, which does nothing for a
+	// fresh clone, so the sparse worktree and index are written here instead.
+	hash := opts.Hash
+	head := plumbing.NewHashReference(plumbing.HEAD, hash)
+	if hash.IsZero() {
+		ref, err := repo.Reference(opts.Branch, true)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", opts.Branch, err)
+		}
+		hash, head = ref.Hash(), plumbing.NewSymbolicReference(plumbing.HEAD, opts.Branch)
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("failed to read tree of %s: %w", hash, err)
+	}
+	for _, dir := range subdirs {
+		if _, err := tree.Tree(dir); err != nil {
+			return fmt.Errorf("subdirectory %s not found in %s: %w", dir, hash, err)
+		}
+	}
+
+	idx := &index.Index{Version: 3}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, e, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to walk tree of %s: %w", hash, err)
+		}
+		if e.Mode == filemode.Dir {
+			continue
+		}
+
+		entry := &index.Entry{Name: name, Hash: e.Hash, Mode: e.Mode}
+		if inCone(name, subdirs) {
+			if err := writeTreeEntry(w, tree, name, &e); err != nil {
+				return fmt.Errorf("failed to check out %s: %w", name, err)
+			}
+		} else {
+			entry.SkipWorktree = true
+		}
+		idx.Entries = append(idx.Entries, entry)
+	}
+
+	if err := repo.Storer.SetIndex(idx); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return repo.Storer.SetReference(head)
+}
+
+// writeTreeEntry writes a file of a tree to the worktree. Submodules become empty directories,
+// which UpdateSubmodules fills.
+//
+// Parameters:
+//   - w: The worktree to write to.
+//   - tree: The root tree of the commit being checked out.
+//   - name: The slash-separated path of the entry.
+//   - e: The tree entry.
+//
+// Returns:
+//   - error: An error if the path is unsafe or the file cannot be written.
+func writeTreeEntry(w *git.Worktree, tree *object.Tree, name string, e *object.TreeEntry) error {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." || strings.EqualFold(part, git.GitDirName) {
+			return fmt.Errorf("invalid path %q", name)
+		}
+	}
+	if e.Mode == filemode.Submodule {
+		return w.Filesystem.MkdirAll(name, 0o755)
+	}
+
+	f, err := tree.TreeEntryFile(e)
+	if err != nil {
+		return err
+	}
+	if err := w.Filesystem.MkdirAll(path.Dir(name), 0o755); err != nil {
+		return err
+	}
+	_ = w.Filesystem.Remove(name)
+	if e.Mode == filemode.Symlink {
+		target, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		return w.Filesystem.Symlink(target, name)
+	}
+
+	mode, err := e.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+	from, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := w.Filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(to, from); err != nil {
+		to.Close()
+		return err
+	}
+	return to.Close()
+}
+
+// inCone reports whether a path belongs to the cone-mode sparse checkout of subdirs: it lies
+// inside one of them, or directly inside the repository root or one of their parents.
+func inCone(relPath string, subdirs []string) bool {
+	if InSubdirs(relPath, subdirs) {
+		return true
+	}
+	dir := path.Dir(relPath)
+	for _, subdir := range subdirs {
+		if dir == "." || strings.HasPrefix(subdir, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// InSubdirs reports whether a path lies inside one of the given directories. Every path lies
+// inside an empty list, which selects the whole repository.
+//
+// Parameters:
+//   - relPath: The slash-separated path relative to the repository root.
+//   - subdirs: Slash-separated directories relative to the repository root.
+//
+// Returns:
+//   - bool: True if relPath is one of subdirs or below one of them.
+func InSubdirs(relPath string, subdirs []string) bool {
+	if len(subdirs) == 0 {
+		return true
+	}
+	for _, dir := range subdirs {
+		if relPath == dir || strings.HasPrefix(relPath, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkoutOptions returns the options checking out hash: the branch HEAD points to when no ref
+// was requested, keeping HEAD attached, or the commit itself with a detached HEAD.
+//
+// Parameters:
+//   - repo: The repository to check out.
+//   - hash: The commit the ref resolved to.
+//   - ref: The requested branch, tag or commit SHA; empty selects the branch HEAD points to.
+//
+// Returns:
+//   - *git.CheckoutOptions: The forced checkout of the branch or commit.
+func checkoutOptions(repo *git.Repository, hash plumbing.Hash, ref string) *git.CheckoutOptions {
+	if head, err := repo.Storer.Reference(plumbing.HEAD); ref == "" && err == nil && head.Type() == plumbing.SymbolicReference {
+		return &git.CheckoutOptions{Branch: head.Target(), Force: true}
+	}
+	return &git.CheckoutOptions{Hash: hash, Force: true}
+}
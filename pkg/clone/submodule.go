@@ -0,0 +1,241 @@
+package clone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// ErrSubmoduleProtocol is returned when .gitmodules points a submodule at a protocol that is not
+// allowed, see SubmoduleOptions.
+var ErrSubmoduleProtocol = errors.New("submodule protocol not allowed")
+
+// Submodule describes a submodule checked out by UpdateSubmodules.
+type Submodule struct {
+	Path   string // Slash-separated path of the submodule relative to the top-level repository
+	URL    string // URL the submodule was cloned from, without credentials
+	Commit string // SHA of the commit recorded by the superproject and checked out
+}
+
+// SubmoduleOptions configures how UpdateSubmodules authenticates and reports progress.
+type SubmoduleOptions struct {
+	Auth      transport.AuthMethod                               // Authentication of the superproject, reused for submodules on the same scheme, host and port
+	AuthFor   func(repoURL string) (transport.AuthMethod, error) // Authentication for submodules on other hosts; nil clones them anonymously
+	AllowHTTP bool                                               // Whether submodules may be cloned over plain http://; they never receive credentials
+	AllowFile bool                                               // Whether submodules may be cloned from file:// URLs and local paths
+	Progress  io.Writer                                          // Destination for progress messages; nil discards them
+	Subdirs   []string                                           // Directories of a sparse checkout; submodules of the top-level repository outside them are skipped
+}
+
+// UpdateSubmodules initialises and checks out the submodules of the repository at repoPath,
+// recursively, at the commits recorded by their superprojects. Relative submodule URLs such as
+// ../lib.git are resolved against repoURL. Submodules on the same scheme, host and port as the
+// top-level repository reuse its authentication; the others are authenticated with opts.AuthFor,
+// which is asked once per scheme, host and port. The .gitmodules file is written by whoever
+// controls the repository, so http:// submodules are refused unless opts.AllowHTTP is set, and
+// are always cloned without credentials, and file:// and local path submodules, which would copy
+// repositories from the user's disk into the output, are refused unless opts.AllowFile is set. In a sparse checkout, only the submodules inside
+// opts.Subdirs are checked out.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - repoPath: The local path of the checked out superproject.
+//   - repoURL: The URL the superproject was cloned from.
+//   - opts: Options selecting the authentication and progress output.
+//
+// Returns:
+//   - []Submodule: The submodules checked out, parents before their own submodules.
+//   - error: An error if a submodule cannot be cloned or checked out.
+func UpdateSubmodules(ctx context.Context, repoPath, repoURL string, opts SubmoduleOptions) ([]Submodule, error) {
+	if opts.Progress == nil {
+		opts.Progress = io.Discard
+	}
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	u := &submoduleUpdater{opts: opts, auths: make(map[string]transport.AuthMethod)}
+	if key, ok := authKey(repoURL); ok {
+		u.auths[key] = opts.Auth
+	}
+	if err := u.update(ctx, repo, repoURL, ""); err != nil {
+		return nil, err
+	}
+	return u.found, nil
+}
+
+// submoduleUpdater checks out the submodules of one top-level repository.
+type submoduleUpdater struct {
+	opts  SubmoduleOptions
+	auths map[string]transport.AuthMethod // Authentication by scheme and host, see authKey
+	found []Submodule                     // Submodules checked out so far
+}
+
+// update checks out the submodules of repo and, recursively, their submodules, recording them
+// in u.found.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - repo: The superproject.
+//   - repoURL: The URL the superproject was cloned from.
+//   - prefix: The slash-separated path of the superproject relative to the top-level repository.
+//
+// Returns:
+//   - error: An error if a submodule cannot be cloned or checked out.
+func (u *submoduleUpdater) update(ctx context.Context, repo *git.Repository, repoURL, prefix string) error {
+	w, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	subs, err := w.Submodules()
+	if err != nil {
+		return fmt.Errorf("failed to read submodules: %w", err)
+	}
+
+	for _, sub := range subs {
+		cfg := sub.Config()
+		subPath := path.Join(prefix, cfg.Path)
+		if prefix == "" && !InSubdirs(path.Clean(cfg.Path), u.opts.Subdirs) {
+			continue // Outside the sparse checkout
+		}
+		cfg.URL = ResolveSubmoduleURL(repoURL, cfg.URL)
+		if err := u.checkURL(cfg.URL); err != nil {
+			return fmt.Errorf("submodule %s: %w", subPath, err)
+		}
+
+		auth, err := u.authFor(cfg.URL)
+		if err != nil {
+			return fmt.Errorf("error setting up authentication for submodule %s: %w", subPath, err)
+		}
+
+		fmt.Fprintf(u.opts.Progress, "Cloning submodule %s from %s\n", subPath, RedactURL(cfg.URL))
+		err = sub.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+			Init:              true,
+			Auth:              auth,
+			RecurseSubmodules: git.NoRecurseSubmodules,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to check out submodule %s: %w", subPath, err)
+		}
+
+		status, err := sub.Status()
+		if err != nil {
+			return fmt.Errorf("failed to read status of submodule %s: %w", subPath, err)
+		}
+		u.found = append(u.found, Submodule{Path: subPath, URL: RedactURL(cfg.URL), Commit: status.Expected.String()})
+
+		subRepo, err := sub.Repository()
+		if err != nil {
+			return fmt.Errorf("failed to open submodule %s: %w", subPath, err)
+		}
+		if err := u.update(ctx, subRepo, cfg.URL, subPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkURL refuses submodule URLs over protocols that are not allowed.
+//
+// Parameters:
+//   - repoURL: The absolute URL of the submodule.
+//
+// Returns:
+//   - error: An error wrapping ErrSubmoduleProtocol if the protocol is not allowed.
+func (u *submoduleUpdater) checkURL(repoURL string) error {
+	endpoint, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return errors.New("invalid submodule URL")
+	}
+	switch {
+	case endpoint.Protocol == SchemeHTTP && !u.opts.AllowHTTP:
+		return fmt.Errorf("%w: %s uses plain http://", ErrSubmoduleProtocol, RedactURL(repoURL))
+	case endpoint.Protocol == SchemeFile && !u.opts.AllowFile:
+		return fmt.Errorf("%w: %s points at the local file system", ErrSubmoduleProtocol, repoURL)
+	}
+	return nil
+}
+
+// authFor returns the authentication for a submodule URL, asking opts.AuthFor once for every
+// scheme, host and port that is not known yet. Submodules over plain http:// are never
+// authenticated, whatever their host.
+//
+// Parameters:
+//   - repoURL: The absolute URL of the submodule.
+//
+// Returns:
+//   - transport.AuthMethod: The authentication method; nil for anonymous clones.
+//   - error: An error if opts.AuthFor fails.
+func (u *submoduleUpdater) authFor(repoURL string) (transport.AuthMethod, error) {
+	key, ok := authKey(repoURL)
+	if !ok || strings.HasPrefix(key, SchemeHTTP+"://") {
+		return nil, nil
+	}
+	if auth, ok := u.auths[key]; ok {
+		return auth, nil
+	}
+	var auth transport.AuthMethod
+	if u.opts.AuthFor != nil {
+		var err error
+		if auth, err = u.opts.AuthFor(repoURL); err != nil {
+			return nil, err
+		}
+	}
+	u.auths[key] = auth
+	return auth, nil
+}
+
+// authKey returns the scheme, host and port of a repository URL, which decide whether two
+// repositories can share authentication. URLs that cannot authenticate yield false.
+func authKey(repoURL string) (string, bool) {
+	u, err := ParseRepoURL(repoURL)
+	if err != nil || !u.SupportsAuth() {
+		return "", false
+	}
+	return fmt.Sprintf("%s://%s:%d", u.Scheme, strings.ToLower(u.Host), u.Port), true
+}
+
+// ResolveSubmoduleURL resolves a submodule URL from .gitmodules against the URL of its
+// superproject the way git does: URLs starting with ./ or ../ are relative to the superproject
+// URL, each ../ removing one path element, while other URLs are returned unchanged.
+//
+// Parameters:
+//   - parentURL: The URL of the superproject.
+//   - subURL: The URL of the submodule as written in .gitmodules.
+//
+// Returns:
+//   - string: The absolute URL of the submodule.
+func ResolveSubmoduleURL(parentURL, subURL string) string {
+	if !strings.HasPrefix(subURL, "./") && !strings.HasPrefix(subURL, "../") {
+		return subURL
+	}
+
+	base, rel, sep := strings.TrimRight(parentURL, "/"), subURL, "/"
+	for {
+		if rest, ok := strings.CutPrefix(rel, "./"); ok {
+			rel = rest
+			continue
+		}
+		rest, ok := strings.CutPrefix(rel, "../")
+		if !ok {
+			break
+		}
+		// SCP-like URLs (git@host:owner/repo) separate the host from the path with a colon
+		i := strings.LastIndexAny(base, "/:")
+		if i < 0 || strings.HasSuffix(base[:i+1], "://") {
+			break // Nothing left to remove but the host
+		}
+		if base[i] == ':' {
+			sep = ":"
+		}
+		base, rel = base[:i], rest
+	}
+	return base + sep + rel
+}
@@ -0,0 +1,293 @@
+package clone
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// maxSymlinks is the number of symbolic links followed when opening a file, as in Linux.
+const maxSymlinks = 40
+
+// TreeFS is a read-only file system over the tree of a commit. File contents are read straight
+// from the git object store, so no worktree is needed. Submodules appear as empty directories,
+// as in a checkout without them, and symbolic links to files inside the tree are followed.
+type TreeFS struct {
+	repo *git.Repository
+	tree *object.Tree
+	when time.Time // Commit time, reported as the modification time of every file
+}
+
+// NewTreeFS returns the file system of the tree of a commit.
+//
+// Parameters:
+//   - repo: The repository holding the commit.
+//   - commit: The commit whose tree is read.
+//
+// Returns:
+//   - *TreeFS: The file system rooted at the repository root.
+//   - error: An error if the commit or its tree cannot be read.
+func NewTreeFS(repo *git.Repository, commit plumbing.Hash) (*TreeFS, error) {
+	c, err := repo.CommitObject(commit)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read commit %s: %w", commit, err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tree of %s: %w", commit, err)
+	}
+	return &TreeFS{repo: repo, tree: tree, when: c.Committer.When}, nil
+}
+
+// Open opens the named file or directory, following symbolic links.
+func (t *TreeFS) Open(name string) (fs.File, error) {
+	resolved, e, err := t.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info := t.info(name, e)
+	if info.IsDir() {
+		entries, err := t.ReadDir(resolved)
+		if err != nil {
+			return nil, err
+		}
+		return &treeDir{info: info, entries: entries}, nil
+	}
+
+	blob, err := t.repo.BlobObject(e.Hash)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &treeFile{info: info, reader: reader}, nil
+}
+
+// ReadDir lists the named directory, sorted by file name. Symbolic links are listed as such.
+func (t *TreeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name, e, err := t.resolve("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case e.Mode == filemode.Submodule:
+		return []fs.DirEntry{}, nil
+	case e.Mode != filemode.Dir:
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	tree := t.tree
+	if name != "." {
+		if tree, err = t.tree.Tree(name); err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+	}
+	entries := make([]fs.DirEntry, 0, len(tree.Entries))
+	for i := range tree.Entries {
+		entries = append(entries, fs.FileInfoToDirEntry(t.info(path.Join(name, tree.Entries[i].Name), &tree.Entries[i])))
+	}
+	// Git sorts directories as if their names ended with a slash
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// Stat returns the file information of the named file, following symbolic links.
+func (t *TreeFS) Stat(name string) (fs.FileInfo, error) {
+	_, e, err := t.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return t.info(name, e), nil
+}
+
+// Lstat returns the file information of the named file without following a symbolic link.
+func (t *TreeFS) Lstat(name string) (fs.FileInfo, error) {
+	_, e, err := t.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return t.info(name, e), nil
+}
+
+// ReadLink returns the target of the named symbolic link.
+func (t *TreeFS) ReadLink(name string) (string, error) {
+	_, e, err := t.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if e.Mode != filemode.Symlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	blob, err := t.repo.BlobObject(e.Hash)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	target, err := readBlob(blob)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return target, nil
+}
+
+// resolve finds the tree entry of a path, following symbolic links that stay inside the tree,
+// including links to directories in the middle of the path.
+//
+// Parameters:
+//   - op: The operation, reported in errors.
+//   - name: The slash-separated path, as accepted by fs.ValidPath.
+//   - followLast: Whether a symbolic link as the last element of the path is followed.
+//
+// Returns:
+//   - string: The path the entry was found at, after following symbolic links.
+//   - *object.TreeEntry: The entry; the root is reported as a directory entry.
+//   - error: A *fs.PathError if the path is invalid, missing or a link leaves the tree.
+func (t *TreeFS) resolve(op, name string, followLast bool) (string, *object.TreeEntry, error) {
+	if !fs.ValidPath(name) {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	dir, e := ".", &object.TreeEntry{Mode: filemode.Dir, Hash: t.tree.Hash}
+	pending := strings.Split(name, "/")
+	if name == "." {
+		pending = nil
+	}
+	for links := 0; len(pending) > 0; {
+		current := path.Join(dir, pending[0])
+		entry, err := t.tree.FindEntry(current)
+		if err != nil {
+			return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if entry.Mode != filemode.Symlink || (len(pending) == 1 && !followLast) {
+			dir, e, pending = current, entry, pending[1:]
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+		}
+		blob, err := t.repo.BlobObject(entry.Hash)
+		if err != nil {
+			return "", nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		target, err := readBlob(blob)
+		if err != nil {
+			return "", nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		target = path.Join(dir, target)
+		if path.IsAbs(target) || !fs.ValidPath(target) {
+			return "", nil, &fs.PathError{Op: op, Path: name, Err: errors.New("symbolic link points outside the repository")}
+		}
+		// Resolve the target from the root, followed by the rest of the path
+		dir, e = ".", &object.TreeEntry{Mode: filemode.Dir, Hash: t.tree.Hash}
+		if target == "." {
+			pending = pending[1:]
+		} else {
+			pending = append(strings.Split(target, "/"), pending[1:]...)
+		}
+	}
+	return dir, e, nil
+}
+
+// info returns the file information of a tree entry, named after the last element of name.
+func (t *TreeFS) info(name string, e *object.TreeEntry) *treeInfo {
+	return &treeInfo{fsys: t, name: path.Base(name), entry: *e}
+}
+
+// readBlob reads the whole content of a blob.
+func readBlob(blob *object.Blob) (string, error) {
+	reader, err := blob.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	return string(data), err
+}
+
+// treeInfo describes a tree entry as fs.FileInfo. The size of a file is looked up when needed.
+type treeInfo struct {
+	fsys  *TreeFS
+	name  string
+	entry object.TreeEntry
+}
+
+func (i *treeInfo) Name() string       { return i.name }
+func (i *treeInfo) ModTime() time.Time { return i.fsys.when }
+func (i *treeInfo) IsDir() bool        { return i.Mode().IsDir() }
+func (i *treeInfo) Sys() any           { return i.entry }
+
+// Size returns the size of the blob of a file; directories have size 0.
+func (i *treeInfo) Size() int64 {
+	if i.IsDir() {
+		return 0
+	}
+	blob, err := i.fsys.repo.BlobObject(i.entry.Hash)
+	if err != nil {
+		return 0
+	}
+	return blob.Size
+}
+
+// Mode maps the git file mode to fs.FileMode. Submodules are directories.
+func (i *treeInfo) Mode() fs.FileMode {
+	switch i.entry.Mode {
+	case filemode.Dir, filemode.Submodule:
+		return fs.ModeDir | 0o755
+	case filemode.Symlink:
+		return fs.ModeSymlink | 0o777
+	case filemode.Executable:
+		return 0o755
+	default:
+		return 0o644
+	}
+}
+
+// treeFile is an open file of a TreeFS.
+type treeFile struct {
+	info   fs.FileInfo
+	reader io.ReadCloser
+}
+
+func (f *treeFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *treeFile) Read(b []byte) (int, error) { return f.reader.Read(b) }
+func (f *treeFile) Close() error               { return f.reader.Close() }
+
+// treeDir is an open directory of a TreeFS.
+type treeDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *treeDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *treeDir) Close() error               { return nil }
+
+func (d *treeDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries of the directory, or all remaining entries if n <= 0.
+func (d *treeDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
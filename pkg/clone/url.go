@@ -0,0 +1,174 @@
+package clone
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Schemes of the repository URLs that can be cloned. SCP-like URLs such as git@host:owner/repo
+// use the ssh scheme.
+const (
+	SchemeHTTPS = "https"
+	SchemeHTTP  = "http"
+	SchemeSSH   = "ssh"
+	SchemeGit   = "git"
+	SchemeFile  = "file"
+)
+
+// Provider identifies the Git hosting service behind a repository URL.
+type Provider string
+
+// Known Git hosting services. Hosts that are not recognised are reported as ProviderGeneric.
+const (
+	ProviderGitHub    Provider = "GitHub"
+	ProviderGitLab    Provider = "GitLab"
+	ProviderBitbucket Provider = "Bitbucket"
+	ProviderGitea     Provider = "Gitea"
+	ProviderAzure     Provider = "Azure DevOps"
+	ProviderGeneric   Provider = "Git"
+)
+
+// RepoURL is a parsed repository URL.
+type RepoURL struct {
+	Scheme string // One of the Scheme constants
+	User   string // User name given in the URL, if any
+	Host   string // Host name without the port; empty for file URLs
+	Port   int    // Port given in the URL; 0 selects the default port of the scheme
+	Path   string // Path of the repository on the host, or on disk for file URLs
+}
+
+// ParseRepoURL parses a repository URL for any Git host. It accepts https:// and http:// URLs,
+// ssh:// URLs including a user and port, SCP-like SSH URLs such as git@host:group/repo.git,
+// git:// URLs and file:// URLs.
+//
+// Parameters:
+//   - repoURL: The URL of the Git repository.
+//
+// Returns:
+//   - *RepoURL: The parsed URL.
+//   - error: An error if the URL is malformed or uses an unsupported scheme.
+func ParseRepoURL(repoURL string) (*RepoURL, error) {
+	repoURL = strings.TrimSpace(repoURL)
+	if repoURL == "" {
+		return nil, errors.New("repository URL cannot be empty")
+	}
+
+	// The parse error quotes the URL, which may embed a token, so it is not wrapped
+	endpoint, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return nil, errors.New("invalid repository URL: the URL cannot be parsed")
+	}
+
+	switch endpoint.Protocol {
+	case SchemeHTTPS, SchemeHTTP, SchemeSSH, SchemeGit:
+		if endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid repository URL %q: missing host", RedactURL(repoURL))
+		}
+	case SchemeFile:
+		// Bare paths are local directories, not URLs
+		if !strings.HasPrefix(repoURL, "file://") {
+			return nil, errors.New("invalid repository URL format")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported repository URL scheme %q: use https, http, ssh, git or file", endpoint.Protocol)
+	}
+
+	u := &RepoURL{
+		Scheme: endpoint.Protocol,
+		User:   endpoint.User,
+		Host:   endpoint.Host,
+		Path:   endpoint.Path,
+	}
+	// SCP-like URLs report the default SSH port even though none was given
+	if !(u.Scheme == SchemeSSH && endpoint.Port == 22) {
+		u.Port = endpoint.Port
+	}
+	return u, nil
+}
+
+// Name returns the name of the repository: the last element of the path without a .git suffix.
+// This covers nested GitLab groups (group/subgroup/repo) and Azure DevOps paths
+// (org/project/_git/repo).
+//
+// Returns:
+//   - string: The repository name.
+//   - error: An error if the path does not name a repository.
+func (u *RepoURL) Name() (string, error) {
+	repoPath := strings.TrimRight(u.Path, "/")
+	repoPath = strings.TrimSuffix(repoPath, "/.git")
+	repoPath = strings.TrimSuffix(repoPath, ".git")
+	name := path.Base(repoPath)
+	if name == "" || name == "." || name == "/" || name == "_git" {
+		return "", errors.New("could not determine repository name from URL")
+	}
+	return name, nil
+}
+
+// Provider returns the Git hosting service serving the repository, derived from the host name.
+//
+// Returns:
+//   - Provider: The hosting service, or ProviderGeneric for unrecognised and self-hosted servers.
+func (u *RepoURL) Provider() Provider {
+	host := strings.ToLower(u.Host)
+	switch {
+	case host == "github.com" || strings.HasSuffix(host, ".github.com") || strings.HasPrefix(host, "github."):
+		return ProviderGitHub
+	case host == "gitlab.com" || strings.HasPrefix(host, "gitlab."):
+		return ProviderGitLab
+	case host == "bitbucket.org" || strings.HasPrefix(host, "bitbucket."):
+		return ProviderBitbucket
+	case host == "dev.azure.com" || host == "ssh.dev.azure.com" || strings.HasSuffix(host, ".visualstudio.com"):
+		return ProviderAzure
+	case host == "codeberg.org" || host == "gitea.com" || strings.HasPrefix(host, "gitea."):
+		return ProviderGitea
+	default:
+		return ProviderGeneric
+	}
+}
+
+// SupportsAuth reports whether clones over the URL's scheme can authenticate. git:// and file://
+// URLs never authenticate.
+func (u *RepoURL) SupportsAuth() bool {
+	return u.Scheme != SchemeGit && u.Scheme != SchemeFile
+}
+
+// IsSSH reports whether the URL is cloned over SSH.
+func (u *RepoURL) IsSSH() bool {
+	return u.Scheme == SchemeSSH
+}
+
+// IsHTTP reports whether the URL is cloned over HTTPS or HTTP.
+func (u *RepoURL) IsHTTP() bool {
+	return u.Scheme == SchemeHTTPS || u.Scheme == SchemeHTTP
+}
+
+// RedactURL removes credentials embedded in a repository URL so that it can be logged or written
+// to the output. HTTP URLs lose their user info entirely, since the user name is often a token
+// itself (https://TOKEN@host/repo.git); other URLs keep the user name but lose any password.
+// Strings that are not URLs are returned unchanged.
+//
+// Parameters:
+//   - repoURL: The repository URL.
+//
+// Returns:
+//   - string: The URL without credentials.
+func RedactURL(repoURL string) string {
+	if !strings.Contains(repoURL, "://") {
+		return repoURL
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil || u.User == nil {
+		return repoURL
+	}
+	if u.Scheme == SchemeHTTPS || u.Scheme == SchemeHTTP {
+		u.User = nil
+	} else {
+		u.User = url.User(u.User.Username())
+	}
+	return u.String()
+}
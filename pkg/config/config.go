@@ -8,7 +8,13 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
+
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/tokens"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/util"
 )
 
 // Constants define default values and version information for the tool.
@@ -25,10 +31,96 @@ const (
 	// DefaultSSHKeyName is the default name for the SSH key file.
 	DefaultSSHKeyName = "git"
 
+	// DefaultShallowDepth is the number of commits fetched by a shallow clone when no depth is given.
+	DefaultShallowDepth = 1
+
 	// DefaultExcludedExt is the default file extension to exclude from processing.
 	DefaultExcludedExt = ".ipynb"
+
+	// DefaultTopFiles is the number of largest files by tokens reported after each run.
+	DefaultTopFiles = 10
+
+	// DefaultJobs is the number of repositories fetched concurrently when packing several.
+	DefaultJobs = 4
+
+	// StdoutOutput is the -o value that writes the output to standard output.
+	StdoutOutput = "-"
 )
 
+// Output formats supported by the -format flag.
+const (
+	FormatText     = "text"
+	FormatMarkdown = "markdown"
+	FormatXML      = "xml"
+	FormatJSON     = "json"
+	FormatJSONL    = "jsonl"
+)
+
+// Formats lists the supported output formats.
+var Formats = []string{FormatText, FormatMarkdown, FormatXML, FormatJSON, FormatJSONL}
+
+// Orders in which files compete for the token budget set by the -max-tokens flag. The README
+// and entry points always come first; the priority decides the order of the remaining files.
+const (
+	PriorityDepth   = "depth"   // Shallower paths first
+	PriorityRecency = "recency" // Most recently changed files first
+	PrioritySize    = "size"    // Smallest files first
+)
+
+// Priorities lists the supported priorities.
+var Priorities = []string{PriorityDepth, PriorityRecency, PrioritySize}
+
+// Ways of handling a file that does not fit in the remaining token budget.
+const (
+	OverflowTruncate = "truncate" // Pack the beginning of the file that fits
+	OverflowDrop     = "drop"     // Leave the file out
+)
+
+// Overflows lists the supported overflow modes.
+var Overflows = []string{OverflowTruncate, OverflowDrop}
+
+// Units in which the size of output chunks is measured by the -chunk-size flag.
+const (
+	ChunkBytes  = "bytes"
+	ChunkLines  = "lines"
+	ChunkTokens = "tokens"
+)
+
+// ChunkUnits lists the supported chunk size units.
+var ChunkUnits = []string{ChunkBytes, ChunkLines, ChunkTokens}
+
+// Ways of resolving a -files name that matches more than one file in the repository.
+const (
+	MatchPrompt = "prompt" // Ask which file to include
+	MatchAll    = "all"    // Include every matching file
+	MatchFirst  = "first"  // Include the first match in path order
+	MatchError  = "error"  // Fail with the list of matches
+)
+
+// MatchPolicies lists the supported policies for multiple file matches.
+var MatchPolicies = []string{MatchPrompt, MatchAll, MatchFirst, MatchError}
+
+// Ways of checking the host key of SSH servers against the known_hosts files. A host key that
+// differs from the one in known_hosts is always rejected.
+const (
+	HostKeyStrict    = "strict"     // Reject hosts that are not in known_hosts
+	HostKeyAcceptNew = "accept-new" // Add the key of hosts that are not in known_hosts
+)
+
+// HostKeyPolicies lists the supported host key checking policies.
+var HostKeyPolicies = []string{HostKeyStrict, HostKeyAcceptNew}
+
+// Ways of packing files stored with Git LFS, whose checkouts hold small pointer files in place of
+// the content unless the LFS objects were downloaded.
+const (
+	LFSResolve = "resolve" // Pack the object from the local LFS store, or mark the pointer when it is missing
+	LFSMark    = "mark"    // Mark every pointer file as an LFS object instead of packing it
+	LFSPointer = "pointer" // Pack the pointer text as if it were the file content
+)
+
+// LFSModes lists the supported ways of packing Git LFS pointer files.
+var LFSModes = []string{LFSResolve, LFSMark, LFSPointer}
+
 // AuthMethod represents the type of authentication to use when accessing repositories.
 type AuthMethod int
 
@@ -42,19 +134,60 @@ const (
 // Config holds all configuration options for the repo-to-txt tool.
 type Config struct {
 	RepoURL             string     // URL of the Git repository to clone
+	LocalPath           string     // Path to a local directory or checkout to pack instead of cloning
+	ArchivePath         string     // Path to a tarball, zip archive or git bundle to pack instead of cloning
+	MaxArchiveSize      int64      // Total size in bytes the files of an archive may expand to; 0 selects the default
+	Ref                 string     // Branch, tag or commit SHA to snapshot instead of the default branch
+	Depth               int        // Number of commits to fetch for shallow clones; 0 fetches the full history
+	SingleBranch        bool       // Fetch only the requested branch or tag when cloning
 	AuthMethod          AuthMethod // Authentication method to use
-	Username            string     // GitHub username for HTTPS authentication
-	PersonalAccessToken string     // GitHub personal access token for HTTPS authentication
+	Username            string     // Username for HTTPS authentication
+	PersonalAccessToken string     // Personal access token, app password or password for HTTPS authentication
 	SSHKeyPath          string     // Path to SSH key for SSH authentication
 	SSHPassphrase       string     // Passphrase for SSH key, if any
-	ExcludeFolders      []string   // List of folders to exclude from processing
+	SSHHostKeyChecking  string     // Host key checking: strict or accept-new; empty follows StrictHostKeyChecking in ~/.ssh/config
+	ExcludeFolders      []string   // List of folders or glob patterns to exclude from processing
+	IncludePatterns     []string   // List of glob patterns selecting the files to include in processing
 	IncludeExt          []string   // List of file extensions to include in processing
+	NoGitignore         bool       // Disable .gitignore and .gitattributes based exclusions
+	NoGitignoreSet      bool       // Indicates if no-gitignore was set via flag
+	UserIgnoreFile      string     // Path to the user-level ignore file, if it exists
 	FileNames           []string   // List of exact file names to copy from the repository
+	Format              string     // Output format: text, markdown, xml, json or jsonl
+	Tree                bool       // Write a directory tree overview before the file contents
+	Tokenizer           string     // Tokenizer used to count tokens: cl100k, o200k, heuristic or none
+	TopFiles            int        // Number of largest files by tokens to report after each run
+	MaxTokens           int        // Token budget for the packed files; 0 disables the budget
+	Priority            string     // Order in which files compete for the budget: depth, recency or size
+	Overflow            string     // Handling of files that do not fit the budget: truncate or drop
+	ChunkSize           int        // Maximum size of each output chunk; 0 writes a single output file
+	ChunkUnit           string     // Unit of the chunk size: bytes, lines or tokens
 	OutputDir           string     // Directory to output the generated text file
+	Output              string     // Explicit output file, or "-" for standard output; overrides OutputDir
 	AuthFlagSet         bool       // Indicates if authentication method was set via flag
 	VersionFlag         bool       // Flag to print version information
 	CopyToClipboard     bool       // Flag to copy output to clipboard
 	CopyToClipboardSet  bool       // Indicates if copy-to-clipboard was set via flag
+	NonInteractive      bool       // Never prompt; fail on missing required inputs and use defaults for optional ones
+	MultipleMatches     string     // Policy for file names with several matches: prompt, all, first or error; empty selects the default
+	Cache               bool       // Fetch remote repositories into the clone cache and check them out from there; implied by Offline
+	NoCache             bool       // Never use the clone cache; the default unless Cache is set
+	Offline             bool       // Use the cached mirror only, without contacting the remote
+	CacheDir            string     // Directory of the clone cache; empty selects the default
+	CacheMaxSize        int64      // Size in bytes the cache is pruned to after each run; 0 disables the limit
+	Submodules          bool       // Check out submodules recursively and pack their files under their paths
+	SubmodulesAllowHTTP bool       // Allow submodules to be cloned over plain http://, which never receives credentials
+	SubmodulesAllowFile bool       // Allow submodules to be cloned from file:// URLs and local paths
+	LFS                 string     // Packing of Git LFS pointer files: resolve, mark or pointer
+	Subdirs             []string   // Slash-separated directories relative to the repository root to check out and pack; empty packs everything
+	InMemory            bool       // Read files from the git object store instead of checking out a worktree
+	Repos               []RepoSpec // Repositories to pack in one run, from repeated -repo flags and -manifest; empty when packing a single repository
+	Manifest            string     // Path to the YAML manifest listing the repositories to pack
+	CombinedName        string     // Name of the combined output when packing several repositories; empty derives it from the repositories
+	Jobs                int        // Number of repositories fetched concurrently when packing several
+	PerRepo             bool       // Write one output per repository and an index instead of a combined document
+
+	userConfig *FileConfig // User configuration file loaded by LoadUserConfig
 }
 
 // NewConfig creates and returns a new Config instance with default values.
@@ -66,32 +199,82 @@ func NewConfig() *Config {
 // It handles required flags, default values, and validates authentication methods.
 func (cfg *Config) ParseFlags() error {
 	var authMethod string
-	var excludeFolders, includeExt, files string
+	var includeExt, files string
+	var excludePatterns, includePatterns, subdirs patternList
+	var repos stringList
+	var shallow bool
+	var cacheMaxSize, maxArchiveSize string
+
+	// Use a dedicated flag set so that flags can be parsed more than once (e.g., in tests).
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
 	// Define command-line flags
-	flag.StringVar(&cfg.RepoURL, "repo", "", "GitHub repository URL (HTTPS or SSH) (Required)")
-	flag.StringVar(&authMethod, "auth", "", "Authentication method: none, https, or ssh (Required)")
-	flag.StringVar(&cfg.Username, "username", "", "GitHub username (for HTTPS)")
-	flag.StringVar(&cfg.PersonalAccessToken, "pat", "", "GitHub Personal Access Token (for HTTPS)")
-	flag.StringVar(&cfg.SSHKeyPath, "ssh-key", "", "Path to SSH private key (for SSH)")
-	flag.StringVar(&cfg.OutputDir, "output-dir", "", "Output directory for the generated text file")
-	flag.StringVar(&excludeFolders, "exclude", "", "Comma-separated list of folders to exclude from the output")
-	flag.StringVar(&includeExt, "include-ext", "", "Comma-separated list of file extensions to include (e.g., .go,.md). If not set, defaults to excluding certain non-code files like .ipynb")
-	flag.StringVar(&files, "files", "", "Comma-separated list of exact file names to copy from the repository")
-	flag.BoolVar(&cfg.VersionFlag, "version", false, "Print the version number and exit")
-	flag.BoolVar(&cfg.CopyToClipboard, "copy-clipboard", false, "Copy the output to clipboard")
+	fs.Var(&repos, "repo", "Git repository URL (HTTPS, SSH, git:// or file://) on any host, or path to a local directory, archive or git bundle (Required). Repeat to pack several repositories in one run")
+	fs.StringVar(&cfg.Manifest, "manifest", "", "Path to a YAML manifest listing the repositories to pack in one run, with optional refs, subdirectories and names")
+	fs.IntVar(&cfg.Jobs, "jobs", DefaultJobs, "Number of repositories fetched concurrently when packing several")
+	fs.BoolVar(&cfg.PerRepo, "per-repo", false, "When packing several repositories, write one output per repository and an index instead of a single combined document")
+	fs.StringVar(&cfg.LocalPath, "path", "", "Path to a local directory or existing checkout to pack without cloning")
+	fs.StringVar(&cfg.ArchivePath, "archive", "", "Path to a .tar, .tar.gz, .tar.bz2 or .zip archive or a git bundle to pack without cloning or extracting it")
+	fs.StringVar(&maxArchiveSize, "max-archive-size", "", "Refuse archives whose files expand beyond this total size (e.g., 500MB; default 1GB)")
+	fs.StringVar(&cfg.Ref, "ref", "", "Branch, tag or commit SHA to snapshot (defaults to the default branch, or the HEAD of a git bundle)")
+	fs.BoolVar(&shallow, "shallow", false, fmt.Sprintf("Fetch only the tip of the history (depth %d unless -depth is set)", DefaultShallowDepth))
+	fs.IntVar(&cfg.Depth, "depth", 0, "Number of commits to fetch when cloning (implies -shallow and -single-branch)")
+	fs.BoolVar(&cfg.SingleBranch, "single-branch", false, "Fetch only the branch or tag being packed instead of every branch")
+	fs.BoolVar(&cfg.Submodules, "submodules", false, "Check out submodules recursively and pack their files under their paths")
+	fs.BoolVar(&cfg.SubmodulesAllowHTTP, "submodules-allow-http", false, "Allow submodules to be cloned over plain http://, without credentials")
+	fs.BoolVar(&cfg.SubmodulesAllowFile, "submodules-allow-file", false, "Allow submodules to be cloned from file:// URLs and local paths")
+	fs.BoolVar(&cfg.InMemory, "in-memory", false, "Pack straight from the git objects, cloned into memory or read from the cache, without checking out a worktree")
+	fs.Var(&subdirs, "subdir", "Comma-separated list of directories, relative to the repository root, to check out and pack instead of the whole repository (e.g., services/billing). Can be repeated")
+	fs.StringVar(&cfg.LFS, "lfs", LFSResolve, fmt.Sprintf("How to pack Git LFS pointer files: %s (resolve reads the local LFS store and marks missing objects)", strings.Join(LFSModes, ", ")))
+	fs.StringVar(&authMethod, "auth", "", "Authentication method: none, https, or ssh (Required)")
+	fs.StringVar(&cfg.Username, "username", "", "Username (for HTTPS)")
+	fs.StringVar(&cfg.PersonalAccessToken, "pat", "", "Personal access token, app password or password (for HTTPS); prefer REPO_TO_TXT_TOKEN, a host variable such as GITHUB_TOKEN, ~/.netrc or a git credential helper, which keep it out of process listings")
+	fs.StringVar(&cfg.SSHKeyPath, "ssh-key", "", "Path to SSH private key (for SSH); defaults to ssh-agent keys and the IdentityFile in ~/.ssh/config or ~/.ssh/id_ed25519, id_ecdsa and id_rsa")
+	fs.StringVar(&cfg.SSHPassphrase, "ssh-passphrase", "", "Passphrase of the SSH private key, if it is encrypted")
+	fs.StringVar(&cfg.SSHHostKeyChecking, "ssh-host-key-checking", "", fmt.Sprintf("How to check SSH host keys against known_hosts: %s (default strict, or StrictHostKeyChecking from ~/.ssh/config)", strings.Join(HostKeyPolicies, ", ")))
+	fs.StringVar(&cfg.OutputDir, "output-dir", "", "Output directory for the generated text file")
+	fs.StringVar(&cfg.Output, "o", "", "Output file path, or - to write to standard output (overrides -output-dir)")
+	fs.StringVar(&cfg.Format, "format", FormatText, "Output format: text, markdown, xml, json or jsonl")
+	fs.StringVar(&cfg.Tokenizer, "tokenizer", tokens.CL100K, fmt.Sprintf("Tokenizer used to count tokens: %s", strings.Join(tokens.Names, ", ")))
+	fs.IntVar(&cfg.TopFiles, "top-files", DefaultTopFiles, "Number of largest files by tokens to report after each run (0 disables the report)")
+	fs.IntVar(&cfg.MaxTokens, "max-tokens", 0, "Maximum number of tokens to pack; lower-priority files are truncated or dropped to fit (0 disables the budget)")
+	fs.StringVar(&cfg.Priority, "priority", PriorityDepth, fmt.Sprintf("Order in which files compete for the token budget after the README and entry points: %s", strings.Join(Priorities, ", ")))
+	fs.StringVar(&cfg.Overflow, "overflow", OverflowTruncate, fmt.Sprintf("What to do with files that exceed the token budget: %s", strings.Join(Overflows, ", ")))
+	fs.IntVar(&cfg.ChunkSize, "chunk-size", 0, "Split the output into numbered part files of at most this size, measured in -chunk-unit (0 writes a single file)")
+	fs.StringVar(&cfg.ChunkUnit, "chunk-unit", ChunkBytes, fmt.Sprintf("Unit of -chunk-size: %s", strings.Join(ChunkUnits, ", ")))
+	fs.BoolVar(&cfg.Tree, "tree", false, "Write a directory tree overview before the file contents")
+	fs.Var(&excludePatterns, "exclude", "Comma-separated list of folders or glob patterns to exclude (e.g., docs,'**/*_test.go'); prefix with ! to re-include. Can be repeated")
+	fs.Var(&includePatterns, "include", "Comma-separated list of glob patterns selecting the files to include (e.g., 'src/**/*.go'). Can be repeated")
+	fs.StringVar(&includeExt, "include-ext", "", "Comma-separated list of file extensions to include (e.g., .go,.md). If not set, defaults to excluding certain non-code files like .ipynb")
+	fs.BoolVar(&cfg.NoGitignore, "no-gitignore", false, "Do not exclude files ignored by .gitignore or marked export-ignore, linguist-generated, linguist-vendored or binary in .gitattributes")
+	fs.StringVar(&files, "files", "", "Comma-separated list of exact file names to copy from the repository")
+	fs.BoolVar(&cfg.VersionFlag, "version", false, "Print the version number and exit")
+	fs.BoolVar(&cfg.CopyToClipboard, "copy-clipboard", false, "Copy the output to clipboard")
+	fs.BoolVar(&cfg.NonInteractive, "non-interactive", false, "Never prompt: fail if required inputs are missing and use defaults for optional ones (automatic when standard input is not a terminal)")
+	fs.BoolVar(&cfg.NonInteractive, "yes", false, "Alias for -non-interactive")
+	fs.BoolVar(&cfg.Cache, "cache", false, "Fetch remote repositories into the clone cache, which keeps a full mirror of each on disk, so later runs only download new commits")
+	fs.BoolVar(&cfg.NoCache, "no-cache", false, "Clone into a temporary directory without using the clone cache (the default unless -cache is given)")
+	fs.BoolVar(&cfg.Offline, "offline", false, "Pack the cached copy of the repository without contacting the remote")
+	fs.StringVar(&cfg.CacheDir, "cache-dir", "", "Directory of the clone cache (defaults to $REPO_TO_TXT_CACHE_DIR or the user cache directory)")
+	fs.StringVar(&cacheMaxSize, "cache-max-size", "", "Prune the least recently used repositories after each run until the cache fits this size (e.g., 5GB)")
+	fs.StringVar(&cfg.MultipleMatches, "multiple-matches", "", fmt.Sprintf("How to resolve a -files name matching several files: %s (default prompt, or error when not interactive)", strings.Join(MatchPolicies, ", ")))
 
 	// Parse the flags
-	flag.Parse()
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return err
+	}
 
 	// Check if copy-to-clipboard was set via flag
-	flag.Visit(func(f *flag.Flag) {
+	fs.Visit(func(f *flag.Flag) {
 		if f.Name == "copy-clipboard" {
 			cfg.CopyToClipboardSet = true
 		}
 		if f.Name == "auth" {
 			cfg.AuthFlagSet = true
 		}
+		if f.Name == "no-gitignore" {
+			cfg.NoGitignoreSet = true
+		}
 	})
 
 	// Handle version flag
@@ -101,10 +284,110 @@ func (cfg *Config) ParseFlags() error {
 	}
 
 	// Process comma-separated inputs
-	cfg.ExcludeFolders = parseCommaSeparated(excludeFolders)
+	cfg.ExcludeFolders = excludePatterns
+	cfg.IncludePatterns = includePatterns
+	cfg.Subdirs = subdirs
 	cfg.IncludeExt = parseCommaSeparated(includeExt)
+	if len(repos) == 1 {
+		cfg.RepoURL = repos[0]
+	}
 	cfg.FileNames = parseCommaSeparated(files)
 
+	// A shallow clone without an explicit depth fetches only the tip commit
+	if cfg.Depth < 0 {
+		return errors.New("depth must not be negative")
+	}
+	if shallow && cfg.Depth == 0 {
+		cfg.Depth = DefaultShallowDepth
+	}
+
+	// Several -repo values or a manifest pack several repositories in one run
+	if len(repos) > 1 || cfg.Manifest != "" {
+		if err := cfg.setRepos(repos); err != nil {
+			return err
+		}
+	} else if cfg.PerRepo {
+		return errors.New("-per-repo requires several -repo values or a -manifest")
+	}
+
+	// Treat a -repo value that points to an existing directory as a local source, and a -repo
+	// or -path value that points to an existing file as an archive
+	if countSet(cfg.RepoURL, cfg.LocalPath, cfg.ArchivePath) > 1 {
+		return errors.New("only one of -repo, -path or -archive can be specified")
+	}
+	switch {
+	case isLocalFile(cfg.RepoURL):
+		cfg.ArchivePath, cfg.RepoURL = cfg.RepoURL, ""
+	case isLocalFile(cfg.LocalPath):
+		cfg.ArchivePath, cfg.LocalPath = cfg.LocalPath, ""
+	case cfg.LocalPath == "" && isLocalDir(cfg.RepoURL):
+		cfg.LocalPath = cfg.RepoURL
+		cfg.RepoURL = ""
+	}
+	if cfg.LocalPath != "" && cfg.Ref != "" {
+		return errors.New("-ref cannot be used with a local directory")
+	}
+
+	if err := cfg.ValidateOutputOptions(); err != nil {
+		return err
+	}
+
+	// Validate the policy for file names with several matches
+	cfg.MultipleMatches = strings.ToLower(cfg.MultipleMatches)
+	if cfg.MultipleMatches != "" && !slices.Contains(MatchPolicies, cfg.MultipleMatches) {
+		return fmt.Errorf("invalid multiple-matches policy %q: choose from %s", cfg.MultipleMatches, strings.Join(MatchPolicies, ", "))
+	}
+	if cfg.NonInteractive && cfg.MultipleMatches == MatchPrompt {
+		return errors.New("-multiple-matches prompt cannot be used in non-interactive mode")
+	}
+
+	// Validate the clone cache options
+	if cfg.Offline && cfg.NoCache {
+		return errors.New("-offline cannot be used with -no-cache")
+	}
+	if cfg.Cache && cfg.NoCache {
+		return errors.New("-cache cannot be used with -no-cache")
+	}
+	if cfg.Offline {
+		cfg.Cache = true // Offline runs read the cache
+	}
+	if cfg.Offline && cfg.Submodules {
+		return errors.New("-submodules cannot be used with -offline, since submodules are not cached")
+	}
+	if cacheMaxSize != "" {
+		size, err := util.ParseSize(cacheMaxSize)
+		if err != nil {
+			return fmt.Errorf("invalid cache-max-size: %w", err)
+		}
+		cfg.CacheMaxSize = size
+	}
+
+	// Validate the archive options
+	if maxArchiveSize != "" {
+		size, err := util.ParseSize(maxArchiveSize)
+		if err != nil {
+			return fmt.Errorf("invalid max-archive-size: %w", err)
+		}
+		cfg.MaxArchiveSize = size
+	}
+	if cfg.ArchivePath != "" && cfg.Submodules {
+		return errors.New("-submodules cannot be used with an archive")
+	}
+
+	// Validate the in-memory mode, which has no worktree to check submodules out into
+	if cfg.InMemory && cfg.LocalPath != "" {
+		return errors.New("-in-memory cannot be used with a local directory")
+	}
+	if cfg.InMemory && cfg.Submodules {
+		return errors.New("-submodules cannot be used with -in-memory")
+	}
+
+	// Validate the SSH host key checking policy
+	cfg.SSHHostKeyChecking = strings.ToLower(cfg.SSHHostKeyChecking)
+	if cfg.SSHHostKeyChecking != "" && !slices.Contains(HostKeyPolicies, cfg.SSHHostKeyChecking) {
+		return fmt.Errorf("invalid ssh-host-key-checking policy %q: choose from %s", cfg.SSHHostKeyChecking, strings.Join(HostKeyPolicies, ", "))
+	}
+
 	// Set authentication method
 	switch strings.ToLower(authMethod) {
 	case "https":
@@ -120,6 +403,218 @@ func (cfg *Config) ParseFlags() error {
 	return nil
 }
 
+// ValidateOutputOptions normalises and validates the output format, tokenizer, token budget,
+// chunking, Git LFS and subdirectory options. Names are matched case-insensitively and stored in lower case.
+//
+// Returns:
+//   - error: An error describing the first invalid or conflicting option.
+func (cfg *Config) ValidateOutputOptions() error {
+	// Validate the output format
+	cfg.Format = strings.ToLower(cfg.Format)
+	switch cfg.Format {
+	case FormatText, FormatMarkdown, FormatXML, FormatJSON, FormatJSONL:
+	default:
+		return fmt.Errorf("invalid output format %q: choose from %s", cfg.Format, strings.Join(Formats, ", "))
+	}
+
+	// Validate the tokenizer
+	cfg.Tokenizer = strings.ToLower(cfg.Tokenizer)
+	if !slices.Contains(tokens.Names, cfg.Tokenizer) {
+		return fmt.Errorf("invalid tokenizer %q: choose from %s", cfg.Tokenizer, strings.Join(tokens.Names, ", "))
+	}
+	if cfg.TopFiles < 0 {
+		return errors.New("top-files must not be negative")
+	}
+
+	// Validate the token budget
+	if cfg.MaxTokens < 0 {
+		return errors.New("max-tokens must not be negative")
+	}
+	if cfg.MaxTokens > 0 && cfg.Tokenizer == tokens.None {
+		return errors.New("-max-tokens requires a tokenizer other than none")
+	}
+	cfg.Priority = strings.ToLower(cfg.Priority)
+	if !slices.Contains(Priorities, cfg.Priority) {
+		return fmt.Errorf("invalid priority %q: choose from %s", cfg.Priority, strings.Join(Priorities, ", "))
+	}
+	cfg.Overflow = strings.ToLower(cfg.Overflow)
+	if !slices.Contains(Overflows, cfg.Overflow) {
+		return fmt.Errorf("invalid overflow mode %q: choose from %s", cfg.Overflow, strings.Join(Overflows, ", "))
+	}
+
+	// Validate the chunking options
+	if cfg.ChunkSize < 0 {
+		return errors.New("chunk-size must not be negative")
+	}
+	cfg.ChunkUnit = strings.ToLower(cfg.ChunkUnit)
+	if !slices.Contains(ChunkUnits, cfg.ChunkUnit) {
+		return fmt.Errorf("invalid chunk unit %q: choose from %s", cfg.ChunkUnit, strings.Join(ChunkUnits, ", "))
+	}
+	if cfg.ChunkSize > 0 && cfg.ChunkUnit == ChunkTokens && cfg.Tokenizer == tokens.None {
+		return errors.New("-chunk-unit tokens requires a tokenizer other than none")
+	}
+	if cfg.ChunkSize > 0 && cfg.WritesToStdout() {
+		return errors.New("-chunk-size cannot be used when writing to standard output")
+	}
+
+	// Validate the Git LFS mode
+	cfg.LFS = strings.ToLower(cfg.LFS)
+	if !slices.Contains(LFSModes, cfg.LFS) {
+		return fmt.Errorf("invalid lfs mode %q: choose from %s", cfg.LFS, strings.Join(LFSModes, ", "))
+	}
+
+	// Validate the subdirectories to pack
+	subdirs, err := NormalizeSubdirs(cfg.Subdirs)
+	if err != nil {
+		return err
+	}
+	cfg.Subdirs = subdirs
+
+	return nil
+}
+
+// NormalizeSubdirs cleans a list of repository subdirectories into slash-separated paths relative
+// to the repository root. Duplicates and directories inside another listed directory are dropped.
+//
+// Parameters:
+//   - subdirs: The directories as given by the user, with slashes or backslashes.
+//
+// Returns:
+//   - []string: The cleaned directories in their original order; nil if subdirs is empty.
+//   - error: An error if a directory is empty, absolute or outside the repository.
+func NormalizeSubdirs(subdirs []string) ([]string, error) {
+	var cleaned []string
+	for _, dir := range subdirs {
+		clean := path.Clean(strings.ReplaceAll(dir, "\\", "/"))
+		switch {
+		case strings.TrimSpace(dir) == "" || clean == ".":
+			return nil, fmt.Errorf("invalid subdir %q: name a directory inside the repository", dir)
+		case path.IsAbs(clean) || filepath.IsAbs(dir) || filepath.VolumeName(dir) != "":
+			return nil, fmt.Errorf("invalid subdir %q: must be relative to the repository root", dir)
+		case clean == ".." || strings.HasPrefix(clean, "../"):
+			return nil, fmt.Errorf("invalid subdir %q: must be inside the repository", dir)
+		}
+		cleaned = append(cleaned, clean)
+	}
+
+	var result []string
+	for i, dir := range cleaned {
+		covered := false
+		for j, other := range cleaned {
+			// Keep the first of duplicates and drop directories inside another one
+			if (other == dir && j < i) || strings.HasPrefix(dir, other+"/") {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			result = append(result, dir)
+		}
+	}
+	return result, nil
+}
+
+// WritesToStdout reports whether the output is written to standard output instead of a file.
+func (cfg *Config) WritesToStdout() bool {
+	return cfg.Output == StdoutOutput
+}
+
+// MatchPolicy returns the policy for file names with several matches: the configured policy, or
+// prompt in interactive mode and error in non-interactive mode when none is configured.
+func (cfg *Config) MatchPolicy() string {
+	switch {
+	case cfg.MultipleMatches != "":
+		return cfg.MultipleMatches
+	case cfg.NonInteractive:
+		return MatchError
+	default:
+		return MatchPrompt
+	}
+}
+
+// IsLocal reports whether the configuration targets a local directory instead of a remote repository.
+func (cfg *Config) IsLocal() bool {
+	return cfg.LocalPath != ""
+}
+
+// IsArchive reports whether the configuration targets a tarball, zip archive or git bundle.
+func (cfg *Config) IsArchive() bool {
+	return cfg.ArchivePath != ""
+}
+
+// isLocalFile reports whether the given path refers to an existing file other than a directory.
+func isLocalFile(path string) bool {
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// countSet returns the number of non-empty values.
+func countSet(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// isLocalDir reports whether the given path refers to an existing directory on disk.
+func isLocalDir(path string) bool {
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// patternList is a repeatable flag value that accumulates comma-separated patterns.
+type patternList []string
+
+// String returns the accumulated patterns as a comma-separated string.
+func (l *patternList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set appends the comma-separated patterns in value to the list.
+func (l *patternList) Set(value string) error {
+	*l = append(*l, ParsePatternList(value)...)
+	return nil
+}
+
+// ParsePatternList splits a comma-separated list of glob patterns into a slice of trimmed patterns.
+// Commas inside brace expressions such as "*.{go,md}" do not split the pattern.
+//
+// Parameters:
+//   - input: The comma-separated list of patterns.
+//
+// Returns:
+//   - []string: The trimmed, non-empty patterns. Returns nil if the input is empty.
+func ParsePatternList(input string) []string {
+	var result []string
+	depth, start := 0, 0
+	for i, r := range input {
+		switch {
+		case r == '{':
+			depth++
+		case r == '}' && depth > 0:
+			depth--
+		case r == ',' && depth == 0:
+			if trimmed := strings.TrimSpace(input[start:i]); trimmed != "" {
+				result = append(result, trimmed)
+			}
+			start = i + 1
+		}
+	}
+	if trimmed := strings.TrimSpace(input[start:]); trimmed != "" {
+		result = append(result, trimmed)
+	}
+	return result
+}
+
 // parseCommaSeparated splits a comma-separated string into a slice of trimmed strings.
 // It returns nil if the input string is empty.
 func parseCommaSeparated(input string) []string {
@@ -3,7 +3,10 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"testing/fstest"
 )
 
 // TestParseFlags verifies that the ParseFlags method correctly parses command-line flags
@@ -139,3 +142,783 @@ func TestParseFlagsInvalidAuth(t *testing.T) {
 	}
 }
 
+// TestParseFlagsLocalPath verifies that a local directory can be provided either via -path
+// or via -repo, and that both cannot be combined.
+func TestParseFlagsLocalPath(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	localDir := t.TempDir()
+
+	os.Args = []string{"cmd", "-path=" + localDir}
+	cfg := NewConfig()
+	if err := cfg.ParseFlags(); err != nil {
+		t.Fatalf("ParseFlags returned an error: %v", err)
+	}
+	if !cfg.IsLocal() || cfg.LocalPath != localDir {
+		t.Errorf("Expected LocalPath to be %q, got %q", localDir, cfg.LocalPath)
+	}
+
+	os.Args = []string{"cmd", "-repo=" + localDir}
+	cfg = NewConfig()
+	if err := cfg.ParseFlags(); err != nil {
+		t.Fatalf("ParseFlags returned an error: %v", err)
+	}
+	if cfg.LocalPath != localDir {
+		t.Errorf("Expected LocalPath to be %q, got %q", localDir, cfg.LocalPath)
+	}
+	if cfg.RepoURL != "" {
+		t.Errorf("Expected RepoURL to be empty for a local directory, got %q", cfg.RepoURL)
+	}
+
+	os.Args = []string{"cmd", "-repo=https://github.com/user/repo.git", "-path=" + localDir}
+	cfg = NewConfig()
+	if err := cfg.ParseFlags(); err == nil {
+		t.Errorf("Expected ParseFlags to return an error when both -repo and -path are set, got nil")
+	}
+}
+
+// TestParseFlagsShallow verifies that -shallow defaults to a depth of one commit and that
+// an explicit -depth takes precedence.
+func TestParseFlagsShallow(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	testCases := []struct {
+		args     []string
+		expected int
+		wantErr  bool
+	}{
+		{[]string{"cmd", "-shallow"}, DefaultShallowDepth, false},
+		{[]string{"cmd", "-shallow", "-depth=5"}, 5, false},
+		{[]string{"cmd", "-depth=3"}, 3, false},
+		{[]string{"cmd"}, 0, false},
+		{[]string{"cmd", "-depth=-1"}, 0, true},
+	}
+
+	for _, tc := range testCases {
+		os.Args = append(tc.args, "-repo=https://github.com/user/repo.git")
+		cfg := NewConfig()
+		err := cfg.ParseFlags()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseFlags(%v) error = %v, wantErr %v", tc.args, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && cfg.Depth != tc.expected {
+			t.Errorf("ParseFlags(%v) Depth = %d; want %d", tc.args, cfg.Depth, tc.expected)
+		}
+	}
+}
+
+// TestParseFlagsPatterns verifies that -include and -exclude can be repeated and that
+// commas inside brace expressions do not split patterns.
+func TestParseFlagsPatterns(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	os.Args = []string{
+		"cmd",
+		"-repo=https://github.com/user/repo.git",
+		"-include=src/**/*.{go,md}",
+		"-exclude=docs,**/*_test.go",
+		"-exclude=!pkg/keep_test.go",
+	}
+
+	cfg := NewConfig()
+	if err := cfg.ParseFlags(); err != nil {
+		t.Fatalf("ParseFlags returned an error: %v", err)
+	}
+
+	expectedIncludes := []string{"src/**/*.{go,md}"}
+	if len(cfg.IncludePatterns) != len(expectedIncludes) || cfg.IncludePatterns[0] != expectedIncludes[0] {
+		t.Errorf("Expected IncludePatterns to be %v, got %v", expectedIncludes, cfg.IncludePatterns)
+	}
+
+	expectedExcludes := []string{"docs", "**/*_test.go", "!pkg/keep_test.go"}
+	if len(cfg.ExcludeFolders) != len(expectedExcludes) {
+		t.Fatalf("Expected ExcludeFolders to be %v, got %v", expectedExcludes, cfg.ExcludeFolders)
+	}
+	for i, v := range expectedExcludes {
+		if cfg.ExcludeFolders[i] != v {
+			t.Errorf("Expected ExcludeFolders[%d] to be %q, got %q", i, v, cfg.ExcludeFolders[i])
+		}
+	}
+}
+
+// TestLoadFileConfig verifies that configuration files are parsed, that missing and empty files
+// yield an empty configuration and that unknown keys are rejected.
+func TestLoadFileConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	fc, err := LoadFileConfig(filepath.Join(dir, "missing.yaml"))
+	if err != nil || fc == nil || len(fc.Exclude) != 0 {
+		t.Errorf("Expected an empty configuration for a missing file, got %+v, %v", fc, err)
+	}
+
+	empty := filepath.Join(dir, "empty.yaml")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if fc, err := LoadFileConfig(empty); err != nil || fc == nil {
+		t.Errorf("Expected an empty configuration for an empty file, got %+v, %v", fc, err)
+	}
+
+	valid := filepath.Join(dir, "valid.yaml")
+	content := "exclude:\n  - docs\n  - \"**/*_test.go\"\ninclude_ext: [.go, .md]\nno_gitignore: false\n"
+	if err := os.WriteFile(valid, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	fc, err = LoadFileConfig(valid)
+	if err != nil {
+		t.Fatalf("LoadFileConfig returned an error: %v", err)
+	}
+	if len(fc.Exclude) != 2 || fc.Exclude[1] != "**/*_test.go" {
+		t.Errorf("Expected Exclude to be [docs **/*_test.go], got %v", fc.Exclude)
+	}
+	if len(fc.IncludeExt) != 2 || fc.IncludeExt[0] != ".go" {
+		t.Errorf("Expected IncludeExt to be [.go .md], got %v", fc.IncludeExt)
+	}
+	if fc.NoGitignore == nil || *fc.NoGitignore {
+		t.Errorf("Expected NoGitignore to be set to false, got %v", fc.NoGitignore)
+	}
+
+	unknown := filepath.Join(dir, "unknown.yaml")
+	if err := os.WriteFile(unknown, []byte("exclud: [docs]\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := LoadFileConfig(unknown); err == nil {
+		t.Errorf("Expected an error for an unknown key, got nil")
+	}
+}
+
+// TestApplyRepoConfig verifies the precedence of flags, the repository configuration file and
+// the user configuration file.
+func TestApplyRepoConfig(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv("HOME", configHome)
+	userDir, err := UserConfigDir()
+	if err != nil {
+		t.Fatalf("UserConfigDir returned an error: %v", err)
+	}
+	if err := os.MkdirAll(userDir, 0755); err != nil {
+		t.Fatalf("Failed to create user config directory: %v", err)
+	}
+	userConfig := "exclude: [vendor]\ninclude: [\"**/*.go\"]\ninclude_ext: [.go]\nno_gitignore: true\noutput_dir: /tmp/out\ncopy_clipboard: true\n"
+	if err := os.WriteFile(filepath.Join(userDir, userConfigFile), []byte(userConfig), 0644); err != nil {
+		t.Fatalf("Failed to write user config file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(userDir, userIgnoreFile), []byte("*.log\n"), 0644); err != nil {
+		t.Fatalf("Failed to write user ignore file: %v", err)
+	}
+
+	repoDir := t.TempDir()
+	repoConfig := "exclude: [docs]\ninclude: [\"src/**\"]\nno_gitignore: false\n"
+	if err := os.WriteFile(filepath.Join(repoDir, RepoConfigFile), []byte(repoConfig), 0644); err != nil {
+		t.Fatalf("Failed to write repository config file: %v", err)
+	}
+
+	// Flags set the copy-clipboard option and add an exclude pattern.
+	cfg := &Config{CopyToClipboard: false, CopyToClipboardSet: true, ExcludeFolders: []string{"!docs/keep.md"}}
+	if err := cfg.LoadUserConfig(); err != nil {
+		t.Fatalf("LoadUserConfig returned an error: %v", err)
+	}
+	found, err := cfg.ApplyRepoConfig(repoDir)
+	if err != nil {
+		t.Fatalf("ApplyRepoConfig returned an error: %v", err)
+	}
+	if !found {
+		t.Errorf("Expected the repository configuration file to be found")
+	}
+
+	if cfg.OutputDir != "/tmp/out" {
+		t.Errorf("Expected OutputDir from the user config, got %q", cfg.OutputDir)
+	}
+	if cfg.CopyToClipboard {
+		t.Errorf("Expected the copy-clipboard flag to take precedence over the user config")
+	}
+	if cfg.UserIgnoreFile != filepath.Join(userDir, userIgnoreFile) {
+		t.Errorf("Expected UserIgnoreFile to be %q, got %q", filepath.Join(userDir, userIgnoreFile), cfg.UserIgnoreFile)
+	}
+
+	expectedExcludes := []string{"vendor", "docs", "!docs/keep.md"}
+	if len(cfg.ExcludeFolders) != len(expectedExcludes) {
+		t.Fatalf("Expected ExcludeFolders to be %v, got %v", expectedExcludes, cfg.ExcludeFolders)
+	}
+	for i, v := range expectedExcludes {
+		if cfg.ExcludeFolders[i] != v {
+			t.Errorf("Expected ExcludeFolders[%d] to be %q, got %q", i, v, cfg.ExcludeFolders[i])
+		}
+	}
+	if len(cfg.IncludePatterns) != 1 || cfg.IncludePatterns[0] != "src/**" {
+		t.Errorf("Expected IncludePatterns from the repository config, got %v", cfg.IncludePatterns)
+	}
+	if len(cfg.IncludeExt) != 1 || cfg.IncludeExt[0] != ".go" {
+		t.Errorf("Expected IncludeExt from the user config, got %v", cfg.IncludeExt)
+	}
+	if cfg.NoGitignore {
+		t.Errorf("Expected no_gitignore from the repository config to take precedence over the user config")
+	}
+}
+
+// TestApplyRepoConfigUserOnlySettings verifies that a repository cannot set user-only settings.
+func TestApplyRepoConfigUserOnlySettings(t *testing.T) {
+	repoDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repoDir, RepoConfigFile), []byte("output_dir: /etc\n"), 0644); err != nil {
+		t.Fatalf("Failed to write repository config file: %v", err)
+	}
+	cfg := NewConfig()
+	if _, err := cfg.ApplyRepoConfig(repoDir); err == nil {
+		t.Errorf("Expected an error for output_dir in the repository config, got nil")
+	}
+}
+
+// TestParseFlagsFormat verifies that the output format is normalised and validated.
+func TestParseFlagsFormat(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	os.Args = []string{"cmd", "-repo=https://github.com/user/repo.git", "-format=Markdown"}
+	cfg := NewConfig()
+	if err := cfg.ParseFlags(); err != nil {
+		t.Fatalf("ParseFlags returned an error: %v", err)
+	}
+	if cfg.Format != FormatMarkdown {
+		t.Errorf("Expected Format to be %q, got %q", FormatMarkdown, cfg.Format)
+	}
+
+	os.Args = []string{"cmd", "-repo=https://github.com/user/repo.git", "-format=pdf"}
+	if err := NewConfig().ParseFlags(); err == nil {
+		t.Errorf("Expected an error for an unsupported format, got nil")
+	}
+}
+
+// TestParseFlagsTokenizer verifies the tokenizer defaults and validation.
+func TestParseFlagsTokenizer(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	os.Args = []string{"cmd", "-repo=https://github.com/user/repo.git"}
+	cfg := NewConfig()
+	if err := cfg.ParseFlags(); err != nil {
+		t.Fatalf("ParseFlags returned an error: %v", err)
+	}
+	if cfg.Tokenizer != "cl100k" || cfg.TopFiles != DefaultTopFiles {
+		t.Errorf("Expected tokenizer cl100k and %d top files, got %q and %d", DefaultTopFiles, cfg.Tokenizer, cfg.TopFiles)
+	}
+
+	os.Args = []string{"cmd", "-repo=https://github.com/user/repo.git", "-tokenizer=bert"}
+	if err := NewConfig().ParseFlags(); err == nil {
+		t.Errorf("Expected an error for an unknown tokenizer, got nil")
+	}
+}
+
+// TestParseFlagsTokenBudget verifies the defaults and validation of the token budget flags.
+func TestParseFlagsTokenBudget(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	os.Args = []string{"cmd", "-repo=https://github.com/user/repo.git", "-max-tokens=1000", "-priority=Recency"}
+	cfg := NewConfig()
+	if err := cfg.ParseFlags(); err != nil {
+		t.Fatalf("ParseFlags returned an error: %v", err)
+	}
+	if cfg.MaxTokens != 1000 || cfg.Priority != PriorityRecency || cfg.Overflow != OverflowTruncate {
+		t.Errorf("Expected budget 1000, priority recency and overflow truncate, got %d, %q and %q", cfg.MaxTokens, cfg.Priority, cfg.Overflow)
+	}
+
+	invalid := [][]string{
+		{"-max-tokens=-1"},
+		{"-max-tokens=1000", "-tokenizer=none"},
+		{"-priority=alphabetical"},
+		{"-overflow=wrap"},
+	}
+	for _, args := range invalid {
+		os.Args = append([]string{"cmd", "-repo=https://github.com/user/repo.git"}, args...)
+		if err := NewConfig().ParseFlags(); err == nil {
+			t.Errorf("Expected an error for %v, got nil", args)
+		}
+	}
+}
+
+// TestParseFlagsChunks verifies the defaults and validation of the chunking flags.
+func TestParseFlagsChunks(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	os.Args = []string{"cmd", "-repo=https://github.com/user/repo.git", "-chunk-size=50000"}
+	cfg := NewConfig()
+	if err := cfg.ParseFlags(); err != nil {
+		t.Fatalf("ParseFlags returned an error: %v", err)
+	}
+	if cfg.ChunkSize != 50000 || cfg.ChunkUnit != ChunkBytes {
+		t.Errorf("Expected chunks of 50000 bytes, got %d %s", cfg.ChunkSize, cfg.ChunkUnit)
+	}
+
+	invalid := [][]string{
+		{"-chunk-size=-1"},
+		{"-chunk-size=100", "-chunk-unit=pages"},
+		{"-chunk-size=100", "-chunk-unit=tokens", "-tokenizer=none"},
+	}
+	for _, args := range invalid {
+		os.Args = append([]string{"cmd", "-repo=https://github.com/user/repo.git"}, args...)
+		if err := NewConfig().ParseFlags(); err == nil {
+			t.Errorf("Expected an error for %v, got nil", args)
+		}
+	}
+}
+
+// TestParseFlagsOutput verifies that -o accepts a file path or - for standard output, and that
+// standard output cannot be chunked.
+func TestParseFlagsOutput(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	testCases := []struct {
+		output  string
+		stdout  bool
+		args    []string
+		wantErr bool
+	}{
+		{"out/repo.md", false, nil, false},
+		{"-", true, nil, false},
+		{"-", true, []string{"-chunk-size=1000"}, true},
+	}
+
+	for _, tc := range testCases {
+		os.Args = append([]string{"cmd", "-repo=https://github.com/user/repo.git", "-o", tc.output}, tc.args...)
+		cfg := NewConfig()
+		err := cfg.ParseFlags()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseFlags(-o %s %v) error = %v, wantErr %v", tc.output, tc.args, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && (cfg.Output != tc.output || cfg.WritesToStdout() != tc.stdout) {
+			t.Errorf("Expected output %q (stdout %v), got %q (stdout %v)", tc.output, tc.stdout, cfg.Output, cfg.WritesToStdout())
+		}
+	}
+}
+
+// TestParseFlagsNonInteractive verifies that -non-interactive, its -yes alias and the
+// -multiple-matches policy are parsed and validated.
+func TestParseFlagsNonInteractive(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	testCases := []struct {
+		args           []string
+		nonInteractive bool
+		policy         string
+		wantErr        bool
+	}{
+		{nil, false, MatchPrompt, false},
+		{[]string{"-non-interactive"}, true, MatchError, false},
+		{[]string{"-yes"}, true, MatchError, false},
+		{[]string{"-yes", "-multiple-matches=ALL"}, true, MatchAll, false},
+		{[]string{"-multiple-matches=first"}, false, MatchFirst, false},
+		{[]string{"-multiple-matches=some"}, false, "", true},
+		{[]string{"-yes", "-multiple-matches=prompt"}, true, "", true},
+	}
+
+	for _, tc := range testCases {
+		os.Args = append([]string{"cmd", "-repo=https://github.com/user/repo.git"}, tc.args...)
+		cfg := NewConfig()
+		err := cfg.ParseFlags()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseFlags(%v) error = %v, wantErr %v", tc.args, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if cfg.NonInteractive != tc.nonInteractive || cfg.MatchPolicy() != tc.policy {
+			t.Errorf("ParseFlags(%v) = non-interactive %v, policy %q; want %v, %q", tc.args, cfg.NonInteractive, cfg.MatchPolicy(), tc.nonInteractive, tc.policy)
+		}
+	}
+}
+
+// TestParseFlagsSSH verifies that the SSH passphrase and host key checking flags are parsed and
+// that unknown host key checking policies are rejected.
+func TestParseFlagsSSH(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	testCases := []struct {
+		args       []string
+		passphrase string
+		policy     string
+		wantErr    bool
+	}{
+		{nil, "", "", false},
+		{[]string{"-ssh-passphrase=secret"}, "secret", "", false},
+		{[]string{"-ssh-host-key-checking=Accept-New"}, "", HostKeyAcceptNew, false},
+		{[]string{"-ssh-host-key-checking=strict"}, "", HostKeyStrict, false},
+		{[]string{"-ssh-host-key-checking=no"}, "", "", true},
+	}
+
+	for _, tc := range testCases {
+		os.Args = append([]string{"cmd", "-repo=git@github.com:user/repo.git", "-auth=ssh"}, tc.args...)
+		cfg := NewConfig()
+		err := cfg.ParseFlags()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseFlags(%v) error = %v, wantErr %v", tc.args, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if cfg.SSHPassphrase != tc.passphrase || cfg.SSHHostKeyChecking != tc.policy {
+			t.Errorf("ParseFlags(%v) = passphrase %q, policy %q; want %q, %q", tc.args, cfg.SSHPassphrase, cfg.SSHHostKeyChecking, tc.passphrase, tc.policy)
+		}
+	}
+}
+
+// TestParseFlagsCache verifies the clone cache flags, including size parsing, that the cache is
+// only used with -cache or -offline, and the conflicts with -no-cache.
+func TestParseFlagsCache(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	testCases := []struct {
+		args    []string
+		maxSize int64
+		offline bool
+		cache   bool
+		wantErr bool
+	}{
+		{nil, 0, false, false, false},
+		{[]string{"-cache"}, 0, false, true, false},
+		{[]string{"-cache", "-cache-max-size=2GiB"}, 2 << 30, false, true, false},
+		{[]string{"-cache-max-size=500M", "-offline"}, 500 << 20, true, true, false},
+		{[]string{"-cache-max-size=lots"}, 0, false, false, true},
+		{[]string{"-offline", "-no-cache"}, 0, false, false, true},
+		{[]string{"-cache", "-no-cache"}, 0, false, false, true},
+	}
+
+	for _, tc := range testCases {
+		os.Args = append([]string{"cmd", "-repo=https://github.com/user/repo.git"}, tc.args...)
+		cfg := NewConfig()
+		err := cfg.ParseFlags()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseFlags(%v) error = %v, wantErr %v", tc.args, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if cfg.CacheMaxSize != tc.maxSize || cfg.Offline != tc.offline || cfg.Cache != tc.cache {
+			t.Errorf("ParseFlags(%v) = max size %d, offline %v, cache %v; want %d, %v, %v", tc.args, cfg.CacheMaxSize, cfg.Offline, cfg.Cache, tc.maxSize, tc.offline, tc.cache)
+		}
+	}
+}
+
+// TestParseFlagsSubmodulesLFS verifies the -submodules and -lfs flags, including the default LFS
+// mode and the conflict between -submodules and -offline.
+func TestParseFlagsSubmodulesLFS(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	testCases := []struct {
+		args       []string
+		submodules bool
+		lfs        string
+		wantErr    bool
+	}{
+		{nil, false, LFSResolve, false},
+		{[]string{"-submodules", "-lfs=Mark"}, true, LFSMark, false},
+		{[]string{"-lfs=pointer"}, false, LFSPointer, false},
+		{[]string{"-lfs=fetch"}, false, "", true},
+		{[]string{"-submodules", "-offline"}, false, "", true},
+	}
+
+	for _, tc := range testCases {
+		os.Args = append([]string{"cmd", "-repo=https://github.com/user/repo.git"}, tc.args...)
+		cfg := NewConfig()
+		err := cfg.ParseFlags()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseFlags(%v) error = %v, wantErr %v", tc.args, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if cfg.Submodules != tc.submodules || cfg.LFS != tc.lfs {
+			t.Errorf("ParseFlags(%v) = submodules %v, lfs %q; want %v, %q", tc.args, cfg.Submodules, cfg.LFS, tc.submodules, tc.lfs)
+		}
+	}
+}
+
+// TestParseFlagsSubdirs verifies that -subdir accumulates cleaned directories relative to the
+// repository root and rejects directories outside it.
+func TestParseFlagsSubdirs(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	testCases := []struct {
+		args     []string
+		expected []string
+		wantErr  bool
+	}{
+		{nil, nil, false},
+		{[]string{"-subdir=services/billing/"}, []string{"services/billing"}, false},
+		{[]string{"-subdir=services/billing,docs", "-subdir", `libs\shared`}, []string{"services/billing", "docs", "libs/shared"}, false},
+		{[]string{"-subdir=services", "-subdir=services/billing", "-subdir=./services"}, []string{"services"}, false},
+		{[]string{"-subdir=."}, nil, true},
+		{[]string{"-subdir=/etc"}, nil, true},
+		{[]string{"-subdir=services/../../etc"}, nil, true},
+	}
+
+	for _, tc := range testCases {
+		os.Args = append([]string{"cmd", "-repo=https://github.com/user/repo.git"}, tc.args...)
+		cfg := NewConfig()
+		err := cfg.ParseFlags()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseFlags(%v) error = %v, wantErr %v", tc.args, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && !slices.Equal(cfg.Subdirs, tc.expected) {
+			t.Errorf("ParseFlags(%v) subdirs = %q; want %q", tc.args, cfg.Subdirs, tc.expected)
+		}
+	}
+}
+
+// TestParseFlagsInMemory verifies the -in-memory flag and its conflicts with local directories
+// and -submodules.
+func TestParseFlagsInMemory(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	testCases := []struct {
+		args     []string
+		inMemory bool
+		wantErr  bool
+	}{
+		{[]string{"-repo=https://github.com/user/repo.git"}, false, false},
+		{[]string{"-repo=https://github.com/user/repo.git", "-in-memory", "-offline"}, true, false},
+		{[]string{"-path=" + t.TempDir(), "-in-memory"}, false, true},
+		{[]string{"-repo=https://github.com/user/repo.git", "-in-memory", "-submodules"}, false, true},
+	}
+
+	for _, tc := range testCases {
+		os.Args = append([]string{"cmd"}, tc.args...)
+		cfg := NewConfig()
+		err := cfg.ParseFlags()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseFlags(%v) error = %v, wantErr %v", tc.args, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && cfg.InMemory != tc.inMemory {
+			t.Errorf("ParseFlags(%v) in-memory = %v; want %v", tc.args, cfg.InMemory, tc.inMemory)
+		}
+	}
+}
+
+// TestParseFlagsArchive verifies that -repo and -path values naming a file select an archive,
+// and that the archive options are validated.
+func TestParseFlagsArchive(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	archivePath := filepath.Join(t.TempDir(), "project.tar.gz")
+	if err := os.WriteFile(archivePath, []byte("archive"), 0o644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	testCases := []struct {
+		args    []string
+		archive string
+		maxSize int64
+		wantErr bool
+	}{
+		{[]string{"-archive=" + archivePath}, archivePath, 0, false},
+		{[]string{"-repo=" + archivePath, "-ref=main"}, archivePath, 0, false},
+		{[]string{"-path=" + archivePath, "-max-archive-size=500MB"}, archivePath, 500 << 20, false},
+		{[]string{"-repo=https://github.com/user/repo.git"}, "", 0, false},
+		{[]string{"-archive=" + archivePath, "-repo=https://github.com/user/repo.git"}, "", 0, true},
+		{[]string{"-archive=" + archivePath, "-submodules"}, "", 0, true},
+		{[]string{"-archive=" + archivePath, "-max-archive-size=lots"}, "", 0, true},
+	}
+
+	for _, tc := range testCases {
+		os.Args = append([]string{"cmd"}, tc.args...)
+		cfg := NewConfig()
+		err := cfg.ParseFlags()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseFlags(%v) error = %v, wantErr %v", tc.args, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if cfg.ArchivePath != tc.archive || cfg.IsArchive() != (tc.archive != "") || cfg.MaxArchiveSize != tc.maxSize {
+			t.Errorf("ParseFlags(%v) archive = %q, max size %d; want %q, %d", tc.args, cfg.ArchivePath, cfg.MaxArchiveSize, tc.archive, tc.maxSize)
+		}
+		if tc.archive != "" && (cfg.RepoURL != "" || cfg.LocalPath != "") {
+			t.Errorf("ParseFlags(%v) kept repo %q and path %q for an archive", tc.args, cfg.RepoURL, cfg.LocalPath)
+		}
+	}
+}
+
+// TestApplyRepoConfigFS verifies that the repository configuration is read from a file system
+// that is not on disk, and that a missing file still merges the user configuration.
+func TestApplyRepoConfigFS(t *testing.T) {
+	fsys := fstest.MapFS{RepoConfigFile: {Data: []byte("exclude: [docs]\ninclude_ext: [.go]\n")}}
+	cfg := &Config{userConfig: &FileConfig{Exclude: []string{"vendor"}}}
+	found, err := cfg.ApplyRepoConfigFS(fsys)
+	if err != nil || !found {
+		t.Fatalf("ApplyRepoConfigFS = %v, %v; want the file to be found", found, err)
+	}
+	if !slices.Equal(cfg.ExcludeFolders, []string{"vendor", "docs"}) || !slices.Equal(cfg.IncludeExt, []string{".go"}) {
+		t.Errorf("Unexpected merged configuration: exclude %v, include_ext %v", cfg.ExcludeFolders, cfg.IncludeExt)
+	}
+
+	cfg = &Config{userConfig: &FileConfig{Exclude: []string{"vendor"}}}
+	if found, err := cfg.ApplyRepoConfigFS(fstest.MapFS{}); err != nil || found {
+		t.Errorf("ApplyRepoConfigFS = %v, %v; want no file and no error", found, err)
+	}
+	if !slices.Equal(cfg.ExcludeFolders, []string{"vendor"}) {
+		t.Errorf("Expected the user configuration to be merged, got exclude %v", cfg.ExcludeFolders)
+	}
+
+	fsys = fstest.MapFS{RepoConfigFile: {Data: []byte("copy_clipboard: true\n")}}
+	if _, err := NewConfig().ApplyRepoConfigFS(fsys); err == nil {
+		t.Error("Expected an error for copy_clipboard in the repository config, got nil")
+	}
+}
+
+// TestParseFlagsMulti verifies that several -repo values and a manifest configure a run packing
+// several repositories, and that options which cannot apply to several repositories are rejected.
+func TestParseFlagsMulti(t *testing.T) {
+	originalArgs := os.Args
+	defer func() { os.Args = originalArgs }()
+
+	dir := t.TempDir()
+	localDir := filepath.Join(dir, "client")
+	if err := os.Mkdir(localDir, 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	archivePath := filepath.Join(dir, "docs.zip")
+	if err := os.WriteFile(archivePath, []byte("archive"), 0o644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	manifest := filepath.Join(dir, "review.yaml")
+	content := "repos:\n  - url: https://github.com/user/service.git\n    ref: v1.0.0\n  - path: client\n    name: go-client\n    subdirs: [pkg/]\n"
+	if err := os.WriteFile(manifest, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	const service = "https://github.com/user/service.git"
+	testCases := []struct {
+		args    []string
+		repos   []RepoSpec
+		name    string
+		wantErr bool
+	}{
+		{[]string{"-repo=" + service}, nil, "", false},
+		{[]string{"-repo=" + service, "-repo=" + localDir, "-repo=" + archivePath}, []RepoSpec{{URL: service}, {Path: localDir}, {Archive: archivePath}}, "", false},
+		{[]string{"-manifest=" + manifest, "-jobs=2"}, []RepoSpec{{URL: service, Ref: "v1.0.0"}, {Name: "go-client", Path: localDir, Subdirs: []string{"pkg"}}}, "review", false},
+		{[]string{"-repo=" + archivePath, "-manifest=" + manifest, "-per-repo"}, []RepoSpec{{Archive: archivePath}, {URL: service, Ref: "v1.0.0"}, {Name: "go-client", Path: localDir, Subdirs: []string{"pkg"}}}, "review", false},
+		{[]string{"-repo=" + service, "-per-repo"}, nil, "", true},
+		{[]string{"-repo=" + service, "-repo=" + localDir, "-jobs=0"}, nil, "", true},
+		{[]string{"-repo=" + service, "-repo=" + localDir, "-per-repo", "-o=out.txt"}, nil, "", true},
+		{[]string{"-repo=" + service, "-repo=" + localDir, "-in-memory"}, nil, "", true},
+		{[]string{"-repo=" + service, "-repo=" + archivePath, "-submodules"}, nil, "", true},
+		{[]string{"-manifest=" + manifest, "-path=" + localDir}, nil, "", true},
+		{[]string{"-manifest=" + filepath.Join(dir, "missing.yaml")}, nil, "", true},
+	}
+
+	for _, tc := range testCases {
+		os.Args = append([]string{"cmd"}, tc.args...)
+		cfg := NewConfig()
+		err := cfg.ParseFlags()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseFlags(%v) error = %v, wantErr %v", tc.args, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if !slices.EqualFunc(cfg.Repos, tc.repos, equalRepoSpec) || cfg.IsMulti() != (tc.repos != nil) || cfg.CombinedName != tc.name {
+			t.Errorf("ParseFlags(%v) repos = %+v, name %q; want %+v, %q", tc.args, cfg.Repos, cfg.CombinedName, tc.repos, tc.name)
+		}
+		if !cfg.IsMulti() && cfg.RepoURL != service {
+			t.Errorf("ParseFlags(%v) repo = %q, want %q", tc.args, cfg.RepoURL, service)
+		}
+	}
+}
+
+// equalRepoSpec reports whether two repository specifications are equal.
+func equalRepoSpec(a, b RepoSpec) bool {
+	return a.Name == b.Name && a.URL == b.URL && a.Path == b.Path && a.Archive == b.Archive && a.Ref == b.Ref && slices.Equal(a.Subdirs, b.Subdirs)
+}
+
+// TestLoadManifest verifies that manifests are parsed, that relative paths are resolved against
+// the manifest directory, and that malformed manifests are rejected.
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "bundle.tar"), []byte("archive"), 0o644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	testCases := []struct {
+		content string
+		want    *Manifest
+		wantErr bool
+	}{
+		{"name: arch\nrepos:\n  - url: https://github.com/user/a.git\n  - path: b\n  - path: bundle.tar\n  - archive: /abs/c.zip\n", &Manifest{Name: "arch", Repos: []RepoSpec{{URL: "https://github.com/user/a.git"}, {Path: filepath.Join(dir, "b")}, {Archive: filepath.Join(dir, "bundle.tar")}, {Archive: "/abs/c.zip"}}}, false},
+		{"repos:\n  - url: https://github.com/user/a.git\n", &Manifest{Name: "manifest", Repos: []RepoSpec{{URL: "https://github.com/user/a.git"}}}, false},
+		{"", nil, true},
+		{"name: empty\nrepos: []\n", nil, true},
+		{"repos:\n  - url: https://github.com/user/a.git\n    path: a\n", nil, true},
+		{"repos:\n  - name: nothing\n", nil, true},
+		{"repos:\n  - url: https://github.com/user/a.git\n    branch: main\n", nil, true},
+		{"repos: [", nil, true},
+	}
+
+	for _, tc := range testCases {
+		path := filepath.Join(dir, "manifest.yaml")
+		if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+		got, err := LoadManifest(path)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("LoadManifest(%q) error = %v, wantErr %v", tc.content, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if got.Name != tc.want.Name || !slices.EqualFunc(got.Repos, tc.want.Repos, equalRepoSpec) {
+			t.Errorf("LoadManifest(%q) = %+v, want %+v", tc.content, got, tc.want)
+		}
+	}
+}
+
+// TestForRepo verifies that the configuration of each repository selects the repository, its
+// ref and subdirectories, and shares no slices with the run configuration.
+func TestForRepo(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Ref = "main"
+	cfg.Subdirs = []string{"cmd"}
+	cfg.ExcludeFolders = []string{"vendor"}
+	cfg.Repos = []RepoSpec{{URL: "https://github.com/user/a.git"}, {Path: "/src/b", Subdirs: []string{"pkg"}}, {Archive: "/src/c.zip", Ref: "v2"}}
+
+	a := cfg.ForRepo(cfg.Repos[0])
+	if a.RepoURL != cfg.Repos[0].URL || a.Ref != "main" || !slices.Equal(a.Subdirs, []string{"cmd"}) || a.IsMulti() {
+		t.Errorf("ForRepo(%+v) = repo %q, ref %q, subdirs %v, multi %v", cfg.Repos[0], a.RepoURL, a.Ref, a.Subdirs, a.IsMulti())
+	}
+	b := cfg.ForRepo(cfg.Repos[1])
+	if b.LocalPath != "/src/b" || b.Ref != "" || !slices.Equal(b.Subdirs, []string{"pkg"}) || !b.IsLocal() {
+		t.Errorf("ForRepo(%+v) = path %q, ref %q, subdirs %v", cfg.Repos[1], b.LocalPath, b.Ref, b.Subdirs)
+	}
+	c := cfg.ForRepo(cfg.Repos[2])
+	if c.ArchivePath != "/src/c.zip" || c.Ref != "v2" || !c.IsArchive() {
+		t.Errorf("ForRepo(%+v) = archive %q, ref %q", cfg.Repos[2], c.ArchivePath, c.Ref)
+	}
+
+	a.ExcludeFolders = append(a.ExcludeFolders, "generated")
+	a.Subdirs[0] = "internal"
+	if !slices.Equal(cfg.ExcludeFolders, []string{"vendor"}) || !slices.Equal(cfg.Subdirs, []string{"cmd"}) {
+		t.Errorf("ForRepo shares slices: exclusions %v, subdirs %v", cfg.ExcludeFolders, cfg.Subdirs)
+	}
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Names of the configuration and ignore files discovered in the repository and the user's config directory.
+const (
+	// RepoConfigFile is the name of the packing policy file in the repository root.
+	RepoConfigFile = ".repototxt.yaml"
+
+	// IgnoreFileName is the name of the ignore files, in gitignore syntax, found in the repository.
+	IgnoreFileName = ".repototxtignore"
+
+	// userConfigDirName is the directory below the user's config directory holding the user-level files.
+	userConfigDirName = "repo-to-txt"
+
+	// userConfigFile is the name of the user-level configuration file.
+	userConfigFile = "config.yaml"
+
+	// userIgnoreFile is the name of the user-level ignore file, in gitignore syntax.
+	userIgnoreFile = "ignore"
+)
+
+// FileConfig holds the settings read from a .repototxt.yaml file or the user configuration file.
+// Pointer fields distinguish a setting that is absent from one explicitly set to false.
+type FileConfig struct {
+	Exclude       []string `yaml:"exclude"`        // Folders or glob patterns to exclude
+	Include       []string `yaml:"include"`        // Glob patterns selecting the files to include
+	IncludeExt    []string `yaml:"include_ext"`    // File extensions to include
+	NoGitignore   *bool    `yaml:"no_gitignore"`   // Disable .gitignore and .gitattributes based exclusions
+	OutputDir     string   `yaml:"output_dir"`     // Output directory; only allowed in the user configuration file
+	CopyClipboard *bool    `yaml:"copy_clipboard"` // Copy the output to the clipboard; only allowed in the user configuration file
+}
+
+// LoadFileConfig reads a YAML configuration file. A missing or empty file yields an empty configuration.
+//
+// Parameters:
+//   - path: The file system path to the configuration file.
+//
+// Returns:
+//   - *FileConfig: The parsed configuration.
+//   - error: An error if the file cannot be read, is malformed or contains unknown keys.
+func LoadFileConfig(path string) (*FileConfig, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &FileConfig{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	defer file.Close()
+	return decodeFileConfig(file, path)
+}
+
+// decodeFileConfig parses a YAML configuration. Empty input yields an empty configuration.
+//
+// Parameters:
+//   - r: The reader of the configuration file.
+//   - name: The name of the file, used in error messages.
+//
+// Returns:
+//   - *FileConfig: The parsed configuration.
+//   - error: An error if the input cannot be read, is malformed or contains unknown keys.
+func decodeFileConfig(r io.Reader, name string) (*FileConfig, error) {
+	var fc FileConfig
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&fc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unable to parse %s: %w", name, err)
+	}
+	return &fc, nil
+}
+
+// UserConfigDir returns the directory holding the user-level configuration and ignore files,
+// e.g. ~/.config/repo-to-txt on Linux.
+//
+// Returns:
+//   - string: The path to the directory. It may not exist.
+//   - error: An error if the user's config directory cannot be determined.
+func UserConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine user config directory: %w", err)
+	}
+	return filepath.Join(dir, userConfigDirName), nil
+}
+
+// LoadUserConfig reads the user configuration file and remembers it so that ApplyRepoConfig can
+// merge its packing policy later. The output directory and clipboard settings are applied
+// immediately unless they were already set on the command line. The user ignore file is
+// recorded in UserIgnoreFile if it exists.
+//
+// Returns:
+//   - error: An error if the user configuration file cannot be read or parsed.
+func (cfg *Config) LoadUserConfig() error {
+	dir, err := UserConfigDir()
+	if err != nil {
+		// Without a config directory there is nothing to load.
+		cfg.userConfig = &FileConfig{}
+		return nil
+	}
+
+	fc, err := LoadFileConfig(filepath.Join(dir, userConfigFile))
+	if err != nil {
+		return err
+	}
+	cfg.userConfig = fc
+
+	if cfg.OutputDir == "" && fc.OutputDir != "" {
+		cfg.OutputDir = expandHome(fc.OutputDir)
+	}
+	if !cfg.CopyToClipboardSet && fc.CopyClipboard != nil {
+		cfg.CopyToClipboard = *fc.CopyClipboard
+		cfg.CopyToClipboardSet = true
+	}
+
+	ignorePath := filepath.Join(dir, userIgnoreFile)
+	if info, err := os.Stat(ignorePath); err == nil && !info.IsDir() {
+		cfg.UserIgnoreFile = ignorePath
+	}
+	return nil
+}
+
+// ApplyRepoConfig reads the .repototxt.yaml file in the repository root and merges its packing
+// policy with the user configuration and the values already set on the command line.
+//
+// Settings are merged with the following precedence, from highest to lowest:
+//  1. Command-line flags and interactive answers.
+//  2. The repository's .repototxt.yaml.
+//  3. The user configuration file.
+//  4. Built-in defaults.
+//
+// Include patterns, included extensions and no_gitignore are taken from the source with the
+// highest precedence that sets them. Exclude patterns accumulate across all sources, ordered
+// from lowest to highest precedence, so that a negated pattern ("!path") in a higher
+// precedence source re-includes files excluded by a lower one.
+//
+// Parameters:
+//   - repoPath: The local path of the repository being packed.
+//
+// Returns:
+//   - bool: True if the repository contains a .repototxt.yaml file, false otherwise.
+//   - error: An error if the file cannot be read, is malformed or sets user-only settings.
+func (cfg *Config) ApplyRepoConfig(repoPath string) (bool, error) {
+	path := filepath.Join(repoPath, RepoConfigFile)
+	_, statErr := os.Stat(path)
+	found := statErr == nil
+
+	repo, err := LoadFileConfig(path)
+	if err != nil {
+		return found, err
+	}
+	return found, cfg.mergeRepoConfig(repo)
+}
+
+// ApplyRepoConfigFS reads the .repototxt.yaml file in the root of a repository file system, such
+// as the tree of a commit that is not checked out, and merges it as ApplyRepoConfig does.
+//
+// Parameters:
+//   - fsys: The file system of the repository being packed, rooted at the repository root.
+//
+// Returns:
+//   - bool: True if the repository contains a .repototxt.yaml file, false otherwise.
+//   - error: An error if the file cannot be read, is malformed or sets user-only settings.
+func (cfg *Config) ApplyRepoConfigFS(fsys fs.FS) (bool, error) {
+	file, err := fsys.Open(RepoConfigFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, cfg.mergeRepoConfig(&FileConfig{})
+	} else if err != nil {
+		return false, fmt.Errorf("unable to open %s: %w", RepoConfigFile, err)
+	}
+	defer file.Close()
+
+	repo, err := decodeFileConfig(file, RepoConfigFile)
+	if err != nil {
+		return true, err
+	}
+	return true, cfg.mergeRepoConfig(repo)
+}
+
+// mergeRepoConfig merges the packing policy of a repository configuration file with the user
+// configuration and the values already set, with the precedence described for ApplyRepoConfig.
+//
+// Parameters:
+//   - repo: The repository configuration; empty if the repository has none.
+//
+// Returns:
+//   - error: An error if the repository configuration sets user-only settings.
+func (cfg *Config) mergeRepoConfig(repo *FileConfig) error {
+	// A repository must not decide where files are written or what ends up on the clipboard.
+	if repo.OutputDir != "" || repo.CopyClipboard != nil {
+		return fmt.Errorf("%s: output_dir and copy_clipboard can only be set in the user configuration file", RepoConfigFile)
+	}
+
+	user := cfg.userConfig
+	if user == nil {
+		user = &FileConfig{}
+	}
+
+	var exclude []string
+	exclude = append(exclude, user.Exclude...)
+	exclude = append(exclude, repo.Exclude...)
+	cfg.ExcludeFolders = append(exclude, cfg.ExcludeFolders...)
+
+	if len(cfg.IncludePatterns) == 0 {
+		cfg.IncludePatterns = firstNonEmpty(repo.Include, user.Include)
+	}
+	if len(cfg.IncludeExt) == 0 {
+		cfg.IncludeExt = firstNonEmpty(repo.IncludeExt, user.IncludeExt)
+	}
+	if !cfg.NoGitignoreSet {
+		switch {
+		case repo.NoGitignore != nil:
+			cfg.NoGitignore = *repo.NoGitignore
+		case user.NoGitignore != nil:
+			cfg.NoGitignore = *user.NoGitignore
+		}
+	}
+
+	return nil
+}
+
+// firstNonEmpty returns the first of the given lists that contains any values.
+func firstNonEmpty(lists ...[]string) []string {
+	for _, list := range lists {
+		if len(list) > 0 {
+			return list
+		}
+	}
+	return nil
+}
+
+// expandHome replaces a leading "~" in the path with the user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RepoSpec describes one of the repositories packed in a run that packs several, given with a
+// repeated -repo flag or listed in a manifest. Exactly one of URL, Path and Archive is set.
+type RepoSpec struct {
+	Name    string   `yaml:"name"`    // Name of the repository in the output; empty derives it from the source
+	URL     string   `yaml:"url"`     // URL of the Git repository to clone
+	Path    string   `yaml:"path"`    // Path to a local directory or checkout to pack instead of cloning
+	Archive string   `yaml:"archive"` // Path to a tarball, zip archive or git bundle to pack instead of cloning
+	Ref     string   `yaml:"ref"`     // Branch, tag or commit SHA to snapshot; empty uses -ref or the default branch
+	Subdirs []string `yaml:"subdirs"` // Directories to check out and pack; empty uses -subdir or packs everything
+}
+
+// Manifest lists the repositories to pack in one run, read from the file given with -manifest.
+//
+// Example:
+//
+//	name: checkout-review
+//	repos:
+//	  - url: https://github.com/acme/checkout
+//	    ref: v2.4.0
+//	  - url: git@github.com:acme/checkout-client-go.git
+//	    subdirs: [client]
+//	  - path: ../checkout-client-js
+//	    name: client-js
+type Manifest struct {
+	Name  string     `yaml:"name"`  // Name of the combined output; empty uses the manifest file name
+	Repos []RepoSpec `yaml:"repos"` // Repositories to pack, in output order
+}
+
+// LoadManifest reads a manifest. Relative local paths and archives are resolved against the
+// directory of the manifest, so that a manifest can be committed next to the repositories it lists.
+//
+// Parameters:
+//   - path: The file system path to the manifest.
+//
+// Returns:
+//   - *Manifest: The parsed manifest, with its name defaulted to the file name.
+//   - error: An error if the file cannot be read, is malformed, contains unknown keys, lists no
+//     repositories or has an entry that does not set exactly one of url, path or archive.
+func LoadManifest(path string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open manifest: %w", err)
+	}
+	defer file.Close()
+
+	var m Manifest
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	if len(m.Repos) == 0 {
+		return nil, fmt.Errorf("%s lists no repositories", path)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range m.Repos {
+		spec := &m.Repos[i]
+		if countSet(spec.URL, spec.Path, spec.Archive) != 1 {
+			return nil, fmt.Errorf("%s: repository %d must set exactly one of url, path or archive", path, i+1)
+		}
+		spec.Path = resolveManifestPath(dir, spec.Path)
+		spec.Archive = resolveManifestPath(dir, spec.Archive)
+		if isLocalFile(spec.Path) {
+			spec.Archive, spec.Path = spec.Path, ""
+		}
+	}
+
+	if m.Name == "" {
+		m.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return &m, nil
+}
+
+// resolveManifestPath resolves a path listed in a manifest against the directory of the manifest.
+func resolveManifestPath(dir, path string) string {
+	if path == "" {
+		return ""
+	}
+	path = expandHome(path)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// setRepos collects the repositories of a run packing several: the -repo values, classified as
+// URLs, local directories or archives as a single -repo value is, followed by the repositories
+// of the manifest. The options that cannot apply to several repositories are rejected.
+//
+// Parameters:
+//   - repos: The -repo values in the order given.
+//
+// Returns:
+//   - error: An error if the manifest cannot be loaded or an option conflicts with packing several repositories.
+func (cfg *Config) setRepos(repos []string) error {
+	if cfg.LocalPath != "" || cfg.ArchivePath != "" {
+		return errors.New("-path and -archive cannot be used when packing several repositories; pass them with -repo or list them in the manifest")
+	}
+
+	cfg.Repos = nil
+	for _, value := range repos {
+		switch {
+		case isLocalFile(value):
+			cfg.Repos = append(cfg.Repos, RepoSpec{Archive: value})
+		case isLocalDir(value):
+			cfg.Repos = append(cfg.Repos, RepoSpec{Path: value})
+		default:
+			cfg.Repos = append(cfg.Repos, RepoSpec{URL: value})
+		}
+	}
+	if cfg.Manifest != "" {
+		m, err := LoadManifest(cfg.Manifest)
+		if err != nil {
+			return err
+		}
+		cfg.Repos = append(cfg.Repos, m.Repos...)
+		cfg.CombinedName = m.Name
+	}
+
+	for i := range cfg.Repos {
+		spec := &cfg.Repos[i]
+		subdirs, err := NormalizeSubdirs(spec.Subdirs)
+		if err != nil {
+			return err
+		}
+		spec.Subdirs = subdirs
+
+		source := spec.URL + spec.Path + spec.Archive
+		switch {
+		case spec.Path != "" && spec.Ref != "":
+			return fmt.Errorf("%s: a ref cannot be selected from a local directory", source)
+		case spec.Path != "" && cfg.InMemory:
+			return fmt.Errorf("%s: -in-memory cannot be used with a local directory", source)
+		case spec.Archive != "" && cfg.Submodules:
+			return fmt.Errorf("%s: -submodules cannot be used with an archive", source)
+		}
+	}
+
+	switch {
+	case cfg.Jobs < 1:
+		return errors.New("jobs must be at least 1")
+	case cfg.PerRepo && cfg.Output != "":
+		return errors.New("-o cannot be used with -per-repo, which writes one output per repository; use -output-dir")
+	}
+	return nil
+}
+
+// IsMulti reports whether the configuration packs several repositories in one run.
+func (cfg *Config) IsMulti() bool {
+	return len(cfg.Repos) > 0
+}
+
+// ForRepo returns the configuration for packing one of the repositories of a run packing
+// several. The copy targets the repository alone, selecting its ref and subdirectories or
+// falling back to -ref and -subdir, and shares no slices with the original, so that merging the
+// repository's .repototxt.yaml leaves the other repositories unaffected. -ref does not apply to
+// local directories, which have no refs to select.
+//
+// Parameters:
+//   - spec: The repository to pack.
+//
+// Returns:
+//   - *Config: The configuration of the repository.
+func (cfg *Config) ForRepo(spec RepoSpec) *Config {
+	repoCfg := *cfg
+	repoCfg.Repos, repoCfg.PerRepo = nil, false
+	repoCfg.RepoURL, repoCfg.LocalPath, repoCfg.ArchivePath = spec.URL, spec.Path, spec.Archive
+	if spec.Ref != "" || spec.Path != "" {
+		repoCfg.Ref = spec.Ref
+	}
+	if len(spec.Subdirs) > 0 {
+		repoCfg.Subdirs = spec.Subdirs
+	}
+
+	repoCfg.ExcludeFolders = slices.Clone(cfg.ExcludeFolders)
+	repoCfg.IncludePatterns = slices.Clone(cfg.IncludePatterns)
+	repoCfg.IncludeExt = slices.Clone(cfg.IncludeExt)
+	repoCfg.FileNames = slices.Clone(cfg.FileNames)
+	repoCfg.Subdirs = slices.Clone(repoCfg.Subdirs)
+	return &repoCfg
+}
+
+// stringList is a repeatable flag value that collects each value as given.
+type stringList []string
+
+// String returns the collected values as a comma-separated string.
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set appends the value to the list.
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
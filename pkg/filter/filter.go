@@ -0,0 +1,302 @@
+// Package filter decides which files of a repository are packed into the output.
+// It evaluates the rules a repository defines for itself, such as nested .gitignore
+// files and .gitattributes, against paths relative to the repository root.
+package filter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// Names of the files and attributes that define repository rules.
+const (
+	gitDir            = ".git"
+	gitignoreFile     = ".gitignore"
+	gitattributesFile = ".gitattributes"
+	infoExcludeFile   = ".git/info/exclude"
+	commentPrefix     = "#"
+	attrExportIgnore  = "export-ignore"
+	attrGenerated     = "linguist-generated"
+	attrVendored      = "linguist-vendored"
+	attrBinary        = "binary"
+	attrDiff          = "diff"
+	attrValueFalse    = "false"
+)
+
+// Reasons reported when a file is excluded by repository rules.
+const (
+	ReasonGitignore    = "ignored by .gitignore"
+	ReasonExportIgnore = "marked export-ignore in .gitattributes"
+	ReasonGenerated    = "marked linguist-generated in .gitattributes"
+	ReasonVendored     = "marked linguist-vendored in .gitattributes"
+	ReasonBinary       = "marked binary in .gitattributes"
+)
+
+// GitRules holds the .gitignore and .gitattributes rules of a repository.
+// A nil *GitRules excludes nothing, so callers can disable the rules by not loading them.
+type GitRules struct {
+	ignore gitignore.Matcher
+	attrs  []gitattributes.MatchAttribute
+}
+
+// LoadGitRules reads .git/info/exclude and every .gitignore and .gitattributes file found in fsys.
+// Patterns in nested files only apply below the directory that contains them, and
+// directories that are already ignored are not searched for further rule files.
+//
+// Parameters:
+//   - fsys: The file system rooted at the repository root.
+//
+// Returns:
+//   - *GitRules: The loaded rules.
+//   - error: An error if the repository cannot be traversed or a rule file cannot be read.
+func LoadGitRules(fsys fs.FS) (*GitRules, error) {
+	// Patterns are ordered by increasing priority, and .gitignore files take precedence over info/exclude.
+	ignorePatterns, err := readIgnoreFile(fsys, infoExcludeFile, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes []gitattributes.MatchAttribute
+	err = walkRuleDirs(fsys, func(dir string, domain []string) error {
+		if len(domain) > 0 && gitignore.NewMatcher(ignorePatterns).Match(domain, true) {
+			return fs.SkipDir
+		}
+
+		ps, err := readIgnoreFile(fsys, path.Join(dir, gitignoreFile), domain)
+		if err != nil {
+			return err
+		}
+		ignorePatterns = append(ignorePatterns, ps...)
+
+		attrs, err := readAttributesFile(fsys, path.Join(dir, gitattributesFile), domain)
+		if err != nil {
+			return err
+		}
+		attributes = append(attributes, attrs...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &GitRules{
+		ignore: gitignore.NewMatcher(ignorePatterns),
+		attrs:  attributes,
+	}, nil
+}
+
+// Ignored reports whether the path is ignored by the repository's .gitignore rules.
+//
+// Parameters:
+//   - relPath: The slash-separated path relative to the repository root.
+//   - isDir: Whether the path refers to a directory.
+//
+// Returns:
+//   - bool: True if the path is ignored, false otherwise.
+func (r *GitRules) Ignored(relPath string, isDir bool) bool {
+	if r == nil {
+		return false
+	}
+	return r.ignore.Match(splitPath(relPath), isDir)
+}
+
+// Exclude reports whether a file should be left out of the output because of its
+// .gitignore rules or its export-ignore, linguist-generated, linguist-vendored or binary attributes.
+//
+// Parameters:
+//   - relPath: The slash-separated path of the file relative to the repository root.
+//
+// Returns:
+//   - string: The reason the file is excluded; empty if it is not excluded.
+//   - bool: True if the file should be excluded, false otherwise.
+func (r *GitRules) Exclude(relPath string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	if r.Ignored(relPath, false) {
+		return ReasonGitignore, true
+	}
+
+	results := r.matchAttributes(splitPath(relPath))
+	switch {
+	case isSet(results[attrExportIgnore]):
+		return ReasonExportIgnore, true
+	case isSet(results[attrGenerated]):
+		return ReasonGenerated, true
+	case isSet(results[attrVendored]):
+		return ReasonVendored, true
+	case isSet(results[attrBinary]) || isUnset(results[attrDiff]):
+		return ReasonBinary, true
+	}
+	return "", false
+}
+
+// matchAttributes returns the attributes that apply to a path. When several rules set the same
+// attribute, the rule with the highest priority (the last one, or the one in the deepest
+// .gitattributes file) wins, as in git.
+//
+// Parameters:
+//   - pathParts: The components of the path relative to the repository root.
+//
+// Returns:
+//   - map[string]gitattributes.Attribute: The applicable attributes keyed by name.
+func (r *GitRules) matchAttributes(pathParts []string) map[string]gitattributes.Attribute {
+	results := make(map[string]gitattributes.Attribute)
+	for i := len(r.attrs) - 1; i >= 0; i-- {
+		rule := r.attrs[i]
+		if rule.Pattern == nil || !rule.Pattern.Match(pathParts) {
+			continue // Macro definitions have no pattern
+		}
+		for _, attr := range rule.Attributes {
+			if _, ok := results[attr.Name()]; !ok {
+				results[attr.Name()] = attr
+			}
+		}
+	}
+	return results
+}
+
+// ReadIgnoreFiles reads every file with the given name in gitignore syntax found in fsys.
+// Patterns are returned in increasing order of priority, with each pattern scoped to the
+// directory containing its file. The .git directory and directories ignored by the
+// patterns read so far are not traversed.
+//
+// Parameters:
+//   - fsys: The file system rooted at the repository root.
+//   - name: The base name of the ignore files, e.g. ".gitignore".
+//
+// Returns:
+//   - []gitignore.Pattern: The parsed patterns.
+//   - error: An error if the repository cannot be traversed or a file cannot be read.
+func ReadIgnoreFiles(fsys fs.FS, name string) ([]gitignore.Pattern, error) {
+	var patterns []gitignore.Pattern
+	err := walkRuleDirs(fsys, func(dir string, domain []string) error {
+		if len(domain) > 0 && gitignore.NewMatcher(patterns).Match(domain, true) {
+			return fs.SkipDir
+		}
+		ps, err := readIgnoreFile(fsys, path.Join(dir, name), domain)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, ps...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// readIgnoreFile parses a single file in gitignore syntax. A missing file yields no patterns.
+//
+// Parameters:
+//   - fsys: The file system rooted at the repository root.
+//   - name: The slash-separated path of the file within fsys.
+//   - domain: The path components of the directory the patterns apply to.
+//
+// Returns:
+//   - []gitignore.Pattern: The parsed patterns.
+//   - error: An error if the file exists but cannot be read.
+func readIgnoreFile(fsys fs.FS, name string, domain []string) ([]gitignore.Pattern, error) {
+	file, err := fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", name, err)
+	}
+	defer file.Close()
+
+	var patterns []gitignore.Pattern
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, commentPrefix) || strings.TrimSpace(line) == "" {
+			continue
+		}
+		patterns = append(patterns, gitignore.ParsePattern(line, domain))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", name, err)
+	}
+	return patterns, nil
+}
+
+// readAttributesFile parses a single .gitattributes file. A missing file yields no attributes.
+// Macros may only be defined in the top-level file, as in git.
+//
+// Parameters:
+//   - fsys: The file system rooted at the repository root.
+//   - name: The slash-separated path of the file within fsys.
+//   - domain: The path components of the directory the attributes apply to.
+//
+// Returns:
+//   - []gitattributes.MatchAttribute: The parsed attributes.
+//   - error: An error if the file exists but cannot be read or parsed.
+func readAttributesFile(fsys fs.FS, name string, domain []string) ([]gitattributes.MatchAttribute, error) {
+	file, err := fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", name, err)
+	}
+	defer file.Close()
+
+	attrs, err := gitattributes.ReadAttributes(file, domain, len(domain) == 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", name, err)
+	}
+	return attrs, nil
+}
+
+// walkRuleDirs calls fn for the root and every directory below it, except the .git directory.
+// Returning fs.SkipDir from fn skips the directory's contents.
+//
+// Parameters:
+//   - fsys: The file system rooted at the repository root.
+//   - fn: The function called with each directory and its path components.
+//
+// Returns:
+//   - error: An error if the traversal or fn fails.
+func walkRuleDirs(fsys fs.FS, fn func(dir string, domain []string) error) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error accessing path %s: %w", p, err)
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == gitDir {
+			return fs.SkipDir
+		}
+		return fn(p, splitPath(p))
+	})
+}
+
+// splitPath splits a slash-separated relative path into its components.
+// The root path "." yields no components.
+func splitPath(relPath string) []string {
+	if relPath == "." || relPath == "" {
+		return nil
+	}
+	return strings.Split(relPath, "/")
+}
+
+// isSet reports whether an attribute is set, either bare or with a value other than "false".
+func isSet(attr gitattributes.Attribute) bool {
+	if attr == nil {
+		return false
+	}
+	return attr.IsSet() || (attr.IsValueSet() && attr.Value() != attrValueFalse)
+}
+
+// isUnset reports whether an attribute is explicitly unset, e.g. "-diff".
+func isUnset(attr gitattributes.Attribute) bool {
+	return attr != nil && attr.IsUnset()
+}
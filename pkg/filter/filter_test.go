@@ -0,0 +1,218 @@
+// Package filter_test contains unit tests for the filter package.
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// TestGitRulesExclude verifies that nested .gitignore files, .git/info/exclude and
+// .gitattributes are honoured when deciding whether a file is excluded.
+func TestGitRulesExclude(t *testing.T) {
+	fsys := fstest.MapFS{
+		".git/info/exclude":      {Data: []byte("local.txt\n")},
+		".gitignore":             {Data: []byte("# comment\n*.log\nbuild/\n!keep.log\n")},
+		".gitattributes":         {Data: []byte("dist/** export-ignore\n*.pb.go linguist-generated\nthird_party/** linguist-vendored\n*.png binary\n*.lock -diff\n")},
+		"src/.gitignore":         {Data: []byte("tmp/\ngenerated.go\n")},
+		"src/.gitattributes":     {Data: []byte("handwritten.pb.go linguist-generated=false\n")},
+		"src/main.go":            {Data: []byte("package main\n")},
+		"src/generated.go":       {Data: []byte("package main\n")},
+		"src/api.pb.go":          {Data: []byte("package main\n")},
+		"src/handwritten.pb.go":  {Data: []byte("package main\n")},
+		"src/tmp/scratch.go":     {Data: []byte("package main\n")},
+		"generated.go":           {Data: []byte("package main\n")},
+		"debug.log":              {Data: []byte("log\n")},
+		"keep.log":               {Data: []byte("log\n")},
+		"local.txt":              {Data: []byte("local\n")},
+		"build/out.go":           {Data: []byte("package main\n")},
+		"dist/bundle.js":         {Data: []byte("bundle\n")},
+		"third_party/lib/lib.go": {Data: []byte("package lib\n")},
+		"logo.png":               {Data: []byte("png\n")},
+		"yarn.lock":              {Data: []byte("lock\n")},
+	}
+
+	rules, err := LoadGitRules(fsys)
+	if err != nil {
+		t.Fatalf("LoadGitRules returned an error: %v", err)
+	}
+
+	testCases := []struct {
+		path     string
+		excluded bool
+		reason   string
+	}{
+		{"src/main.go", false, ""},
+		{"src/generated.go", true, ReasonGitignore},
+		{"generated.go", false, ""}, // Nested .gitignore only applies to its own directory
+		{"src/tmp/scratch.go", true, ReasonGitignore},
+		{"debug.log", true, ReasonGitignore},
+		{"keep.log", false, ""},
+		{"local.txt", true, ReasonGitignore},
+		{"build/out.go", true, ReasonGitignore},
+		{"dist/bundle.js", true, ReasonExportIgnore},
+		{"src/api.pb.go", true, ReasonGenerated},
+		{"src/handwritten.pb.go", false, ""},
+		{"third_party/lib/lib.go", true, ReasonVendored},
+		{"logo.png", true, ReasonBinary},
+		{"yarn.lock", true, ReasonBinary},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			reason, excluded := rules.Exclude(tc.path)
+			if excluded != tc.excluded || reason != tc.reason {
+				t.Errorf("Exclude(%q) = (%q, %v); want (%q, %v)", tc.path, reason, excluded, tc.reason, tc.excluded)
+			}
+		})
+	}
+
+	if !rules.Ignored("build", true) {
+		t.Errorf("Expected directory %q to be ignored", "build")
+	}
+}
+
+// TestNilGitRules verifies that a nil *GitRules excludes nothing.
+func TestNilGitRules(t *testing.T) {
+	var rules *GitRules
+	if _, excluded := rules.Exclude("anything.log"); excluded {
+		t.Errorf("Expected nil rules to exclude nothing")
+	}
+	if rules.Ignored("build", true) {
+		t.Errorf("Expected nil rules to ignore nothing")
+	}
+}
+
+// TestReadIgnoreFilesSkipsIgnoredDirs verifies that ignore files inside ignored directories are not read.
+func TestReadIgnoreFilesSkipsIgnoredDirs(t *testing.T) {
+	fsys := fstest.MapFS{
+		".gitignore":                  {Data: []byte("node_modules/\n")},
+		"node_modules/pkg/.gitignore": {Data: []byte("*.go\n")},
+		"main.go":                     {Data: []byte("package main\n")},
+	}
+
+	patterns, err := ReadIgnoreFiles(fsys, ".gitignore")
+	if err != nil {
+		t.Fatalf("ReadIgnoreFiles returned an error: %v", err)
+	}
+	if len(patterns) != 1 {
+		t.Errorf("Expected 1 pattern, got %d", len(patterns))
+	}
+}
+
+// TestPatternsExclude verifies plain and glob patterns, negation and the precedence of
+// exclude patterns over include patterns.
+func TestPatternsExclude(t *testing.T) {
+	testCases := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		path     string
+		excluded bool
+	}{
+		{"no patterns", nil, nil, "main.go", false},
+		{"plain folder", nil, []string{"docs"}, "docs/guide/intro.md", true},
+		{"plain folder prefix only", nil, []string{"docs"}, "docs2/intro.md", false},
+		{"plain folder trailing slash", nil, []string{"docs/"}, "docs/intro.md", true},
+		{"plain file", nil, []string{"cmd/tool/main.go"}, "cmd/tool/main.go", true},
+		{"doublestar suffix", nil, []string{"**/*_test.go"}, "pkg/output/output_test.go", true},
+		{"doublestar at root", nil, []string{"**/*_test.go"}, "main_test.go", true},
+		{"doublestar directory", nil, []string{"**/testdata/**"}, "pkg/a/testdata/in.txt", true},
+		{"glob matches parent directory", nil, []string{"**/testdata"}, "pkg/a/testdata/in.txt", true},
+		{"negation re-includes", nil, []string{"**/*_test.go", "!pkg/keep_test.go"}, "pkg/keep_test.go", false},
+		{"last match wins", nil, []string{"!pkg/keep_test.go", "**/*_test.go"}, "pkg/keep_test.go", true},
+		{"include match", []string{"src/**/*.go"}, nil, "src/a/b.go", false},
+		{"include miss", []string{"src/**/*.go"}, nil, "src/a/b.md", true},
+		{"include outside", []string{"src/**/*.go"}, nil, "main.go", true},
+		{"include negation", []string{"src/**", "!src/gen/**"}, nil, "src/gen/a.go", true},
+		{"exclude beats include", []string{"src/**/*.go"}, []string{"**/*_test.go"}, "src/a_test.go", true},
+		{"brace alternatives", []string{"*.{go,md}"}, nil, "README.md", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patterns, err := NewPatterns(tc.include, tc.exclude)
+			if err != nil {
+				t.Fatalf("NewPatterns returned an error: %v", err)
+			}
+			if _, excluded := patterns.Exclude(tc.path); excluded != tc.excluded {
+				t.Errorf("Exclude(%q) with include %v and exclude %v = %v; want %v", tc.path, tc.include, tc.exclude, excluded, tc.excluded)
+			}
+		})
+	}
+}
+
+// TestPatternsSkipDir verifies that directories are only skipped when no negated pattern
+// could re-include their contents.
+func TestPatternsSkipDir(t *testing.T) {
+	patterns, err := NewPatterns(nil, []string{"vendor", "**/node_modules"})
+	if err != nil {
+		t.Fatalf("NewPatterns returned an error: %v", err)
+	}
+	if !patterns.SkipDir("vendor") || !patterns.SkipDir("web/node_modules") {
+		t.Errorf("Expected excluded directories to be skipped")
+	}
+	if patterns.SkipDir("src") {
+		t.Errorf("Expected directory %q not to be skipped", "src")
+	}
+
+	patterns, err = NewPatterns(nil, []string{"vendor", "!vendor/keep.go"})
+	if err != nil {
+		t.Fatalf("NewPatterns returned an error: %v", err)
+	}
+	if patterns.SkipDir("vendor") {
+		t.Errorf("Expected directory not to be skipped when a negated pattern exists")
+	}
+}
+
+// TestNewPatternsInvalid verifies that malformed globs are rejected.
+func TestNewPatternsInvalid(t *testing.T) {
+	if _, err := NewPatterns([]string{"src/[a-"}, nil); err == nil {
+		t.Errorf("Expected an error for a malformed pattern, got nil")
+	}
+}
+
+// TestLoadIgnoreRules verifies that nested ignore files and the global ignore file are combined,
+// with the repository's files taking precedence.
+func TestLoadIgnoreRules(t *testing.T) {
+	globalFile := filepath.Join(t.TempDir(), "ignore")
+	if err := os.WriteFile(globalFile, []byte("*.log\n*.snap\n"), 0644); err != nil {
+		t.Fatalf("Failed to write global ignore file: %v", err)
+	}
+	fsys := fstest.MapFS{
+		".repototxtignore":     {Data: []byte("fixtures/\n!keep.snap\n")},
+		"web/.repototxtignore": {Data: []byte("*.css\n")},
+		"web/app.css":          {Data: []byte("body {}\n")},
+		"app.css":              {Data: []byte("body {}\n")},
+	}
+
+	rules, err := LoadIgnoreRules(fsys, ".repototxtignore", globalFile)
+	if err != nil {
+		t.Fatalf("LoadIgnoreRules returned an error: %v", err)
+	}
+
+	testCases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"debug.log", false, true},
+		{"a.snap", false, true},
+		{"keep.snap", false, false},
+		{"fixtures", true, true},
+		{"web/app.css", false, true},
+		{"app.css", false, false},
+		{"main.go", false, false},
+	}
+	for _, tc := range testCases {
+		if ignored := rules.Ignored(tc.path, tc.isDir); ignored != tc.ignored {
+			t.Errorf("Ignored(%q, %v) = %v; want %v", tc.path, tc.isDir, ignored, tc.ignored)
+		}
+	}
+
+	rules, err = LoadIgnoreRules(fstest.MapFS{}, ".repototxtignore", "")
+	if err != nil || rules != nil {
+		t.Errorf("Expected no rules without ignore files, got %v, %v", rules, err)
+	}
+}
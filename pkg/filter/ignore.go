@@ -0,0 +1,66 @@
+package filter
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// IgnoreRules holds the patterns of the tool-specific ignore files, such as .repototxtignore.
+// Unlike GitRules they apply even when .gitignore handling is disabled.
+// A nil *IgnoreRules excludes nothing.
+type IgnoreRules struct {
+	matcher gitignore.Matcher
+}
+
+// LoadIgnoreRules reads every ignore file with the given name found in fsys, together with an
+// optional global ignore file whose patterns apply from the repository root. Patterns in the
+// repository's files take precedence over the global file, and patterns in nested files only
+// apply below the directory that contains them.
+//
+// Parameters:
+//   - fsys: The file system rooted at the repository root.
+//   - name: The base name of the ignore files in the repository, e.g. ".repototxtignore".
+//   - globalFile: The file system path to a global ignore file; empty if there is none.
+//
+// Returns:
+//   - *IgnoreRules: The loaded rules, or nil if no patterns were found.
+//   - error: An error if the repository cannot be traversed or an ignore file cannot be read.
+func LoadIgnoreRules(fsys fs.FS, name, globalFile string) (*IgnoreRules, error) {
+	var patterns []gitignore.Pattern
+	if globalFile != "" {
+		ps, err := readIgnoreFile(os.DirFS(filepath.Dir(globalFile)), filepath.Base(globalFile), nil)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, ps...)
+	}
+
+	ps, err := ReadIgnoreFiles(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	patterns = append(patterns, ps...)
+
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	return &IgnoreRules{matcher: gitignore.NewMatcher(patterns)}, nil
+}
+
+// Ignored reports whether the path is ignored by the ignore files.
+//
+// Parameters:
+//   - relPath: The slash-separated path relative to the repository root.
+//   - isDir: Whether the path refers to a directory.
+//
+// Returns:
+//   - bool: True if the path is ignored, false otherwise.
+func (r *IgnoreRules) Ignored(relPath string, isDir bool) bool {
+	if r == nil {
+		return false
+	}
+	return r.matcher.Match(splitPath(relPath), isDir)
+}
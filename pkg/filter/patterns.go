@@ -0,0 +1,177 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// negationPrefix marks a pattern that re-includes paths matched by earlier patterns.
+const negationPrefix = "!"
+
+// Reasons reported when a file is excluded by user-supplied patterns.
+const (
+	ReasonExcludePattern = "matched exclude pattern"
+	ReasonNotIncluded    = "not matched by any include pattern"
+)
+
+// Patterns holds the include and exclude patterns supplied by the user.
+//
+// Each pattern is either a plain path such as "docs" or "cmd/tool", which matches that file
+// or everything below that directory, or a glob such as "src/**/*.go" or "**/testdata/**",
+// where "**" matches any number of directories. A glob also matches every file below a
+// directory it matches. A leading "!" negates a pattern.
+//
+// Precedence rules:
+//  1. Exclude patterns are evaluated in order and the last matching pattern wins: a plain
+//     pattern excludes the path and a negated pattern re-includes it.
+//  2. When include patterns are given, a path must match at least one of them. Include
+//     patterns are also evaluated in order, so a negated include pattern removes paths
+//     from the include set again.
+//  3. Exclusion takes precedence over inclusion: a path that is both included and
+//     excluded is excluded.
+//
+// A nil *Patterns excludes nothing.
+type Patterns struct {
+	include []pattern
+	exclude []pattern
+}
+
+// pattern is a single parsed include or exclude pattern.
+type pattern struct {
+	raw    string // Pattern as supplied by the user, including any negation prefix
+	expr   string // Normalised pattern without negation prefix or trailing slash
+	negate bool   // Whether the pattern re-includes matching paths
+	glob   bool   // Whether the pattern contains glob metacharacters
+}
+
+// NewPatterns parses the given include and exclude patterns.
+//
+// Parameters:
+//   - include: Patterns selecting the files to include; empty includes everything.
+//   - exclude: Patterns selecting the files to exclude.
+//
+// Returns:
+//   - *Patterns: The parsed patterns.
+//   - error: An error if any pattern is malformed.
+func NewPatterns(include, exclude []string) (*Patterns, error) {
+	includePatterns, err := parsePatterns(include)
+	if err != nil {
+		return nil, err
+	}
+	excludePatterns, err := parsePatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &Patterns{include: includePatterns, exclude: excludePatterns}, nil
+}
+
+// Exclude reports whether a file should be left out of the output according to the patterns.
+//
+// Parameters:
+//   - relPath: The slash-separated path of the file relative to the repository root.
+//
+// Returns:
+//   - string: The reason the file is excluded; empty if it is not excluded.
+//   - bool: True if the file should be excluded, false otherwise.
+func (p *Patterns) Exclude(relPath string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	if matched, ok := lastMatch(p.exclude, relPath); ok && !matched.negate {
+		return fmt.Sprintf("%s %q", ReasonExcludePattern, matched.raw), true
+	}
+	if p.HasIncludes() {
+		if matched, ok := lastMatch(p.include, relPath); !ok || matched.negate {
+			return ReasonNotIncluded, true
+		}
+	}
+	return "", false
+}
+
+// SkipDir reports whether a whole directory can be skipped because every file below it is
+// excluded. Directories are only skipped when no negated exclude pattern could re-include
+// one of their files.
+//
+// Parameters:
+//   - relPath: The slash-separated path of the directory relative to the repository root.
+//
+// Returns:
+//   - bool: True if the directory can be skipped, false otherwise.
+func (p *Patterns) SkipDir(relPath string) bool {
+	if p == nil {
+		return false
+	}
+	for _, pat := range p.exclude {
+		if pat.negate {
+			return false
+		}
+	}
+	_, ok := lastMatch(p.exclude, relPath)
+	return ok
+}
+
+// HasIncludes reports whether any include patterns were given.
+func (p *Patterns) HasIncludes() bool {
+	return p != nil && len(p.include) > 0
+}
+
+// parsePatterns normalises and validates a list of raw patterns.
+//
+// Parameters:
+//   - raw: The patterns as supplied by the user.
+//
+// Returns:
+//   - []pattern: The parsed patterns, skipping empty entries.
+//   - error: An error if any glob is malformed.
+func parsePatterns(raw []string) ([]pattern, error) {
+	patterns := make([]pattern, 0, len(raw))
+	for _, r := range raw {
+		expr := strings.TrimSpace(r)
+		negate := strings.HasPrefix(expr, negationPrefix)
+		expr = strings.TrimPrefix(expr, negationPrefix)
+		expr = strings.TrimPrefix(strings.TrimSuffix(path.Clean("/"+expr), "/"), "/")
+		if expr == "" {
+			continue
+		}
+		glob := strings.ContainsAny(expr, "*?[{")
+		if glob && !doublestar.ValidatePattern(expr) {
+			return nil, fmt.Errorf("invalid pattern %q", r)
+		}
+		patterns = append(patterns, pattern{raw: strings.TrimSpace(r), expr: expr, negate: negate, glob: glob})
+	}
+	return patterns, nil
+}
+
+// lastMatch returns the last pattern in the list matching the path.
+//
+// Parameters:
+//   - patterns: The patterns to evaluate in order.
+//   - relPath: The slash-separated path relative to the repository root.
+//
+// Returns:
+//   - pattern: The last matching pattern.
+//   - bool: True if any pattern matched, false otherwise.
+func lastMatch(patterns []pattern, relPath string) (pattern, bool) {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if patterns[i].match(relPath) {
+			return patterns[i], true
+		}
+	}
+	return pattern{}, false
+}
+
+// match reports whether the pattern matches the path itself or one of its parent directories.
+func (p pattern) match(relPath string) bool {
+	if !p.glob {
+		return relPath == p.expr || strings.HasPrefix(relPath, p.expr+"/")
+	}
+	for candidate := relPath; candidate != "." && candidate != "/" && candidate != ""; candidate = path.Dir(candidate) {
+		if ok, _ := doublestar.Match(p.expr, candidate); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/tokens"
+)
+
+// minTruncatedTokens is the smallest part of a file worth packing when it is truncated to fit
+// the token budget. Files that would be cut shorter than this are dropped instead.
+const minTruncatedTokens = 32
+
+// Reasons recorded for files left out, in whole or in part, to fit the token budget.
+const (
+	ReasonTruncated      = "truncated to fit the token budget"
+	ReasonBudgetExceeded = "does not fit in the remaining token budget"
+	ReasonBudgetSpent    = "token budget exhausted"
+)
+
+// Tiers in which files compete for the token budget. Files in a lower tier are always
+// considered before files in a higher tier, whatever the configured priority.
+const (
+	tierReadme = iota
+	tierEntryPoint
+	tierOther
+)
+
+// entryPointNames lists file names, compared case-insensitively, that usually hold the
+// entry point of a program or library.
+var entryPointNames = map[string]bool{
+	"main.go":     true,
+	"main.py":     true,
+	"__main__.py": true,
+	"app.py":      true,
+	"manage.py":   true,
+	"main.rs":     true,
+	"lib.rs":      true,
+	"main.js":     true,
+	"main.ts":     true,
+	"index.js":    true,
+	"index.ts":    true,
+	"index.tsx":   true,
+	"main.c":      true,
+	"main.cpp":    true,
+	"main.java":   true,
+	"program.cs":  true,
+	"main.swift":  true,
+	"main.kt":     true,
+}
+
+// manifestNames lists file names, compared case-insensitively, of build manifests that
+// describe a project when they sit at the repository root.
+var manifestNames = map[string]bool{
+	"go.mod":           true,
+	"package.json":     true,
+	"cargo.toml":       true,
+	"pyproject.toml":   true,
+	"setup.py":         true,
+	"requirements.txt": true,
+	"pom.xml":          true,
+	"build.gradle":     true,
+	"build.gradle.kts": true,
+	"gemfile":          true,
+	"composer.json":    true,
+	"makefile":         true,
+	"dockerfile":       true,
+}
+
+// OmittedFile describes a file left out of the output, in whole or in part, to fit the token budget.
+type OmittedFile struct {
+	Path   string // Slash-separated path relative to the repository root
+	Tokens int    // Number of tokens of the file left out of the output
+	Reason string // Why the file, or the rest of it, was left out
+}
+
+// fileTier returns the tier in which the file at the given path competes for the token budget.
+func fileTier(relPath string) int {
+	name := strings.ToLower(path.Base(relPath))
+	root := !strings.Contains(relPath, "/")
+	switch {
+	case root && strings.HasPrefix(name, "readme"):
+		return tierReadme
+	case entryPointNames[name], root && manifestNames[name]:
+		return tierEntryPoint
+	default:
+		return tierOther
+	}
+}
+
+// budgetOrder returns the indexes of the packed entries in the order in which they compete for
+// the token budget: the root README first, then entry points and build manifests, then all other
+// files. Within a tier, files are ordered by the priority, with ties broken by path. In a combined
+// document, tiers and depths are taken relative to the root of each repository, so that the
+// README of every repository comes first.
+//
+// Parameters:
+//   - entries: The entries to order.
+//   - priority: One of the config.Priority constants.
+//
+// Returns:
+//   - []int: The indexes of the packed entries in priority order.
+func budgetOrder(entries []entry, priority string) []int {
+	var order []int
+	for i := range entries {
+		if entries[i].packed() {
+			order = append(order, i)
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := &entries[order[i]], &entries[order[j]]
+		if tierA, tierB := fileTier(a.repoPath()), fileTier(b.repoPath()); tierA != tierB {
+			return tierA < tierB
+		}
+		switch priority {
+		case config.PriorityRecency:
+			if !a.modTime.Equal(b.modTime) {
+				return a.modTime.After(b.modTime)
+			}
+		case config.PrioritySize:
+			if a.tokens != b.tokens {
+				return a.tokens < b.tokens
+			}
+		default:
+			if depthA, depthB := strings.Count(a.repoPath(), "/"), strings.Count(b.repoPath(), "/"); depthA != depthB {
+				return depthA < depthB
+			}
+		}
+		return a.relPath < b.relPath
+	})
+	return order
+}
+
+// applyBudget fits the packed entries into the token budget. Entries are considered in priority
+// order and packed whole while they fit; an entry that does not fit is truncated to the remaining
+// budget when the overflow mode allows it, and otherwise left out. The output order of the
+// entries is not changed.
+//
+// Parameters:
+//   - entries: The entries with counted tokens; they are updated in place.
+//   - counter: The counter used to count the tokens of truncated content.
+//   - maxTokens: The token budget.
+//   - priority: One of the config.Priority constants.
+//   - overflow: One of the config.Overflow constants.
+//
+// Returns:
+//   - []OmittedFile: The files left out or truncated, in priority order.
+func applyBudget(entries []entry, counter tokens.Counter, maxTokens int, priority, overflow string) []OmittedFile {
+	var omitted []OmittedFile
+	remaining := maxTokens
+	for _, i := range budgetOrder(entries, priority) {
+		e := &entries[i]
+		if e.tokens <= remaining {
+			remaining -= e.tokens
+			continue
+		}
+
+		if overflow == config.OverflowTruncate && remaining >= minTruncatedTokens {
+			// Only the files that are truncated are read into memory
+			if content, err := e.read(); err == nil {
+				if content, count, left, ok := truncateContent(content, e.tokens, remaining, counter); ok {
+					omitted = append(omitted, OmittedFile{Path: e.relPath, Tokens: left, Reason: ReasonTruncated})
+					e.content, e.lines, e.tokens, e.truncated = content, countLines(content), count, true
+					remaining -= count
+					continue
+				}
+			}
+		}
+
+		reason := ReasonBudgetExceeded
+		if remaining == 0 {
+			reason = ReasonBudgetSpent
+		}
+		omitted = append(omitted, OmittedFile{Path: e.relPath, Tokens: e.tokens, Reason: reason})
+		e.content, e.reason, e.omitted = nil, reason, true
+	}
+	return omitted
+}
+
+// truncateContent cuts the content at the last line boundary that keeps it, together with a
+// marker noting the truncation, within the given number of tokens.
+//
+// Parameters:
+//   - content: The content to truncate.
+//   - total: The number of tokens in the whole content.
+//   - limit: The maximum number of tokens of the truncated content, marker included.
+//   - counter: The counter used to count tokens.
+//
+// Returns:
+//   - []byte: The truncated content ending with the marker.
+//   - int: The number of tokens in the truncated content.
+//   - int: The number of tokens of the original content left out.
+//   - bool: False if not even the first line fits, or too little of the file would be left to be useful.
+func truncateContent(content []byte, total, limit int, counter tokens.Counter) ([]byte, int, int, bool) {
+	// Offsets just past each line break; the content is cut at one of them.
+	var cuts []int
+	for offset, b := range content {
+		if b == '\n' {
+			cuts = append(cuts, offset+1)
+		}
+	}
+
+	build := func(n int) ([]byte, int, int) {
+		kept := content[:cuts[n]]
+		keptTokens := counter.Count(kept)
+		marker := fmt.Sprintf("[... truncated: %d of %d tokens omitted to fit the token budget ...]\n", total-keptTokens, total)
+		truncated := append(bytes.Clone(kept), marker...)
+		return truncated, counter.Count(truncated), keptTokens
+	}
+
+	// Find the most lines that fit; token counts grow with the number of lines kept.
+	n := sort.Search(len(cuts), func(n int) bool {
+		_, count, _ := build(n)
+		return count > limit
+	}) - 1
+	if n < 0 {
+		return nil, 0, 0, false
+	}
+	truncated, count, keptTokens := build(n)
+	if keptTokens < minTruncatedTokens {
+		return nil, 0, 0, false
+	}
+	return truncated, count, total - keptTokens, true
+}
@@ -0,0 +1,508 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/tokens"
+)
+
+// Chunk describes one of the part files the output was split into.
+type Chunk struct {
+	File   string      // Path of the chunk file
+	Size   int64       // Size of the chunk file in bytes
+	Lines  int         // Number of lines in the chunk file
+	Tokens int         // Number of tokens in the files packed into the chunk; 0 if tokens were not counted
+	Files  []ChunkFile // Files packed into the chunk, in output order
+}
+
+// ChunkFile describes a file, or part of a file, packed into a chunk.
+type ChunkFile struct {
+	Path  string // Slash-separated path relative to the repository root
+	Part  int    // 1-based number of the part in this chunk when the file is split across chunks; 0 if whole
+	Parts int    // Number of parts the file is split into; 0 if whole
+}
+
+// JSONChunkIndex is the index written next to chunked json and jsonl output.
+type JSONChunkIndex struct {
+	Repository   JSONRepository    `json:"repository"`
+	Repositories []JSONRepository  `json:"repositories,omitempty"` // Repositories of a combined document, present when several were packed
+	Unit         string            `json:"unit"`                   // Unit of the chunk size: bytes, lines or tokens
+	ChunkSize    int               `json:"chunk_size"`             // Maximum size of each chunk
+	Chunks       []JSONChunk       `json:"chunks"`
+	Omitted      []JSONOmittedFile `json:"omitted,omitempty"` // Files left out to fit the token budget, present when any were
+}
+
+// JSONChunk describes a chunk file in the JSON index.
+type JSONChunk struct {
+	File   string          `json:"file"`   // Name of the chunk file, relative to the index
+	Size   int64           `json:"size"`   // Size of the chunk file in bytes
+	Lines  int             `json:"lines"`  // Number of lines in the chunk file
+	Tokens int             `json:"tokens"` // Number of tokens in the files packed into the chunk; 0 if tokens were not counted
+	Files  []JSONChunkFile `json:"files"`
+}
+
+// JSONChunkFile describes a file, or part of a file, packed into a chunk in the JSON index.
+type JSONChunkFile struct {
+	Path  string `json:"path"`            // Slash-separated path relative to the repository root
+	Part  int    `json:"part,omitempty"`  // Number of the part, present when the file is split across chunks
+	Parts int    `json:"parts,omitempty"` // Number of parts, present when the file is split across chunks
+}
+
+// chunkSizer measures documents and file blocks in the configured chunk unit.
+type chunkSizer struct {
+	format  string
+	unit    string
+	meta    Metadata
+	summary *Summary
+}
+
+// measure returns the size of rendered output in bytes or lines.
+func (s *chunkSizer) measure(data []byte) int {
+	if s.unit == config.ChunkLines {
+		return countLines(data)
+	}
+	return len(data)
+}
+
+// overhead returns the size of a chunk without files. The first chunk also holds the directory
+// tree and the list of files left out to fit the token budget. Token sizes only count file contents.
+func (s *chunkSizer) overhead(first bool, tree string) (int, error) {
+	if s.unit == config.ChunkTokens {
+		return 0, nil
+	}
+	summary := *s.summary
+	if !first {
+		summary.Omitted, tree = nil, ""
+	}
+	var buf bytes.Buffer
+	if err := writeDocument(&buf, s.format, s.meta, &summary, tree, nil); err != nil {
+		return 0, err
+	}
+	return s.measure(buf.Bytes()), nil
+}
+
+// file returns the size the entry adds to a chunk, or an error if the entry cannot be formatted.
+func (s *chunkSizer) file(e entry) (int, error) {
+	if s.unit == config.ChunkTokens {
+		return e.tokens, nil
+	}
+	var buf bytes.Buffer
+	formatter, err := newFormatter(s.format, &buf)
+	if err != nil {
+		return 0, err
+	}
+	// Begin sets up formatter state, such as whether token counts are written; its output is discarded.
+	if err := formatter.begin(s.meta, s.summary); err != nil {
+		return 0, err
+	}
+	buf.Reset()
+	if err := formatter.file(e); err != nil {
+		return 0, err
+	}
+	return s.measure(buf.Bytes()), nil
+}
+
+// section returns the size of the section opening the files of a repository in a combined
+// document; 0 for a single repository and for sizes in tokens. Sections are measured as if they
+// followed another one, whose closing tag they also account for.
+func (s *chunkSizer) section(repo string) (int, error) {
+	if repo == "" || s.unit == config.ChunkTokens {
+		return 0, nil
+	}
+	var buf bytes.Buffer
+	formatter, err := newFormatter(s.format, &buf)
+	if err != nil {
+		return 0, err
+	}
+	meta := s.meta.repository(repo)
+	if err := formatter.begin(s.meta, s.summary); err != nil {
+		return 0, err
+	}
+	if err := formatter.repository(meta); err != nil {
+		return 0, err
+	}
+	buf.Reset()
+	if err := formatter.repository(meta); err != nil {
+		return 0, err
+	}
+	return s.measure(buf.Bytes()), nil
+}
+
+// writeChunks splits the packed entries into part files of at most cfg.ChunkSize bytes, lines or
+// tokens and writes an index describing which files landed in which chunk. Files are never split
+// unless a file alone exceeds the chunk size. Each chunk is a complete document in the configured
+// output format; the first chunk also holds the directory tree and the list of omitted files.
+// Sizes in bytes and lines cover the whole chunk file, while sizes in tokens cover the file contents.
+//
+// Parameters:
+//   - outputFile: The path to the output file; chunks are named after it, e.g. repo.part-001.txt.
+//   - entries: The entries to write.
+//   - meta: Metadata describing the repository snapshot, written as a header in every chunk.
+//   - summary: The summary of the packed files; its Chunks and Index fields are set.
+//   - counter: The counter used to count the tokens of split files; nil if tokens are not counted.
+//   - cfg: A pointer to the Config struct containing the output and chunking options.
+//
+// Returns:
+//   - error: An error if the chunk size is too small for the output or writing fails.
+func writeChunks(outputFile string, entries []entry, meta Metadata, summary *Summary, counter tokens.Counter, cfg *config.Config) error {
+	if cfg.ChunkUnit == config.ChunkTokens && counter == nil {
+		return errors.New("chunking by tokens requires a tokenizer")
+	}
+
+	var tree string
+	if cfg.Tree {
+		tree = renderTree(meta.Name, entries)
+	}
+
+	sizer := &chunkSizer{format: cfg.Format, unit: cfg.ChunkUnit, meta: meta, summary: summary}
+	chunks, err := planChunks(entries, sizer, counter, tree, cfg.ChunkSize)
+	if err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(outputFile)
+	base := strings.TrimSuffix(outputFile, ext)
+	for i, chunkEntries := range chunks {
+		chunkSummary := summarize(chunkEntries, counter)
+		chunkSummary.MaxTokens = summary.MaxTokens
+		chunkTree := ""
+		if i == 0 {
+			chunkSummary.Omitted, chunkTree = summary.Omitted, tree
+		}
+
+		chunk := Chunk{File: fmt.Sprintf("%s.part-%03d%s", base, i+1, ext), Tokens: chunkSummary.Tokens}
+		for _, e := range chunkEntries {
+			chunk.Files = append(chunk.Files, ChunkFile{Path: e.relPath, Part: e.part, Parts: e.parts})
+		}
+		if chunk.Size, chunk.Lines, err = writeChunkFile(chunk.File, cfg.Format, meta, chunkSummary, chunkTree, chunkEntries); err != nil {
+			return err
+		}
+		summary.Chunks = append(summary.Chunks, chunk)
+	}
+
+	summary.Index = indexFile(base, cfg.Format)
+	return writeChunkIndex(summary.Index, cfg.Format, meta, summary, cfg.ChunkUnit, cfg.ChunkSize)
+}
+
+// planChunks distributes the packed entries over chunks in output order. A file that does not fit
+// in the current chunk starts a new one, and a file that does not fit in an empty chunk is split
+// into parts that each fill a chunk of their own. In a combined document, every chunk repeats the
+// section of the repository its first file belongs to, so sections count towards the chunk size.
+//
+// Parameters:
+//   - entries: The entries to distribute.
+//   - sizer: Measures chunks and files in the chunk unit.
+//   - counter: The counter used to count the tokens of file parts; nil if tokens are not counted.
+//   - tree: The rendered directory tree written to the first chunk; empty if there is none.
+//   - limit: The chunk size.
+//
+// Returns:
+//   - [][]entry: The entries of each chunk.
+//   - error: An error if the chunk size is too small to hold the document header or a single
+//     character, or if a file cannot be formatted.
+func planChunks(entries []entry, sizer *chunkSizer, counter tokens.Counter, tree string, limit int) ([][]entry, error) {
+	firstOverhead, err := sizer.overhead(true, tree)
+	if err != nil {
+		return nil, err
+	}
+	restOverhead, err := sizer.overhead(false, tree)
+	if err != nil {
+		return nil, err
+	}
+	if max(firstOverhead, restOverhead) >= limit {
+		return nil, fmt.Errorf("chunk size of %d %s is too small to hold the document header of %d %s",
+			limit, sizer.unit, max(firstOverhead, restOverhead), sizer.unit)
+	}
+
+	var chunks [][]entry
+	var current []entry
+	used, repo := firstOverhead, ""
+	flush := func() {
+		chunks = append(chunks, current)
+		current, used, repo = nil, restOverhead, ""
+	}
+	// add returns the size the entry adds to the current chunk, including the section it opens, if any.
+	add := func(e entry) (int, error) {
+		size, err := sizer.file(e)
+		if err != nil || e.repo == repo {
+			return size, err
+		}
+		section, err := sizer.section(e.repo)
+		return section + size, err
+	}
+
+	for _, e := range entries {
+		if !e.packed() {
+			continue
+		}
+		size, err := add(e)
+		if err != nil {
+			return nil, err
+		}
+		if used+size > limit && len(current) > 0 {
+			flush()
+			if size, err = add(e); err != nil {
+				return nil, err
+			}
+		}
+		if used+size <= limit {
+			used += size
+			current, repo = append(current, e), e.repo
+			continue
+		}
+
+		// The file alone exceeds the chunk size, so split it into parts that each fill a chunk.
+		section, err := sizer.section(e.repo)
+		if err != nil {
+			return nil, err
+		}
+		parts, err := splitEntry(e, sizer, counter, limit-max(firstOverhead, restOverhead)-section)
+		if err != nil {
+			return nil, err
+		}
+		for i, part := range parts {
+			if i > 0 {
+				flush()
+			}
+			size, err := add(part)
+			if err != nil {
+				return nil, err
+			}
+			used += size
+			current, repo = append(current, part), part.repo
+		}
+	}
+	if len(current) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks, nil
+}
+
+// splitEntry splits a file into parts that each fit in the available size. Files are split at
+// line boundaries; a single line that does not fit is split between characters.
+//
+// Parameters:
+//   - e: The entry to split.
+//   - sizer: Measures file parts in the chunk unit.
+//   - counter: The counter used to count the tokens of each part; nil if tokens are not counted.
+//   - available: The size available for a part.
+//
+// Returns:
+//   - []entry: The parts in order, numbered from 1.
+//   - error: An error if not even a single character fits or a part cannot be formatted.
+func splitEntry(e entry, sizer *chunkSizer, counter tokens.Counter, available int) ([]entry, error) {
+	content := e.content
+	// Part numbers are not known until the file is split, so measure with the widest possible ones.
+	part := func(data []byte) entry {
+		p := e
+		p.content, p.size, p.part, p.parts = data, int64(len(data)), len(content), len(content)
+		if counter != nil {
+			p.tokens = counter.Count(data)
+		}
+		return p
+	}
+
+	// Offsets just past each line; the content is split at some of them.
+	var lineEnds []int
+	for offset, b := range content {
+		if b == '\n' {
+			lineEnds = append(lineEnds, offset+1)
+		}
+	}
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lineEnds = append(lineEnds, len(content))
+	}
+
+	var parts []entry
+	for start, next := 0, 0; start < len(content); {
+		// fits returns how many of the ends give a part that fits; an error ends the search.
+		fits := func(ends []int) (int, error) {
+			var sizeErr error
+			n := sort.Search(len(ends), func(i int) bool {
+				size, err := sizer.file(part(content[start:ends[i]]))
+				if err != nil {
+					sizeErr = err
+				}
+				return sizeErr != nil || size > available
+			})
+			return n, sizeErr
+		}
+
+		end := 0
+		n, err := fits(lineEnds[next:])
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			end = lineEnds[next+n-1]
+			next += n
+		} else {
+			// Not even one line fits, so split the line between characters.
+			var runeEnds []int
+			for offset := start; offset < lineEnds[next]; {
+				_, size := utf8.DecodeRune(content[offset:])
+				offset += size
+				runeEnds = append(runeEnds, offset)
+			}
+			n, err := fits(runeEnds)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				return nil, fmt.Errorf("chunk size is too small to hold any part of %s", e.relPath)
+			}
+			end = runeEnds[n-1]
+			if end == lineEnds[next] {
+				next++
+			}
+		}
+		parts = append(parts, part(content[start:end]))
+		start = end
+	}
+
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("chunk size is too small to hold %s", e.relPath)
+	}
+	for i := range parts {
+		parts[i].part, parts[i].parts = i+1, len(parts)
+	}
+	return parts, nil
+}
+
+// countingWriter counts the bytes and lines written through it.
+type countingWriter struct {
+	writer io.Writer
+	bytes  int64
+	lines  int
+}
+
+// Write writes the data to the underlying writer and counts it.
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.bytes += int64(n)
+	w.lines += bytes.Count(p[:n], []byte("\n"))
+	return n, err
+}
+
+// writeChunkFile writes a chunk as a complete document.
+//
+// Parameters:
+//   - path: The path of the chunk file.
+//   - format: The output format, one of the config.Format constants.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - summary: The summary of the files in the chunk.
+//   - tree: The rendered directory tree; empty to leave it out.
+//   - entries: The entries of the chunk.
+//
+// Returns:
+//   - int64: The size of the chunk file in bytes.
+//   - int: The number of lines in the chunk file.
+//   - error: An error if the file cannot be created or written.
+func writeChunkFile(path, format string, meta Metadata, summary *Summary, tree string, entries []entry) (int64, int, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to create chunk file: %w", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	counter := &countingWriter{writer: writer}
+	if err := writeDocument(counter, format, meta, summary, tree, entries); err != nil {
+		return 0, 0, err
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, 0, fmt.Errorf("error writing chunk file: %w", err)
+	}
+	return counter.bytes, counter.lines, nil
+}
+
+// indexFile returns the path of an index file named after an output file: a JSON document for
+// the json and jsonl output formats and plain text otherwise.
+//
+// Parameters:
+//   - base: The path of the output file without its extension.
+//   - format: The output format, one of the config.Format constants.
+//
+// Returns:
+//   - string: The path of the index file, e.g. repo.index.txt.
+func indexFile(base, format string) string {
+	if format == config.FormatJSON || format == config.FormatJSONL {
+		return base + ".index" + FileExtension(config.FormatJSON)
+	}
+	return base + ".index" + config.DefaultOutputExt
+}
+
+// writeChunkIndex writes the index describing which files landed in which chunk. The index is a
+// JSONChunkIndex document for the json and jsonl output formats and plain text otherwise.
+//
+// Parameters:
+//   - path: The path of the index file.
+//   - format: The output format, one of the config.Format constants.
+//   - meta: Metadata describing the repository snapshot.
+//   - summary: The summary of the packed files, including the chunks.
+//   - unit: The unit of the chunk size.
+//   - limit: The chunk size.
+//
+// Returns:
+//   - error: An error if the index cannot be written.
+func writeChunkIndex(path, format string, meta Metadata, summary *Summary, unit string, limit int) error {
+	var index []byte
+	if format == config.FormatJSON || format == config.FormatJSONL {
+		doc := JSONChunkIndex{
+			Repository:   newJSONRepository(meta),
+			Repositories: newJSONRepositories(meta),
+			Unit:         unit,
+			ChunkSize:    limit,
+		}
+		for _, chunk := range summary.Chunks {
+			record := JSONChunk{File: filepath.Base(chunk.File), Size: chunk.Size, Lines: chunk.Lines, Tokens: chunk.Tokens, Files: []JSONChunkFile{}}
+			for _, file := range chunk.Files {
+				record.Files = append(record.Files, JSONChunkFile(file))
+			}
+			doc.Chunks = append(doc.Chunks, record)
+		}
+		for _, file := range summary.Omitted {
+			doc.Omitted = append(doc.Omitted, JSONOmittedFile(file))
+		}
+		data, err := marshalJSON(doc)
+		if err != nil {
+			return err
+		}
+		index = append(data, '\n')
+	} else {
+		var b strings.Builder
+		fmt.Fprintf(&b, "Index of %s: %s of at most %d %s\n", meta.Name, pluralize(len(summary.Chunks), "chunk"), limit, unit)
+		for _, chunk := range summary.Chunks {
+			fmt.Fprintf(&b, "\n%s (%s, %s, %s", filepath.Base(chunk.File), pluralize(len(chunk.Files), "file"), pluralize(int(chunk.Size), "byte"), pluralize(chunk.Lines, "line"))
+			if summary.Tokenizer != "" {
+				fmt.Fprintf(&b, ", %s", pluralize(chunk.Tokens, "token"))
+			}
+			b.WriteString("):\n")
+			for _, file := range chunk.Files {
+				fmt.Fprintf(&b, "- %s\n", entry{relPath: file.Path, part: file.Part, parts: file.Parts}.label())
+			}
+		}
+		if len(summary.Omitted) > 0 {
+			b.WriteString("\n")
+			if err := (&textFormatter{writer: &b}).omitted(summary.MaxTokens, summary.Omitted); err != nil {
+				return err
+			}
+		}
+		index = []byte(b.String())
+	}
+
+	if err := os.WriteFile(path, index, 0644); err != nil {
+		return fmt.Errorf("unable to write chunk index: %w", err)
+	}
+	return nil
+}
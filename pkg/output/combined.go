@@ -0,0 +1,255 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+)
+
+// Repository is one of the repositories packed into a combined document.
+type Repository struct {
+	Snapshot Snapshot       // Snapshot of the repository
+	Meta     Metadata       // Metadata of the repository; its name is the directory its files are listed under
+	Paths    []string       // Slash-separated paths of the files to pack relative to the repository root; nil packs the whole repository
+	Config   *config.Config // Exclusion and inclusion rules of the repository, with its .repototxt.yaml merged
+}
+
+// WriteCombinedToFile writes the contents of several repositories to a single output file. The
+// files of each repository are listed below a directory named after it and preceded by a section
+// describing the repository. The output format, directory tree, token budget and chunking are
+// configured once for the whole document, so the repositories share the token budget.
+//
+// Parameters:
+//   - repos: The repositories to pack, in output order. Their names must be distinct.
+//   - outputFile: The path to the output file.
+//   - meta: Metadata describing the combined document, such as its name; its Repositories are set from repos.
+//   - cfg: A pointer to the Config struct containing the output options.
+//
+// Returns:
+//   - *Summary: The files written and their token counts, with paths prefixed by the repository names.
+//   - error: An error if a name is invalid or repeated, a repository cannot be walked or writing fails.
+func WriteCombinedToFile(repos []Repository, outputFile string, meta Metadata, cfg *config.Config) (*Summary, error) {
+	absOutputFile, err := filepath.Abs(outputFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve output file path: %w", err)
+	}
+
+	entries, meta, err := collectCombinedEntries(repos, absOutputFile, meta)
+	if err != nil {
+		return nil, err
+	}
+	return writeEntries(outputFile, entries, meta, cfg)
+}
+
+// WriteCombined writes the contents of several repositories to the writer, in the same way as
+// WriteCombinedToFile. Chunked output needs files and is not supported.
+//
+// Parameters:
+//   - writer: The writer for the output.
+//   - repos: The repositories to pack, in output order. Their names must be distinct.
+//   - meta: Metadata describing the combined document, such as its name; its Repositories are set from repos.
+//   - cfg: A pointer to the Config struct containing the output options.
+//
+// Returns:
+//   - *Summary: The files written and their token counts, with paths prefixed by the repository names.
+//   - error: An error if the configuration sets a chunk size, a name is invalid or repeated, a
+//     repository cannot be walked or writing fails.
+func WriteCombined(writer io.Writer, repos []Repository, meta Metadata, cfg *config.Config) (*Summary, error) {
+	entries, meta, err := collectCombinedEntries(repos, "", meta)
+	if err != nil {
+		return nil, err
+	}
+	return writeEntriesTo(writer, entries, meta, cfg)
+}
+
+// collectCombinedEntries collects the entries of every repository of a combined document, moving
+// them below a directory named after their repository.
+//
+// Parameters:
+//   - repos: The repositories to pack, in output order.
+//   - absOutputFile: The absolute path of the output file, which is never packed; empty if there is none.
+//   - meta: Metadata describing the combined document.
+//
+// Returns:
+//   - []entry: The entries of all repositories, in output order.
+//   - Metadata: The metadata of the combined document, listing the repositories.
+//   - error: An error if a name is invalid or repeated, or a repository cannot be walked.
+func collectCombinedEntries(repos []Repository, absOutputFile string, meta Metadata) ([]entry, Metadata, error) {
+	meta.Repositories = nil
+	var entries []entry
+	for _, repo := range repos {
+		name := repo.Meta.Name
+		switch {
+		case name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`):
+			return nil, meta, fmt.Errorf("invalid repository name %q", name)
+		case containsName(meta.Repositories, name):
+			return nil, meta, fmt.Errorf("repository name %q is used more than once; give the repositories distinct names", name)
+		}
+		meta.Repositories = append(meta.Repositories, repo.Meta)
+
+		var repoEntries []entry
+		if repo.Paths != nil {
+			repoEntries = readEntries(repo.Snapshot, repo.Paths, repo.Config)
+		} else {
+			var err error
+			if repoEntries, err = collectRepoEntries(repo.Snapshot, absOutputFile, repo.Config); err != nil {
+				return nil, meta, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		for i := range repoEntries {
+			repoEntries[i].repo = name
+			repoEntries[i].relPath = name + "/" + repoEntries[i].relPath
+		}
+		entries = append(entries, repoEntries...)
+	}
+	return entries, meta, nil
+}
+
+// containsName reports whether one of the repositories has the given name.
+func containsName(repos []Metadata, name string) bool {
+	for _, repo := range repos {
+		if repo.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RepositoryOutput describes the output written for one repository of a run that writes one
+// output per repository.
+type RepositoryOutput struct {
+	Meta    Metadata // Metadata of the repository
+	File    string   // Path of the output file, or of the chunk index when the output was split; empty if packing failed
+	Summary *Summary // Files written and their token counts; nil if packing failed
+	Err     error    // Why the repository could not be packed; nil if it was
+}
+
+// JSONRepositoryIndex is the index written for json and jsonl output when one output is written
+// per repository.
+type JSONRepositoryIndex struct {
+	Name         string                 `json:"name"`                // Name of the run
+	Tokenizer    string                 `json:"tokenizer,omitempty"` // Tokenizer used to count tokens, if any
+	Tokens       int                    `json:"tokens"`              // Total number of tokens in the files packed for all repositories
+	Repositories []JSONRepositoryOutput `json:"repositories"`
+}
+
+// JSONRepositoryOutput describes the output written for a repository in the JSON index.
+type JSONRepositoryOutput struct {
+	Repository JSONRepository `json:"repository"`
+	Output     string         `json:"output,omitempty"`  // Output file or chunk index, relative to the index; absent if packing failed
+	Files      int            `json:"files"`             // Number of files packed
+	Tokens     int            `json:"tokens"`            // Number of tokens in the packed files; 0 if tokens were not counted
+	Omitted    int            `json:"omitted,omitempty"` // Number of files left out or truncated to fit the token budget, present when any were
+	Chunks     int            `json:"chunks,omitempty"`  // Number of chunks the output was split into, present when it was split
+	Error      string         `json:"error,omitempty"`   // Why the repository could not be packed, present when it failed
+}
+
+// RepositoryIndexFile returns the path of the index describing the outputs written per repository.
+//
+// Parameters:
+//   - outputDir: The directory the outputs are written to.
+//   - name: The name of the run, e.g. the manifest name.
+//   - format: The output format, one of the config.Format constants.
+//
+// Returns:
+//   - string: The path of the index, e.g. review.index.txt.
+func RepositoryIndexFile(outputDir, name, format string) string {
+	return indexFile(filepath.Join(outputDir, name), format)
+}
+
+// WriteRepositoryIndex writes the index describing the outputs written per repository: which file
+// holds which repository, how many files and tokens were packed, and why repositories failed.
+// The index is a JSONRepositoryIndex document for the json and jsonl output formats and plain
+// text otherwise.
+//
+// Parameters:
+//   - path: The path of the index file.
+//   - format: The output format, one of the config.Format constants.
+//   - name: The name of the run.
+//   - outputs: The outputs of the repositories, in order.
+//
+// Returns:
+//   - error: An error if no outputs are given or the index cannot be written.
+func WriteRepositoryIndex(path, format, name string, outputs []RepositoryOutput) error {
+	if len(outputs) == 0 {
+		return errors.New("no repositories to index")
+	}
+
+	var tokenizer string
+	var total, packed int
+	for _, out := range outputs {
+		if out.Summary != nil {
+			tokenizer = out.Summary.Tokenizer
+			total += out.Summary.Tokens
+			packed++
+		}
+	}
+	relative := func(file string) string {
+		if rel, err := filepath.Rel(filepath.Dir(path), file); err == nil {
+			return filepath.ToSlash(rel)
+		}
+		return file
+	}
+
+	var index []byte
+	if format == config.FormatJSON || format == config.FormatJSONL {
+		doc := JSONRepositoryIndex{Name: name, Tokenizer: tokenizer, Tokens: total}
+		for _, out := range outputs {
+			record := JSONRepositoryOutput{Repository: newJSONRepository(out.Meta)}
+			if out.Err != nil {
+				record.Error = out.Err.Error()
+			}
+			if out.Summary != nil {
+				record.Output = relative(out.File)
+				record.Files, record.Tokens = len(out.Summary.Files), out.Summary.Tokens
+				record.Omitted, record.Chunks = len(out.Summary.Omitted), len(out.Summary.Chunks)
+			}
+			doc.Repositories = append(doc.Repositories, record)
+		}
+		data, err := marshalJSON(doc)
+		if err != nil {
+			return err
+		}
+		index = append(data, '\n')
+	} else {
+		var b strings.Builder
+		fmt.Fprintf(&b, "Index of %s: %d of %d repositories packed", name, packed, len(outputs))
+		if tokenizer != "" {
+			fmt.Fprintf(&b, ", %s (%s)", pluralize(total, "token"), tokenizer)
+		}
+		b.WriteString("\n")
+		for _, out := range outputs {
+			fmt.Fprintf(&b, "\n%s (%s", out.Meta.Name, out.Meta.Source)
+			if out.Meta.Commit != "" {
+				fmt.Fprintf(&b, " at %s", out.Meta.Commit)
+			}
+			b.WriteString("):\n")
+			if out.Summary == nil {
+				fmt.Fprintf(&b, "- Failed: %v\n", out.Err)
+				continue
+			}
+			fmt.Fprintf(&b, "- Output: %s\n- %s", relative(out.File), pluralize(len(out.Summary.Files), "file"))
+			if out.Summary.Tokenizer != "" {
+				fmt.Fprintf(&b, ", %s", pluralize(out.Summary.Tokens, "token"))
+			}
+			if len(out.Summary.Omitted) > 0 {
+				fmt.Fprintf(&b, ", %d left out or truncated to fit the token budget", len(out.Summary.Omitted))
+			}
+			if len(out.Summary.Chunks) > 0 {
+				fmt.Fprintf(&b, ", split into %s", pluralize(len(out.Summary.Chunks), "chunk"))
+			}
+			b.WriteString("\n")
+		}
+		index = []byte(b.String())
+	}
+
+	if err := os.WriteFile(path, index, 0644); err != nil {
+		return fmt.Errorf("unable to write repository index: %w", err)
+	}
+	return nil
+}
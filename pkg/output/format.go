@@ -0,0 +1,777 @@
+package output
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+)
+
+// minFenceLength is the shortest fence used for Markdown code blocks.
+const minFenceLength = 3
+
+// Markers delimiting CDATA sections in XML output.
+const (
+	cdataStart = "<![CDATA["
+	cdataEnd   = "]]>"
+)
+
+// JSONRepository describes the repository snapshot in JSON output.
+type JSONRepository struct {
+	Name       string          `json:"name"`
+	Source     string          `json:"source"`
+	Ref        string          `json:"ref,omitempty"`
+	Commit     string          `json:"commit,omitempty"`
+	Submodules []JSONSubmodule `json:"submodules,omitempty"` // Submodules checked out into the snapshot, present when any were
+	Subdirs    []string        `json:"subdirs,omitempty"`    // Directories the snapshot was limited to, present when it was
+}
+
+// JSONSubmodule describes a submodule checked out into the snapshot in JSON output.
+type JSONSubmodule struct {
+	Path   string `json:"path"`   // Slash-separated path relative to the repository root
+	URL    string `json:"url"`    // URL the submodule was cloned from
+	Commit string `json:"commit"` // SHA of the commit checked out
+}
+
+// newJSONRepositories returns the JSON descriptions of the repositories of a combined document;
+// nil for a single repository.
+func newJSONRepositories(meta Metadata) []JSONRepository {
+	var repos []JSONRepository
+	for _, repo := range meta.Repositories {
+		repos = append(repos, newJSONRepository(repo))
+	}
+	return repos
+}
+
+// newJSONRepository returns the JSON description of the repository snapshot.
+func newJSONRepository(meta Metadata) JSONRepository {
+	repo := JSONRepository{Name: meta.Name, Source: meta.Source, Ref: meta.Ref, Commit: meta.Commit, Subdirs: meta.Subdirs}
+	for _, sub := range meta.Submodules {
+		repo.Submodules = append(repo.Submodules, JSONSubmodule(sub))
+	}
+	return repo
+}
+
+// JSONFile describes a single packed file in JSON and JSON Lines output.
+type JSONFile struct {
+	Type       string `json:"type,omitempty"`       // JSONLFileType in JSON Lines output, where records of several types share a stream; empty in JSON documents
+	Repository string `json:"repository,omitempty"` // Name of the repository the file belongs to, present in combined documents
+	Path       string `json:"path"`                 // Slash-separated path relative to the repository root, prefixed with the repository name in combined documents
+	Size       int    `json:"size"`                 // Size of the content in bytes
+	SHA256     string `json:"sha256"`               // Hex-encoded SHA-256 digest of the content
+	Language   string `json:"language"`             // Detected language; empty if unknown
+	Tokens     int    `json:"tokens"`               // Number of tokens in the content; 0 if tokens were not counted
+	Truncated  bool   `json:"truncated,omitempty"`  // Whether the content was truncated to fit the token budget
+	Part       int    `json:"part,omitempty"`       // Number of the part, present when the file is split across chunks
+	Parts      int    `json:"parts,omitempty"`      // Number of parts, present when the file is split across chunks
+	Encoding   string `json:"encoding,omitempty"`   // JSONEncodingBase64 when the content is not valid UTF-8; empty for text
+	Content    string `json:"content"`              // File content, base64-encoded if Encoding says so
+}
+
+// JSONEncodingBase64 marks a JSONFile whose content is base64-encoded. Files that are not valid
+// UTF-8, such as Latin-1 text, are encoded so that their bytes survive JSON, which would replace
+// invalid sequences with U+FFFD.
+const JSONEncodingBase64 = "base64"
+
+// JSONOmittedFile describes a file left out, in whole or in part, to fit the token budget in JSON output.
+type JSONOmittedFile struct {
+	Path   string `json:"path"`   // Slash-separated path relative to the repository root
+	Tokens int    `json:"tokens"` // Number of tokens of the file left out of the output
+	Reason string `json:"reason"` // Why the file, or the rest of it, was left out
+}
+
+// JSONLOmitted is the record written after the file objects by the jsonl output format when files
+// were left out, in whole or in part, to fit the token budget. Every JSON Lines record has a type
+// field, JSONLFileType or JSONLOmittedType, telling the two apart.
+type JSONLOmitted struct {
+	Type      string            `json:"type"`       // Always JSONLOmittedType
+	MaxTokens int               `json:"max_tokens"` // Token budget the files were left out to fit
+	Files     []JSONOmittedFile `json:"files"`      // Files left out, in priority order
+}
+
+// Types of the records in JSON Lines output.
+const (
+	JSONLFileType    = "file"    // A JSONFile record
+	JSONLOmittedType = "omitted" // The JSONLOmitted record
+)
+
+// JSONDocument is the document written by the json output format.
+type JSONDocument struct {
+	Repository   JSONRepository    `json:"repository"`
+	Repositories []JSONRepository  `json:"repositories,omitempty"` // Repositories of a combined document, present when several were packed
+	Tokenizer    string            `json:"tokenizer,omitempty"`    // Tokenizer used to count tokens, if any
+	Tokens       int               `json:"tokens"`                 // Total number of tokens in the packed files
+	MaxTokens    int               `json:"max_tokens,omitempty"`   // Token budget, present when one was set
+	Tree         string            `json:"tree,omitempty"`         // Directory tree, present when requested
+	Files        []JSONFile        `json:"files"`
+	Omitted      []JSONOmittedFile `json:"omitted,omitempty"` // Files left out to fit the token budget, present when any were
+}
+
+// treeHeading introduces the directory tree in the text and Markdown output formats.
+const treeHeading = "Directory structure"
+
+// omittedHeading introduces the list of files left out to fit the token budget in the text and Markdown output formats.
+const omittedHeading = "Omitted files"
+
+// formatter writes the repository snapshot in one of the supported output formats.
+// Implementations receive the metadata and summary once, optionally the rendered directory
+// tree, then every packed file in order, optionally the files left out to fit the token budget,
+// and finally a call to end once all files have been written. In a combined document, the files
+// of each repository are preceded by a call to repository with its metadata.
+type formatter interface {
+	begin(meta Metadata, summary *Summary) error
+	tree(tree string) error
+	repository(meta Metadata) error
+	file(e entry) error
+	omitted(maxTokens int, files []OmittedFile) error
+	end() error
+}
+
+// newFormatter returns the formatter for the given output format.
+//
+// Parameters:
+//   - format: The output format, one of the config.Format constants. Empty selects plain text.
+//   - writer: The writer for the output file.
+//
+// Returns:
+//   - formatter: The formatter writing to the writer.
+//   - error: An error if the format is not supported.
+func newFormatter(format string, writer io.Writer) (formatter, error) {
+	switch format {
+	case config.FormatText, "":
+		return &textFormatter{writer: writer}, nil
+	case config.FormatMarkdown:
+		return &markdownFormatter{writer: writer}, nil
+	case config.FormatXML:
+		return &xmlFormatter{writer: writer}, nil
+	case config.FormatJSON:
+		return &jsonFormatter{writer: writer}, nil
+	case config.FormatJSONL:
+		return &jsonlFormatter{writer: writer}, nil
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// FileExtension returns the extension of the output file for the given output format.
+//
+// Parameters:
+//   - format: The output format, one of the config.Format constants.
+//
+// Returns:
+//   - string: The file extension including the leading dot.
+func FileExtension(format string) string {
+	switch format {
+	case config.FormatMarkdown:
+		return ".md"
+	case config.FormatXML:
+		return ".xml"
+	case config.FormatJSON:
+		return ".json"
+	case config.FormatJSONL:
+		return ".jsonl"
+	default:
+		return config.DefaultOutputExt
+	}
+}
+
+// textFormatter writes files separated by "=== path ===" lines.
+type textFormatter struct {
+	writer io.Writer
+}
+
+// begin writes the plain-text header, or the list of repositories of a combined document.
+func (f *textFormatter) begin(meta Metadata, summary *Summary) error {
+	if len(meta.Repositories) == 0 {
+		return WriteHeader(f.writer, meta)
+	}
+	if _, err := fmt.Fprintf(f.writer, "Repositories: %s\n\n", strings.Join(meta.repositoryNames(), ", ")); err != nil {
+		return fmt.Errorf("error writing header to output file: %w", err)
+	}
+	return nil
+}
+
+// tree writes the directory tree below a heading, followed by a blank line.
+func (f *textFormatter) tree(tree string) error {
+	if _, err := fmt.Fprintf(f.writer, "%s:\n%s\n", treeHeading, tree); err != nil {
+		return fmt.Errorf("error writing directory tree to output file: %w", err)
+	}
+	return nil
+}
+
+// repository writes the plain-text header of the repository, whether or not its commit is known.
+func (f *textFormatter) repository(meta Metadata) error {
+	if _, err := io.WriteString(f.writer, formatHeader(meta)); err != nil {
+		return fmt.Errorf("error writing header to output file: %w", err)
+	}
+	return nil
+}
+
+// file writes a separator with the file path followed by the raw content.
+func (f *textFormatter) file(e entry) error {
+	return writeFileContent(f.writer, e.label(), e.content)
+}
+
+// omitted lists the files left out below a heading.
+func (f *textFormatter) omitted(maxTokens int, files []OmittedFile) error {
+	var footer strings.Builder
+	fmt.Fprintf(&footer, "%s (token budget of %d):\n", omittedHeading, maxTokens)
+	for _, file := range files {
+		fmt.Fprintf(&footer, "- %s: %s (%s left out)\n", file.Path, file.Reason, pluralize(file.Tokens, "token"))
+	}
+	if _, err := io.WriteString(f.writer, footer.String()); err != nil {
+		return fmt.Errorf("error writing footer to output file: %w", err)
+	}
+	return nil
+}
+
+// end writes nothing; plain text has no trailer.
+func (f *textFormatter) end() error {
+	return nil
+}
+
+// markdownFormatter writes a heading per file followed by a fenced code block.
+type markdownFormatter struct {
+	writer io.Writer
+}
+
+// begin writes the repository name as a top-level heading followed by its metadata. A combined
+// document lists its repositories instead.
+func (f *markdownFormatter) begin(meta Metadata, summary *Summary) error {
+	if len(meta.Repositories) == 0 {
+		return f.repository(meta)
+	}
+	var header strings.Builder
+	if meta.Name != "" {
+		fmt.Fprintf(&header, "# %s\n\n", meta.Name)
+	}
+	for _, name := range meta.repositoryNames() {
+		fmt.Fprintf(&header, "- Repository: `%s`\n", name)
+	}
+	header.WriteString("\n")
+	if _, err := io.WriteString(f.writer, header.String()); err != nil {
+		return fmt.Errorf("error writing header to output file: %w", err)
+	}
+	return nil
+}
+
+// repository writes the repository name as a top-level heading followed by its metadata.
+func (f *markdownFormatter) repository(meta Metadata) error {
+	var header strings.Builder
+	if meta.Name != "" {
+		fmt.Fprintf(&header, "# %s\n\n", meta.Name)
+	}
+	if meta.Source != "" {
+		fmt.Fprintf(&header, "- Source: %s\n", meta.Source)
+	}
+	if meta.Ref != "" {
+		fmt.Fprintf(&header, "- Ref: %s\n", meta.Ref)
+	}
+	if meta.Commit != "" {
+		fmt.Fprintf(&header, "- Commit: %s\n", meta.Commit)
+	}
+	for _, sub := range meta.Submodules {
+		fmt.Fprintf(&header, "- Submodule: `%s` at %s (%s)\n", sub.Path, sub.Commit, sub.URL)
+	}
+	for _, dir := range meta.Subdirs {
+		fmt.Fprintf(&header, "- Subdir: `%s`\n", dir)
+	}
+	if meta.Source != "" || meta.Ref != "" || meta.Commit != "" {
+		header.WriteString("\n")
+	}
+	if _, err := io.WriteString(f.writer, header.String()); err != nil {
+		return fmt.Errorf("error writing header to output file: %w", err)
+	}
+	return nil
+}
+
+// tree writes the directory tree in a fenced code block below a heading.
+func (f *markdownFormatter) tree(tree string) error {
+	fence := strings.Repeat("`", max(minFenceLength, longestBacktickRun([]byte(tree))+1))
+	if _, err := fmt.Fprintf(f.writer, "## %s\n\n%stext\n%s%s\n\n", treeHeading, fence, tree, fence); err != nil {
+		return fmt.Errorf("error writing directory tree to output file: %w", err)
+	}
+	return nil
+}
+
+// file writes a heading with the file path and the content in a fenced code block tagged
+// with the detected language. The fence is longer than any backtick run in the content,
+// so embedded Markdown cannot close the block early.
+func (f *markdownFormatter) file(e entry) error {
+	fence := strings.Repeat("`", max(minFenceLength, longestBacktickRun(e.content)+1))
+
+	var block strings.Builder
+	fmt.Fprintf(&block, "## %s\n\n%s%s\n", e.label(), fence, detectLanguage(e.relPath))
+	block.Write(e.content)
+	if len(e.content) > 0 && e.content[len(e.content)-1] != '\n' {
+		block.WriteString("\n")
+	}
+	fmt.Fprintf(&block, "%s\n\n", fence)
+
+	if _, err := io.WriteString(f.writer, block.String()); err != nil {
+		return fmt.Errorf("error writing file content: %w", err)
+	}
+	return nil
+}
+
+// omitted lists the files left out below a heading.
+func (f *markdownFormatter) omitted(maxTokens int, files []OmittedFile) error {
+	var footer strings.Builder
+	fmt.Fprintf(&footer, "## %s\n\nFiles left out to fit the token budget of %d:\n\n", omittedHeading, maxTokens)
+	for _, file := range files {
+		fmt.Fprintf(&footer, "- `%s`: %s (%s left out)\n", file.Path, file.Reason, pluralize(file.Tokens, "token"))
+	}
+	if _, err := io.WriteString(f.writer, footer.String()); err != nil {
+		return fmt.Errorf("error writing footer to output file: %w", err)
+	}
+	return nil
+}
+
+// end writes nothing; Markdown has no trailer.
+func (f *markdownFormatter) end() error {
+	return nil
+}
+
+// longestBacktickRun returns the length of the longest run of consecutive backticks in the content.
+func longestBacktickRun(content []byte) int {
+	longest, current := 0, 0
+	for _, b := range content {
+		if b != '`' {
+			current = 0
+			continue
+		}
+		current++
+		longest = max(longest, current)
+	}
+	return longest
+}
+
+// xmlFormatter wraps each file in a <file> element inside a <repository> root element. A combined
+// document has a <repositories> root element holding a <repository> element per repository.
+type xmlFormatter struct {
+	writer      io.Writer
+	countTokens bool // Whether token counts are written, set by begin
+	combined    bool // Whether the document combines several repositories, set by begin
+	open        bool // Whether a <repository> element of a combined document is open
+}
+
+// begin writes the XML declaration and the opening root element with the repository metadata.
+func (f *xmlFormatter) begin(meta Metadata, summary *Summary) error {
+	f.countTokens = summary.Tokenizer != ""
+	f.combined = len(meta.Repositories) > 0
+
+	var header strings.Builder
+	header.WriteString(xml.Header)
+	if f.combined {
+		header.WriteString("<repositories")
+		writeXMLAttr(&header, "name", meta.Name)
+		writeXMLAttr(&header, "source", meta.Source)
+	} else {
+		writeXMLRepository(&header, meta)
+	}
+	if summary.Tokenizer != "" {
+		writeXMLAttr(&header, "tokenizer", summary.Tokenizer)
+		writeXMLAttr(&header, "tokens", strconv.Itoa(summary.Tokens))
+	}
+	if summary.MaxTokens > 0 {
+		writeXMLAttr(&header, "max-tokens", strconv.Itoa(summary.MaxTokens))
+	}
+	header.WriteString(">\n")
+	if !f.combined {
+		writeXMLSnapshot(&header, meta)
+	}
+	if _, err := io.WriteString(f.writer, header.String()); err != nil {
+		return fmt.Errorf("error writing header to output file: %w", err)
+	}
+	return nil
+}
+
+// repository closes the element of the previous repository of a combined document, if any, and
+// opens a <repository> element with the repository metadata.
+func (f *xmlFormatter) repository(meta Metadata) error {
+	var element strings.Builder
+	if f.open {
+		element.WriteString("</repository>\n")
+	}
+	writeXMLRepository(&element, meta)
+	element.WriteString(">\n")
+	writeXMLSnapshot(&element, meta)
+	f.open = true
+	if _, err := io.WriteString(f.writer, element.String()); err != nil {
+		return fmt.Errorf("error writing header to output file: %w", err)
+	}
+	return nil
+}
+
+// closeRepository closes the element of the last repository of a combined document, if one is open.
+func (f *xmlFormatter) closeRepository() error {
+	if !f.open {
+		return nil
+	}
+	f.open = false
+	if _, err := io.WriteString(f.writer, "</repository>\n"); err != nil {
+		return fmt.Errorf("error writing footer to output file: %w", err)
+	}
+	return nil
+}
+
+// writeXMLRepository writes the start of a <repository> element with the repository metadata as
+// attributes, leaving the start tag open for further attributes.
+func writeXMLRepository(b *strings.Builder, meta Metadata) {
+	b.WriteString("<repository")
+	writeXMLAttr(b, "name", meta.Name)
+	writeXMLAttr(b, "source", meta.Source)
+	writeXMLAttr(b, "ref", meta.Ref)
+	writeXMLAttr(b, "commit", meta.Commit)
+}
+
+// writeXMLSnapshot writes a <submodule> element per submodule checked out into the snapshot and a
+// <subdir> element per directory the snapshot was limited to.
+func writeXMLSnapshot(b *strings.Builder, meta Metadata) {
+	for _, sub := range meta.Submodules {
+		b.WriteString("<submodule")
+		writeXMLAttr(b, "path", sub.Path)
+		writeXMLAttr(b, "url", sub.URL)
+		writeXMLAttr(b, "commit", sub.Commit)
+		b.WriteString("/>\n")
+	}
+	for _, dir := range meta.Subdirs {
+		b.WriteString("<subdir")
+		writeXMLAttr(b, "path", dir)
+		b.WriteString("/>\n")
+	}
+}
+
+// tree writes the directory tree in a <tree> element.
+func (f *xmlFormatter) tree(tree string) error {
+	var element strings.Builder
+	element.WriteString("<tree>")
+	writeXMLText(&element, []byte(tree))
+	element.WriteString("</tree>\n")
+	if _, err := io.WriteString(f.writer, element.String()); err != nil {
+		return fmt.Errorf("error writing directory tree to output file: %w", err)
+	}
+	return nil
+}
+
+// file writes a <file> element with path, size, language, line-count and, when tokens are counted,
+// token-count attributes. Truncated files are marked with a truncated attribute, and parts of
+// files split across chunks with part and parts attributes.
+func (f *xmlFormatter) file(e entry) error {
+	var element strings.Builder
+	element.WriteString("<file")
+	writeXMLAttr(&element, "path", e.relPath)
+	writeXMLAttr(&element, "size", strconv.Itoa(len(e.content)))
+	writeXMLAttr(&element, "language", detectLanguage(e.relPath))
+	writeXMLAttr(&element, "lines", strconv.Itoa(countLines(e.content)))
+	if f.countTokens {
+		writeXMLAttr(&element, "tokens", strconv.Itoa(e.tokens))
+	}
+	if e.truncated {
+		writeXMLAttr(&element, "truncated", "true")
+	}
+	if e.parts > 0 {
+		writeXMLAttr(&element, "part", strconv.Itoa(e.part))
+		writeXMLAttr(&element, "parts", strconv.Itoa(e.parts))
+	}
+	element.WriteString(">")
+	writeXMLText(&element, e.content)
+	element.WriteString("</file>\n")
+
+	if _, err := io.WriteString(f.writer, element.String()); err != nil {
+		return fmt.Errorf("error writing file content: %w", err)
+	}
+	return nil
+}
+
+// omitted writes an <omitted> element with an empty <file> element for each file left out, after
+// closing the element of the last repository of a combined document.
+func (f *xmlFormatter) omitted(maxTokens int, files []OmittedFile) error {
+	if err := f.closeRepository(); err != nil {
+		return err
+	}
+	var element strings.Builder
+	element.WriteString("<omitted>\n")
+	for _, file := range files {
+		element.WriteString("<file")
+		writeXMLAttr(&element, "path", file.Path)
+		writeXMLAttr(&element, "tokens", strconv.Itoa(file.Tokens))
+		writeXMLAttr(&element, "reason", file.Reason)
+		element.WriteString("/>\n")
+	}
+	element.WriteString("</omitted>\n")
+	if _, err := io.WriteString(f.writer, element.String()); err != nil {
+		return fmt.Errorf("error writing footer to output file: %w", err)
+	}
+	return nil
+}
+
+// end closes the root element, and the element of the last repository of a combined document.
+func (f *xmlFormatter) end() error {
+	if err := f.closeRepository(); err != nil {
+		return err
+	}
+	root := "</repository>\n"
+	if f.combined {
+		root = "</repositories>\n"
+	}
+	if _, err := io.WriteString(f.writer, root); err != nil {
+		return fmt.Errorf("error writing footer to output file: %w", err)
+	}
+	return nil
+}
+
+// writeXMLAttr writes an escaped attribute to the builder. Empty values are omitted.
+func writeXMLAttr(b *strings.Builder, name, value string) {
+	if value == "" {
+		return
+	}
+	fmt.Fprintf(b, " %s=\"", name)
+	xml.EscapeText(b, []byte(value)) // Writing to a strings.Builder never fails
+	b.WriteString(`"`)
+}
+
+// writeXMLText writes the content to the builder wrapped in CDATA sections, or escaped when it
+// contains characters XML cannot represent.
+func writeXMLText(b *strings.Builder, content []byte) {
+	if !isValidXMLText(content) {
+		xml.EscapeText(b, content) // Writing to a strings.Builder never fails
+		return
+	}
+	// "]]>" cannot appear inside a CDATA section, so split the section around it.
+	b.WriteString(cdataStart)
+	b.WriteString(strings.ReplaceAll(string(content), cdataEnd, "]]"+cdataEnd+cdataStart+">"))
+	b.WriteString(cdataEnd)
+}
+
+// isValidXMLText reports whether the content consists only of characters allowed in XML 1.0 documents.
+func isValidXMLText(content []byte) bool {
+	for len(content) > 0 {
+		r, size := utf8.DecodeRune(content)
+		if r == utf8.RuneError && size == 1 {
+			return false
+		}
+		if !(r == 0x09 || r == 0x0A || r == 0x0D ||
+			r >= 0x20 && r <= 0xD7FF ||
+			r >= 0xE000 && r <= 0xFFFD ||
+			r >= 0x10000 && r <= 0x10FFFF) {
+			return false
+		}
+		content = content[size:]
+	}
+	return true
+}
+
+// countLines returns the number of lines in the content. A final line without a trailing newline is counted.
+func countLines(content []byte) int {
+	lines := bytes.Count(content, []byte("\n"))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
+
+// jsonFormatter writes a single JSONDocument. Each file is encoded and written to the files array
+// as it is packed, so only one file's record is held at a time; the document itself is never
+// assembled in memory. Only the omitted files are kept until end writes them.
+type jsonFormatter struct {
+	writer       io.Writer
+	count        int    // Number of files written so far
+	omittedFiles []byte // Encoded omitted files, written after the files array by end
+}
+
+// begin opens the document and writes the repository metadata and token totals. A combined
+// document also lists its repositories.
+func (f *jsonFormatter) begin(meta Metadata, summary *Summary) error {
+	repo, err := marshalJSON(newJSONRepository(meta))
+	if err != nil {
+		return err
+	}
+	if repos := newJSONRepositories(meta); repos != nil {
+		value, err := marshalJSON(repos)
+		if err != nil {
+			return err
+		}
+		repo = fmt.Appendf(repo, ",\n\"repositories\":%s", value)
+	}
+	tokenizer := ""
+	if summary.Tokenizer != "" {
+		value, err := marshalJSON(summary.Tokenizer)
+		if err != nil {
+			return err
+		}
+		tokenizer = fmt.Sprintf(",\n\"tokenizer\":%s", value)
+	}
+	maxTokens := ""
+	if summary.MaxTokens > 0 {
+		maxTokens = fmt.Sprintf(",\n\"max_tokens\":%d", summary.MaxTokens)
+	}
+	if _, err := fmt.Fprintf(f.writer, "{\"repository\":%s%s,\n\"tokens\":%d%s", repo, tokenizer, summary.Tokens, maxTokens); err != nil {
+		return fmt.Errorf("error writing header to output file: %w", err)
+	}
+	return nil
+}
+
+// tree writes the directory tree as a string field.
+func (f *jsonFormatter) tree(tree string) error {
+	value, err := marshalJSON(tree)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f.writer, ",\n\"tree\":%s", value); err != nil {
+		return fmt.Errorf("error writing directory tree to output file: %w", err)
+	}
+	return nil
+}
+
+// repository writes nothing; files name their repository, which begin describes.
+func (f *jsonFormatter) repository(meta Metadata) error {
+	return nil
+}
+
+// file writes the file as the next element of the files array, opening the array first if needed.
+func (f *jsonFormatter) file(e entry) error {
+	record, err := marshalJSON(newJSONFile(e))
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if f.count == 0 {
+		separator = ",\"files\":[\n"
+	}
+	f.count++
+	if _, err := fmt.Fprintf(f.writer, "%s%s", separator, record); err != nil {
+		return fmt.Errorf("error writing file content: %w", err)
+	}
+	return nil
+}
+
+// omitted encodes the files left out; they are written by end once the files array is closed.
+func (f *jsonFormatter) omitted(maxTokens int, files []OmittedFile) error {
+	records := make([]JSONOmittedFile, 0, len(files))
+	for _, file := range files {
+		records = append(records, JSONOmittedFile{Path: file.Path, Tokens: file.Tokens, Reason: file.Reason})
+	}
+	value, err := marshalJSON(records)
+	if err != nil {
+		return err
+	}
+	f.omittedFiles = value
+	return nil
+}
+
+// end closes the files array, writes the omitted files if there are any, and closes the document.
+func (f *jsonFormatter) end() error {
+	footer := "\n]"
+	if f.count == 0 {
+		footer = ",\"files\":[]"
+	}
+	if f.omittedFiles != nil {
+		footer += fmt.Sprintf(",\n\"omitted\":%s", f.omittedFiles)
+	}
+	footer += "}\n"
+	if _, err := io.WriteString(f.writer, footer); err != nil {
+		return fmt.Errorf("error writing footer to output file: %w", err)
+	}
+	return nil
+}
+
+// jsonlFormatter writes one JSONFile object per line, followed by a JSONLOmitted record if files
+// were left out to fit the token budget.
+type jsonlFormatter struct {
+	writer io.Writer
+}
+
+// begin writes nothing; JSON Lines output has no header.
+func (f *jsonlFormatter) begin(meta Metadata, summary *Summary) error {
+	return nil
+}
+
+// tree writes nothing; JSON Lines output omits the tree.
+func (f *jsonlFormatter) tree(tree string) error {
+	return nil
+}
+
+// repository writes nothing; the file objects name their repository.
+func (f *jsonlFormatter) repository(meta Metadata) error {
+	return nil
+}
+
+// file writes the file as a single line of type JSONLFileType.
+func (f *jsonlFormatter) file(e entry) error {
+	file := newJSONFile(e)
+	file.Type = JSONLFileType
+	record, err := marshalJSON(file)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f.writer, "%s\n", record); err != nil {
+		return fmt.Errorf("error writing file content: %w", err)
+	}
+	return nil
+}
+
+// omitted writes the files left out as a single JSONLOmitted record after the file objects.
+func (f *jsonlFormatter) omitted(maxTokens int, files []OmittedFile) error {
+	record := JSONLOmitted{Type: JSONLOmittedType, MaxTokens: maxTokens, Files: make([]JSONOmittedFile, 0, len(files))}
+	for _, file := range files {
+		record.Files = append(record.Files, JSONOmittedFile(file))
+	}
+	value, err := marshalJSON(record)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f.writer, "%s\n", value); err != nil {
+		return fmt.Errorf("error writing omitted files to output file: %w", err)
+	}
+	return nil
+}
+
+// end writes nothing; JSON Lines has no trailer.
+func (f *jsonlFormatter) end() error {
+	return nil
+}
+
+// newJSONFile builds the JSON record for a packed file. Content that is not valid UTF-8 is
+// base64-encoded, so that it matches the size and digest of the record.
+func newJSONFile(e entry) JSONFile {
+	sum := sha256.Sum256(e.content)
+	encoding, content := "", string(e.content)
+	if !utf8.Valid(e.content) {
+		encoding, content = JSONEncodingBase64, base64.StdEncoding.EncodeToString(e.content)
+	}
+	return JSONFile{
+		Repository: e.repo,
+		Path:       e.relPath,
+		Size:       len(e.content),
+		SHA256:     hex.EncodeToString(sum[:]),
+		Language:   detectLanguage(e.relPath),
+		Tokens:     e.tokens,
+		Truncated:  e.truncated,
+		Part:       e.part,
+		Parts:      e.parts,
+		Encoding:   encoding,
+		Content:    content,
+	}
+}
+
+// marshalJSON encodes the value as compact JSON without escaping HTML characters,
+// which keeps source code readable in the output.
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, fmt.Errorf("error encoding JSON: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,120 @@
+package output
+
+import (
+	"path"
+	"strings"
+)
+
+// languagesByName maps well-known file names without a meaningful extension to their language.
+var languagesByName = map[string]string{
+	"dockerfile":     "dockerfile",
+	"makefile":       "makefile",
+	"gnumakefile":    "makefile",
+	"cmakelists.txt": "cmake",
+	"gemfile":        "ruby",
+	"rakefile":       "ruby",
+	"jenkinsfile":    "groovy",
+	"vagrantfile":    "ruby",
+}
+
+// languagesByExt maps lower-case file extensions to the language names used by common
+// Markdown renderers for syntax highlighting.
+var languagesByExt = map[string]string{
+	".bash":       "bash",
+	".c":          "c",
+	".cc":         "cpp",
+	".cfg":        "ini",
+	".clj":        "clojure",
+	".cmake":      "cmake",
+	".conf":       "ini",
+	".cpp":        "cpp",
+	".cs":         "csharp",
+	".css":        "css",
+	".csv":        "csv",
+	".cxx":        "cpp",
+	".dart":       "dart",
+	".diff":       "diff",
+	".dockerfile": "dockerfile",
+	".el":         "elisp",
+	".erl":        "erlang",
+	".ex":         "elixir",
+	".exs":        "elixir",
+	".fish":       "fish",
+	".fs":         "fsharp",
+	".go":         "go",
+	".gradle":     "groovy",
+	".graphql":    "graphql",
+	".groovy":     "groovy",
+	".h":          "c",
+	".hcl":        "hcl",
+	".hh":         "cpp",
+	".hpp":        "cpp",
+	".hs":         "haskell",
+	".htm":        "html",
+	".html":       "html",
+	".ini":        "ini",
+	".ipynb":      "json",
+	".java":       "java",
+	".jl":         "julia",
+	".js":         "javascript",
+	".json":       "json",
+	".jsx":        "jsx",
+	".kt":         "kotlin",
+	".kts":        "kotlin",
+	".less":       "less",
+	".lua":        "lua",
+	".m":          "objectivec",
+	".md":         "markdown",
+	".mjs":        "javascript",
+	".cjs":        "javascript",
+	".mk":         "makefile",
+	".ml":         "ocaml",
+	".nix":        "nix",
+	".patch":      "diff",
+	".php":        "php",
+	".pl":         "perl",
+	".proto":      "protobuf",
+	".ps1":        "powershell",
+	".py":         "python",
+	".r":          "r",
+	".rb":         "ruby",
+	".rs":         "rust",
+	".rst":        "rst",
+	".sass":       "sass",
+	".scala":      "scala",
+	".scss":       "scss",
+	".sh":         "bash",
+	".sql":        "sql",
+	".svelte":     "svelte",
+	".swift":      "swift",
+	".tex":        "latex",
+	".tf":         "hcl",
+	".toml":       "toml",
+	".ts":         "typescript",
+	".tsx":        "tsx",
+	".txt":        "text",
+	".vue":        "vue",
+	".xml":        "xml",
+	".yaml":       "yaml",
+	".yml":        "yaml",
+	".zig":        "zig",
+	".zsh":        "zsh",
+}
+
+// detectLanguage returns the language of a file based on its name or extension.
+//
+// Parameters:
+//   - relPath: The slash-separated path of the file relative to the repository root.
+//
+// Returns:
+//   - string: The language name, or an empty string if it is unknown.
+func detectLanguage(relPath string) string {
+	name := strings.ToLower(path.Base(relPath))
+	if lang, ok := languagesByName[name]; ok {
+		return lang
+	}
+	if strings.HasPrefix(name, "dockerfile.") {
+		return languagesByName["dockerfile"]
+	}
+	return languagesByExt[path.Ext(name)]
+}
@@ -0,0 +1,212 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+)
+
+// Reasons recorded for Git LFS pointer files that are not packed.
+const (
+	ReasonLFSMissing = "Git LFS pointer; the object is not in the local LFS store"
+	ReasonLFSPointer = "Git LFS pointer"
+)
+
+// maxLFSPointerSize is the size of the largest file read as a Git LFS pointer, as in git-lfs itself.
+const maxLFSPointerSize = 1024
+
+// lfsVersions lists the version lines that start a Git LFS pointer file.
+var lfsVersions = []string{
+	"version https://git-lfs.github.com/spec/v1",
+	"version https://hawser.github.com/spec/v1",
+}
+
+// lfsPointer is a parsed Git LFS pointer file.
+type lfsPointer struct {
+	oid  string // Hex-encoded SHA-256 digest of the object
+	size int64  // Size of the object in bytes
+}
+
+// parseLFSPointer parses the content of a Git LFS pointer file.
+//
+// Parameters:
+//   - content: The content of the file.
+//
+// Returns:
+//   - lfsPointer: The parsed pointer.
+//   - bool: Whether the content is a valid pointer.
+func parseLFSPointer(content []byte) (lfsPointer, bool) {
+	var p lfsPointer
+	if len(content) > maxLFSPointerSize {
+		return p, false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	if !scanner.Scan() {
+		return p, false
+	}
+	version := scanner.Text()
+	if version != lfsVersions[0] && version != lfsVersions[1] {
+		return p, false
+	}
+	size := int64(-1)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			return p, false
+		}
+		switch key {
+		case "oid":
+			oid, ok := strings.CutPrefix(value, "sha256:")
+			if !ok || len(oid) != 64 || strings.Trim(oid, "0123456789abcdef") != "" {
+				return p, false
+			}
+			p.oid = oid
+		case "size":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return p, false
+			}
+			size = n
+		}
+	}
+	if p.oid == "" || size < 0 {
+		return p, false
+	}
+	p.size = size
+	return p, true
+}
+
+// lfsResolver replaces Git LFS pointer files with their objects, or marks them, according to
+// the configured LFS mode. Objects are looked up in the LFS store of the innermost repository
+// containing the file, so submodules use their own stores.
+type lfsResolver struct {
+	mode   string            // One of the config.LFS constants
+	stores map[string]string // LFS object directory by file system directory; empty if there is none
+}
+
+// newLFSResolver returns the resolver for the LFS mode of the configuration.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct containing the LFS mode.
+//
+// Returns:
+//   - *lfsResolver: The resolver; an empty mode selects config.LFSResolve.
+func newLFSResolver(cfg *config.Config) *lfsResolver {
+	mode := cfg.LFS
+	if mode == "" {
+		mode = config.LFSResolve
+	}
+	return &lfsResolver{mode: mode, stores: make(map[string]string)}
+}
+
+// apply checks whether a packed entry is a Git LFS pointer and, if so, packs the object from
+// the local LFS store instead or records why the file is left out. Like other files, objects
+// too large to be pointers are read again when they are written.
+//
+// Parameters:
+//   - e: The entry read from the file.
+//   - path: The file system path of the file; empty if it is not on disk, which leaves the object missing.
+func (r *lfsResolver) apply(e *entry, path string) {
+	if r == nil || r.mode == config.LFSPointer || e.reason != "" {
+		return
+	}
+	pointer, ok := parseLFSPointer(e.content)
+	if !ok {
+		return
+	}
+
+	e.content, e.load, e.size, e.lines, e.lfs = nil, nil, pointer.size, 0, true
+	e.reason = ReasonLFSPointer
+	if r.mode != config.LFSResolve {
+		return
+	}
+	e.reason = ReasonLFSMissing
+	if path == "" {
+		return
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	store := r.store(dir)
+	if store == "" {
+		return
+	}
+	object := filepath.Join(store, pointer.oid[0:2], pointer.oid[2:4], pointer.oid)
+	if _, err := os.Stat(object); err != nil {
+		return
+	}
+
+	e.lfs = false
+	file, err := os.Open(object)
+	if err != nil {
+		e.reason = err.Error()
+		return
+	}
+	defer file.Close()
+	content, size, lines, err := probeContent(file)
+	if err != nil {
+		e.reason = err.Error()
+		e.binary = errors.Is(err, errBinaryFile)
+		return
+	}
+	e.content, e.size, e.lines, e.reason = content, size, lines, ""
+	if content == nil {
+		e.load = func() ([]byte, error) { return readFileContent(object) }
+	}
+}
+
+// store returns the LFS object directory of the innermost repository containing dir, found by
+// looking for a .git directory or file in dir and its parents.
+//
+// Parameters:
+//   - dir: The directory of the file.
+//
+// Returns:
+//   - string: The LFS object directory; empty if dir is not inside a repository.
+func (r *lfsResolver) store(dir string) string {
+	if store, ok := r.stores[dir]; ok {
+		return store
+	}
+
+	var store string
+	dotGit := filepath.Join(dir, ".git")
+	if info, err := os.Stat(dotGit); err == nil {
+		gitDir := dotGit
+		if !info.IsDir() {
+			// Submodules and linked worktrees point at their git directory with a gitdir: line
+			gitDir = ""
+			if data, err := os.ReadFile(dotGit); err == nil {
+				if target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: "); ok {
+					gitDir = target
+					if !filepath.IsAbs(gitDir) {
+						gitDir = filepath.Join(dir, gitDir)
+					}
+				}
+			}
+		}
+		// Linked worktrees share the LFS store of the main repository
+		if data, err := os.ReadFile(filepath.Join(gitDir, "commondir")); gitDir != "" && err == nil {
+			common := strings.TrimSpace(string(data))
+			if !filepath.IsAbs(common) {
+				common = filepath.Join(gitDir, common)
+			}
+			gitDir = common
+		}
+		if gitDir != "" {
+			store = filepath.Join(gitDir, "lfs", "objects")
+		}
+	} else if parent := filepath.Dir(dir); parent != dir {
+		store = r.store(parent)
+	}
+
+	r.stores[dir] = store
+	return store
+}
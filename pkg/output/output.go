@@ -9,143 +9,1080 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/filter"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/tokens"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/util"
 )
 
+// errBinaryFile is returned when a file is skipped because it looks like binary data.
+var errBinaryFile = errors.New("binary file")
+
+// binarySampleSize is the number of leading bytes checked to tell binary files apart.
+const binarySampleSize = 512
+
+// Metadata describes the repository snapshot that is being written to the output.
+type Metadata struct {
+	Name       string            // Name of the repository
+	Source     string            // Repository URL or local path the contents were read from
+	Ref        string            // Branch, tag or commit SHA requested by the user, if any
+	Commit     string            // SHA of the commit the contents were read from, if known
+	Submodules []clone.Submodule // Submodules checked out into the snapshot, if any
+	Subdirs    []string          // Directories the snapshot was limited to, relative to the repository root, if any
+
+	Repositories []Metadata // Repositories of a combined document, in output order; empty for a single repository
+}
+
+// repository returns the metadata of the named repository of a combined document.
+func (m Metadata) repository(name string) Metadata {
+	for _, repo := range m.Repositories {
+		if repo.Name == name {
+			return repo
+		}
+	}
+	return Metadata{Name: name}
+}
+
+// repositoryNames returns the names of the repositories of a combined document.
+func (m Metadata) repositoryNames() []string {
+	names := make([]string, 0, len(m.Repositories))
+	for _, repo := range m.Repositories {
+		names = append(names, repo.Name)
+	}
+	return names
+}
+
+// WriteHeader writes a short header describing the repository snapshot to the writer.
+// Nothing is written when the commit is unknown, e.g. for local directories.
+//
+// Parameters:
+//   - writer: The writer for the output file.
+//   - meta: The repository metadata to record.
+//
+// Returns:
+//   - error: An error if writing to the output fails.
+func WriteHeader(writer io.Writer, meta Metadata) error {
+	if meta.Commit == "" {
+		return nil
+	}
+	if _, err := io.WriteString(writer, formatHeader(meta)); err != nil {
+		return fmt.Errorf("error writing header to output file: %w", err)
+	}
+	return nil
+}
+
+// formatHeader renders the plain-text header describing a repository snapshot, followed by a blank line.
+func formatHeader(meta Metadata) string {
+	var header strings.Builder
+	fmt.Fprintf(&header, "Repository: %s\n", meta.Name)
+	fmt.Fprintf(&header, "Source: %s\n", meta.Source)
+	if meta.Ref != "" {
+		fmt.Fprintf(&header, "Ref: %s\n", meta.Ref)
+	}
+	if meta.Commit != "" {
+		fmt.Fprintf(&header, "Commit: %s\n", meta.Commit)
+	}
+	for _, sub := range meta.Submodules {
+		fmt.Fprintf(&header, "Submodule: %s at %s (%s)\n", sub.Path, sub.Commit, sub.URL)
+	}
+	for _, dir := range meta.Subdirs {
+		fmt.Fprintf(&header, "Subdir: %s\n", dir)
+	}
+	header.WriteString("\n")
+	return header.String()
+}
+
 // FindFiles searches for the specified file names within the repository directory.
+// Files and directories excluded by the include and exclude patterns in the configuration
+// or by .repototxtignore files are not searched.
 //
 // Parameters:
 //   - repoPath: The local path of the cloned repository.
 //   - fileNames: A slice of exact file names to search for.
+//   - cfg: A pointer to the Config struct containing the include and exclude patterns.
 //
 // Returns:
 //   - map[string][]string: A map where the key is the file name and the value is a slice of matching file paths.
 //   - error: An error if the search fails.
-func FindFiles(repoPath string, fileNames []string) (map[string][]string, error) {
+func FindFiles(repoPath string, fileNames []string, cfg *config.Config) (map[string][]string, error) {
+	snap := DirSnapshot(repoPath)
+	fileMatches, err := FindSnapshotFiles(snap, fileNames, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for name, paths := range fileMatches {
+		for i, relPath := range paths {
+			paths[i] = snap.diskPath(relPath)
+		}
+		fileMatches[name] = paths
+	}
+	return fileMatches, nil
+}
+
+// FindSnapshotFiles searches for the specified file names within a repository snapshot, in the
+// same way as FindFiles.
+//
+// Parameters:
+//   - snap: The repository snapshot.
+//   - fileNames: A slice of exact file names to search for.
+//   - cfg: A pointer to the Config struct containing the include and exclude patterns.
+//
+// Returns:
+//   - map[string][]string: A map where the key is the file name and the value is a slice of
+//     matching slash-separated paths relative to the repository root.
+//   - error: An error if the search fails.
+func FindSnapshotFiles(snap Snapshot, fileNames []string, cfg *config.Config) (map[string][]string, error) {
 	if len(fileNames) == 0 {
 		return nil, errors.New("no file names provided to search for")
 	}
 
+	patterns, err := newPatterns(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ignores, err := newIgnoreRules(snap, cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	fileMatches := make(map[string][]string)
 
-	err := filepath.Walk(repoPath, func(path string, info os.FileInfo, err error) error {
+	roots, err := walkRoots(snap, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var root string
+	walk := func(relPath string, d fs.DirEntry, err error) error {
 		if err != nil {
-			// Skip paths that can't be accessed
-			log.Printf("Error accessing path %s: %v", path, err)
+			return nil // Skip paths that can't be accessed
+		}
+
+		if d.IsDir() {
+			if relPath != root && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir // Skip hidden directories such as .git
+			}
+			if relPath != root && ignores.Ignored(relPath, true) {
+				return fs.SkipDir // Skip directories ignored by .repototxtignore
+			}
+			if relPath != root && patterns.SkipDir(relPath) {
+				return fs.SkipDir // Skip directories excluded by patterns
+			}
 			return nil
 		}
 
-		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
-			return nil // Skip directories and hidden files
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil // Skip hidden files
+		}
+
+		if ignores.Ignored(relPath, false) {
+			return nil // Skip files ignored by .repototxtignore
+		}
+
+		if _, excluded := patterns.Exclude(relPath); excluded {
+			return nil // Skip files excluded by patterns
 		}
 
 		for _, fileName := range fileNames {
-			if strings.EqualFold(info.Name(), fileName) {
-				fileMatches[fileName] = append(fileMatches[fileName], path)
+			if strings.EqualFold(d.Name(), fileName) {
+				fileMatches[fileName] = append(fileMatches[fileName], relPath)
 			}
 		}
 
 		return nil
+	}
+
+	for _, root = range roots {
+		if err := fs.WalkDir(snap.FS, root, walk); err != nil {
+			return nil, fmt.Errorf("error walking the path %s: %w", root, err)
+		}
+	}
+
+	return fileMatches, nil
+}
+
+// entry describes a file, or a directory skipped as a whole, found while walking the repository.
+type entry struct {
+	relPath   string                 // Slash-separated path relative to the repository root, or to the document root in a combined document
+	repo      string                 // Name of the repository the file belongs to in a combined document; empty otherwise
+	isDir     bool                   // Whether the entry is a directory that was excluded as a whole
+	size      int64                  // Size of the file in bytes
+	lines     int                    // Number of lines in the content of a packed file
+	modTime   time.Time              // Time the file was last changed
+	content   []byte                 // Content of the file when it is held in memory; nil unless the file is packed
+	load      func() ([]byte, error) // Reads the content of a packed file that is not held; nil if content holds it
+	reason    string                 // Reason the entry is not packed; empty if it is packed
+	binary    bool                   // Whether the file was left out because it is binary
+	lfs       bool                   // Whether the file was left out because it is a Git LFS pointer
+	tokens    int                    // Number of tokens in the content; 0 if tokens are not counted
+	truncated bool                   // Whether the content was truncated to fit the token budget
+	omitted   bool                   // Whether the file was left out to fit the token budget
+	part      int                    // 1-based number of this part when the file is split across chunks; 0 if whole
+	parts     int                    // Number of parts the file is split into; 0 if whole
+}
+
+// Summary describes the files written to the output.
+type Summary struct {
+	Tokenizer string        // Name of the tokenizer used to count tokens; empty if tokens were not counted
+	Tokens    int           // Total number of tokens in the packed files
+	MaxTokens int           // Token budget the files were fitted into; 0 if there was no budget
+	Files     []FileSummary // Packed files in output order
+	Omitted   []OmittedFile // Files left out or truncated to fit the token budget, in priority order
+	Skipped   []SkippedFile // Files and directories left out of the output, with the reason, in walk order
+	Chunks    []Chunk       // Chunk files the output was split into; empty if it was written to a single file
+	Index     string        // Path of the index file describing the chunks; empty if the output was not split
+}
+
+// FileSummary describes a single file written to the output.
+type FileSummary struct {
+	Path   string // Slash-separated path relative to the repository root
+	Size   int64  // Size of the file in bytes
+	Tokens int    // Number of tokens in the file; 0 if tokens were not counted
+}
+
+// SkippedFile describes a file, or a directory as a whole, left out of the output.
+type SkippedFile struct {
+	Path   string // Slash-separated path relative to the repository root
+	Dir    bool   // Whether the path is a directory that was left out as a whole
+	Reason string // Why the file or directory was left out
+}
+
+// Largest returns up to n packed files with the most tokens, largest first.
+// Files with the same number of tokens keep their output order.
+//
+// Parameters:
+//   - n: The maximum number of files to return.
+//
+// Returns:
+//   - []FileSummary: The largest files.
+func (s *Summary) Largest(n int) []FileSummary {
+	files := append([]FileSummary(nil), s.Files...)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Tokens > files[j].Tokens
 	})
+	if n < len(files) {
+		files = files[:n]
+	}
+	return files
+}
+
+// packed reports whether the entry is a file whose content is written to the output.
+func (e entry) packed() bool {
+	return !e.isDir && e.reason == ""
+}
 
+// read returns the content of a packed entry, reading it with load when it is not held in memory.
+//
+// Returns:
+//   - []byte: The content of the file.
+//   - error: An error if the file can no longer be read or has become binary.
+func (e entry) read() ([]byte, error) {
+	if e.content != nil || e.load == nil {
+		return e.content, nil
+	}
+	content, err := e.load()
 	if err != nil {
-		return nil, fmt.Errorf("error walking the path %s: %w", repoPath, err)
+		return nil, fmt.Errorf("error reading %s: %w", e.relPath, err)
 	}
+	return content, nil
+}
 
-	return fileMatches, nil
+// repoPath returns the path of the entry relative to the root of its repository, which in a
+// combined document is below a directory named after the repository.
+func (e entry) repoPath() string {
+	if e.repo == "" {
+		return e.relPath
+	}
+	return strings.TrimPrefix(e.relPath, e.repo+"/")
+}
+
+// label returns the path of the entry, followed by the part number when the file is split across chunks.
+func (e entry) label() string {
+	if e.parts == 0 {
+		return e.relPath
+	}
+	return fmt.Sprintf("%s (part %d of %d)", e.relPath, e.part, e.parts)
 }
 
 // WriteRepoContentsToFile writes the contents of the specified repository directory to an output file.
 // It traverses the repository, applies exclusion rules, and formats the output with file separators.
+// When the configuration requests it, a directory tree of the repository is written before the contents.
+// When the configuration sets a chunk size, numbered part files and an index are written next to
+// the output file instead.
 //
 // Parameters:
 //   - repoPath: The local path of the cloned repository.
 //   - outputFile: The path to the output text file.
+//   - meta: Metadata describing the repository snapshot, written as a header.
 //   - cfg: A pointer to the Config struct containing exclusion and inclusion rules.
 //
 // Returns:
+//   - *Summary: The files written and their token counts.
 //   - error: An error if writing to the file fails.
-func WriteRepoContentsToFile(repoPath, outputFile string, cfg *config.Config) error {
-	file, err := os.Create(outputFile)
+func WriteRepoContentsToFile(repoPath, outputFile string, meta Metadata, cfg *config.Config) (*Summary, error) {
+	return WriteSnapshotToFile(DirSnapshot(repoPath), outputFile, meta, cfg)
+}
+
+// WriteRepoContents writes the contents of the specified repository directory to the writer, in
+// the same way as WriteRepoContentsToFile. The output is buffered and flushed before returning,
+// so the writer may be unbuffered. Chunked output needs files and is not supported.
+//
+// Parameters:
+//   - writer: The writer for the output, e.g. os.Stdout, a buffer or an HTTP response.
+//   - repoPath: The local path of the repository.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - cfg: A pointer to the Config struct containing exclusion and inclusion rules.
+//
+// Returns:
+//   - *Summary: The files written and their token counts.
+//   - error: An error if the configuration sets a chunk size or writing fails.
+func WriteRepoContents(writer io.Writer, repoPath string, meta Metadata, cfg *config.Config) (*Summary, error) {
+	return WriteSnapshot(writer, DirSnapshot(repoPath), meta, cfg)
+}
+
+// WriteSelectedFiles writes the contents of the given files to an output file, using the
+// output format selected in the configuration. Files that cannot be read or are binary are skipped.
+//
+// Parameters:
+//   - repoPath: The local path of the repository the files belong to.
+//   - outputFile: The path to the output file.
+//   - paths: The file system paths of the files to write, in order.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - cfg: A pointer to the Config struct containing the output format.
+//
+// Returns:
+//   - *Summary: The files written and their token counts.
+//   - error: An error if writing to the file fails.
+func WriteSelectedFiles(repoPath, outputFile string, paths []string, meta Metadata, cfg *config.Config) (*Summary, error) {
+	return WriteSnapshotFilesToFile(DirSnapshot(repoPath), outputFile, relPaths(repoPath, paths), meta, cfg)
+}
+
+// WriteFiles writes the contents of the given files to the writer, in the same way as
+// WriteSelectedFiles. The output is buffered and flushed before returning. Chunked output
+// needs files and is not supported.
+//
+// Parameters:
+//   - writer: The writer for the output.
+//   - repoPath: The local path of the repository the files belong to.
+//   - paths: The file system paths of the files to write, in order.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - cfg: A pointer to the Config struct containing the output format.
+//
+// Returns:
+//   - *Summary: The files written and their token counts.
+//   - error: An error if the configuration sets a chunk size or writing fails.
+func WriteFiles(writer io.Writer, repoPath string, paths []string, meta Metadata, cfg *config.Config) (*Summary, error) {
+	return WriteSnapshotFiles(writer, DirSnapshot(repoPath), relPaths(repoPath, paths), meta, cfg)
+}
+
+// WriteSnapshotToFile writes the contents of a repository snapshot to an output file, in the same
+// way as WriteRepoContentsToFile.
+//
+// Parameters:
+//   - snap: The repository snapshot.
+//   - outputFile: The path to the output file.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - cfg: A pointer to the Config struct containing exclusion and inclusion rules.
+//
+// Returns:
+//   - *Summary: The files written and their token counts.
+//   - error: An error if the repository cannot be walked or writing to the file fails.
+func WriteSnapshotToFile(snap Snapshot, outputFile string, meta Metadata, cfg *config.Config) (*Summary, error) {
+	// The output file may live inside the walked directory (e.g., when packing "./"),
+	// so remember its location to avoid packing it into itself.
+	absOutputFile, err := filepath.Abs(outputFile)
 	if err != nil {
-		return fmt.Errorf("unable to create output file: %w", err)
+		return nil, fmt.Errorf("unable to resolve output file path: %w", err)
 	}
-	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
+	entries, err := collectRepoEntries(snap, absOutputFile, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return writeEntries(outputFile, entries, meta, cfg)
+}
+
+// WriteSnapshot writes the contents of a repository snapshot to the writer, in the same way as
+// WriteRepoContents. Chunked output needs files and is not supported.
+//
+// Parameters:
+//   - writer: The writer for the output.
+//   - snap: The repository snapshot.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - cfg: A pointer to the Config struct containing exclusion and inclusion rules.
+//
+// Returns:
+//   - *Summary: The files written and their token counts.
+//   - error: An error if the configuration sets a chunk size, the repository cannot be walked or writing fails.
+func WriteSnapshot(writer io.Writer, snap Snapshot, meta Metadata, cfg *config.Config) (*Summary, error) {
+	entries, err := collectRepoEntries(snap, "", cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return writeEntriesTo(writer, entries, meta, cfg)
+}
+
+// WriteSnapshotFilesToFile writes the given files of a repository snapshot to an output file, in
+// the same way as WriteSelectedFiles.
+//
+// Parameters:
+//   - snap: The repository snapshot the files belong to.
+//   - outputFile: The path to the output file.
+//   - paths: The slash-separated paths of the files to write relative to the repository root, in order.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - cfg: A pointer to the Config struct containing the output format.
+//
+// Returns:
+//   - *Summary: The files written and their token counts.
+//   - error: An error if writing to the file fails.
+func WriteSnapshotFilesToFile(snap Snapshot, outputFile string, paths []string, meta Metadata, cfg *config.Config) (*Summary, error) {
+	return writeEntries(outputFile, readEntries(snap, paths, cfg), meta, cfg)
+}
 
-	err = filepath.Walk(repoPath, func(path string, info os.FileInfo, err error) error {
+// WriteSnapshotFiles writes the given files of a repository snapshot to the writer, in the same
+// way as WriteFiles. Chunked output needs files and is not supported.
+//
+// Parameters:
+//   - writer: The writer for the output.
+//   - snap: The repository snapshot the files belong to.
+//   - paths: The slash-separated paths of the files to write relative to the repository root, in order.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - cfg: A pointer to the Config struct containing the output format.
+//
+// Returns:
+//   - *Summary: The files written and their token counts.
+//   - error: An error if the configuration sets a chunk size or writing fails.
+func WriteSnapshotFiles(writer io.Writer, snap Snapshot, paths []string, meta Metadata, cfg *config.Config) (*Summary, error) {
+	return writeEntriesTo(writer, readEntries(snap, paths, cfg), meta, cfg)
+}
+
+// relPaths converts file system paths inside a repository to slash-separated paths relative to
+// its root.
+//
+// Parameters:
+//   - repoPath: The local path of the repository the files belong to.
+//   - paths: The file system paths of the files.
+//
+// Returns:
+//   - []string: The relative paths, in the same order.
+func relPaths(repoPath string, paths []string) []string {
+	rel := make([]string, 0, len(paths))
+	for _, path := range paths {
+		relPath, err := filepath.Rel(repoPath, path)
 		if err != nil {
-			return fmt.Errorf("error accessing path %s: %w", path, err)
+			relPath = filepath.Base(path) // fallback to base name
 		}
+		rel = append(rel, filepath.ToSlash(relPath))
+	}
+	return rel
+}
+
+// readEntries reads the given files of a repository snapshot.
+//
+// Parameters:
+//   - snap: The repository snapshot the files belong to.
+//   - paths: The slash-separated paths of the files to read, in order.
+//   - cfg: A pointer to the Config struct containing the Git LFS mode.
+//
+// Returns:
+//   - []entry: The entries describing the files.
+func readEntries(snap Snapshot, paths []string, cfg *config.Config) []entry {
+	lfs := newLFSResolver(cfg)
+	entries := make([]entry, 0, len(paths))
+	for _, relPath := range paths {
+		entries = append(entries, readEntry(snap, relPath, lfs))
+	}
+	return entries
+}
+
+// collectRepoEntries walks the repository with collectEntries and, when files compete for the
+// token budget by recency, dates them by the last commit that changed them.
+//
+// Parameters:
+//   - snap: The repository snapshot.
+//   - absOutputFile: The absolute path of the output file, which is never packed; empty if there is none.
+//   - cfg: A pointer to the Config struct containing exclusion, inclusion and budget rules.
+//
+// Returns:
+//   - []entry: The entries in walk order.
+//   - error: An error if the rules cannot be loaded or the repository cannot be walked.
+func collectRepoEntries(snap Snapshot, absOutputFile string, cfg *config.Config) ([]entry, error) {
+	entries, err := collectEntries(snap, absOutputFile, cfg)
+	if err != nil {
+		return nil, err
+	}
 
-		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
-			return nil // Skip directories and hidden files
+	// Modification times are meaningless in a fresh clone, so prefer the history when there is one.
+	if cfg.MaxTokens > 0 && cfg.Priority == config.PriorityRecency && snap.Times != nil {
+		if times, err := snap.Times(); err == nil {
+			for i := range entries {
+				if t, ok := times[entries[i].relPath]; ok {
+					entries[i].modTime = t
+				}
+			}
 		}
+	}
+	return entries, nil
+}
 
-		relPath, err := filepath.Rel(repoPath, path)
+// collectEntries walks the repository and returns an entry for every file that is part of it,
+// recording why files are left out. Hidden files and files ignored by .gitignore or
+// .repototxtignore are not part of the snapshot and yield no entries.
+//
+// Parameters:
+//   - snap: The repository snapshot.
+//   - absOutputFile: The absolute path of the output file, which is never packed; empty if there is none.
+//   - cfg: A pointer to the Config struct containing exclusion and inclusion rules.
+//
+// Returns:
+//   - []entry: The entries in walk order.
+//   - error: An error if the rules cannot be loaded or the repository cannot be walked.
+func collectEntries(snap Snapshot, absOutputFile string, cfg *config.Config) ([]entry, error) {
+	patterns, err := newPatterns(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ignores, err := newIgnoreRules(snap, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules *filter.GitRules
+	if !cfg.NoGitignore {
+		rules, err = filter.LoadGitRules(repoFS(snap, cfg))
+		if err != nil {
+			return nil, fmt.Errorf("error loading .gitignore and .gitattributes rules: %w", err)
+		}
+	}
+
+	roots, err := walkRoots(snap, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	lfs := newLFSResolver(cfg)
+	var entries []entry
+	var root string
+	walk := func(slashPath string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return fmt.Errorf("error getting relative path: %w", err)
+			return fmt.Errorf("error accessing path %s: %w", slashPath, err)
 		}
 
-		if shouldExcludeFile(relPath, cfg) {
+		if d.IsDir() {
+			if slashPath != root && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir // Skip hidden directories such as .git
+			}
+			if slashPath != root && rules.Ignored(slashPath, true) {
+				return fs.SkipDir // Skip directories ignored by .gitignore
+			}
+			if slashPath != root && ignores.Ignored(slashPath, true) {
+				return fs.SkipDir // Skip directories ignored by .repototxtignore
+			}
+			if slashPath != root && patterns.SkipDir(slashPath) {
+				reason, _ := patterns.Exclude(slashPath)
+				entries = append(entries, entry{relPath: slashPath, isDir: true, reason: reason})
+				return fs.SkipDir // Skip directories excluded by patterns
+			}
+			return nil
+		}
+
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil // Skip hidden files
+		}
+
+		if path := snap.diskPath(slashPath); path != "" && absOutputFile != "" {
+			if absPath, err := filepath.Abs(path); err == nil && absPath == absOutputFile {
+				return nil // Skip the output file itself
+			}
+		}
+
+		if ignores.Ignored(slashPath, false) {
+			return nil // Skip files ignored by .repototxtignore
+		}
+
+		if rules.Ignored(slashPath, false) {
+			return nil // Skip files ignored by .gitignore
+		}
+
+		if reason, excluded := shouldExcludeFile(slashPath, patterns, cfg); excluded {
+			entries = append(entries, entry{relPath: slashPath, size: entrySize(d), reason: reason})
 			return nil // Skip excluded files
 		}
 
-		content, err := readFileContent(path)
+		if reason, excluded := rules.Exclude(slashPath); excluded {
+			entries = append(entries, entry{relPath: slashPath, size: entrySize(d), reason: reason, binary: reason == filter.ReasonBinary})
+			return nil // Skip files excluded by .gitattributes
+		}
+
+		entries = append(entries, readEntry(snap, slashPath, lfs))
+		return nil
+	}
+
+	for _, root = range roots {
+		if err := fs.WalkDir(snap.FS, root, walk); err != nil {
+			return nil, fmt.Errorf("error walking the path %s: %w", root, err)
+		}
+	}
+
+	return entries, nil
+}
+
+// entrySize returns the size of a file found while walking, or 0 if it cannot be determined.
+func entrySize(d fs.DirEntry) int64 {
+	info, err := d.Info()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// readEntry checks a file and returns its entry. The content is only held in memory when the
+// file is small enough to be a Git LFS pointer; larger files are read again when they are written,
+// so that memory use does not grow with the size of the repository. Files that cannot be read or
+// are binary are recorded as left out, with the problem as the reason. Git LFS pointer files are
+// handled by lfs.
+//
+// Parameters:
+//   - snap: The repository snapshot the file belongs to.
+//   - relPath: The slash-separated path of the file relative to the repository root.
+//   - lfs: The resolver for Git LFS pointer files.
+//
+// Returns:
+//   - entry: The entry describing the file.
+func readEntry(snap Snapshot, relPath string, lfs *lfsResolver) entry {
+	e := entry{relPath: relPath}
+	if info, err := fs.Stat(snap.FS, relPath); err == nil {
+		e.size = info.Size()
+		e.modTime = info.ModTime()
+	}
+
+	file, err := snap.FS.Open(relPath)
+	if err != nil {
+		e.reason = err.Error()
+		return e
+	}
+	defer file.Close()
+	content, size, lines, err := probeContent(file)
+	if err != nil {
+		e.reason = err.Error()
+		e.binary = errors.Is(err, errBinaryFile)
+		return e
+	}
+	e.content, e.size, e.lines = content, size, lines
+	if e.content == nil {
+		e.load = func() ([]byte, error) { return readSnapshotFile(snap.FS, relPath) }
+	}
+	lfs.apply(&e, snap.diskPath(relPath))
+	return e
+}
+
+// probeContent reads a file without holding on to it: it checks the first bytes for binary data
+// and counts the bytes and lines. The content is returned only when the whole file is small
+// enough to be a Git LFS pointer.
+//
+// Parameters:
+//   - r: The reader of the file content.
+//
+// Returns:
+//   - []byte: The content of a small file; nil for larger files.
+//   - int64: The size of the content in bytes.
+//   - int: The number of lines in the content.
+//   - error: An error if the content cannot be read or is identified as binary.
+func probeContent(r io.Reader) ([]byte, int64, int, error) {
+	head := make([]byte, maxLFSPointerSize+1)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, 0, 0, err
+	}
+	if isBinary(head[:min(n, binarySampleSize)]) {
+		return nil, 0, 0, errBinaryFile
+	}
+	if n <= maxLFSPointerSize {
+		content := bytes.Clone(head[:n])
+		return content, int64(n), countLines(content), nil
+	}
+
+	counter := &lineCounter{}
+	counter.Write(head[:n])
+	if _, err := io.Copy(counter, r); err != nil {
+		return nil, 0, 0, err
+	}
+	return nil, counter.size, counter.count(), nil
+}
+
+// lineCounter is a writer that counts the bytes and lines written to it, as countLines does.
+type lineCounter struct {
+	size  int64 // Number of bytes written
+	lines int   // Number of line breaks written
+	last  byte  // Last byte written
+}
+
+// Write counts the bytes and line breaks of p.
+func (c *lineCounter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		c.size += int64(len(p))
+		c.lines += bytes.Count(p, []byte("\n"))
+		c.last = p[len(p)-1]
+	}
+	return len(p), nil
+}
+
+// count returns the number of lines written. A final line without a trailing newline is counted.
+func (c *lineCounter) count() int {
+	if c.size > 0 && c.last != '\n' {
+		return c.lines + 1
+	}
+	return c.lines
+}
+
+// writeEntries writes the packed entries to the output file with writeEntriesTo. When the
+// configuration sets a chunk size, the output is split into numbered part files next to the
+// output file instead.
+//
+// Parameters:
+//   - outputFile: The path to the output file.
+//   - entries: The entries to write.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - cfg: A pointer to the Config struct containing the output options.
+//
+// Returns:
+//   - *Summary: The files written and their token counts.
+//   - error: An error if tokens cannot be counted or writing to the file fails.
+func writeEntries(outputFile string, entries []entry, meta Metadata, cfg *config.Config) (*Summary, error) {
+	if cfg.ChunkSize > 0 {
+		summary, counter, err := prepareEntries(entries, cfg)
 		if err != nil {
-			log.Printf("Skipping file %s: %v", relPath, err)
-			return nil // Skip files that cannot be read or are binary
+			return nil, err
 		}
+		if err := writeChunks(outputFile, entries, meta, summary, counter, cfg); err != nil {
+			return nil, err
+		}
+		return summary, nil
+	}
 
-		return writeFileContent(writer, relPath, content)
-	})
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create output file: %w", err)
+	}
+	defer file.Close()
+
+	summary, err := writeEntriesTo(file, entries, meta, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("error writing output file: %w", err)
+	}
+	return summary, nil
+}
+
+// writeEntriesTo writes the packed entries to the writer in the configured output format,
+// preceded by a directory tree of all entries when the configuration requests it. Tokens are
+// counted with the configured tokenizer before anything is written, so that structured formats
+// can record the totals up front. Contents that are not held in memory are read one file at a
+// time while counting and again while writing. When the configuration sets a token budget, files are fitted
+// into it first and the files left out are listed after the packed files.
+//
+// Parameters:
+//   - writer: The writer for the output; writes are buffered and flushed before returning.
+//   - entries: The entries to write.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - cfg: A pointer to the Config struct containing the output options.
+//
+// Returns:
+//   - *Summary: The files written and their token counts.
+//   - error: An error if the configuration sets a chunk size, tokens cannot be counted or writing fails.
+func writeEntriesTo(writer io.Writer, entries []entry, meta Metadata, cfg *config.Config) (*Summary, error) {
+	if cfg.ChunkSize > 0 {
+		return nil, errors.New("chunked output must be written to files")
+	}
+
+	summary, _, err := prepareEntries(entries, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree string
+	if cfg.Tree {
+		tree = renderTree(meta.Name, entries)
+	}
+
+	buffered := bufio.NewWriter(writer)
+	if err := writeDocument(buffered, cfg.Format, meta, summary, tree, entries); err != nil {
+		return nil, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, fmt.Errorf("error writing output: %w", err)
+	}
+	return summary, nil
+}
+
+// prepareEntries counts the tokens of the packed entries with the configured tokenizer, fits
+// them into the token budget when the configuration sets one, and summarises the result.
+// Chunking splits and measures the contents, so with a chunk size every packed file is read
+// into memory first; otherwise contents are only read one file at a time.
+//
+// Parameters:
+//   - entries: The entries to prepare; they are updated in place.
+//   - cfg: A pointer to the Config struct containing the tokenizer and budget options.
+//
+// Returns:
+//   - *Summary: The packed files, their token counts and the files left out.
+//   - tokens.Counter: The counter used, or nil if tokens are not counted.
+//   - error: An error if the tokenizer cannot be loaded or a budget is set without one.
+func prepareEntries(entries []entry, cfg *config.Config) (*Summary, tokens.Counter, error) {
+	counter, err := newCounter(cfg.Tokenizer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.ChunkSize > 0 {
+		if err := loadEntries(entries); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := countTokens(entries, counter); err != nil {
+		return nil, nil, err
+	}
+
+	var omitted []OmittedFile
+	if cfg.MaxTokens > 0 {
+		if counter == nil {
+			return nil, nil, errors.New("a token budget requires a tokenizer")
+		}
+		omitted = applyBudget(entries, counter, cfg.MaxTokens, cfg.Priority, cfg.Overflow)
+	}
+
+	summary := summarize(entries, counter)
+	summary.MaxTokens, summary.Omitted = cfg.MaxTokens, omitted
+	return summary, counter, nil
+}
 
+// writeDocument writes a complete document in the given output format: the header, the directory
+// tree if one is given, the packed entries, and the files left out to fit the token budget. In a
+// combined document, the files of each repository are preceded by a section describing it.
+//
+// Parameters:
+//   - writer: The writer for the document.
+//   - format: The output format, one of the config.Format constants.
+//   - meta: Metadata describing the repository snapshot, written as a header.
+//   - summary: The summary of the files in the document.
+//   - tree: The rendered directory tree; empty to leave it out.
+//   - entries: The entries to write; only packed entries are written.
+//
+// Returns:
+//   - error: An error if the format is not supported or writing fails.
+func writeDocument(writer io.Writer, format string, meta Metadata, summary *Summary, tree string, entries []entry) error {
+	formatter, err := newFormatter(format, writer)
+	if err != nil {
+		return err
+	}
+	if err := formatter.begin(meta, summary); err != nil {
+		return err
+	}
+
+	if tree != "" {
+		if err := formatter.tree(tree); err != nil {
+			return err
+		}
+	}
+
+	repo := ""
+	for _, e := range entries {
+		if !e.packed() {
+			continue
+		}
+		if e.repo != repo {
+			repo = e.repo
+			if err := formatter.repository(meta.repository(repo)); err != nil {
+				return err
+			}
+		}
+		// The content is dropped again once written, so only one file is held at a time
+		content, err := e.read()
+		if err != nil {
+			return err
+		}
+		e.content = content
+		if err := formatter.file(e); err != nil {
+			return err
+		}
+	}
+
+	if len(summary.Omitted) > 0 {
+		if err := formatter.omitted(summary.MaxTokens, summary.Omitted); err != nil {
+			return err
+		}
+	}
+
+	return formatter.end()
+}
+
+// newCounter returns the counter for the named tokenizer.
+//
+// Parameters:
+//   - tokenizer: The tokenizer name; empty or tokens.None disables counting.
+//
+// Returns:
+//   - tokens.Counter: The counter, or nil if counting is disabled.
+//   - error: An error if the tokenizer is unknown or cannot be loaded.
+func newCounter(tokenizer string) (tokens.Counter, error) {
+	if tokenizer == "" {
+		return nil, nil
+	}
+	counter, err := tokens.NewCounter(tokenizer)
 	if err != nil {
-		return fmt.Errorf("error walking the path %s: %w", repoPath, err)
+		return nil, fmt.Errorf("error creating token counter: %w", err)
+	}
+	return counter, nil
+}
+
+// loadEntries reads the contents of the packed entries that are not held in memory.
+//
+// Parameters:
+//   - entries: The entries to load; their contents are updated in place.
+//
+// Returns:
+//   - error: An error if a file can no longer be read.
+func loadEntries(entries []entry) error {
+	for i := range entries {
+		if !entries[i].packed() {
+			continue
+		}
+		content, err := entries[i].read()
+		if err != nil {
+			return err
+		}
+		entries[i].content = content
 	}
+	return nil
+}
 
+// countTokens counts the tokens of every packed entry with the counter, reading contents that
+// are not held in memory one file at a time without keeping them.
+//
+// Parameters:
+//   - entries: The entries to count; their token counts are updated in place.
+//   - counter: The counter to use; nil leaves the counts at zero.
+//
+// Returns:
+//   - error: An error if a file can no longer be read.
+func countTokens(entries []entry, counter tokens.Counter) error {
+	if counter == nil {
+		return nil
+	}
+	for i := range entries {
+		if !entries[i].packed() {
+			continue
+		}
+		content, err := entries[i].read()
+		if err != nil {
+			return err
+		}
+		entries[i].tokens = counter.Count(content)
+	}
 	return nil
 }
 
+// summarize describes the packed entries and their token counts, and the entries left out.
+//
+// Parameters:
+//   - entries: The entries with counted tokens.
+//   - counter: The counter the tokens were counted with; nil if tokens were not counted.
+//
+// Returns:
+//   - *Summary: The packed files, their token counts and the skipped entries.
+func summarize(entries []entry, counter tokens.Counter) *Summary {
+	summary := &Summary{}
+	if counter != nil {
+		summary.Tokenizer = counter.Name()
+	}
+	for _, e := range entries {
+		if !e.packed() {
+			summary.Skipped = append(summary.Skipped, SkippedFile{Path: e.relPath, Dir: e.isDir, Reason: e.reason})
+			continue
+		}
+		summary.Tokens += e.tokens
+		summary.Files = append(summary.Files, FileSummary{Path: e.relPath, Size: e.size, Tokens: e.tokens})
+	}
+	return summary
+}
+
 // shouldExcludeFile determines whether a file should be excluded based on its relative path and extension.
-// It checks against the excluded folders and included extensions specified in the configuration.
+// It checks against the include and exclude patterns and the included extensions specified in the configuration.
 //
 // Parameters:
 //   - relPath: The relative path of the file within the repository.
+//   - patterns: The include and exclude patterns built from the configuration.
 //   - cfg: A pointer to the Config struct containing exclusion and inclusion rules.
 //
 // Returns:
+//   - string: The reason the file is excluded; empty if it is not excluded.
 //   - bool: True if the file should be excluded, false otherwise.
-func shouldExcludeFile(relPath string, cfg *config.Config) bool {
-	normalizedRelPath := filepath.ToSlash(relPath)
-	for _, exclude := range cfg.ExcludeFolders {
-		normalizedExclude := filepath.ToSlash(strings.TrimSpace(exclude))
-		if normalizedExclude == "" {
-			continue
-		}
-		if strings.HasPrefix(normalizedRelPath, normalizedExclude+"/") || normalizedRelPath == normalizedExclude {
-			return true
-		}
+func shouldExcludeFile(relPath string, patterns *filter.Patterns, cfg *config.Config) (string, bool) {
+	if reason, excluded := patterns.Exclude(filepath.ToSlash(relPath)); excluded {
+		return reason, true
 	}
 
 	if len(cfg.IncludeExt) > 0 {
 		ext := strings.ToLower(filepath.Ext(relPath))
-		return !util.Contains(cfg.IncludeExt, ext)
+		if !util.Contains(cfg.IncludeExt, ext) {
+			return "extension not included", true
+		}
+		return "", false
+	}
+
+	// Explicit include patterns replace the default extension exclusions.
+	if !patterns.HasIncludes() && strings.HasSuffix(strings.ToLower(relPath), config.DefaultExcludedExt) {
+		return "excluded by default", true
+	}
+	return "", false
+}
+
+// newPatterns builds the include and exclude patterns specified in the configuration.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct containing the patterns.
+//
+// Returns:
+//   - *filter.Patterns: The parsed patterns.
+//   - error: An error if any pattern is malformed.
+func newPatterns(cfg *config.Config) (*filter.Patterns, error) {
+	patterns, err := filter.NewPatterns(cfg.IncludePatterns, cfg.ExcludeFolders)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing include/exclude patterns: %w", err)
 	}
+	return patterns, nil
+}
 
-	return strings.HasSuffix(strings.ToLower(relPath), config.DefaultExcludedExt)
+// newIgnoreRules loads the .repototxtignore files of the repository and the user-level ignore file.
+//
+// Parameters:
+//   - snap: The repository snapshot.
+//   - cfg: A pointer to the Config struct containing the path of the user-level ignore file.
+//
+// Returns:
+//   - *filter.IgnoreRules: The loaded rules.
+//   - error: An error if an ignore file cannot be read.
+func newIgnoreRules(snap Snapshot, cfg *config.Config) (*filter.IgnoreRules, error) {
+	ignores, err := filter.LoadIgnoreRules(repoFS(snap, cfg), config.IgnoreFileName, cfg.UserIgnoreFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s rules: %w", config.IgnoreFileName, err)
+	}
+	return ignores, nil
 }
 
-// readFileContent reads and returns the content of a file if it is a text file.
-// It skips binary files by checking for null bytes.
+// readFileContent reads and returns the content of a file on disk if it is a text file.
 //
 // Parameters:
 //   - path: The file system path to the file.
@@ -159,34 +1096,60 @@ func readFileContent(path string) ([]byte, error) {
 		return nil, err
 	}
 	defer file.Close()
+	return readContent(file)
+}
 
-	buf := make([]byte, 512)
-	n, err := file.Read(buf)
-	if err != nil && err != io.EOF {
+// readSnapshotFile reads and returns the content of a file of a snapshot if it is a text file.
+//
+// Parameters:
+//   - fsys: The file system of the snapshot.
+//   - name: The slash-separated path of the file.
+//
+// Returns:
+//   - []byte: The content of the file.
+//   - error: An error if the file cannot be read or is identified as binary.
+func readSnapshotFile(fsys fs.FS, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
 		return nil, err
 	}
-	if isBinary(buf[:n]) {
-		return nil, errors.New("binary file")
-	}
+	defer file.Close()
+	return readContent(file)
+}
 
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
+// readContent reads and returns the content of a file if it is a text file.
+// It skips binary files by checking the first bytes for null bytes.
+//
+// Parameters:
+//   - r: The reader of the file content.
+//
+// Returns:
+//   - []byte: The content of the file.
+//   - error: An error if the content cannot be read or is identified as binary.
+func readContent(r io.Reader) ([]byte, error) {
+	buf := make([]byte, binarySampleSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		return nil, err
 	}
+	if isBinary(buf[:n]) {
+		return nil, errBinaryFile
+	}
 
-	return io.ReadAll(file)
+	return io.ReadAll(io.MultiReader(bytes.NewReader(buf[:n]), r))
 }
 
 // writeFileContent writes the content of a file to the output writer with appropriate formatting.
 // It adds a separator with the relative file path before the content.
 //
 // Parameters:
-//   - writer: The buffered writer for the output file.
+//   - writer: The writer for the output file.
 //   - relPath: The relative path of the file within the repository.
 //   - content: The content of the file.
 //
 // Returns:
 //   - error: An error if writing to the output file fails.
-func writeFileContent(writer *bufio.Writer, relPath string, content []byte) error {
+func writeFileContent(writer io.Writer, relPath string, content []byte) error {
 	separator := fmt.Sprintf("=== %s ===\n", relPath)
 	if _, err := io.WriteString(writer, separator); err != nil {
 		return fmt.Errorf("error writing to output file: %w", err)
@@ -201,9 +1164,11 @@ func writeFileContent(writer *bufio.Writer, relPath string, content []byte) erro
 }
 
 // isBinary checks if the provided byte slice contains any null bytes, indicating a binary file.
+// Text in legacy encodings such as Latin-1 or Shift-JIS is not valid UTF-8 but is still text, so
+// it is packed; files that should be left out can be marked `binary` in .gitattributes.
 //
 // Parameters:
-//   - data: The byte slice to check.
+//   - data: The byte slice to check, typically the first bytes of a file.
 //
 // Returns:
 //   - bool: True if the data is binary, false otherwise.
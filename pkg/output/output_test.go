@@ -2,11 +2,28 @@
 package output
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/filter"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/tokens"
 )
 
 // TestWriteRepoContentsToFile verifies that the WriteRepoContentsToFile function
@@ -27,7 +44,7 @@ func TestWriteRepoContentsToFile(t *testing.T) {
 		IncludeExt:     []string{".go"},
 	}
 
-	err = WriteRepoContentsToFile(tempDir, outputFile, cfg)
+	_, err = WriteRepoContentsToFile(tempDir, outputFile, Metadata{}, cfg)
 	if err != nil {
 		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
 	}
@@ -79,7 +96,7 @@ func TestWriteRepoContentsToFileExclusions(t *testing.T) {
 		IncludeExt:     []string{".go", ".md"},
 	}
 
-	err = WriteRepoContentsToFile(tempDir, outputFile, cfg)
+	_, err = WriteRepoContentsToFile(tempDir, outputFile, Metadata{}, cfg)
 	if err != nil {
 		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
 	}
@@ -108,7 +125,7 @@ func TestWriteRepoContentsToFileBinaryFile(t *testing.T) {
 
 	// Create a binary file
 	binaryFile := filepath.Join(tempDir, "image.png")
-	err := os.WriteFile(binaryFile, []byte{0x89, 0x50, 0x4E, 0x47}, 0644) // PNG header bytes
+	err := os.WriteFile(binaryFile, []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A, 0x00, 0x00, 0x00, 0x0D}, 0644) // PNG signature and IHDR length
 	if err != nil {
 		t.Fatalf("Failed to write binary file: %v", err)
 	}
@@ -125,7 +142,7 @@ func TestWriteRepoContentsToFileBinaryFile(t *testing.T) {
 		IncludeExt:     []string{".go", ".png"},
 	}
 
-	err = WriteRepoContentsToFile(tempDir, outputFile, cfg)
+	_, err = WriteRepoContentsToFile(tempDir, outputFile, Metadata{}, cfg)
 	if err != nil {
 		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
 	}
@@ -171,7 +188,7 @@ func TestWriteRepoContentsToFileDefaultExclusions(t *testing.T) {
 		IncludeExt:     nil, // No specific extensions to include
 	}
 
-	err = WriteRepoContentsToFile(tempDir, outputFile, cfg)
+	_, err = WriteRepoContentsToFile(tempDir, outputFile, Metadata{}, cfg)
 	if err != nil {
 		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
 	}
@@ -192,3 +209,1518 @@ func TestWriteRepoContentsToFileDefaultExclusions(t *testing.T) {
 		t.Errorf("Output file content mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, string(content))
 	}
 }
+
+// TestWriteRepoContentsToFileHeader verifies that the repository metadata header is written
+// before the file contents when the commit is known.
+func TestWriteRepoContentsToFileHeader(t *testing.T) {
+	tempDir := t.TempDir()
+	outputFile := filepath.Join(tempDir, "output.txt")
+
+	err := os.WriteFile(filepath.Join(tempDir, "main.go"), []byte("package main\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	meta := Metadata{
+		Name:   "repo",
+		Source: "https://github.com/user/repo.git",
+		Ref:    "v1.0.0",
+		Commit: "0123456789abcdef0123456789abcdef01234567",
+	}
+	cfg := &config.Config{IncludeExt: []string{".go"}}
+
+	if _, err := WriteRepoContentsToFile(tempDir, outputFile, meta, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	expectedContent := "Repository: repo\n" +
+		"Source: https://github.com/user/repo.git\n" +
+		"Ref: v1.0.0\n" +
+		"Commit: 0123456789abcdef0123456789abcdef01234567\n\n" +
+		"=== main.go ===\npackage main\n\n\n"
+	if string(content) != expectedContent {
+		t.Errorf("Output file content mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, string(content))
+	}
+}
+
+// TestWriteRepoContentsToFileSubmodules verifies that checked out submodules are listed in the
+// header and their files are packed under their paths.
+func TestWriteRepoContentsToFileSubmodules(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tempDir, "vendor", "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "vendor", "lib", "lib.go"), []byte("package lib\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Submodule checkouts point at their git directory with a hidden .git file
+	if err := os.WriteFile(filepath.Join(tempDir, "vendor", "lib", ".git"), []byte("gitdir: ../../.git/modules/lib\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := Metadata{
+		Name:       "repo",
+		Source:     "https://github.com/user/repo.git",
+		Commit:     "0123456789abcdef0123456789abcdef01234567",
+		Submodules: []clone.Submodule{{Path: "vendor/lib", URL: "https://github.com/user/lib.git", Commit: "89abcdef0123456789abcdef0123456789abcdef"}},
+	}
+	var buf bytes.Buffer
+	if _, err := WriteRepoContents(&buf, tempDir, meta, &config.Config{}); err != nil {
+		t.Fatalf("WriteRepoContents returned an error: %v", err)
+	}
+	expectedContent := "Repository: repo\n" +
+		"Source: https://github.com/user/repo.git\n" +
+		"Commit: 0123456789abcdef0123456789abcdef01234567\n" +
+		"Submodule: vendor/lib at 89abcdef0123456789abcdef0123456789abcdef (https://github.com/user/lib.git)\n\n" +
+		"=== vendor/lib/lib.go ===\npackage lib\n\n\n"
+	if buf.String() != expectedContent {
+		t.Errorf("Output mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, buf.String())
+	}
+
+	buf.Reset()
+	if _, err := WriteRepoContents(&buf, tempDir, meta, &config.Config{Format: config.FormatJSON}); err != nil {
+		t.Fatalf("WriteRepoContents returned an error: %v", err)
+	}
+	var doc JSONDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if len(doc.Repository.Submodules) != 1 || doc.Repository.Submodules[0] != JSONSubmodule(meta.Submodules[0]) {
+		t.Errorf("Expected the submodule in the JSON repository, got %+v", doc.Repository)
+	}
+}
+
+// TestWriteRepoContentsToFileLFS verifies that Git LFS pointer files are replaced with objects
+// from the local LFS store, marked when the object is missing or marking is requested, and
+// packed as they are in pointer mode.
+func TestWriteRepoContentsToFileLFS(t *testing.T) {
+	repoDir := t.TempDir()
+	object := []byte("id,name\n1,widget\n")
+	sum := sha256.Sum256(object)
+	oid := hex.EncodeToString(sum[:])
+	pointer := func(oid string, size int) string {
+		return fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n", oid, size)
+	}
+	missingOID := strings.Repeat("ab", 32)
+
+	files := map[string]string{
+		"data.csv":    pointer(oid, len(object)),
+		"model.bin":   pointer(missingOID, 3<<20),
+		"main.go":     "package main\n",
+		"fake.txt":    "version https://git-lfs.github.com/spec/v1\nnot a pointer\n",
+		".git/config": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := filepath.Join(repoDir, ".git", "lfs", "objects", oid[0:2], oid[2:4])
+	if err := os.MkdirAll(store, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(store, oid), object, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		mode     string
+		packed   map[string]string // Packed files and their content
+		skipped  map[string]string // Skipped files and the reason
+		treeLine string
+	}{
+		{
+			mode:     config.LFSResolve,
+			packed:   map[string]string{"data.csv": string(object), "main.go": "package main\n", "fake.txt": files["fake.txt"]},
+			skipped:  map[string]string{"model.bin": ReasonLFSMissing},
+			treeLine: "model.bin [Git LFS object, 3.0 MiB, not packed]",
+		},
+		{
+			mode:     config.LFSMark,
+			packed:   map[string]string{"main.go": "package main\n", "fake.txt": files["fake.txt"]},
+			skipped:  map[string]string{"data.csv": ReasonLFSPointer, "model.bin": ReasonLFSPointer},
+			treeLine: "data.csv [Git LFS object, 17 B, not packed]",
+		},
+		{
+			mode:    config.LFSPointer,
+			packed:  map[string]string{"data.csv": files["data.csv"], "model.bin": files["model.bin"], "main.go": "package main\n", "fake.txt": files["fake.txt"]},
+			skipped: map[string]string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.mode, func(t *testing.T) {
+			var buf bytes.Buffer
+			cfg := &config.Config{Format: config.FormatJSON, Tree: true, LFS: tc.mode}
+			summary, err := WriteRepoContents(&buf, repoDir, Metadata{Name: "repo"}, cfg)
+			if err != nil {
+				t.Fatalf("WriteRepoContents returned an error: %v", err)
+			}
+			var doc JSONDocument
+			if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+				t.Fatalf("Output is not valid JSON: %v", err)
+			}
+
+			packed := make(map[string]string)
+			for _, file := range doc.Files {
+				packed[file.Path] = file.Content
+			}
+			if !reflect.DeepEqual(packed, tc.packed) {
+				t.Errorf("Packed files = %v; want %v", packed, tc.packed)
+			}
+			skipped := make(map[string]string)
+			for _, file := range summary.Skipped {
+				skipped[file.Path] = file.Reason
+			}
+			if !reflect.DeepEqual(skipped, tc.skipped) {
+				t.Errorf("Skipped files = %v; want %v", skipped, tc.skipped)
+			}
+			if tc.treeLine != "" && !strings.Contains(doc.Tree, tc.treeLine) {
+				t.Errorf("Expected %q in the tree, got:\n%s", tc.treeLine, doc.Tree)
+			}
+		})
+	}
+}
+
+// TestWriteRepoContentsToFileGitRules verifies that .gitignore and .gitattributes rules are
+// honoured by default and can be disabled with NoGitignore.
+func TestWriteRepoContentsToFileGitRules(t *testing.T) {
+	repoDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output.txt")
+
+	files := map[string]string{
+		".gitignore":        "ignored/\n",
+		".gitattributes":    "*.gen.go linguist-generated\n",
+		"main.go":           "package main\n",
+		"api.gen.go":        "package main\n",
+		"ignored/ignore.go": "package ignored\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	cfg := &config.Config{IncludeExt: []string{".go"}}
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{}, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+	content, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	expectedContent := "=== main.go ===\npackage main\n\n\n"
+	if string(content) != expectedContent {
+		t.Errorf("Output file content mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, string(content))
+	}
+
+	cfg.NoGitignore = true
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{}, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+	content, err = os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	expectedContent = "=== api.gen.go ===\npackage main\n\n\n" +
+		"=== ignored/ignore.go ===\npackage ignored\n\n\n" +
+		"=== main.go ===\npackage main\n\n\n"
+	if string(content) != expectedContent {
+		t.Errorf("Output file content mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, string(content))
+	}
+}
+
+// TestIsBinary verifies that null bytes are detected as binary while text in any encoding is not.
+func TestIsBinary(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected bool
+	}{
+		{"plain text", []byte("package main\n"), false},
+		{"utf-8 text", []byte("héllo wörld"), false},
+		{"truncated rune", []byte("héllo \xe2\x82"), false},
+		{"latin-1 text", []byte("caf\xe9 cr\xe8me br\xfbl\xe9e"), false},
+		{"windows-1252 text", []byte("\x93quoted\x94 \x80 price"), false},
+		{"shift-jis text", []byte("\x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd"), false},
+		{"null byte", []byte("abc\x00def"), true},
+		{"png header", []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A, 0x00, 0x00, 0x00, 0x0D}, true},
+		{"empty", []byte{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := isBinary(tt.data); result != tt.expected {
+				t.Errorf("isBinary(%q) = %v; want %v", tt.data, result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestWriteRepoContentsToFilePatterns verifies that glob include and exclude patterns are applied to the walk.
+func TestWriteRepoContentsToFilePatterns(t *testing.T) {
+	repoDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output.txt")
+
+	for _, name := range []string{"src/main.go", "src/main_test.go", "src/testdata/in.go", "docs/intro.md"} {
+		path := filepath.Join(repoDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("content\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	cfg := &config.Config{
+		IncludePatterns: []string{"src/**/*.go"},
+		ExcludeFolders:  []string{"**/*_test.go", "**/testdata/**"},
+	}
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{}, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	expectedContent := "=== src/main.go ===\ncontent\n\n\n"
+	if string(content) != expectedContent {
+		t.Errorf("Output file content mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, string(content))
+	}
+}
+
+// TestFindFiles verifies that FindFiles finds files by exact name and honours exclude patterns.
+func TestFindFiles(t *testing.T) {
+	repoDir := t.TempDir()
+	for _, name := range []string{"main.go", "cmd/main.go", "vendor/lib/main.go", ".git/main.go"} {
+		path := filepath.Join(repoDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("package main\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	cfg := &config.Config{ExcludeFolders: []string{"vendor"}}
+	matches, err := FindFiles(repoDir, []string{"main.go"}, cfg)
+	if err != nil {
+		t.Fatalf("FindFiles returned an error: %v", err)
+	}
+
+	expected := []string{filepath.Join(repoDir, "cmd", "main.go"), filepath.Join(repoDir, "main.go")}
+	if len(matches["main.go"]) != len(expected) {
+		t.Fatalf("Expected matches %v, got %v", expected, matches["main.go"])
+	}
+	for i, v := range expected {
+		if matches["main.go"][i] != v {
+			t.Errorf("Expected match %d to be %q, got %q", i, v, matches["main.go"][i])
+		}
+	}
+}
+
+// TestWriteRepoContentsToFileIgnoreFile verifies that .repototxtignore files are honoured even
+// when .gitignore handling is disabled.
+func TestWriteRepoContentsToFileIgnoreFile(t *testing.T) {
+	repoDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output.txt")
+
+	files := map[string]string{
+		config.IgnoreFileName: "fixtures/\n*.snap\n",
+		"main.go":             "package main\n",
+		"main.snap":           "snapshot\n",
+		"fixtures/data.txt":   "data\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	cfg := &config.Config{NoGitignore: true}
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{}, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	expectedContent := "=== main.go ===\npackage main\n\n\n"
+	if string(content) != expectedContent {
+		t.Errorf("Output file content mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, string(content))
+	}
+}
+
+// TestWriteRepoContentsToFileMarkdown verifies the Markdown output format, including fences that
+// are longer than any backtick run inside a file.
+func TestWriteRepoContentsToFileMarkdown(t *testing.T) {
+	repoDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output.md")
+
+	files := map[string]string{
+		"README.md": "# Title\n\n```go\nfmt.Println()\n```\n",
+		"main.go":   "package main",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(repoDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	cfg := &config.Config{Format: config.FormatMarkdown}
+	meta := Metadata{Name: "repo", Source: "https://example.com/repo.git", Commit: "abc123"}
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, meta, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	expectedContent := "# repo\n\n" +
+		"- Source: https://example.com/repo.git\n" +
+		"- Commit: abc123\n\n" +
+		"## README.md\n\n````markdown\n# Title\n\n```go\nfmt.Println()\n```\n````\n\n" +
+		"## main.go\n\n```go\npackage main\n```\n\n"
+	if string(content) != expectedContent {
+		t.Errorf("Output file content mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, string(content))
+	}
+}
+
+// TestWriteSelectedFiles verifies that selected files are written in order with paths relative to the repository.
+func TestWriteSelectedFiles(t *testing.T) {
+	repoDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output.txt")
+
+	if err := os.MkdirAll(filepath.Join(repoDir, "cmd"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	paths := []string{filepath.Join(repoDir, "cmd", "main.go"), filepath.Join(repoDir, "go.mod")}
+	for _, path := range paths {
+		if err := os.WriteFile(path, []byte(filepath.Base(path)+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	if _, err := WriteSelectedFiles(repoDir, outputFile, paths, Metadata{}, &config.Config{}); err != nil {
+		t.Fatalf("WriteSelectedFiles returned an error: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	expectedContent := "=== cmd/main.go ===\nmain.go\n\n\n=== go.mod ===\ngo.mod\n\n\n"
+	if string(content) != expectedContent {
+		t.Errorf("Output file content mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, string(content))
+	}
+}
+
+// TestDetectLanguage verifies language detection by file name and extension.
+func TestDetectLanguage(t *testing.T) {
+	testCases := map[string]string{
+		"main.go":              "go",
+		"src/App.TSX":          "tsx",
+		"Dockerfile":           "dockerfile",
+		"build/Dockerfile.dev": "dockerfile",
+		"Makefile":             "makefile",
+		"LICENSE":              "",
+		"data.unknown":         "",
+	}
+	for path, expected := range testCases {
+		if lang := detectLanguage(path); lang != expected {
+			t.Errorf("detectLanguage(%q) = %q; want %q", path, lang, expected)
+		}
+	}
+}
+
+// TestWriteRepoContentsToFileXML verifies that the XML output is well-formed, carries the file
+// attributes and repository metadata, and preserves content containing CDATA terminators.
+func TestWriteRepoContentsToFileXML(t *testing.T) {
+	repoDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output.xml")
+
+	files := map[string]string{
+		"main.go":            "package main\n\nfunc main() {}\n",
+		"weird & <name>.txt": "a]]>b <tag> & \x0c",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(repoDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "cdata.md"), []byte("x ]]> y\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	cfg := &config.Config{Format: config.FormatXML}
+	meta := Metadata{Name: "repo", Source: "https://example.com/repo.git?a=1&b=\"2\"", Commit: "abc123"}
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, meta, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	var doc struct {
+		Name   string `xml:"name,attr"`
+		Source string `xml:"source,attr"`
+		Commit string `xml:"commit,attr"`
+		Files  []struct {
+			Path     string `xml:"path,attr"`
+			Size     int    `xml:"size,attr"`
+			Language string `xml:"language,attr"`
+			Lines    int    `xml:"lines,attr"`
+			Content  string `xml:",chardata"`
+		} `xml:"file"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Output is not well-formed XML: %v\n%s", err, data)
+	}
+
+	if doc.Name != meta.Name || doc.Source != meta.Source || doc.Commit != meta.Commit {
+		t.Errorf("Repository metadata mismatch: got %+v", doc)
+	}
+	if len(doc.Files) != 3 {
+		t.Fatalf("Expected 3 files, got %d", len(doc.Files))
+	}
+
+	cdata, main, weird := doc.Files[0], doc.Files[1], doc.Files[2]
+	if cdata.Path != "cdata.md" || cdata.Content != "x ]]> y\n" || cdata.Language != "markdown" {
+		t.Errorf("Unexpected element for cdata.md: %+v", cdata)
+	}
+	if main.Path != "main.go" || main.Content != files["main.go"] || main.Size != len(files["main.go"]) || main.Lines != 3 || main.Language != "go" {
+		t.Errorf("Unexpected element for main.go: %+v", main)
+	}
+	// The form feed is not allowed in XML and is replaced during escaping.
+	if weird.Path != "weird & <name>.txt" || weird.Content != "a]]>b <tag> & \uFFFD" || weird.Language != "text" || weird.Lines != 1 {
+		t.Errorf("Unexpected element for weird & <name>.txt: %+v", weird)
+	}
+}
+
+// TestWriteRepoContentsToFileJSON verifies that the JSON output is a single document with the
+// repository metadata and a record per file, and that an empty repository yields an empty array.
+func TestWriteRepoContentsToFileJSON(t *testing.T) {
+	repoDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output.json")
+	cfg := &config.Config{Format: config.FormatJSON}
+	meta := Metadata{Name: "repo", Source: "https://example.com/repo.git", Ref: "v1.0.0", Commit: "abc123"}
+
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, meta, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+	var doc JSONDocument
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, data)
+	}
+	if len(doc.Files) != 0 {
+		t.Errorf("Expected no files for an empty repository, got %d", len(doc.Files))
+	}
+
+	files := map[string]string{
+		"a.html":  "<p>a & b</p>\n",
+		"main.go": "package main\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(repoDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, meta, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+	data, err = os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, data)
+	}
+
+	expectedRepo := JSONRepository{Name: meta.Name, Source: meta.Source, Ref: meta.Ref, Commit: meta.Commit}
+	if !reflect.DeepEqual(doc.Repository, expectedRepo) {
+		t.Errorf("Expected repository %+v, got %+v", expectedRepo, doc.Repository)
+	}
+	if len(doc.Files) != 2 {
+		t.Fatalf("Expected 2 files, got %d", len(doc.Files))
+	}
+	for _, file := range doc.Files {
+		checkJSONFile(t, file, files[file.Path])
+	}
+	if !bytes.Contains(data, []byte("<p>a & b</p>")) {
+		t.Errorf("Expected HTML characters not to be escaped in the output")
+	}
+}
+
+// TestWriteRepoContentsToFileJSONL verifies that the JSON Lines output contains one record per line.
+func TestWriteRepoContentsToFileJSONL(t *testing.T) {
+	repoDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output.jsonl")
+
+	files := map[string]string{
+		"main.go":   "package main\n",
+		"README.md": "# Title\nline \"two\"\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(repoDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	cfg := &config.Config{Format: config.FormatJSONL}
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{Name: "repo", Commit: "abc123"}, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+
+	file, err := os.Open(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to open output file: %v", err)
+	}
+	defer file.Close()
+
+	var count int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record JSONFile
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Line %d is not valid JSON: %v", count+1, err)
+		}
+		if record.Type != JSONLFileType {
+			t.Errorf("Expected line %d to have type %q, got %q", count+1, JSONLFileType, record.Type)
+		}
+		checkJSONFile(t, record, files[record.Path])
+		count++
+	}
+	if count != len(files) {
+		t.Errorf("Expected %d lines, got %d", len(files), count)
+	}
+}
+
+// checkJSONFile verifies that a JSON record describes the expected content.
+func checkJSONFile(t *testing.T, file JSONFile, expectedContent string) {
+	t.Helper()
+	content := []byte(file.Content)
+	if file.Encoding == JSONEncodingBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(file.Content)
+		if err != nil {
+			t.Fatalf("Content of %s is not valid base64: %v", file.Path, err)
+		}
+		content = decoded
+	}
+	if string(content) != expectedContent {
+		t.Errorf("Expected content of %s to be %q, got %q", file.Path, expectedContent, content)
+	}
+	// The size and digest describe the decoded content
+	sum := sha256.Sum256(content)
+	if file.Size != len(expectedContent) || file.Size != len(content) {
+		t.Errorf("Expected size of %s to be %d, got %d", file.Path, len(expectedContent), file.Size)
+	}
+	if file.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected sha256 for %s: %s", file.Path, file.SHA256)
+	}
+	if file.Language != detectLanguage(file.Path) || file.Language == "" {
+		t.Errorf("Unexpected language for %s: %q", file.Path, file.Language)
+	}
+}
+
+// TestWriteRepoContentsToFileTree verifies the directory tree written before the file contents,
+// including the annotations for packed, binary and excluded entries.
+func TestWriteRepoContentsToFileTree(t *testing.T) {
+	repoDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output.txt")
+
+	files := map[string]string{
+		"README.md":         "# Title\n\nText\n",
+		"cmd/app/main.go":   "package main\n",
+		"logo.png":          "\x89PNG\r\n\x1a\n\x00\x00",
+		"notebook.ipynb":    "{}",
+		"vendor/lib/lib.go": "package lib\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	cfg := &config.Config{Tree: true, ExcludeFolders: []string{"vendor"}}
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{Name: "repo"}, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	expectedContent := "Directory structure:\n" +
+		"repo/\n" +
+		"├── README.md (3 lines, 14 B)\n" +
+		"├── cmd/\n" +
+		"│   └── app/\n" +
+		"│       └── main.go (1 line, 13 B)\n" +
+		"├── logo.png [binary, 10 B]\n" +
+		"├── notebook.ipynb [excluded: excluded by default]\n" +
+		"└── vendor/ [excluded: matched exclude pattern \"vendor\"]\n" +
+		"\n" +
+		"=== README.md ===\n# Title\n\nText\n\n\n" +
+		"=== cmd/app/main.go ===\npackage main\n\n\n"
+	if string(content) != expectedContent {
+		t.Errorf("Output file content mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, string(content))
+	}
+
+	// The tree is also part of the structured formats.
+	cfg.Format = config.FormatJSON
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{Name: "repo"}, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	var doc JSONDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, data)
+	}
+	if !bytes.HasPrefix([]byte(doc.Tree), []byte("repo/\n├── README.md")) || len(doc.Files) != 2 {
+		t.Errorf("Unexpected JSON document: tree %q, %d files", doc.Tree, len(doc.Files))
+	}
+}
+
+// TestWriteRepoContentsToFileSubdirs verifies that only the requested subdirectories are walked,
+// that their paths stay relative to the repository root and that the root's .gitignore applies.
+func TestWriteRepoContentsToFileSubdirs(t *testing.T) {
+	repoDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output.txt")
+
+	files := map[string]string{
+		".gitignore":                   "*.log\n",
+		"README.md":                    "# Monorepo\n",
+		"services/billing/main.go":     "package main\n",
+		"services/billing/debug.log":   "debug\n",
+		"services/billing-old/main.go": "package old\n",
+		"services/api/main.go":         "package api\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	cfg := &config.Config{Tree: true, Subdirs: []string{"services/billing"}}
+	meta := Metadata{Name: "repo", Source: "https://github.com/user/repo.git", Commit: "0123456789abcdef0123456789abcdef01234567", Subdirs: cfg.Subdirs}
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, meta, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+	content, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	expectedContent := "Repository: repo\n" +
+		"Source: https://github.com/user/repo.git\n" +
+		"Commit: 0123456789abcdef0123456789abcdef01234567\n" +
+		"Subdir: services/billing\n" +
+		"\n" +
+		"Directory structure:\n" +
+		"repo/\n" +
+		"└── services/\n" +
+		"    └── billing/\n" +
+		"        └── main.go (1 line, 13 B)\n" +
+		"\n" +
+		"=== services/billing/main.go ===\npackage main\n\n\n"
+	if string(content) != expectedContent {
+		t.Errorf("Output file content mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, string(content))
+	}
+
+	matches, err := FindFiles(repoDir, []string{"main.go"}, cfg)
+	if err != nil {
+		t.Fatalf("FindFiles returned an error: %v", err)
+	}
+	if expected := filepath.Join(repoDir, "services", "billing", "main.go"); len(matches["main.go"]) != 1 || matches["main.go"][0] != expected {
+		t.Errorf("Expected FindFiles to match only %s, got %v", expected, matches["main.go"])
+	}
+
+	cfg.Subdirs = []string{"services/missing"}
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{}, cfg); err == nil {
+		t.Error("Expected an error for a missing subdirectory")
+	}
+}
+
+// TestWriteRepoContentsToFileTokens verifies that tokens are counted per file, summarised, and
+// recorded in the structured output formats.
+func TestWriteRepoContentsToFileTokens(t *testing.T) {
+	repoDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "output.json")
+
+	files := map[string]string{
+		"small.go": "package small\n",
+		"large.go": "package large\n\nfunc Large() string {\n\treturn \"large\"\n}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(repoDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	counter, err := tokens.NewCounter(tokens.CL100K)
+	if err != nil {
+		t.Fatalf("NewCounter returned an error: %v", err)
+	}
+
+	cfg := &config.Config{Format: config.FormatJSON, Tokenizer: tokens.CL100K}
+	summary, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{Name: "repo"}, cfg)
+	if err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+
+	expectedTotal := counter.Count([]byte(files["small.go"])) + counter.Count([]byte(files["large.go"]))
+	if summary.Tokenizer != tokens.CL100K || summary.Tokens != expectedTotal {
+		t.Errorf("Expected %d %s tokens, got %d %s", expectedTotal, tokens.CL100K, summary.Tokens, summary.Tokenizer)
+	}
+	largest := summary.Largest(1)
+	if len(largest) != 1 || largest[0].Path != "large.go" {
+		t.Errorf("Expected large.go to be the largest file, got %+v", largest)
+	}
+
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	var doc JSONDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, data)
+	}
+	if doc.Tokenizer != tokens.CL100K || doc.Tokens != expectedTotal {
+		t.Errorf("Expected document totals %d %s, got %d %s", expectedTotal, tokens.CL100K, doc.Tokens, doc.Tokenizer)
+	}
+	for _, file := range doc.Files {
+		if expected := counter.Count([]byte(files[file.Path])); file.Tokens != expected {
+			t.Errorf("Expected %d tokens for %s, got %d", expected, file.Path, file.Tokens)
+		}
+	}
+}
+
+// TestSummaryLargest verifies that the largest files are ordered by tokens and limited to n.
+func TestSummaryLargest(t *testing.T) {
+	summary := &Summary{Files: []FileSummary{
+		{Path: "a", Tokens: 5},
+		{Path: "b", Tokens: 20},
+		{Path: "c", Tokens: 5},
+		{Path: "d", Tokens: 10},
+	}}
+
+	largest := summary.Largest(3)
+	expected := []string{"b", "d", "a"}
+	if len(largest) != len(expected) {
+		t.Fatalf("Expected %d files, got %d", len(expected), len(largest))
+	}
+	for i, path := range expected {
+		if largest[i].Path != path {
+			t.Errorf("Expected file %d to be %s, got %s", i, path, largest[i].Path)
+		}
+	}
+	if len(summary.Largest(0)) != 0 || len(summary.Largest(10)) != 4 {
+		t.Errorf("Expected Largest to respect the limit")
+	}
+	if summary.Files[0].Path != "a" {
+		t.Errorf("Expected Largest not to reorder the summary")
+	}
+}
+
+// TestBudgetOrder verifies that the README and entry points compete for the token budget first,
+// followed by the other files in the order given by the priority.
+func TestBudgetOrder(t *testing.T) {
+	now := time.Now()
+	entries := []entry{
+		{relPath: "a/b.go", tokens: 1, modTime: now.Add(-time.Hour)},
+		{relPath: "cmd/app/main.go", tokens: 30, modTime: now.Add(-3 * time.Hour)},
+		{relPath: "docs/README.md", tokens: 2, modTime: now.Add(-5 * time.Hour)},
+		{relPath: "go.mod", tokens: 20, modTime: now.Add(-4 * time.Hour)},
+		{relPath: "image.png", reason: filter.ReasonBinary, binary: true},
+		{relPath: "pkg/deep/util.go", tokens: 5, modTime: now},
+		{relPath: "README.md", tokens: 100, modTime: now.Add(-6 * time.Hour)},
+		{relPath: "z.go", tokens: 50, modTime: now.Add(-2 * time.Hour)},
+	}
+
+	testCases := []struct {
+		priority string
+		expected []string
+	}{
+		{config.PriorityDepth, []string{"README.md", "go.mod", "cmd/app/main.go", "z.go", "a/b.go", "docs/README.md", "pkg/deep/util.go"}},
+		{config.PriorityRecency, []string{"README.md", "cmd/app/main.go", "go.mod", "pkg/deep/util.go", "a/b.go", "z.go", "docs/README.md"}},
+		{config.PrioritySize, []string{"README.md", "go.mod", "cmd/app/main.go", "a/b.go", "docs/README.md", "pkg/deep/util.go", "z.go"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.priority, func(t *testing.T) {
+			var order []string
+			for _, i := range budgetOrder(entries, tc.priority) {
+				order = append(order, entries[i].relPath)
+			}
+			if strings.Join(order, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("Expected order %v, got %v", tc.expected, order)
+			}
+		})
+	}
+}
+
+// TestWriteRepoContentsToFileTokenBudget verifies that files are dropped or truncated to fit the
+// token budget, in priority order, and that the files left out are listed after the packed files.
+func TestWriteRepoContentsToFileTokenBudget(t *testing.T) {
+	repoDir := t.TempDir()
+
+	var large strings.Builder
+	for i := 0; i < 200; i++ {
+		large.WriteString("func helper() { return }\n")
+	}
+	files := map[string]string{
+		"README.md":       "# Project\n\nDescribes the project.\n",
+		"main.go":         "package main\n\nfunc main() {}\n",
+		"pkg/large.go":    large.String(),
+		"pkg/nested/z.go": "package nested\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	counter, err := tokens.NewCounter(tokens.Heuristic)
+	if err != nil {
+		t.Fatalf("NewCounter returned an error: %v", err)
+	}
+	count := func(name string) int { return counter.Count([]byte(files[name])) }
+	budget := count("README.md") + count("main.go") + 200
+
+	t.Run("drop", func(t *testing.T) {
+		outputFile := filepath.Join(t.TempDir(), "output.json")
+		cfg := &config.Config{Format: config.FormatJSON, Tokenizer: tokens.Heuristic, MaxTokens: budget, Priority: config.PriorityDepth, Overflow: config.OverflowDrop}
+		summary, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{Name: "repo"}, cfg)
+		if err != nil {
+			t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+		}
+		if summary.Tokens > budget {
+			t.Errorf("Expected at most %d tokens, got %d", budget, summary.Tokens)
+		}
+
+		data, err := os.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+		var doc JSONDocument
+		if err := json.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("Output is not valid JSON: %v\n%s", err, data)
+		}
+		var packed []string
+		for _, file := range doc.Files {
+			packed = append(packed, file.Path)
+		}
+		if strings.Join(packed, ",") != "README.md,main.go,pkg/nested/z.go" {
+			t.Errorf("Expected README.md, main.go and pkg/nested/z.go to be packed, got %v", packed)
+		}
+		expected := []JSONOmittedFile{{Path: "pkg/large.go", Tokens: count("pkg/large.go"), Reason: ReasonBudgetExceeded}}
+		if doc.MaxTokens != budget || len(doc.Omitted) != 1 || doc.Omitted[0] != expected[0] {
+			t.Errorf("Expected budget %d and omitted files %+v, got %d and %+v", budget, expected, doc.MaxTokens, doc.Omitted)
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		outputFile := filepath.Join(t.TempDir(), "output.txt")
+		cfg := &config.Config{Tokenizer: tokens.Heuristic, Tree: true, MaxTokens: budget, Priority: config.PriorityDepth, Overflow: config.OverflowTruncate}
+		summary, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{Name: "repo"}, cfg)
+		if err != nil {
+			t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+		}
+		if summary.Tokens > budget {
+			t.Errorf("Expected at most %d tokens, got %d", budget, summary.Tokens)
+		}
+		// pkg/large.go is shallower than pkg/nested/z.go, so it is truncated to the remaining budget first.
+		if len(summary.Files) != 3 || len(summary.Omitted) != 2 ||
+			summary.Omitted[0].Path != "pkg/large.go" || summary.Omitted[0].Reason != ReasonTruncated ||
+			summary.Omitted[1].Path != "pkg/nested/z.go" || summary.Omitted[1].Reason != ReasonBudgetExceeded {
+			t.Fatalf("Expected pkg/large.go to be truncated and pkg/nested/z.go dropped, got %+v and %+v", summary.Files, summary.Omitted)
+		}
+
+		data, err := os.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+		output := string(data)
+		for _, expected := range []string{
+			"large.go (",
+			", truncated)",
+			"z.go [omitted: " + ReasonBudgetExceeded + "]",
+			"func helper() { return }\n[... truncated: ",
+			"Omitted files (token budget of " + strconv.Itoa(budget) + "):\n- pkg/large.go: " + ReasonTruncated,
+		} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("Expected output to contain %q, got:\n%s", expected, output)
+			}
+		}
+		if !strings.HasSuffix(output, "left out)\n") {
+			t.Errorf("Expected the omitted files to be listed at the end of the output")
+		}
+	})
+
+	t.Run("jsonl", func(t *testing.T) {
+		outputFile := filepath.Join(t.TempDir(), "output.jsonl")
+		cfg := &config.Config{Format: config.FormatJSONL, Tokenizer: tokens.Heuristic, MaxTokens: budget, Priority: config.PriorityDepth, Overflow: config.OverflowDrop}
+		if _, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{Name: "repo"}, cfg); err != nil {
+			t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+		}
+
+		data, err := os.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if len(lines) != 4 {
+			t.Fatalf("Expected 3 file records and an omitted record, got:\n%s", data)
+		}
+		for _, line := range lines[:3] {
+			var record JSONFile
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("Line is not valid JSON: %v", err)
+			}
+			if record.Type != JSONLFileType {
+				t.Errorf("Expected file records to have type %q, got %s", JSONLFileType, line)
+			}
+			checkJSONFile(t, record, files[record.Path])
+		}
+		var omitted JSONLOmitted
+		if err := json.Unmarshal([]byte(lines[3]), &omitted); err != nil {
+			t.Fatalf("Last line is not valid JSON: %v", err)
+		}
+		expected := JSONOmittedFile{Path: "pkg/large.go", Tokens: count("pkg/large.go"), Reason: ReasonBudgetExceeded}
+		if omitted.Type != JSONLOmittedType || omitted.MaxTokens != budget || len(omitted.Files) != 1 || omitted.Files[0] != expected {
+			t.Errorf("Expected an omitted record listing %+v within %d tokens, got %s", expected, budget, lines[3])
+		}
+	})
+}
+
+// TestChunkSizerErrors verifies that formatter errors are returned by the chunk sizer and the
+// chunk planner instead of being counted as empty output.
+func TestChunkSizerErrors(t *testing.T) {
+	sizer := &chunkSizer{format: "unknown", unit: config.ChunkBytes, summary: &Summary{}}
+	e := entry{relPath: "main.go", content: []byte("package main\n")}
+
+	if _, err := sizer.file(e); err == nil {
+		t.Error("Expected file to return the formatter error")
+	}
+	if _, err := sizer.section("repo"); err == nil {
+		t.Error("Expected section to return the formatter error")
+	}
+	if _, err := splitEntry(e, sizer, nil, 100); err == nil {
+		t.Error("Expected splitEntry to return the formatter error")
+	}
+	if _, err := planChunks([]entry{e}, sizer, nil, "", 100); err == nil {
+		t.Error("Expected planChunks to return the formatter error")
+	}
+}
+
+// TestWriteRepoContentsToFileChunks verifies that the output is split into part files within the
+// chunk size, that only a file larger than a chunk is split, and that the index lists every chunk.
+func TestWriteRepoContentsToFileChunks(t *testing.T) {
+	repoDir := t.TempDir()
+
+	var large strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&large, "line %03d of the large file\n", i)
+	}
+	files := map[string]string{
+		"a.go":     strings.Repeat("// a\n", 40),
+		"b.go":     strings.Repeat("// b\n", 40),
+		"large.go": large.String(),
+		"z.go":     "package z\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(repoDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		unit   string
+		format string
+		size   int
+	}{
+		{config.ChunkBytes, config.FormatText, 1000},
+		{config.ChunkLines, config.FormatMarkdown, 60},
+		{config.ChunkTokens, config.FormatJSON, 400},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.unit, func(t *testing.T) {
+			outputDir := t.TempDir()
+			outputFile := filepath.Join(outputDir, "repo"+FileExtension(tc.format))
+			cfg := &config.Config{Format: tc.format, Tokenizer: tokens.Heuristic, Tree: true, ChunkSize: tc.size, ChunkUnit: tc.unit}
+			summary, err := WriteRepoContentsToFile(repoDir, outputFile, Metadata{Name: "repo"}, cfg)
+			if err != nil {
+				t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+			}
+			if len(summary.Chunks) < 2 {
+				t.Fatalf("Expected the output to be split into several chunks, got %+v", summary.Chunks)
+			}
+			if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+				t.Errorf("Expected no single output file to be written, got %v", err)
+			}
+
+			var packed []string
+			split := false
+			for i, chunk := range summary.Chunks {
+				if expected := filepath.Join(outputDir, fmt.Sprintf("repo.part-%03d%s", i+1, FileExtension(tc.format))); chunk.File != expected {
+					t.Errorf("Expected chunk %d to be written to %s, got %s", i+1, expected, chunk.File)
+				}
+				data, err := os.ReadFile(chunk.File)
+				if err != nil {
+					t.Fatalf("Failed to read chunk file: %v", err)
+				}
+				size := map[string]int{config.ChunkBytes: len(data), config.ChunkLines: countLines(data), config.ChunkTokens: chunk.Tokens}[tc.unit]
+				if size > tc.size {
+					t.Errorf("Expected %s to hold at most %d %s, got %d", chunk.File, tc.size, tc.unit, size)
+				}
+				for _, file := range chunk.Files {
+					if file.Parts > 0 && file.Path != "large.go" {
+						t.Errorf("Expected only large.go to be split, got %+v", file)
+					}
+					split = split || file.Parts > 1
+					if file.Part <= 1 {
+						packed = append(packed, file.Path)
+					}
+				}
+			}
+			if !split {
+				t.Errorf("Expected large.go to be split across chunks")
+			}
+			if strings.Join(packed, ",") != "a.go,b.go,large.go,z.go" {
+				t.Errorf("Expected every file to be packed once in order, got %v", packed)
+			}
+
+			index, err := os.ReadFile(summary.Index)
+			if err != nil {
+				t.Fatalf("Failed to read index file: %v", err)
+			}
+			for _, chunk := range summary.Chunks {
+				if !strings.Contains(string(index), filepath.Base(chunk.File)) {
+					t.Errorf("Expected the index to list %s, got:\n%s", chunk.File, index)
+				}
+			}
+			if tc.format == config.FormatJSON {
+				var doc JSONChunkIndex
+				if err := json.Unmarshal(index, &doc); err != nil {
+					t.Fatalf("Index is not valid JSON: %v\n%s", err, index)
+				}
+				if len(doc.Chunks) != len(summary.Chunks) || doc.Unit != tc.unit || doc.ChunkSize != tc.size {
+					t.Errorf("Expected the JSON index to describe %d chunks of %d %s, got %+v", len(summary.Chunks), tc.size, tc.unit, doc)
+				}
+			}
+		})
+	}
+
+	// A chunk size smaller than the document header cannot hold any file.
+	cfg := &config.Config{Format: config.FormatXML, ChunkSize: 10, ChunkUnit: config.ChunkBytes}
+	if _, err := WriteRepoContentsToFile(repoDir, filepath.Join(t.TempDir(), "repo.xml"), Metadata{Name: "repo"}, cfg); err == nil {
+		t.Errorf("Expected an error for a chunk size smaller than the header, got nil")
+	}
+}
+
+// TestWriteRepoContents verifies that writing to an io.Writer produces the same output as
+// writing to a file, and that chunked output is rejected.
+func TestWriteRepoContents(t *testing.T) {
+	repoDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repoDir, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("# Repo\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	meta := Metadata{Name: "repo", Source: "https://github.com/user/repo.git", Commit: "abc123"}
+	cfg := &config.Config{Format: config.FormatXML, Tokenizer: tokens.Heuristic, Tree: true}
+
+	outputFile := filepath.Join(t.TempDir(), "repo.xml")
+	if _, err := WriteRepoContentsToFile(repoDir, outputFile, meta, cfg); err != nil {
+		t.Fatalf("WriteRepoContentsToFile returned an error: %v", err)
+	}
+	expected, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	summary, err := WriteRepoContents(&buf, repoDir, meta, cfg)
+	if err != nil {
+		t.Fatalf("WriteRepoContents returned an error: %v", err)
+	}
+	if buf.String() != string(expected) {
+		t.Errorf("Expected the writer to receive the file output:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if len(summary.Files) != 2 {
+		t.Errorf("Expected 2 packed files, got %+v", summary.Files)
+	}
+
+	buf.Reset()
+	if _, err := WriteFiles(&buf, repoDir, []string{filepath.Join(repoDir, "main.go")}, meta, cfg); err != nil {
+		t.Fatalf("WriteFiles returned an error: %v", err)
+	}
+	if !strings.Contains(buf.String(), `<file path="main.go"`) || strings.Contains(buf.String(), `<file path="README.md"`) {
+		t.Errorf("Expected only main.go to be written, got:\n%s", buf.String())
+	}
+
+	cfg.ChunkSize, cfg.ChunkUnit = 1000, config.ChunkBytes
+	if _, err := WriteRepoContents(&buf, repoDir, meta, cfg); err == nil {
+		t.Errorf("Expected an error for chunked output to a writer, got nil")
+	}
+}
+
+// TestWriteSnapshot verifies that packing the tree of a commit from the object store produces
+// the same output as packing its checkout, including rules, subdirectories and file selection.
+//
+// The commit is made with the git binary, so the test is skipped when git is not installed.
+func TestWriteSnapshot(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("Skipping snapshot test; git is not installed")
+	}
+
+	repoDir := t.TempDir()
+	files := map[string]string{
+		"main.go":                       "package main\n",
+		"README.md":                     "# Repo\n",
+		".gitignore":                    "build/\n",
+		".gitattributes":                "*.pb.go linguist-generated\n",
+		"logo.png":                      "\x89PNG\x00\x00",
+		"api/api.pb.go":                 "package api\n",
+		"services/api/main.go":          "package main // api\n",
+		"services/api/.repototxtignore": "secret.txt\n",
+		"services/api/secret.txt":       "token\n",
+		"docs/guide.md":                 "# Guide\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{{"init", "-q"}, {"add", "."}, {"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "snapshot"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := TreeSnapshot(repo, head.Hash())
+	if err != nil {
+		t.Fatalf("TreeSnapshot returned an error: %v", err)
+	}
+
+	meta := Metadata{Name: "repo", Commit: head.Hash().String()}
+	testCases := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{"text", config.Config{Tree: true}},
+		{"json", config.Config{Format: config.FormatJSON, Tokenizer: tokens.Heuristic}},
+		{"subdirs", config.Config{Tree: true, Subdirs: []string{"services"}}},
+		{"budget by recency", config.Config{Tokenizer: tokens.Heuristic, MaxTokens: 10, Priority: config.PriorityRecency}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+			var expected, got bytes.Buffer
+			if _, err := WriteRepoContents(&expected, repoDir, meta, &cfg); err != nil {
+				t.Fatalf("WriteRepoContents returned an error: %v", err)
+			}
+			cfg = tc.cfg
+			if _, err := WriteSnapshot(&got, snap, meta, &cfg); err != nil {
+				t.Fatalf("WriteSnapshot returned an error: %v", err)
+			}
+			if got.String() != expected.String() {
+				t.Errorf("Expected the tree to be packed like its checkout:\n%s\ngot:\n%s", expected.String(), got.String())
+			}
+		})
+	}
+
+	cfg := &config.Config{}
+	matches, err := FindSnapshotFiles(snap, []string{"main.go"}, cfg)
+	if err != nil {
+		t.Fatalf("FindSnapshotFiles returned an error: %v", err)
+	}
+	if expected := []string{"main.go", "services/api/main.go"}; !reflect.DeepEqual(matches["main.go"], expected) {
+		t.Errorf("FindSnapshotFiles matched %v; want %v", matches["main.go"], expected)
+	}
+	var buf bytes.Buffer
+	if _, err := WriteSnapshotFiles(&buf, snap, matches["main.go"], meta, cfg); err != nil {
+		t.Fatalf("WriteSnapshotFiles returned an error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "=== services/api/main.go ===\npackage main // api\n") || strings.Contains(buf.String(), "README.md") {
+		t.Errorf("Expected only the matching files to be written, got:\n%s", buf.String())
+	}
+}
+
+// TestWriteCombined verifies that several repositories are packed into one document with a
+// section per repository and paths prefixed by the repository names, in every output format.
+func TestWriteCombined(t *testing.T) {
+	service, client := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		filepath.Join(service, "main.go"):         "package main\n",
+		filepath.Join(service, "README.md"):       "# Service\n",
+		filepath.Join(client, "client.go"):        "package client\n",
+		filepath.Join(client, "docs", "usage.md"): "# Usage\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repos := func() []Repository {
+		return []Repository{
+			{Snapshot: DirSnapshot(service), Meta: Metadata{Name: "service", Source: "https://github.com/user/service.git", Commit: "abc123"}, Config: &config.Config{}},
+			{Snapshot: DirSnapshot(client), Meta: Metadata{Name: "client", Source: client}, Paths: []string{"client.go"}, Config: &config.Config{}},
+		}
+	}
+	meta := Metadata{Name: "review", Source: "review.yaml"}
+	expected := []string{"service/README.md", "service/main.go", "client/client.go"}
+
+	for _, format := range []string{config.FormatText, config.FormatMarkdown, config.FormatXML, config.FormatJSON, config.FormatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			cfg := &config.Config{Format: format, Tokenizer: tokens.Heuristic, Tree: true}
+			var buf bytes.Buffer
+			summary, err := WriteCombined(&buf, repos(), meta, cfg)
+			if err != nil {
+				t.Fatalf("WriteCombined returned an error: %v", err)
+			}
+			var packed []string
+			for _, file := range summary.Files {
+				packed = append(packed, file.Path)
+			}
+			if !reflect.DeepEqual(packed, expected) {
+				t.Errorf("Expected files %v, got %v", expected, packed)
+			}
+			out := buf.String()
+			if strings.Contains(out, "usage.md") {
+				t.Errorf("Expected only the selected files of client to be packed, got:\n%s", out)
+			}
+
+			switch format {
+			case config.FormatXML:
+				decoder := xml.NewDecoder(strings.NewReader(out))
+				for {
+					if _, err := decoder.Token(); err != nil {
+						if err.Error() != "EOF" {
+							t.Errorf("Output is not well-formed XML: %v\n%s", err, out)
+						}
+						break
+					}
+				}
+				if strings.Count(out, "<repository ") != 2 || !strings.Contains(out, `<repositories name="review"`) {
+					t.Errorf("Expected a section per repository, got:\n%s", out)
+				}
+			case config.FormatJSON:
+				var doc JSONDocument
+				if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+					t.Fatalf("Output is not valid JSON: %v\n%s", err, out)
+				}
+				if len(doc.Repositories) != 2 || doc.Repositories[0].Commit != "abc123" || doc.Repository.Name != "review" {
+					t.Errorf("Expected the document to list both repositories, got %+v", doc)
+				}
+				if doc.Files[0].Repository != "service" || doc.Files[2].Repository != "client" {
+					t.Errorf("Expected the files to name their repository, got %+v", doc.Files)
+				}
+			case config.FormatJSONL:
+				if !strings.Contains(out, `"repository":"client"`) {
+					t.Errorf("Expected the records to name their repository, got:\n%s", out)
+				}
+			default:
+				if !strings.Contains(out, "abc123") || !strings.Contains(out, client) {
+					t.Errorf("Expected a section describing each repository, got:\n%s", out)
+				}
+				if strings.Index(out, "abc123") > strings.Index(out, "service/main.go") {
+					t.Errorf("Expected the service section before its files, got:\n%s", out)
+				}
+			}
+		})
+	}
+
+	// The token budget ranks the files of each repository as if it were packed alone, so the README
+	// at the root of a repository is kept although it is nested in the combined document.
+	cfg := &config.Config{Tokenizer: tokens.Heuristic, MaxTokens: 4}
+	summary, err := WriteCombined(&bytes.Buffer{}, repos()[:1], meta, cfg)
+	if err != nil {
+		t.Fatalf("WriteCombined returned an error: %v", err)
+	}
+	if len(summary.Files) != 1 || summary.Files[0].Path != "service/README.md" {
+		t.Errorf("Expected only the README to be kept within the budget, got %+v", summary.Files)
+	}
+
+	// Chunked output accounts for the repository sections.
+	outputDir := t.TempDir()
+	chunkCfg := &config.Config{Format: config.FormatXML, ChunkSize: 400, ChunkUnit: config.ChunkBytes}
+	summary, err = WriteCombinedToFile(repos(), filepath.Join(outputDir, "review.xml"), meta, chunkCfg)
+	if err != nil {
+		t.Fatalf("WriteCombinedToFile returned an error: %v", err)
+	}
+	if len(summary.Chunks) < 2 {
+		t.Errorf("Expected the output to be split into several chunks, got %+v", summary.Chunks)
+	}
+	for _, chunk := range summary.Chunks {
+		data, err := os.ReadFile(chunk.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > 400 {
+			t.Errorf("Expected %s to hold at most 400 bytes, got %d", chunk.File, len(data))
+		}
+	}
+
+	for _, invalid := range [][]Repository{
+		{{Snapshot: DirSnapshot(service), Meta: Metadata{Name: "repo"}, Config: &config.Config{}}, {Snapshot: DirSnapshot(client), Meta: Metadata{Name: "repo"}, Config: &config.Config{}}},
+		{{Snapshot: DirSnapshot(service), Meta: Metadata{Name: "a/b"}, Config: &config.Config{}}},
+		{{Snapshot: DirSnapshot(service), Meta: Metadata{Name: ".."}, Config: &config.Config{}}},
+	} {
+		if _, err := WriteCombined(&bytes.Buffer{}, invalid, meta, &config.Config{}); err == nil {
+			t.Errorf("Expected an error for repository names %q and %q", invalid[0].Meta.Name, invalid[len(invalid)-1].Meta.Name)
+		}
+	}
+}
+
+// TestWriteRepositoryIndex verifies that the index of the outputs written per repository lists
+// each output relative to the index, and the reason a repository failed.
+func TestWriteRepositoryIndex(t *testing.T) {
+	outputDir := t.TempDir()
+	outputs := []RepositoryOutput{
+		{Meta: Metadata{Name: "service", Source: "https://github.com/user/service.git", Commit: "abc123"}, File: filepath.Join(outputDir, "service.json"), Summary: &Summary{Tokenizer: tokens.Heuristic, Tokens: 42, Files: []FileSummary{{Path: "main.go"}}}},
+		{Meta: Metadata{Name: "client", Source: "https://github.com/user/client.git"}, Err: fmt.Errorf("repository not found")},
+	}
+
+	index := RepositoryIndexFile(outputDir, "review", config.FormatJSON)
+	if index != filepath.Join(outputDir, "review.index.json") {
+		t.Errorf("Expected the JSON index at review.index.json, got %s", index)
+	}
+	if err := WriteRepositoryIndex(index, config.FormatJSON, "review", outputs); err != nil {
+		t.Fatalf("WriteRepositoryIndex returned an error: %v", err)
+	}
+	data, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc JSONRepositoryIndex
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Index is not valid JSON: %v\n%s", err, data)
+	}
+	if doc.Name != "review" || doc.Tokens != 42 || len(doc.Repositories) != 2 {
+		t.Fatalf("Unexpected index %+v", doc)
+	}
+	if got := doc.Repositories[0]; got.Output != "service.json" || got.Files != 1 || got.Repository.Commit != "abc123" {
+		t.Errorf("Unexpected entry for service: %+v", got)
+	}
+	if got := doc.Repositories[1]; got.Output != "" || got.Error != "repository not found" {
+		t.Errorf("Unexpected entry for client: %+v", got)
+	}
+
+	index = RepositoryIndexFile(outputDir, "review", config.FormatText)
+	if err := WriteRepositoryIndex(index, config.FormatText, "review", outputs); err != nil {
+		t.Fatalf("WriteRepositoryIndex returned an error: %v", err)
+	}
+	data, err = os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"1 of 2 repositories packed", "- Output: service.json", "- Failed: repository not found"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected the index to contain %q, got:\n%s", want, data)
+		}
+	}
+
+	if err := WriteRepositoryIndex(index, config.FormatText, "review", nil); err == nil {
+		t.Errorf("Expected an error for an empty index, got nil")
+	}
+}
+
+// TestCollectEntriesLazy verifies that walking a repository records the size and line count of
+// larger files without holding their contents, which are read when the output is written.
+func TestCollectEntriesLazy(t *testing.T) {
+	repoDir := t.TempDir()
+	large := strings.Repeat("line of a larger file\n", 200) + "last line"
+	files := map[string]string{"large.go": large, "small.go": "package small\n"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(repoDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := collectEntries(DirSnapshot(repoDir), "", &config.Config{})
+	if err != nil {
+		t.Fatalf("collectEntries returned an error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].relPath != "large.go" {
+		t.Fatalf("Unexpected entries %+v", entries)
+	}
+	if e := entries[0]; e.content != nil || e.load == nil || e.size != int64(len(large)) || e.lines != 201 {
+		t.Errorf("Expected large.go to be described without its content, got size %d, %d lines, content held %v", e.size, e.lines, e.content != nil)
+	}
+	if e := entries[1]; string(e.content) != files["small.go"] || e.lines != 1 {
+		t.Errorf("Expected small.go to be held with 1 line, got %q, %d lines", e.content, e.lines)
+	}
+
+	for _, cfg := range []*config.Config{{Tree: true}, {Tokenizer: tokens.Heuristic, MaxTokens: 400, Overflow: config.OverflowTruncate}} {
+		var buf bytes.Buffer
+		summary, err := writeEntriesTo(&buf, entries, Metadata{Name: "repo"}, cfg)
+		if err != nil {
+			t.Fatalf("writeEntriesTo returned an error: %v", err)
+		}
+		if cfg.MaxTokens == 0 && !strings.Contains(buf.String(), large) {
+			t.Errorf("Expected the whole content of large.go to be written, got:\n%s", buf.String())
+		}
+		if cfg.MaxTokens > 0 && (summary.Tokens > cfg.MaxTokens || !strings.Contains(buf.String(), "[... truncated:") || strings.Contains(buf.String(), "last line")) {
+			t.Errorf("Expected large.go to be truncated to the budget, got %d tokens:\n%s", summary.Tokens, buf.String())
+		}
+	}
+}
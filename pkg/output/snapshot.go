@@ -0,0 +1,98 @@
+package output
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/archive"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
+)
+
+// Snapshot is the file system of a repository snapshot that is packed into the output: a
+// directory on disk, or the tree of a commit read straight from the git object store.
+type Snapshot struct {
+	FS    fs.FS                                // Files of the snapshot, rooted at the repository root
+	Dir   string                               // Directory the snapshot is on disk; empty if it is not on disk
+	Times func() (map[string]time.Time, error) // Last commit time of every file, used to order files by recency; nil if unknown
+}
+
+// DirSnapshot returns the snapshot of a directory on disk. Files are dated by the history of
+// the repository when the directory is the root of one.
+//
+// Parameters:
+//   - dir: The local path of the repository.
+//
+// Returns:
+//   - Snapshot: The snapshot of the directory.
+func DirSnapshot(dir string) Snapshot {
+	return Snapshot{
+		FS:    os.DirFS(dir),
+		Dir:   dir,
+		Times: func() (map[string]time.Time, error) { return clone.LastCommitTimes(dir) },
+	}
+}
+
+// TreeSnapshot returns the snapshot of the tree of a commit. File contents are read from the
+// object store of the repository, so no worktree is needed. Git LFS objects are not available,
+// so LFS pointer files are reported as missing in config.LFSResolve mode.
+//
+// Parameters:
+//   - repo: The repository holding the commit, e.g. an in-memory clone.
+//   - commit: The commit whose tree is packed.
+//
+// Returns:
+//   - Snapshot: The snapshot of the tree.
+//   - error: An error if the commit or its tree cannot be read.
+func TreeSnapshot(repo *git.Repository, commit plumbing.Hash) (Snapshot, error) {
+	fsys, err := clone.NewTreeFS(repo, commit)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{
+		FS:    fsys,
+		Times: func() (map[string]time.Time, error) { return clone.LastCommitTimesAt(repo, commit) },
+	}, nil
+}
+
+// ArchiveSnapshot returns the snapshot of an opened archive. A git bundle is packed like an
+// in-memory clone of the commit it resolved to; the files of tarballs and zip archives are
+// dated by the modification times recorded in the archive.
+//
+// Parameters:
+//   - a: The opened archive, which must stay open until the snapshot is written.
+//
+// Returns:
+//   - Snapshot: The snapshot of the archive.
+//   - error: An error if the commit of a bundle or its tree cannot be read.
+func ArchiveSnapshot(a *archive.Archive) (Snapshot, error) {
+	if a.Repo != nil {
+		return TreeSnapshot(a.Repo, a.Commit)
+	}
+	return Snapshot{FS: a.FS}, nil
+}
+
+// diskPath returns the file system path of a file of the snapshot.
+//
+// Parameters:
+//   - relPath: The slash-separated path of the file relative to the repository root.
+//
+// Returns:
+//   - string: The path on disk; empty if the snapshot is not on disk.
+func (s Snapshot) diskPath(relPath string) string {
+	if s.Dir == "" {
+		return ""
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(relPath))
+}
+
+// location describes where the snapshot is read from, for error messages.
+func (s Snapshot) location() string {
+	if s.Dir == "" {
+		return "the repository"
+	}
+	return s.Dir
+}
@@ -0,0 +1,96 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+)
+
+// walkRoots returns the directories to walk: the subdirectories the configuration limits the
+// snapshot to, or the repository root.
+//
+// Parameters:
+//   - snap: The repository snapshot.
+//   - cfg: A pointer to the Config struct containing the subdirectories.
+//
+// Returns:
+//   - []string: The slash-separated paths of the directories to walk, relative to the repository root.
+//   - error: An error if a subdirectory does not exist or is not a directory.
+func walkRoots(snap Snapshot, cfg *config.Config) ([]string, error) {
+	if len(cfg.Subdirs) == 0 {
+		return []string{"."}, nil
+	}
+	for _, dir := range cfg.Subdirs {
+		var info fs.FileInfo
+		var err error
+		if root := snap.diskPath(dir); root != "" {
+			info, err = os.Lstat(root)
+		} else {
+			info, err = fs.Stat(snap.FS, dir)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("subdirectory %s not found in %s", dir, snap.location())
+		} else if err != nil {
+			return nil, fmt.Errorf("error accessing subdirectory %s: %w", dir, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("subdirectory %s is not a directory", dir)
+		}
+	}
+	return cfg.Subdirs, nil
+}
+
+// repoFS returns the file system of the repository that ignore and attribute files are read
+// from. When the configuration limits the snapshot to subdirectories, directories outside them
+// and their parents are hidden, so that the rest of a large repository is not traversed.
+//
+// Parameters:
+//   - snap: The repository snapshot.
+//   - cfg: A pointer to the Config struct containing the subdirectories.
+//
+// Returns:
+//   - fs.FS: The file system rooted at the repository root.
+func repoFS(snap Snapshot, cfg *config.Config) fs.FS {
+	if len(cfg.Subdirs) == 0 {
+		return snap.FS
+	}
+	return subdirFS{FS: snap.FS, subdirs: cfg.Subdirs}
+}
+
+// subdirFS is a file system whose directory listings only contain the directories inside or
+// leading to a set of subdirectories. Files can still be opened by name.
+type subdirFS struct {
+	fs.FS
+	subdirs []string // Slash-separated directories relative to the root
+}
+
+// ReadDir lists the directory, leaving out the directories outside the subdirectories.
+func (f subdirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.FS, name)
+	if err != nil || clone.InSubdirs(name, f.subdirs) {
+		return entries, err
+	}
+	kept := entries[:0]
+	for _, e := range entries {
+		p := path.Join(name, e.Name())
+		if !e.IsDir() || clone.InSubdirs(p, f.subdirs) || f.leadsToSubdir(p) {
+			kept = append(kept, e)
+		}
+	}
+	return kept, nil
+}
+
+// leadsToSubdir reports whether the directory is a parent of one of the subdirectories.
+func (f subdirFS) leadsToSubdir(dir string) bool {
+	for _, subdir := range f.subdirs {
+		if len(subdir) > len(dir) && subdir[:len(dir)+1] == dir+"/" {
+			return true
+		}
+	}
+	return false
+}
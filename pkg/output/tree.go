@@ -0,0 +1,112 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/util"
+)
+
+// Connectors used to draw the directory tree.
+const (
+	treeBranch     = "├── "
+	treeLastBranch = "└── "
+	treeIndent     = "│   "
+	treeLastIndent = "    "
+)
+
+// treeNode is a file or directory in the directory tree.
+type treeNode struct {
+	name     string
+	entry    *entry // Entry for files and skipped directories; nil for directories that were walked
+	children []*treeNode
+}
+
+// renderTree draws the entries as an indented directory tree in the style of the tree command.
+// Packed files are annotated with their line count and size, binary files and Git LFS pointers
+// with their size, and excluded or omitted files and directories with the reason they were left out.
+//
+// Parameters:
+//   - rootName: The name shown for the repository root.
+//   - entries: The entries found while walking the repository.
+//
+// Returns:
+//   - string: The rendered tree, ending with a newline.
+func renderTree(rootName string, entries []entry) string {
+	if rootName == "" {
+		rootName = "."
+	}
+	root := &treeNode{name: rootName}
+	for i := range entries {
+		root.insert(strings.Split(entries[i].relPath, "/"), &entries[i])
+	}
+
+	var b strings.Builder
+	b.WriteString(rootName + "/\n")
+	root.render(&b, "")
+	return b.String()
+}
+
+// insert adds an entry to the tree below the node, creating intermediate directories as needed.
+func (n *treeNode) insert(parts []string, e *entry) {
+	for _, child := range n.children {
+		if child.name == parts[0] && child.entry == nil && len(parts) > 1 {
+			child.insert(parts[1:], e)
+			return
+		}
+	}
+	child := &treeNode{name: parts[0]}
+	n.children = append(n.children, child)
+	if len(parts) == 1 {
+		child.entry = e
+		return
+	}
+	child.insert(parts[1:], e)
+}
+
+// render writes the children of the node, prefixing each line with the given indentation.
+func (n *treeNode) render(b *strings.Builder, prefix string) {
+	sort.SliceStable(n.children, func(i, j int) bool {
+		return n.children[i].name < n.children[j].name
+	})
+	for i, child := range n.children {
+		connector, indent := treeBranch, treeIndent
+		if i == len(n.children)-1 {
+			connector, indent = treeLastBranch, treeLastIndent
+		}
+		b.WriteString(prefix + connector + child.label() + "\n")
+		child.render(b, prefix+indent)
+	}
+}
+
+// label returns the name of the node followed by its annotation.
+func (n *treeNode) label() string {
+	e := n.entry
+	switch {
+	case e == nil:
+		return n.name + "/"
+	case e.isDir:
+		return fmt.Sprintf("%s/ [excluded: %s]", n.name, e.reason)
+	case e.omitted:
+		return fmt.Sprintf("%s [omitted: %s]", n.name, e.reason)
+	case e.binary:
+		return fmt.Sprintf("%s [binary, %s]", n.name, util.FormatSize(e.size))
+	case e.lfs:
+		return fmt.Sprintf("%s [Git LFS object, %s, not packed]", n.name, util.FormatSize(e.size))
+	case e.reason != "":
+		return fmt.Sprintf("%s [excluded: %s]", n.name, e.reason)
+	case e.truncated:
+		return fmt.Sprintf("%s (%s, %s, truncated)", n.name, pluralize(e.lines, "line"), util.FormatSize(e.size))
+	default:
+		return fmt.Sprintf("%s (%s, %s)", n.name, pluralize(e.lines, "line"), util.FormatSize(e.size))
+	}
+}
+
+// pluralize formats a count followed by a noun, adding an "s" unless the count is one.
+func pluralize(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
@@ -6,7 +6,6 @@ package prompt
 import (
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -14,31 +13,49 @@ import (
 	"strings"
 
 	"github.com/charmbracelet/huh"
+	"github.com/mattn/go-isatty"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/auth"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/util"
+	"golang.org/x/crypto/ssh"
 )
 
 // ErrEmptyInput is returned when the user provides an empty input for a required field.
 var ErrEmptyInput = errors.New("input cannot be empty")
 
+// ErrMissingInputs is returned in non-interactive mode when required inputs were not provided.
+var ErrMissingInputs = errors.New("missing required inputs for non-interactive mode")
+
+// StdinIsTerminal reports whether standard input is an interactive terminal that prompts can read from.
+func StdinIsTerminal() bool {
+	return isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())
+}
+
 // PromptForMissingInputs prompts the user interactively for any missing configuration inputs.
 // It updates the provided Config struct with the collected inputs.
+// In non-interactive mode nothing is prompted: optional inputs get their defaults and an error
+// wrapping ErrMissingInputs lists every required input that is missing.
 //
 // Parameters:
 //   - cfg: A pointer to the Config struct to be populated.
 //
 // Returns:
 //   - error: An error if prompting fails or input validation fails.
-//
-// PromptForMissingInputs prompts the user interactively for any missing configuration inputs.
-// It updates the provided Config struct with the collected inputs.
 func PromptForMissingInputs(cfg *config.Config) error {
+	if cfg.NonInteractive {
+		if err := applyDefaults(cfg); err != nil {
+			return err
+		}
+		return ensureOutputDir(cfg)
+	}
+
 	// Prompt for repository URL if not provided
 	if cfg.RepoURL == "" {
 		repoForm := huh.NewForm(
 			huh.NewGroup(
 				huh.NewInput().
-					Title("GitHub repository URL (HTTPS or SSH)").
+					Title("Git repository URL (HTTPS, SSH, git:// or file://)").
 					Value(&cfg.RepoURL).
 					Validate(validateRepoURL),
 			),
@@ -51,49 +68,59 @@ func PromptForMissingInputs(cfg *config.Config) error {
 
 	// Prompt for authentication method if not set via flag
 	if !cfg.AuthFlagSet {
+		repoURL, err := clone.ParseRepoURL(cfg.RepoURL)
+		if err != nil {
+			return err
+		}
+
 		var authOptions []huh.Option[config.AuthMethod]
-		if isHTTPSURL(cfg.RepoURL) {
+		switch {
+		case repoURL.IsHTTP():
 			authOptions = []huh.Option[config.AuthMethod]{
 				huh.NewOption("No Authentication", config.AuthMethodNone),
-				huh.NewOption("HTTPS with PAT", config.AuthMethodHTTPS),
+				huh.NewOption("HTTPS with username and token", config.AuthMethodHTTPS),
 			}
-		} else if isSSHURL(cfg.RepoURL) {
+		case repoURL.IsSSH():
 			authOptions = []huh.Option[config.AuthMethod]{
 				huh.NewOption("SSH Authentication", config.AuthMethodSSH),
 			}
-		} else {
-			return errors.New("unsupported repository URL format for authentication options")
 		}
 
-		authForm := huh.NewForm(
-			huh.NewGroup(
-				huh.NewSelect[config.AuthMethod]().
-					Title("Select authentication method").
-					Options(authOptions...).
-					Value(&cfg.AuthMethod),
-			),
-		)
-		err := authForm.Run()
-		if err != nil {
-			return fmt.Errorf("authentication method input error: %w", err)
+		// git:// and file:// URLs never authenticate, so there is nothing to choose from
+		if len(authOptions) == 0 {
+			cfg.AuthMethod = config.AuthMethodNone
+		} else {
+			authForm := huh.NewForm(
+				huh.NewGroup(
+					huh.NewSelect[config.AuthMethod]().
+						Title("Select authentication method").
+						Options(authOptions...).
+						Value(&cfg.AuthMethod),
+				),
+			)
+			if err := authForm.Run(); err != nil {
+				return fmt.Errorf("authentication method input error: %w", err)
+			}
 		}
 	}
 
 	// Prompt for additional authentication details based on the selected method
+	hints := authHintsFor(cfg.RepoURL)
 	switch cfg.AuthMethod {
 	case config.AuthMethodHTTPS:
-		if cfg.Username == "" || cfg.PersonalAccessToken == "" {
+		if !useStoredCredentials(cfg, true) {
 			httpsForm := huh.NewForm(
 				huh.NewGroup(
 					huh.NewInput().
-						Title("GitHub username").
-						Value(&cfg.Username).
-						Validate(nonEmptyValidator("GitHub username")),
+						Title(hints.username).
+						Description("Leave empty to authenticate with the token alone").
+						Value(&cfg.Username),
 					huh.NewInput().
-						Title("GitHub Personal Access Token").
+						Title(hints.token).
+						Description(hints.tokenHelp).
 						Value(&cfg.PersonalAccessToken).
 						Password(true).
-						Validate(nonEmptyValidator("Personal Access Token")),
+						Validate(nonEmptyValidator(hints.token)),
 				),
 			)
 			err := httpsForm.Run()
@@ -103,17 +130,16 @@ func PromptForMissingInputs(cfg *config.Config) error {
 		}
 	case config.AuthMethodSSH:
 		if cfg.SSHKeyPath == "" {
-			defaultSSHKey := DefaultSSHKeyPath()
 			sshForm := huh.NewForm(
 				huh.NewGroup(
 					huh.NewInput().
-						Title("Path to SSH private key").
+						Title("Path to SSH private key (leave empty to use ssh-agent and ~/.ssh)").
+						Description(hints.sshHelp).
 						Value(&cfg.SSHKeyPath).
-						Placeholder(defaultSSHKey).
+						Placeholder("ssh-agent, IdentityFile or ~/.ssh/id_ed25519, id_ecdsa, id_rsa").
 						Validate(func(s string) error {
 							trimmed := strings.TrimSpace(s)
 							if trimmed == "" {
-								cfg.SSHKeyPath = defaultSSHKey
 								return nil
 							}
 							if _, err := os.Stat(trimmed); os.IsNotExist(err) {
@@ -127,9 +153,10 @@ func PromptForMissingInputs(cfg *config.Config) error {
 			if err != nil {
 				return fmt.Errorf("SSH key path input error: %w", err)
 			}
+			cfg.SSHKeyPath = strings.TrimSpace(cfg.SSHKeyPath)
 		}
 
-		if isSSHKeyPassphraseProtected(cfg.SSHKeyPath) && cfg.SSHPassphrase == "" {
+		if cfg.SSHKeyPath != "" && isSSHKeyPassphraseProtected(cfg.SSHKeyPath) && cfg.SSHPassphrase == "" {
 			passphraseForm := huh.NewForm(
 				huh.NewGroup(
 					huh.NewInput().
@@ -145,8 +172,47 @@ func PromptForMissingInputs(cfg *config.Config) error {
 		}
 	}
 
+	if err := promptForOutputs(cfg); err != nil {
+		return err
+	}
+	return ensureOutputDir(cfg)
+}
+
+// PromptForRunInputs prompts for the inputs shared by the repositories of a run packing several:
+// the output directory, exclusions, file names and clipboard. The repositories themselves come
+// from -repo flags or a manifest and are authenticated without prompting. In non-interactive mode
+// nothing is prompted, the output directory defaults to the Downloads directory and nothing is
+// copied to the clipboard.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct to be populated.
+//
+// Returns:
+//   - error: An error if prompting fails or the output directory cannot be created.
+func PromptForRunInputs(cfg *config.Config) error {
+	if cfg.NonInteractive {
+		if cfg.OutputDir == "" && cfg.Output == "" {
+			cfg.OutputDir = defaultDownloadsPath()
+		}
+		return ensureOutputDir(cfg)
+	}
+	if err := promptForOutputs(cfg); err != nil {
+		return err
+	}
+	return ensureOutputDir(cfg)
+}
+
+// promptForOutputs prompts for the output directory, exclusions, file names and clipboard, unless
+// they were already given.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct to be populated.
+//
+// Returns:
+//   - error: An error if prompting fails.
+func promptForOutputs(cfg *config.Config) error {
 	// Prompt for output configuration if not provided
-	if cfg.OutputDir == "" {
+	if cfg.OutputDir == "" && cfg.Output == "" {
 		var excludeFolders, includeExt string
 		defaultOutputDir := defaultDownloadsPath()
 		outputForm := huh.NewForm(
@@ -164,7 +230,7 @@ func PromptForMissingInputs(cfg *config.Config) error {
 						return nil
 					}),
 				huh.NewInput().
-					Title("Folders to exclude (comma-separated, leave empty to include all)").
+					Title("Folders or glob patterns to exclude (comma-separated, leave empty to include all)").
 					Value(&excludeFolders),
 				huh.NewInput().
 					Title("File extensions to include (comma-separated, leave empty to include all)").
@@ -176,7 +242,7 @@ func PromptForMissingInputs(cfg *config.Config) error {
 			return fmt.Errorf("output configuration input error: %w", err)
 		}
 
-		cfg.ExcludeFolders = util.ParseCommaSeparated(excludeFolders)
+		cfg.ExcludeFolders = config.ParsePatternList(excludeFolders)
 		cfg.IncludeExt = util.ParseCommaSeparated(includeExt)
 
 		// Logging for debugging
@@ -216,6 +282,87 @@ func PromptForMissingInputs(cfg *config.Config) error {
 		}
 	}
 
+	return nil
+}
+
+// applyDefaults fills in the inputs that would otherwise be prompted for, for non-interactive mode.
+// SSH URLs use SSH authentication, with ssh-agent and the keys in ~/.ssh unless -ssh-key is given,
+// the output directory defaults to the Downloads directory, all files are packed and nothing is
+// copied to the clipboard.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct to be populated.
+//
+// Returns:
+//   - error: An error wrapping ErrMissingInputs listing the missing required inputs, or an error if the URL is invalid.
+func applyDefaults(cfg *config.Config) error {
+	var missing []string
+	if cfg.RepoURL == "" {
+		missing = append(missing, "-repo (repository URL)")
+	} else if repoURL, err := clone.ParseRepoURL(cfg.RepoURL); err != nil {
+		return err
+	} else if !cfg.AuthFlagSet && repoURL.IsSSH() {
+		cfg.AuthMethod = config.AuthMethodSSH
+	} else if !cfg.AuthFlagSet && repoURL.IsHTTP() && useStoredCredentials(cfg, false) {
+		// A token in the environment or ~/.netrc is meant to be used without asking
+		cfg.AuthMethod = config.AuthMethodHTTPS
+	}
+	switch cfg.AuthMethod {
+	case config.AuthMethodHTTPS:
+		if !useStoredCredentials(cfg, true) {
+			hints := authHintsFor(cfg.RepoURL)
+			missing = append(missing, fmt.Sprintf("-pat or %s (%s, required for HTTPS authentication; %s)", auth.TokenEnvVar, hints.token, hints.tokenHelp))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingInputs, strings.Join(missing, ", "))
+	}
+
+	if cfg.OutputDir == "" && cfg.Output == "" {
+		cfg.OutputDir = defaultDownloadsPath()
+	}
+	return nil
+}
+
+// useStoredCredentials fills in the HTTPS token, and the username if none was given, from the
+// environment, ~/.netrc or, if useHelper is set, the git credential helpers. Where the token came
+// from is logged; the token itself never is.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct to be populated.
+//   - useHelper: Whether to ask git credential helpers.
+//
+// Returns:
+//   - bool: True if a token is configured, whether given explicitly or found.
+func useStoredCredentials(cfg *config.Config, useHelper bool) bool {
+	if cfg.PersonalAccessToken != "" {
+		return true
+	}
+	creds := auth.LookupCredentials(cfg.RepoURL, cfg.Username, useHelper)
+	if creds == nil {
+		return false
+	}
+	cfg.PersonalAccessToken = creds.Token
+	if cfg.Username == "" {
+		cfg.Username = creds.Username
+	}
+	log.Printf("Using HTTPS %s", creds)
+	return true
+}
+
+// ensureOutputDir creates the configured output directory if it does not exist.
+//
+// Parameters:
+//   - cfg: A pointer to the Config struct holding the output directory.
+//
+// Returns:
+//   - error: An error if the directory cannot be created.
+func ensureOutputDir(cfg *config.Config) error {
+	if cfg.OutputDir == "" {
+		return nil
+	}
+
 	// Logging for debugging
 	log.Printf("Final OutputDir: %s", cfg.OutputDir)
 
@@ -223,10 +370,44 @@ func PromptForMissingInputs(cfg *config.Config) error {
 	if err := os.MkdirAll(cfg.OutputDir, os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
-
 	return nil
 }
 
+// ResolveMatches decides which of the files matching a file name to include, following the
+// configured policy for multiple matches. A single match is always included.
+//
+// Parameters:
+//   - fileName: The file name that was searched for.
+//   - matches: The paths of the files matching the name, in path order.
+//   - cfg: A pointer to the Config struct holding the policy.
+//
+// Returns:
+//   - []string: The paths of the files to include.
+//   - error: An error if the policy rejects multiple matches or the selection fails.
+func ResolveMatches(fileName string, matches []string, cfg *config.Config) ([]string, error) {
+	if len(matches) <= 1 {
+		return matches, nil
+	}
+
+	switch cfg.MatchPolicy() {
+	case config.MatchAll:
+		return matches, nil
+	case config.MatchFirst:
+		return matches[:1], nil
+	case config.MatchError:
+		return nil, fmt.Errorf("file name %s matches %d files (%s): use -multiple-matches all or first to include them", fileName, len(matches), strings.Join(matches, ", "))
+	}
+
+	if cfg.NonInteractive {
+		return nil, fmt.Errorf("file name %s matches %d files and cannot be prompted for in non-interactive mode: use -multiple-matches all, first or error", fileName, len(matches))
+	}
+	selected, err := SelectFile(fileName, matches)
+	if err != nil {
+		return nil, err
+	}
+	return []string{selected}, nil
+}
+
 // SelectFile prompts the user to select a file from multiple matches for a given file name.
 //
 // Parameters:
@@ -237,11 +418,12 @@ func PromptForMissingInputs(cfg *config.Config) error {
 //   - string: The selected file path.
 //   - error: An error if the selection fails.
 func SelectFile(fileName string, matches []string) (string, error) {
-	fmt.Printf("Multiple matches found for file '%s':\n", fileName)
+	// Print to standard error so that standard output stays free for the packed output
+	fmt.Fprintf(os.Stderr, "Multiple matches found for file '%s':\n", fileName)
 	for i, match := range matches {
-		fmt.Printf("  %d) %s\n", i+1, match)
+		fmt.Fprintf(os.Stderr, "  %d) %s\n", i+1, match)
 	}
-	fmt.Printf("Select the number of the file you want to include (1-%d): ", len(matches))
+	fmt.Fprintf(os.Stderr, "Select the number of the file you want to include (1-%d): ", len(matches))
 
 	var choice int
 	_, err := fmt.Scanf("%d\n", &choice)
@@ -256,8 +438,9 @@ func SelectFile(fileName string, matches []string) (string, error) {
 	return matches[choice-1], nil
 }
 
-// validateRepoURL validates the format of the provided GitHub repository URL.
-// It ensures that the URL is either a valid HTTPS or SSH GitHub repository URL.
+// validateRepoURL validates the format of the provided repository URL.
+// Any Git host is accepted over HTTPS, HTTP, SSH (including SCP-like URLs such as
+// git@host:group/repo.git), git:// and file://.
 //
 // Parameters:
 //   - repoURL: The repository URL to validate.
@@ -269,33 +452,82 @@ func validateRepoURL(repoURL string) error {
 		return errors.New("repository URL cannot be empty")
 	}
 
-	if isHTTPSURL(repoURL) || isSSHURL(repoURL) {
-		return nil
+	u, err := clone.ParseRepoURL(repoURL)
+	if err != nil {
+		return fmt.Errorf("%w (e.g. https://host/owner/repo.git, git@host:owner/repo.git or ssh://user@host:port/owner/repo.git)", err)
 	}
-
-	return errors.New("URL must be either HTTPS (https://github.com/user/repo) or SSH (git@github.com:user/repo) format")
+	if _, err := u.Name(); err != nil {
+		return err
+	}
+	return nil
 }
 
-// isHTTPSURL checks if the provided URL is an HTTPS GitHub repository URL.
-//
-// Parameters:
-//   - url: The repository URL to check.
-//
-// Returns:
-//   - bool: True if the URL starts with "https://github.com/", false otherwise.
-func isHTTPSURL(url string) bool {
-	return strings.HasPrefix(url, "https://github.com/")
+// authHints holds the host-specific titles and help texts shown when prompting for credentials.
+type authHints struct {
+	username  string // Title of the username input
+	token     string // Title of the token input
+	tokenHelp string // How to create a token accepted for HTTPS clones
+	sshHelp   string // Where the SSH public key must be registered
 }
 
-// isSSHURL checks if the provided URL is an SSH GitHub repository URL.
+// authHintsFor returns the credential prompts for the Git host serving the repository.
+// Unrecognised and self-hosted servers get generic hints.
 //
 // Parameters:
-//   - url: The repository URL to check.
+//   - repoURL: The repository URL.
 //
 // Returns:
-//   - bool: True if the URL starts with "git@github.com:", false otherwise.
-func isSSHURL(url string) bool {
-	return strings.HasPrefix(url, "git@github.com:")
+//   - authHints: The titles and help texts for the host.
+func authHintsFor(repoURL string) authHints {
+	provider := clone.ProviderGeneric
+	if u, err := clone.ParseRepoURL(repoURL); err == nil {
+		provider = u.Provider()
+	}
+
+	switch provider {
+	case clone.ProviderGitHub:
+		return authHints{
+			username:  "GitHub username",
+			token:     "GitHub Personal Access Token",
+			tokenHelp: "Create a token with the repo scope (or Contents: Read) under Settings > Developer settings",
+			sshHelp:   "The public key must be added under Settings > SSH and GPG keys",
+		}
+	case clone.ProviderGitLab:
+		return authHints{
+			username:  "GitLab username",
+			token:     "GitLab Personal Access Token",
+			tokenHelp: "Create a personal, project or group access token with the read_repository scope",
+			sshHelp:   "The public key must be added under Preferences > SSH Keys",
+		}
+	case clone.ProviderBitbucket:
+		return authHints{
+			username:  "Bitbucket username (not your email address)",
+			token:     "Bitbucket app password",
+			tokenHelp: "Create an app password with the Repositories: Read permission under Personal settings > App passwords",
+			sshHelp:   "The public key must be added under Personal settings > SSH keys",
+		}
+	case clone.ProviderAzure:
+		return authHints{
+			username:  "Azure DevOps username (any non-empty value)",
+			token:     "Azure DevOps Personal Access Token",
+			tokenHelp: "Create a token with the Code (Read) scope under User settings > Personal access tokens",
+			sshHelp:   "The public key must be added under User settings > SSH public keys",
+		}
+	case clone.ProviderGitea:
+		return authHints{
+			username:  "Gitea username",
+			token:     "Gitea access token",
+			tokenHelp: "Create a token with the read:repository scope under Settings > Applications",
+			sshHelp:   "The public key must be added under Settings > SSH / GPG Keys",
+		}
+	default:
+		return authHints{
+			username:  "Username",
+			token:     "Access token or password",
+			tokenHelp: "Use a token or password the Git server accepts for HTTPS clones",
+			sshHelp:   "The public key must be authorised on the Git server",
+		}
+	}
 }
 
 // nonEmptyValidator returns a validator function that ensures the input string is not empty.
@@ -356,7 +588,8 @@ func DefaultSSHKeyPath() string {
 }
 
 // isSSHKeyPassphraseProtected checks if the SSH key at the given path is protected by a passphrase.
-// It does this by looking for the "ENCRYPTED" keyword in the key file.
+// It does this by looking for the "ENCRYPTED" keyword of PEM keys, or by parsing the key, since
+// encrypted OpenSSH keys do not carry the keyword.
 //
 // Parameters:
 //   - keyPath: The file system path to the SSH private key.
@@ -364,19 +597,16 @@ func DefaultSSHKeyPath() string {
 // Returns:
 //   - bool: True if the key is passphrase protected, false otherwise.
 func isSSHKeyPassphraseProtected(keyPath string) bool {
-	file, err := os.Open(keyPath)
+	data, err := os.ReadFile(keyPath)
 	if err != nil {
 		return false
 	}
-	defer file.Close()
-
-	// Attempt to read the first few bytes to check for encryption.
-	buf := make([]byte, 100)
-	n, err := file.Read(buf)
-	if err != nil && err != io.EOF {
-		return false
+	// PEM headers come first, so only the beginning of the file is checked
+	if strings.Contains(string(data[:min(len(data), 100)]), "ENCRYPTED") {
+		return true
 	}
 
-	content := string(buf[:n])
-	return strings.Contains(content, "ENCRYPTED")
+	_, err = ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	return errors.As(err, &missing)
 }
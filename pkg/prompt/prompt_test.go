@@ -2,8 +2,11 @@
 package prompt
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
@@ -19,11 +22,17 @@ func TestValidateRepoURL(t *testing.T) {
 	}{
 		{"Valid HTTPS URL", "https://github.com/user/repo.git", false},
 		{"Valid SSH URL", "git@github.com:user/repo.git", false},
-		{"Invalid HTTP URL", "http://github.com/user/repo.git", false}, // Allowed as per isHTTPSURL and isSSHURL
+		{"HTTP URL", "http://github.com/user/repo.git", false},
 		{"Invalid FTP URL", "ftp://github.com/user/repo.git", true},
 		{"Empty URL", "", true},
 		{"HTTPS without .git", "https://github.com/user/repo", false},
 		{"SSH without .git", "git@github.com:user/repo", false},
+		{"Self-hosted GitLab with nested groups", "https://gitlab.example.com/group/sub/repo.git", false},
+		{"SSH URL with user and port", "ssh://git@gitea.example.com:2222/owner/repo.git", false},
+		{"Git protocol", "git://git.example.com/repo.git", false},
+		{"File URL", "file:///srv/git/repo.git", false},
+		{"Azure DevOps", "https://dev.azure.com/org/project/_git/repo", false},
+		{"Host without repository", "https://github.com/", true},
 	}
 
 	for _, tt := range tests {
@@ -93,14 +102,87 @@ func TestDefaultDownloadsPath(t *testing.T) {
 	}
 }
 
-// TestPromptForMissingInputs is a placeholder for testing the PromptForMissingInputs function.
-// In a real scenario, you would mock the huh library to simulate user input.
+// TestPromptForMissingInputs verifies that non-interactive mode applies defaults for optional
+// inputs and lists every missing required input instead of prompting.
 func TestPromptForMissingInputs(t *testing.T) {
-	// This is a basic test structure. In a real scenario, you'd need to mock the huh library,
-	// which is beyond the scope of this example. Here's a simplified version:
-	cfg := &config.Config{}
-	err := PromptForMissingInputs(cfg)
-	if err != nil {
-		t.Fatalf("PromptForMissingInputs() error = %v", err)
+	t.Run("defaults", func(t *testing.T) {
+		outputDir := filepath.Join(t.TempDir(), "out")
+		cfg := &config.Config{
+			RepoURL:        "git@github.com:user/repo.git",
+			OutputDir:      outputDir,
+			NonInteractive: true,
+		}
+		if err := PromptForMissingInputs(cfg); err != nil {
+			t.Fatalf("PromptForMissingInputs() error = %v", err)
+		}
+		if cfg.AuthMethod != config.AuthMethodSSH || cfg.SSHKeyPath != "" {
+			t.Errorf("Expected SSH authentication with discovered keys, got %v %q", cfg.AuthMethod, cfg.SSHKeyPath)
+		}
+		if cfg.CopyToClipboard || len(cfg.FileNames) != 0 {
+			t.Errorf("Expected optional inputs to keep their defaults, got %+v", cfg)
+		}
+		if _, err := os.Stat(outputDir); err != nil {
+			t.Errorf("Expected the output directory to be created: %v", err)
+		}
+	})
+
+	t.Run("missing inputs", func(t *testing.T) {
+		cfg := &config.Config{
+			AuthMethod:     config.AuthMethodHTTPS,
+			AuthFlagSet:    true,
+			Username:       "user",
+			Output:         config.StdoutOutput,
+			NonInteractive: true,
+		}
+		err := PromptForMissingInputs(cfg)
+		if !errors.Is(err, ErrMissingInputs) {
+			t.Fatalf("Expected ErrMissingInputs, got %v", err)
+		}
+		for _, want := range []string{"-repo", "-pat"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Expected %s in the missing inputs, got %v", want, err)
+			}
+		}
+		if strings.Contains(err.Error(), "-username") {
+			t.Errorf("Did not expect -username in the missing inputs, got %v", err)
+		}
+	})
+}
+
+// TestResolveMatches verifies that multiple matches for a file name are resolved by the configured policy.
+func TestResolveMatches(t *testing.T) {
+	matches := []string{"a/main.go", "b/main.go"}
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		matches []string
+		want    []string
+		wantErr string
+	}{
+		{"single match", config.Config{NonInteractive: true}, matches[:1], matches[:1], ""},
+		{"all", config.Config{MultipleMatches: config.MatchAll}, matches, matches, ""},
+		{"first", config.Config{MultipleMatches: config.MatchFirst}, matches, matches[:1], ""},
+		{"error", config.Config{MultipleMatches: config.MatchError}, matches, nil, "matches 2 files"},
+		{"non-interactive default", config.Config{NonInteractive: true}, matches, nil, "matches 2 files"},
+		{"prompt when non-interactive", config.Config{NonInteractive: true, MultipleMatches: config.MatchPrompt}, matches, nil, "non-interactive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveMatches("main.go", tt.matches, &tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveMatches() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveMatches() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveMatches() = %v, want %v", got, tt.want)
+			}
+		})
 	}
 }
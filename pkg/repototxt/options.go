@@ -0,0 +1,253 @@
+package repototxt
+
+import (
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/auth"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+)
+
+// Output formats accepted by WithFormat.
+const (
+	FormatText     = config.FormatText
+	FormatMarkdown = config.FormatMarkdown
+	FormatXML      = config.FormatXML
+	FormatJSON     = config.FormatJSON
+	FormatJSONL    = config.FormatJSONL
+)
+
+// Priorities accepted by WithPriority.
+const (
+	PriorityDepth   = config.PriorityDepth
+	PriorityRecency = config.PriorityRecency
+	PrioritySize    = config.PrioritySize
+)
+
+// Overflow modes accepted by WithOverflow.
+const (
+	OverflowTruncate = config.OverflowTruncate
+	OverflowDrop     = config.OverflowDrop
+)
+
+// Chunk size units accepted by WithChunks.
+const (
+	ChunkBytes  = config.ChunkBytes
+	ChunkLines  = config.ChunkLines
+	ChunkTokens = config.ChunkTokens
+)
+
+// Git LFS modes accepted by WithLFS.
+const (
+	LFSResolve = config.LFSResolve
+	LFSMark    = config.LFSMark
+	LFSPointer = config.LFSPointer
+)
+
+// Host key checking policies accepted by WithHostKeyChecking.
+const (
+	HostKeyStrict    = config.HostKeyStrict
+	HostKeyAcceptNew = config.HostKeyAcceptNew
+)
+
+// Option configures how Pack and PackFile select and write files.
+type Option func(*settings)
+
+// settings collects the options of a single Pack or PackFile call.
+type settings struct {
+	cfg           config.Config
+	auth          transport.AuthMethod
+	token         string
+	sshKeyPath    string
+	sshPassphrase string
+	progress      io.Writer
+	noRepoConfig  bool
+	useCache      bool
+}
+
+// WithFormat selects the output format, one of the Format constants. The default is FormatText.
+func WithFormat(format string) Option {
+	return func(s *settings) { s.cfg.Format = format }
+}
+
+// WithTree writes a directory tree overview of the repository before the file contents.
+func WithTree() Option {
+	return func(s *settings) { s.cfg.Tree = true }
+}
+
+// WithTokenizer selects the tokenizer used to count tokens: "cl100k" (the default), "o200k",
+// "heuristic" or "none".
+func WithTokenizer(name string) Option {
+	return func(s *settings) { s.cfg.Tokenizer = name }
+}
+
+// WithMaxTokens fits the packed files into a token budget, truncating or dropping the files with
+// the lowest priority. Zero disables the budget.
+func WithMaxTokens(maxTokens int) Option {
+	return func(s *settings) { s.cfg.MaxTokens = maxTokens }
+}
+
+// WithPriority selects the order in which files compete for the token budget after the README
+// and entry points, one of the Priority constants. The default is PriorityDepth.
+func WithPriority(priority string) Option {
+	return func(s *settings) { s.cfg.Priority = priority }
+}
+
+// WithOverflow selects what happens to a file that does not fit the token budget, one of the
+// Overflow constants. The default is OverflowTruncate.
+func WithOverflow(overflow string) Option {
+	return func(s *settings) { s.cfg.Overflow = overflow }
+}
+
+// WithChunks splits the output into numbered part files of at most size units, one of the Chunk
+// constants, and writes an index next to them. Only PackFile supports chunked output.
+func WithChunks(size int, unit string) Option {
+	return func(s *settings) { s.cfg.ChunkSize, s.cfg.ChunkUnit = size, unit }
+}
+
+// WithInclude packs only the files matching the glob patterns, e.g. "src/**/*.go".
+func WithInclude(patterns ...string) Option {
+	return func(s *settings) { s.cfg.IncludePatterns = append(s.cfg.IncludePatterns, patterns...) }
+}
+
+// WithExclude leaves out the folders and files matching the glob patterns. Patterns starting
+// with "!" re-include files excluded by earlier patterns.
+func WithExclude(patterns ...string) Option {
+	return func(s *settings) { s.cfg.ExcludeFolders = append(s.cfg.ExcludeFolders, patterns...) }
+}
+
+// WithExtensions packs only the files with the given extensions, e.g. ".go".
+func WithExtensions(extensions ...string) Option {
+	return func(s *settings) { s.cfg.IncludeExt = append(s.cfg.IncludeExt, extensions...) }
+}
+
+// WithFiles packs only the files with the given exact names, wherever they are in the repository.
+// Every file matching a name is packed.
+func WithFiles(names ...string) Option {
+	return func(s *settings) { s.cfg.FileNames = append(s.cfg.FileNames, names...) }
+}
+
+// WithoutGitignore packs files that .gitignore ignores or .gitattributes marks as generated,
+// vendored, binary or export-ignore.
+func WithoutGitignore() Option {
+	return func(s *settings) { s.cfg.NoGitignore, s.cfg.NoGitignoreSet = true, true }
+}
+
+// WithIgnoreFile applies the patterns of a user-level ignore file in addition to the
+// .repototxtignore files of the repository.
+func WithIgnoreFile(path string) Option {
+	return func(s *settings) { s.cfg.UserIgnoreFile = path }
+}
+
+// WithoutRepoConfig ignores the .repototxt.yaml packing policy committed to the repository.
+func WithoutRepoConfig() Option {
+	return func(s *settings) { s.noRepoConfig = true }
+}
+
+// WithDepth limits a clone to the given number of commits from the tip of the history.
+func WithDepth(depth int) Option {
+	return func(s *settings) { s.cfg.Depth = depth }
+}
+
+// WithSingleBranch fetches only the branch or tag being packed when cloning.
+func WithSingleBranch() Option {
+	return func(s *settings) { s.cfg.SingleBranch = true }
+}
+
+// WithSubmodules checks out the submodules of cloned repositories recursively, so that their
+// files are packed under their paths. Submodules on the repository's host reuse its
+// authentication; those on other hosts are cloned with SSH keys for SSH URLs, or anonymously.
+// Submodules over plain http:// and on the local file system are refused, see
+// WithHTTPSubmodules and WithFileSubmodules.
+func WithSubmodules() Option {
+	return func(s *settings) { s.cfg.Submodules = true }
+}
+
+// WithHTTPSubmodules allows submodules to be cloned over plain http://, which WithSubmodules
+// refuses by default. Such submodules are always cloned without credentials.
+func WithHTTPSubmodules() Option {
+	return func(s *settings) { s.cfg.SubmodulesAllowHTTP = true }
+}
+
+// WithFileSubmodules allows submodules to be cloned from file:// URLs and local paths, which
+// WithSubmodules refuses by default so that a repository cannot copy other repositories from the
+// local disk into the output.
+func WithFileSubmodules() Option {
+	return func(s *settings) { s.cfg.SubmodulesAllowFile = true }
+}
+
+// WithSubdirs limits the snapshot to the given directories, relative to the repository root.
+// Clones check out only them and the top-level files, and paths in the output stay relative to
+// the repository root.
+func WithSubdirs(dirs ...string) Option {
+	return func(s *settings) { s.cfg.Subdirs = append(s.cfg.Subdirs, dirs...) }
+}
+
+// WithLFS selects how files stored with Git LFS are packed, one of the LFS constants. The
+// default, LFSResolve, reads objects from the local LFS store and marks missing ones.
+func WithLFS(mode string) Option {
+	return func(s *settings) { s.cfg.LFS = mode }
+}
+
+// WithAuth authenticates clones with the given go-git authentication method.
+func WithAuth(auth transport.AuthMethod) Option {
+	return func(s *settings) { s.auth = auth }
+}
+
+// WithBasicAuth authenticates HTTPS clones with a username and personal access token.
+func WithBasicAuth(username, token string) Option {
+	return WithAuth(auth.HTTPAuth("", username, token))
+}
+
+// WithToken authenticates HTTPS clones with a token alone. Known hosts receive it with the
+// username they expect for tokens; other hosts receive it as a bearer token.
+func WithToken(token string) Option {
+	return func(s *settings) { s.token = token }
+}
+
+// WithSSHKey authenticates SSH clones with the private key at the given path, decrypted with
+// the passphrase if it is not empty. Without it, SSH clones use the keys of ssh-agent and those
+// named in ~/.ssh/config or found in ~/.ssh.
+func WithSSHKey(path, passphrase string) Option {
+	return func(s *settings) { s.sshKeyPath, s.sshPassphrase = path, passphrase }
+}
+
+// WithHostKeyChecking selects how SSH host keys are checked against known_hosts, one of the
+// HostKey constants. The default follows StrictHostKeyChecking in ~/.ssh/config, or is HostKeyStrict.
+// Host keys added with HostKeyAcceptNew are reported to the WithProgress writer.
+func WithHostKeyChecking(policy string) Option {
+	return func(s *settings) { s.cfg.SSHHostKeyChecking = policy }
+}
+
+// WithCache fetches remote repositories into the clone cache at dir and packs them from there,
+// so that later calls only download new objects. An empty dir selects the default cache
+// directory used by the command-line tool. By default every call clones from scratch.
+func WithCache(dir string) Option {
+	return func(s *settings) { s.useCache, s.cfg.CacheDir = true, dir }
+}
+
+// WithOffline packs the cached copy of remote repositories without contacting the remote.
+// It implies WithCache with the default cache directory unless WithCache selects another.
+func WithOffline() Option {
+	return func(s *settings) { s.useCache, s.cfg.Offline = true, true }
+}
+
+// WithInMemory packs remote repositories straight from their git objects without checking out
+// a worktree: they are cloned into memory, or read in place from the cache with WithCache, so
+// no working tree is written to disk. It cannot be combined with WithSubmodules or a local source,
+// and Git LFS objects are reported as missing.
+func WithInMemory() Option {
+	return func(s *settings) { s.cfg.InMemory = true }
+}
+
+// WithMaxArchiveSize refuses archive sources whose files, or git bundles whose objects, expand
+// beyond size bytes in total. The default is 1 GiB.
+func WithMaxArchiveSize(size int64) Option {
+	return func(s *settings) { s.cfg.MaxArchiveSize = size }
+}
+
+// WithProgress writes clone progress messages, and the SSH host keys added to known_hosts, to the
+// writer. By default they are discarded.
+func WithProgress(w io.Writer) Option {
+	return func(s *settings) { s.progress = w }
+}
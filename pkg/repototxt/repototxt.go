@@ -0,0 +1,473 @@
+// Package repototxt packs Git repositories, local directories and archives into a single
+// document for use as context for large language models.
+//
+// It is the library counterpart of the repo-to-txt command: it applies the same file selection,
+// token counting, token budget and output formats, but never prompts, logs or exits. Pack writes
+// to any io.Writer and PackFile writes to a file, optionally split into chunks:
+//
+//	var buf bytes.Buffer
+//	result, err := repototxt.Pack(ctx, repototxt.Remote("https://github.com/owner/repo.git"), &buf,
+//		repototxt.WithFormat(repototxt.FormatMarkdown),
+//		repototxt.WithMaxTokens(100000),
+//	)
+package repototxt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/archive"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/auth"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/clone"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/config"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/output"
+	"github.com/vytautas-bunevicius/repo-to-txt/pkg/tokens"
+)
+
+// Source identifies the repository to pack: a remote repository cloned from URL, a local
+// directory or checkout at Path packed in place, or a tarball, zip archive or git bundle at
+// Archive read without extracting it.
+type Source struct {
+	URL     string // URL of the Git repository to clone
+	Path    string // Path to a local directory or checkout to pack instead of cloning
+	Archive string // Path to a .tar, .tar.gz, .tar.bz2 or .zip archive or a git bundle to pack instead of cloning
+	Ref     string // Branch, tag or commit SHA to pack instead of the default branch; only for URL and git bundles
+}
+
+// Remote returns a Source that clones the repository at url.
+func Remote(url string) Source {
+	return Source{URL: url}
+}
+
+// Local returns a Source that packs the directory at path in place.
+func Local(path string) Source {
+	return Source{Path: path}
+}
+
+// Archive returns a Source that packs the tarball, zip archive or git bundle at path.
+func Archive(path string) Source {
+	return Source{Archive: path}
+}
+
+// File describes a file written to the output.
+type File = output.FileSummary
+
+// Skipped describes a file, or a directory as a whole, left out of the output and why.
+type Skipped = output.SkippedFile
+
+// Omitted describes a file left out or truncated to fit the token budget.
+type Omitted = output.OmittedFile
+
+// Chunk describes a chunk file written by PackFile when the output is split into chunks.
+type Chunk = output.Chunk
+
+// Submodule describes a submodule checked out by WithSubmodules.
+type Submodule = clone.Submodule
+
+// Result describes a packed repository.
+type Result struct {
+	Name       string      // Name of the repository, derived from the URL, directory or archive name
+	Commit     string      // SHA of the commit that was cloned or read from a git bundle; empty for directories, tarballs and zip archives
+	Submodules []Submodule // Submodules checked out with WithSubmodules, parents before their own submodules
+	Files      []File      // Packed files in output order
+	Skipped    []Skipped   // Files and directories left out of the output, in walk order
+	Omitted    []Omitted   // Files left out or truncated to fit the token budget, in priority order
+	Chunks     []Chunk     // Chunk files written by PackFile; empty if the output was not split
+	Index      string      // Path of the index file describing the chunks; empty if the output was not split
+	Stats      Stats       // Totals over the packed files
+}
+
+// Stats holds totals over the packed files.
+type Stats struct {
+	Files     int           // Number of files packed
+	Skipped   int           // Number of files and directories left out
+	Bytes     int64         // Total size of the packed files in bytes
+	Tokens    int           // Total number of tokens in the packed files; 0 if tokens were not counted
+	Tokenizer string        // Name of the tokenizer used to count tokens; empty if tokens were not counted
+	Duration  time.Duration // Time taken to clone and pack the repository
+}
+
+// Pack packs the repository described by src and writes the document to w.
+// Remote repositories are cloned into a temporary directory that is removed before Pack returns,
+// or into memory with WithInMemory.
+//
+// Parameters:
+//   - ctx: The context for managing cancellation and deadlines while cloning.
+//   - src: The repository to pack.
+//   - w: The writer the document is written to.
+//   - opts: Options selecting the files and the output format.
+//
+// Returns:
+//   - *Result: The packed files, the files left out and totals.
+//   - error: An error if the options are invalid, or cloning or packing fails.
+func Pack(ctx context.Context, src Source, w io.Writer, opts ...Option) (*Result, error) {
+	return pack(ctx, src, opts, func(snap output.Snapshot, paths []string, meta output.Metadata, cfg *config.Config) (*output.Summary, error) {
+		if paths != nil {
+			return output.WriteSnapshotFiles(w, snap, paths, meta, cfg)
+		}
+		return output.WriteSnapshot(w, snap, meta, cfg)
+	})
+}
+
+// PackFile packs the repository described by src and writes the document to the file at path.
+// Unlike Pack it supports splitting the output into chunks with WithChunks.
+//
+// Parameters:
+//   - ctx: The context for managing cancellation and deadlines while cloning.
+//   - src: The repository to pack.
+//   - path: The path of the output file; chunk files and their index are written next to it.
+//   - opts: Options selecting the files and the output format.
+//
+// Returns:
+//   - *Result: The packed files, the files left out, the chunks written and totals.
+//   - error: An error if the options are invalid, or cloning or packing fails.
+func PackFile(ctx context.Context, src Source, path string, opts ...Option) (*Result, error) {
+	return pack(ctx, src, opts, func(snap output.Snapshot, paths []string, meta output.Metadata, cfg *config.Config) (*output.Summary, error) {
+		if paths != nil {
+			return output.WriteSnapshotFilesToFile(snap, path, paths, meta, cfg)
+		}
+		return output.WriteSnapshotToFile(snap, path, meta, cfg)
+	})
+}
+
+// writeFunc writes the selected files of a repository, or the whole repository when paths is nil.
+type writeFunc func(snap output.Snapshot, paths []string, meta output.Metadata, cfg *config.Config) (*output.Summary, error)
+
+// pack resolves the options and the source, then writes the repository with write.
+//
+// Parameters:
+//   - ctx: The context for managing cancellation and deadlines while cloning.
+//   - src: The repository to pack.
+//   - opts: Options selecting the files and the output format.
+//   - write: The function writing the files to the output.
+//
+// Returns:
+//   - *Result: The result of packing the repository.
+//   - error: An error if any step fails.
+func pack(ctx context.Context, src Source, opts []Option, write writeFunc) (*Result, error) {
+	start := time.Now()
+
+	s, err := newSettings(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := src.validate(); err != nil {
+		return nil, err
+	}
+	if src.Path != "" && s.cfg.InMemory {
+		return nil, errors.New("a local path cannot be packed in memory")
+	}
+	if src.Archive != "" && s.cfg.Submodules {
+		return nil, errors.New("submodules cannot be checked out from an archive")
+	}
+
+	var snap output.Snapshot
+	var name, commit string
+	meta := output.Metadata{Ref: src.Ref, Subdirs: s.cfg.Subdirs}
+	switch {
+	case src.Path != "":
+		if name, err = clone.ExtractLocalRepoName(src.Path); err != nil {
+			return nil, fmt.Errorf("error extracting repository name: %w", err)
+		}
+		snap, meta.Source = output.DirSnapshot(src.Path), src.Path
+	case src.Archive != "":
+		if name, err = archive.Name(src.Archive); err != nil {
+			return nil, fmt.Errorf("error extracting repository name: %w", err)
+		}
+		a, err := archive.Open(src.Archive, archive.Options{Ref: src.Ref, MaxSize: s.cfg.MaxArchiveSize})
+		if err != nil {
+			return nil, fmt.Errorf("error opening archive: %w", err)
+		}
+		defer a.Close()
+		if snap, err = output.ArchiveSnapshot(a); err != nil {
+			return nil, err
+		}
+		if a.Repo != nil {
+			commit = a.Commit.String()
+		}
+		meta.Source, meta.Commit = src.Archive, commit
+	default:
+		if name, err = clone.ExtractRepoName(src.URL); err != nil {
+			return nil, fmt.Errorf("error extracting repository name: %w", err)
+		}
+		var cache *clone.Cache
+		if s.useCache {
+			if cache, err = clone.NewCache(s.cfg.CacheDir); err != nil {
+				return nil, err
+			}
+		}
+		var authMethod transport.AuthMethod
+		if !s.cfg.Offline {
+			if authMethod, err = s.authMethod(src.URL); err != nil {
+				return nil, err
+			}
+		}
+		cloneOpts := clone.Options{
+			Ref:          src.Ref,
+			Depth:        s.cfg.Depth,
+			SingleBranch: s.cfg.SingleBranch,
+			Progress:     s.progress,
+			Cache:        cache,
+			Offline:      s.cfg.Offline,
+			Subdirs:      s.cfg.Subdirs,
+		}
+
+		var tempDir string
+		if s.cfg.InMemory {
+			repo, hash, err := clone.CloneToMemory(ctx, src.URL, authMethod, cloneOpts)
+			if err != nil {
+				return nil, fmt.Errorf("error cloning repository: %w", err)
+			}
+			if snap, err = output.TreeSnapshot(repo, hash); err != nil {
+				return nil, err
+			}
+			commit = hash.String()
+		} else {
+			if cache != nil {
+				var cleanup func()
+				if tempDir, cleanup, err = cache.TempDir(); err != nil {
+					return nil, fmt.Errorf("unable to create temporary directory: %w", err)
+				}
+				defer cleanup()
+			} else {
+				if tempDir, err = os.MkdirTemp("", config.DefaultCloneDir); err != nil {
+					return nil, fmt.Errorf("unable to create temporary directory: %w", err)
+				}
+				defer os.RemoveAll(tempDir)
+			}
+
+			if commit, err = clone.CloneOrPullRepo(ctx, src.URL, tempDir, authMethod, cloneOpts); err != nil {
+				return nil, fmt.Errorf("error cloning repository: %w", err)
+			}
+			snap = output.DirSnapshot(tempDir)
+		}
+		meta.Source, meta.Commit = clone.RedactURL(src.URL), commit
+
+		if s.cfg.Submodules {
+			meta.Submodules, err = clone.UpdateSubmodules(ctx, tempDir, src.URL, clone.SubmoduleOptions{
+				Auth:      authMethod,
+				AuthFor:   s.sshAuth,
+				AllowHTTP: s.cfg.SubmodulesAllowHTTP,
+				AllowFile: s.cfg.SubmodulesAllowFile,
+				Progress:  s.progress,
+				Subdirs:   s.cfg.Subdirs,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error checking out submodules: %w", err)
+			}
+		}
+	}
+	meta.Name = name
+
+	if !s.noRepoConfig {
+		if _, err := s.cfg.ApplyRepoConfigFS(snap.FS); err != nil {
+			return nil, fmt.Errorf("error loading repository configuration: %w", err)
+		}
+	}
+
+	var paths []string
+	if len(s.cfg.FileNames) > 0 {
+		if paths, err = selectFiles(snap, &s.cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	summary, err := write(snap, paths, meta, &s.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error writing repository contents: %w", err)
+	}
+	result := newResult(name, commit, summary, time.Since(start))
+	result.Submodules = meta.Submodules
+	return result, nil
+}
+
+// validate checks that the source names exactly one repository.
+//
+// Returns:
+//   - error: An error if the source is empty, ambiguous, combines a ref with a local path or names
+//     a missing archive.
+func (src Source) validate() error {
+	switch {
+	case src.URL == "" && src.Path == "" && src.Archive == "":
+		return errors.New("source must have a URL or a path or an archive")
+	case src.URL != "" && src.Path != "":
+		return errors.New("source cannot have both a URL and a path")
+	case src.Archive != "" && (src.URL != "" || src.Path != ""):
+		return errors.New("source cannot have both an archive and a URL or path")
+	case src.Ref != "" && src.Path != "":
+		return errors.New("a ref cannot be used with a local path")
+	}
+	if src.Archive != "" {
+		info, err := os.Stat(src.Archive)
+		if err != nil {
+			return fmt.Errorf("error accessing source archive: %w", err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("source archive %s is a directory; use Local to pack it", src.Archive)
+		}
+	}
+	if src.Path != "" {
+		info, err := os.Stat(src.Path)
+		if err != nil {
+			return fmt.Errorf("error accessing source path: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("source path %s is not a directory", src.Path)
+		}
+	}
+	return nil
+}
+
+// newSettings applies the options on top of the defaults and validates the result.
+//
+// Parameters:
+//   - opts: The options to apply.
+//
+// Returns:
+//   - *settings: The resolved settings.
+//   - error: An error if an option value is invalid.
+func newSettings(opts []Option) (*settings, error) {
+	s := &settings{
+		cfg: config.Config{
+			Format:     config.FormatText,
+			Tokenizer:  tokens.CL100K,
+			Priority:   config.PriorityDepth,
+			Overflow:   config.OverflowTruncate,
+			ChunkUnit:  config.ChunkBytes,
+			AuthMethod: config.AuthMethodNone,
+			LFS:        config.LFSResolve,
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.cfg.Depth < 0 {
+		return nil, errors.New("depth must not be negative")
+	}
+	if s.cfg.SSHHostKeyChecking != "" && !slices.Contains(config.HostKeyPolicies, s.cfg.SSHHostKeyChecking) {
+		return nil, fmt.Errorf("invalid host key checking policy %q: choose from %s", s.cfg.SSHHostKeyChecking, strings.Join(config.HostKeyPolicies, ", "))
+	}
+	if s.cfg.Offline && s.cfg.Submodules {
+		return nil, errors.New("submodules cannot be checked out offline, since they are not cached")
+	}
+	if s.cfg.InMemory && s.cfg.Submodules {
+		return nil, errors.New("submodules cannot be checked out in memory")
+	}
+	if err := s.cfg.ValidateOutputOptions(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// authMethod returns the authentication method for cloning: the method given with WithAuth,
+// the token given with WithToken, SSH keys for SSH URLs, or none.
+//
+// Parameters:
+//   - repoURL: The URL of the repository being cloned.
+//
+// Returns:
+//   - transport.AuthMethod: The authentication method; nil for anonymous clones.
+//   - error: An error if no SSH key can be loaded or known_hosts cannot be read.
+func (s *settings) authMethod(repoURL string) (transport.AuthMethod, error) {
+	switch {
+	case s.auth != nil:
+		return s.auth, nil
+	case s.token != "":
+		return auth.HTTPAuth(repoURL, "", s.token), nil
+	}
+	return s.sshAuth(repoURL)
+}
+
+// sshAuth returns SSH authentication for SSH URLs, using the key given with WithSSHKey or the
+// keys of ssh-agent and ~/.ssh, and no authentication for other URLs. It also authenticates
+// submodules on other hosts than the repository, which never receive its token.
+//
+// Parameters:
+//   - repoURL: The URL of the repository being cloned.
+//
+// Returns:
+//   - transport.AuthMethod: The authentication method; nil for anonymous clones.
+//   - error: An error if no SSH key can be loaded or known_hosts cannot be read.
+func (s *settings) sshAuth(repoURL string) (transport.AuthMethod, error) {
+	if u, err := clone.ParseRepoURL(repoURL); err != nil || !u.IsSSH() {
+		return nil, nil
+	}
+	cfg := config.Config{
+		RepoURL:            repoURL,
+		AuthMethod:         config.AuthMethodSSH,
+		SSHKeyPath:         s.sshKeyPath,
+		SSHPassphrase:      s.sshPassphrase,
+		SSHHostKeyChecking: s.cfg.SSHHostKeyChecking,
+	}
+	// Host keys accepted with WithHostKeyChecking are reported with the progress messages
+	method, err := auth.SetupAuth(&cfg, s.progress)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up authentication: %w", err)
+	}
+	return method, nil
+}
+
+// selectFiles finds every file matching the configured file names.
+//
+// Parameters:
+//   - snap: The repository snapshot.
+//   - cfg: A pointer to the Config struct holding the file names.
+//
+// Returns:
+//   - []string: The slash-separated paths of the matching files relative to the repository root,
+//     in the order of the file names.
+//   - error: An error if searching fails or no file matches.
+func selectFiles(snap output.Snapshot, cfg *config.Config) ([]string, error) {
+	matches, err := output.FindSnapshotFiles(snap, cfg.FileNames, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error searching for specified files: %w", err)
+	}
+	paths := []string{}
+	for _, name := range cfg.FileNames {
+		paths = append(paths, matches[name]...)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files found matching %v", cfg.FileNames)
+	}
+	return paths, nil
+}
+
+// newResult converts the output summary into a Result.
+//
+// Parameters:
+//   - name: The name of the repository.
+//   - commit: The SHA of the commit that was cloned, if any.
+//   - summary: The summary of the files written to the output.
+//   - duration: The time taken to clone and pack the repository.
+//
+// Returns:
+//   - *Result: The result of packing the repository.
+func newResult(name, commit string, summary *output.Summary, duration time.Duration) *Result {
+	result := &Result{
+		Name:    name,
+		Commit:  commit,
+		Files:   summary.Files,
+		Skipped: summary.Skipped,
+		Omitted: summary.Omitted,
+		Chunks:  summary.Chunks,
+		Index:   summary.Index,
+		Stats: Stats{
+			Files:     len(summary.Files),
+			Skipped:   len(summary.Skipped),
+			Tokens:    summary.Tokens,
+			Tokenizer: summary.Tokenizer,
+			Duration:  duration,
+		},
+	}
+	for _, file := range summary.Files {
+		result.Stats.Bytes += file.Size
+	}
+	return result
+}
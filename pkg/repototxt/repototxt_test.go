@@ -0,0 +1,372 @@
+// Package repototxt_test contains unit tests for the repototxt package.
+package repototxt
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// writeRepo creates a directory holding the given files, keyed by slash-separated path.
+func writeRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+// writeZip creates a zip archive holding the given files, keyed by slash-separated path, under
+// a single top-level directory as in the archives downloaded from Git hosts.
+func writeZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "project-main.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create("project-main/" + name)
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+	return path
+}
+
+// TestPackLocal verifies that Pack writes a local directory to the writer and reports the
+// packed and skipped files.
+func TestPackLocal(t *testing.T) {
+	dir := writeRepo(t, map[string]string{
+		".gitignore":          "node_modules/\n",
+		"main.go":             "package main\n",
+		"README.md":           "# Demo\n",
+		"node_modules/x.js":   "module.exports = 1\n",
+		"docs/guide/intro.md": "Intro\n",
+	})
+
+	var buf bytes.Buffer
+	result, err := Pack(context.Background(), Local(dir), &buf, WithExclude("docs/**"))
+	if err != nil {
+		t.Fatalf("Pack returned an error: %v", err)
+	}
+
+	if result.Name != filepath.Base(dir) {
+		t.Errorf("Expected name %q, got %q", filepath.Base(dir), result.Name)
+	}
+	if result.Commit != "" {
+		t.Errorf("Expected no commit for a local source, got %q", result.Commit)
+	}
+	if result.Stats.Files != 2 || len(result.Files) != 2 {
+		t.Fatalf("Expected 2 packed files, got %+v", result.Files)
+	}
+	if result.Stats.Bytes != int64(len("package main\n")+len("# Demo\n")) {
+		t.Errorf("Unexpected byte total %d", result.Stats.Bytes)
+	}
+	if result.Stats.Tokens == 0 || result.Stats.Tokenizer == "" {
+		t.Errorf("Expected tokens to be counted, got %+v", result.Stats)
+	}
+
+	skipped := map[string]bool{}
+	for _, s := range result.Skipped {
+		skipped[s.Path] = true
+		if s.Reason == "" {
+			t.Errorf("Expected a reason for skipping %s", s.Path)
+		}
+	}
+	if !skipped["docs"] {
+		t.Errorf("Expected docs to be skipped, got %+v", result.Skipped)
+	}
+	if result.Stats.Skipped != len(result.Skipped) {
+		t.Errorf("Expected %d skipped in stats, got %d", len(result.Skipped), result.Stats.Skipped)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "package main") || !strings.Contains(out, "# Demo") {
+		t.Errorf("Expected the file contents in the output, got:\n%s", out)
+	}
+	if strings.Contains(out, "Intro") || strings.Contains(out, "module.exports") {
+		t.Errorf("Expected docs and node_modules to be excluded, got:\n%s", out)
+	}
+}
+
+// TestPackOptions verifies that the output options are applied.
+func TestPackOptions(t *testing.T) {
+	dir := writeRepo(t, map[string]string{
+		"a.go":     "package a\n",
+		"b.py":     "print('b')\n",
+		"sub/c.go": "package sub\n",
+	})
+
+	var buf bytes.Buffer
+	result, err := Pack(context.Background(), Local(dir), &buf,
+		WithFormat(FormatJSON),
+		WithExtensions(".go"),
+		WithTokenizer("none"),
+	)
+	if err != nil {
+		t.Fatalf("Pack returned an error: %v", err)
+	}
+
+	var doc struct {
+		Files []struct {
+			Path string `json:"path"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Expected JSON output: %v\n%s", err, buf.String())
+	}
+	if len(doc.Files) != 2 {
+		t.Errorf("Expected 2 Go files in the output, got %+v", doc.Files)
+	}
+	if result.Stats.Tokenizer != "" || result.Stats.Tokens != 0 {
+		t.Errorf("Expected no tokens to be counted, got %+v", result.Stats)
+	}
+
+	buf.Reset()
+	result, err = Pack(context.Background(), Local(dir), &buf, WithFiles("c.go"))
+	if err != nil {
+		t.Fatalf("Pack returned an error: %v", err)
+	}
+	if len(result.Files) != 1 || result.Files[0].Path != "sub/c.go" {
+		t.Errorf("Expected only sub/c.go to be packed, got %+v", result.Files)
+	}
+
+	buf.Reset()
+	result, err = Pack(context.Background(), Local(dir), &buf, WithSubdirs("sub/"))
+	if err != nil {
+		t.Fatalf("Pack returned an error: %v", err)
+	}
+	if len(result.Files) != 1 || result.Files[0].Path != "sub/c.go" {
+		t.Errorf("Expected only sub/c.go to be packed from the subdirectory, got %+v", result.Files)
+	}
+}
+
+// TestPackFileChunks verifies that PackFile splits the output into chunks.
+func TestPackFileChunks(t *testing.T) {
+	dir := writeRepo(t, map[string]string{
+		"a.txt": strings.Repeat("alpha\n", 20),
+		"b.txt": strings.Repeat("bravo\n", 20),
+	})
+	outputFile := filepath.Join(t.TempDir(), "out.txt")
+
+	result, err := PackFile(context.Background(), Local(dir), outputFile, WithChunks(15, ChunkLines))
+	if err != nil {
+		t.Fatalf("PackFile returned an error: %v", err)
+	}
+	if len(result.Chunks) < 2 {
+		t.Fatalf("Expected several chunks, got %d", len(result.Chunks))
+	}
+	if _, err := os.Stat(result.Index); err != nil {
+		t.Errorf("Expected the chunk index to exist: %v", err)
+	}
+}
+
+// TestPackErrors verifies that invalid sources and options are rejected before packing.
+func TestPackErrors(t *testing.T) {
+	dir := writeRepo(t, map[string]string{"a.go": "package a\n"})
+	file := filepath.Join(dir, "a.go")
+
+	tests := []struct {
+		name string
+		src  Source
+		opts []Option
+		want string
+	}{
+		{"empty source", Source{}, nil, "URL or a path"},
+		{"both URL and path", Source{URL: "https://github.com/o/r.git", Path: dir}, nil, "both"},
+		{"ref with path", Source{Path: dir, Ref: "main"}, nil, "ref"},
+		{"path is a file", Local(file), nil, "not a directory"},
+		{"invalid format", Local(dir), []Option{WithFormat("yaml")}, "format"},
+		{"budget without tokenizer", Local(dir), []Option{WithMaxTokens(10), WithTokenizer("none")}, "tokenizer"},
+		{"negative depth", Local(dir), []Option{WithDepth(-1)}, "depth"},
+		{"chunks to writer", Local(dir), []Option{WithChunks(10, ChunkLines)}, "chunk"},
+		{"missing file", Local(dir), []Option{WithFiles("nope.go")}, "no files found"},
+		{"invalid lfs mode", Local(dir), []Option{WithLFS("fetch")}, "lfs mode"},
+		{"subdir outside repository", Local(dir), []Option{WithSubdirs("../other")}, "subdir"},
+		{"missing subdir", Local(dir), []Option{WithSubdirs("missing")}, "not found"},
+		{"offline submodules", Remote("https://github.com/o/r.git"), []Option{WithOffline(), WithSubmodules()}, "submodules"},
+		{"in-memory submodules", Remote("https://github.com/o/r.git"), []Option{WithInMemory(), WithSubmodules()}, "in memory"},
+		{"in-memory local path", Local(dir), []Option{WithInMemory()}, "in memory"},
+		{"both archive and URL", Source{URL: "https://github.com/o/r.git", Archive: file}, nil, "both"},
+		{"archive is a directory", Archive(dir), nil, "is a directory"},
+		{"missing archive", Archive(filepath.Join(dir, "missing.zip")), nil, "archive"},
+		{"unsupported archive", Archive(file), nil, "unsupported archive format"},
+		{"ref in zip archive", Source{Archive: writeZip(t, map[string]string{"a.go": "package a\n"}), Ref: "main"}, nil, "git bundle"},
+		{"archive submodules", Archive(file), []Option{WithSubmodules()}, "submodules"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Pack(context.Background(), tt.src, &bytes.Buffer{}, tt.opts...)
+			if err == nil {
+				t.Fatal("Expected an error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+// TestPackArchive verifies that zip archives and git bundles are packed with the same filters
+// as a directory holding the same files, and that bundles report the commit they were read at.
+func TestPackArchive(t *testing.T) {
+	files := map[string]string{
+		".gitignore":    "build/\n",
+		"main.go":       "package main\n",
+		"README.md":     "# Demo\n",
+		"build/out.js":  "console.log(1)\n",
+		"docs/intro.md": "Intro\n",
+	}
+	dir := writeRepo(t, files)
+	want, err := Pack(context.Background(), Local(dir), io.Discard, WithExclude("docs/**"))
+	if err != nil {
+		t.Fatalf("Pack of the directory returned an error: %v", err)
+	}
+	sources := map[string]Source{"zip": Archive(writeZip(t, files))}
+
+	if _, err := exec.LookPath("git"); err == nil {
+		for _, args := range [][]string{
+			{"init", "-q"},
+			{"add", "."},
+			{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+			{"bundle", "create", "project.bundle", "--all"},
+		} {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = dir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git %v failed: %v\n%s", args, err, out)
+			}
+		}
+		bundle := filepath.Join(t.TempDir(), "project.bundle")
+		if err := os.Rename(filepath.Join(dir, "project.bundle"), bundle); err != nil {
+			t.Fatalf("Failed to move bundle: %v", err)
+		}
+		sources["bundle"] = Archive(bundle)
+	}
+
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			result, err := Pack(context.Background(), src, &buf, WithExclude("docs/**"))
+			if err != nil {
+				t.Fatalf("Pack returned an error: %v", err)
+			}
+			if result.Name != "project-main" && result.Name != "project" {
+				t.Errorf("Unexpected name %q", result.Name)
+			}
+			if (result.Commit != "") != (name == "bundle") {
+				t.Errorf("Unexpected commit %q for a %s", result.Commit, name)
+			}
+			paths := func(r *Result) []string {
+				var paths []string
+				for _, f := range r.Files {
+					paths = append(paths, f.Path)
+				}
+				return paths
+			}
+			if !slices.Equal(paths(result), paths(want)) || result.Stats.Bytes != want.Stats.Bytes {
+				t.Errorf("Packed %v (%d bytes); want %v (%d bytes)", paths(result), result.Stats.Bytes, paths(want), want.Stats.Bytes)
+			}
+			if !strings.Contains(buf.String(), "package main") || strings.Contains(buf.String(), "console.log") {
+				t.Errorf("Unexpected output:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+// TestPackRemote verifies that Pack clones a remote source, here a file:// URL, and reports the commit.
+//
+// Cloning a file:// URL uses the git-upload-pack binary, so the test is skipped when git is not installed.
+func TestPackRemote(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("Skipping clone test; git is not installed")
+	}
+
+	dir := writeRepo(t, map[string]string{"main.go": "package main\n"})
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("Failed to initialise repository: %v", err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Failed to get worktree: %v", err)
+	}
+	if _, err := w.Add("main.go"); err != nil {
+		t.Fatalf("Failed to stage file: %v", err)
+	}
+	hash, err := w.Commit("initial", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	var buf bytes.Buffer
+	result, err := Pack(context.Background(), Remote("file://"+filepath.ToSlash(dir)), &buf, WithDepth(1))
+	if err != nil {
+		t.Fatalf("Pack returned an error: %v", err)
+	}
+	if result.Commit != hash.String() {
+		t.Errorf("Expected commit %s, got %s", hash, result.Commit)
+	}
+	if result.Name != filepath.Base(dir) || len(result.Files) != 1 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if !strings.Contains(buf.String(), "package main") {
+		t.Errorf("Expected the file contents in the output, got:\n%s", buf.String())
+	}
+	// A cached pack can be repeated offline
+	cacheDir := t.TempDir()
+	if _, err := Pack(context.Background(), Remote("file://"+filepath.ToSlash(dir)), io.Discard, WithCache(cacheDir)); err != nil {
+		t.Fatalf("Pack with the cache returned an error: %v", err)
+	}
+	buf.Reset()
+	result, err = Pack(context.Background(), Remote("file://"+filepath.ToSlash(dir)), &buf, WithCache(cacheDir), WithOffline())
+	if err != nil {
+		t.Fatalf("Offline pack returned an error: %v", err)
+	}
+	if result.Commit != hash.String() || !strings.Contains(buf.String(), "package main") {
+		t.Errorf("Expected the cached commit %s, got %+v", hash, result)
+	}
+
+	// In-memory packs read the same tree from the objects, cloned or cached
+	for _, opts := range [][]Option{{WithInMemory()}, {WithInMemory(), WithCache(cacheDir), WithOffline()}} {
+		buf.Reset()
+		result, err = Pack(context.Background(), Remote("file://"+filepath.ToSlash(dir)), &buf, append(opts, WithFiles("main.go"))...)
+		if err != nil {
+			t.Fatalf("In-memory pack returned an error: %v", err)
+		}
+		if result.Commit != hash.String() || len(result.Files) != 1 || !strings.Contains(buf.String(), "=== main.go ===\npackage main\n") {
+			t.Errorf("Expected main.go at commit %s, got %+v:\n%s", hash, result, buf.String())
+		}
+	}
+}
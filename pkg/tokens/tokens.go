@@ -0,0 +1,140 @@
+// Package tokens counts how many LLM tokens a piece of text occupies.
+// It provides byte-pair encoding counters for the cl100k and o200k encodings, whose
+// vocabularies are embedded in the binary so no network access is needed, and a cheap
+// heuristic counter for when speed matters more than accuracy.
+package tokens
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// Names of the supported tokenizers.
+const (
+	// CL100K is the BPE encoding used by GPT-4 and GPT-3.5 models.
+	CL100K = "cl100k"
+
+	// O200K is the BPE encoding used by GPT-4o and newer models.
+	O200K = "o200k"
+
+	// Heuristic estimates tokens from word and punctuation boundaries without a vocabulary.
+	Heuristic = "heuristic"
+
+	// None disables token counting.
+	None = "none"
+)
+
+// Names lists the supported tokenizers.
+var Names = []string{CL100K, O200K, Heuristic, None}
+
+// heuristicCharsPerToken is the average number of characters of a word per token in source code and English text.
+const heuristicCharsPerToken = 4
+
+// encodingNames maps tokenizer names to the tiktoken encoding names.
+var encodingNames = map[string]string{
+	CL100K: "cl100k_base",
+	O200K:  "o200k_base",
+}
+
+// loaderOnce installs the offline vocabulary loader the first time a BPE counter is created.
+var loaderOnce sync.Once
+
+// Counter counts the tokens in a piece of text.
+type Counter interface {
+	// Name returns the name of the tokenizer, e.g. "cl100k".
+	Name() string
+
+	// Count returns the number of tokens in the text.
+	Count(text []byte) int
+}
+
+// NewCounter returns the counter for the named tokenizer.
+//
+// Parameters:
+//   - name: The tokenizer name, one of CL100K, O200K, Heuristic or None.
+//
+// Returns:
+//   - Counter: The counter, or nil if counting is disabled with None.
+//   - error: An error if the tokenizer is unknown or its vocabulary cannot be loaded.
+func NewCounter(name string) (Counter, error) {
+	switch name {
+	case None:
+		return nil, nil
+	case Heuristic:
+		return heuristicCounter{}, nil
+	}
+
+	encodingName, ok := encodingNames[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tokenizer %q: choose from %s", name, strings.Join(Names, ", "))
+	}
+
+	loaderOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+	})
+	encoding, err := tiktoken.GetEncoding(encodingName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load %s vocabulary: %w", name, err)
+	}
+	return &bpeCounter{name: name, encoding: encoding}, nil
+}
+
+// bpeCounter counts tokens by encoding the text with a byte-pair encoding vocabulary.
+type bpeCounter struct {
+	name     string
+	encoding *tiktoken.Tiktoken
+}
+
+// Name returns the name of the encoding.
+func (c *bpeCounter) Name() string {
+	return c.name
+}
+
+// Count returns the number of tokens in the encoded text. Special tokens such as
+// "<|endoftext|>" are counted as ordinary text.
+func (c *bpeCounter) Count(text []byte) int {
+	return len(c.encoding.EncodeOrdinary(string(text)))
+}
+
+// heuristicCounter estimates tokens without a vocabulary. Runs of letters and digits count
+// one token per four characters, each punctuation character and each non-ASCII character
+// counts as one token, and whitespace is free except for line breaks.
+type heuristicCounter struct{}
+
+// Name returns the name of the heuristic.
+func (heuristicCounter) Name() string {
+	return Heuristic
+}
+
+// Count returns the estimated number of tokens in the text.
+func (heuristicCounter) Count(text []byte) int {
+	count, word := 0, 0
+	flush := func() {
+		count += (word + heuristicCharsPerToken - 1) / heuristicCharsPerToken
+		word = 0
+	}
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		text = text[size:]
+		switch {
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'):
+			word++
+		case r == '\n':
+			flush()
+			count++
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			flush()
+			count++
+		}
+	}
+	flush()
+	return count
+}
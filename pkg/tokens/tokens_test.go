@@ -0,0 +1,65 @@
+// Package tokens_test contains unit tests for the tokens package.
+package tokens
+
+import "testing"
+
+// TestBPECounter verifies token counts of the embedded BPE vocabularies against known encodings.
+func TestBPECounter(t *testing.T) {
+	testCases := []struct {
+		tokenizer string
+		text      string
+		expected  int
+	}{
+		{CL100K, "", 0},
+		{CL100K, "hello world", 2},
+		{CL100K, "tiktoken is great!", 6},
+		{CL100K, "<|endoftext|>", 7},
+		{O200K, "hello world", 2},
+	}
+
+	for _, tc := range testCases {
+		counter, err := NewCounter(tc.tokenizer)
+		if err != nil {
+			t.Fatalf("NewCounter(%q) returned an error: %v", tc.tokenizer, err)
+		}
+		if counter.Name() != tc.tokenizer {
+			t.Errorf("Expected counter name %q, got %q", tc.tokenizer, counter.Name())
+		}
+		if count := counter.Count([]byte(tc.text)); count != tc.expected {
+			t.Errorf("%s: Count(%q) = %d; want %d", tc.tokenizer, tc.text, count, tc.expected)
+		}
+	}
+}
+
+// TestHeuristicCounter verifies the heuristic estimate for words, punctuation and line breaks.
+func TestHeuristicCounter(t *testing.T) {
+	testCases := map[string]int{
+		"":                     0,
+		"hello world":          4,
+		"func main() {}\n":     7,
+		"a, b":                 3,
+		"naïve":                3,
+		"internationalization": 5,
+	}
+
+	counter, err := NewCounter(Heuristic)
+	if err != nil {
+		t.Fatalf("NewCounter returned an error: %v", err)
+	}
+	for text, expected := range testCases {
+		if count := counter.Count([]byte(text)); count != expected {
+			t.Errorf("Count(%q) = %d; want %d", text, count, expected)
+		}
+	}
+}
+
+// TestNewCounter verifies that counting can be disabled and that unknown tokenizers are rejected.
+func TestNewCounter(t *testing.T) {
+	counter, err := NewCounter(None)
+	if err != nil || counter != nil {
+		t.Errorf("Expected no counter for %q, got %v, %v", None, counter, err)
+	}
+	if _, err := NewCounter("gpt2"); err == nil {
+		t.Errorf("Expected an error for an unknown tokenizer, got nil")
+	}
+}
@@ -4,13 +4,28 @@
 package util
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+// sizeUnits maps the suffixes accepted by ParseSize to their number of bytes. Decimal and binary
+// suffixes are both treated as powers of 1024, as most users mean the latter.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"tib", 1 << 40}, {"gib", 1 << 30}, {"mib", 1 << 20}, {"kib", 1 << 10},
+	{"tb", 1 << 40}, {"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+	{"t", 1 << 40}, {"g", 1 << 30}, {"m", 1 << 20}, {"k", 1 << 10},
+	{"b", 1},
+}
+
 // Contains checks if a slice contains a particular string (case-insensitive).
 //
 // Parameters:
@@ -93,3 +108,63 @@ func CopyFile(src, dst string) error {
 
 	return nil
 }
+
+// ParseSize parses a size such as 500MB, 2G, 1.5GiB or 1048576 into a number of bytes.
+// Units are case-insensitive and powers of 1024.
+//
+// Parameters:
+//   - input: The size to parse.
+//
+// Returns:
+//   - int64: The size in bytes.
+//   - error: An error if the size is malformed, negative, not finite or does not fit in an int64.
+func ParseSize(input string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(input))
+	if value == "" {
+		return 0, errors.New("size cannot be empty")
+	}
+
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 || math.IsInf(number, 0) || math.IsNaN(number) {
+		return 0, fmt.Errorf("invalid size %q: use a number of bytes or a unit such as 500MB or 2GB", input)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, which no longer fits in an int64
+	size := number * float64(multiplier)
+	if size >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("invalid size %q: sizes must be below 8 EiB", input)
+	}
+	return int64(size), nil
+}
+
+// FormatSize formats a number of bytes with the largest unit that keeps it at or above 1, e.g. 1.5 GiB.
+//
+// Parameters:
+//   - bytes: The size in bytes.
+//
+// Returns:
+//   - string: The formatted size.
+func FormatSize(bytes int64) string {
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	if bytes < 1024 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	size := float64(bytes)
+	unit := ""
+	for _, u := range units {
+		if size < 1024 {
+			break
+		}
+		size /= 1024
+		unit = u
+	}
+	return fmt.Sprintf("%.1f %s", size, unit)
+}
@@ -57,3 +57,60 @@ func TestContains(t *testing.T) {
 		})
 	}
 }
+
+// TestParseSize verifies that sizes with and without units are parsed into bytes and that
+// malformed sizes are rejected.
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"1048576", 1 << 20, false},
+		{"500MB", 500 << 20, false},
+		{"2g", 2 << 30, false},
+		{"1.5 GiB", 3 << 29, false},
+		{"10k", 10 << 10, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"lots", 0, true},
+		{"-1GB", 0, true},
+		{"inf", 0, true},
+		{"+Inf GB", 0, true},
+		{"NaN", 0, true},
+		{"1e30GB", 0, true},
+		{"8388608TiB", 0, true},
+		{"9223372036854775807", 0, true},
+		{"8388607TiB", 8388607 << 40, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if result != tt.expected {
+				t.Errorf("ParseSize(%q) = %d; want %d", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestFormatSize verifies that sizes are formatted with the largest binary unit that keeps them at or above 1.
+func TestFormatSize(t *testing.T) {
+	testCases := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1024:            "1.0 KiB",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 29:         "1.5 GiB",
+		1 << 50:         "1024.0 TiB",
+	}
+	for size, expected := range testCases {
+		if formatted := FormatSize(size); formatted != expected {
+			t.Errorf("FormatSize(%d) = %q; want %q", size, formatted, expected)
+		}
+	}
+}